@@ -0,0 +1,354 @@
+package archive
+
+/*
+ *
+ * file: 		archiver.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the archiver that moves old logs into compressed daily archives and restores them.
+ *
+ */
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"logging_service/core"
+	"logging_service/models"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// manifestKey is the key of the manifest listing every archive.
+const manifestKey = "manifest.json"
+
+// deleteBatchSize is the number of archived logs removed from the store at a time.
+const deleteBatchSize = 1000
+
+// Manifest lists every archive written to a blob store.
+type Manifest struct {
+	Archives []ManifestEntry `json:"archives"`
+}
+
+// ManifestEntry describes one archive: the logs of one log level created on one day, archived by one run.
+type ManifestEntry struct {
+	Key        string    `json:"key"`
+	LogLevel   string    `json:"log_level"`
+	Day        string    `json:"day"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Count      int64     `json:"count"`
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// RestoreResults describes the outcome of a restore.
+type RestoreResults struct {
+	Archives int64 `json:"archives"`
+	Restored int64 `json:"restored"`
+	Skipped  int64 `json:"skipped"`
+}
+
+// Archiver moves logs from the log store into gzip compressed ndjson archives partitioned by log level and day, and
+// restores them. Only one archive or restore runs at a time since both read and write the manifest.
+type Archiver struct {
+	blobs BlobStore
+	mutex sync.Mutex
+}
+
+// NewArchiver creates an archiver writing to the blob store.
+//
+// Parameters:
+//	BlobStore	blobs	- Blob store archives are written to.
+//
+// Returns
+//	*Archiver	- Archiver.
+//
+func NewArchiver(blobs BlobStore) *Archiver {
+	return &Archiver{blobs: blobs}
+}
+
+// Archive moves logs of a log level created before a time into one archive per day. Each archive is uploaded and added
+// to the manifest before its logs are removed from the log store. Logs restored from an archive are not archived again,
+// and logs under a legal hold are not archived.
+//
+// Receiver:
+//	*Archiver	a
+//
+// Parameters:
+//	string		logLevel	- Log level to archive.
+//	time.Time	before		- Logs created before this time are archived.
+//
+// Returns
+//	int64	- Number of logs archived.
+//	error	- Any error that occurs.
+//
+func (a *Archiver) Archive(ctx context.Context, logLevel string, before time.Time) (int64, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	from := time.Unix(0, 0).UTC()
+	to := before.Add(-time.Nanosecond)
+	days, err := models.GetLogStore().CountByDates(ctx, models.ExcludeHeld(models.LogSearchFields{LogLevel: logLevel, FromDate: &from, ToDate: &to, ExcludeRestored: true}))
+	if err != nil {
+		return 0, err
+	}
+
+	archived := int64(0)
+	for _, day := range days {
+		dayStart, err := time.Parse(core.CreatedDayFormat, day.ID.Date)
+		if err != nil {
+			return archived, err
+		}
+		dayEnd := dayStart.Add(24*time.Hour - time.Nanosecond)
+		if dayEnd.After(to) {
+			dayEnd = to
+		}
+
+		count, err := a.archiveDay(ctx, logLevel, day.ID.Date, dayStart, dayEnd)
+		archived += count
+		if err != nil {
+			return archived, err
+		}
+	}
+
+	return archived, nil
+}
+
+// Restore copies archived logs created within a time range back into the log store. Logs that are already in the log
+// store are skipped. Restored logs are stamped with the time they were restored and expire after the given number of
+// days instead of under the retention policy.
+//
+// Receiver:
+//	*Archiver	a
+//
+// Parameters:
+//	time.Time	from		- Start of the range.
+//	time.Time	to			- End of the range.
+//	string		logLevel	- Log level to restore, empty for every log level.
+//	int			days		- Number of days restored logs are kept, zero to keep them until removed.
+//
+// Returns
+//	RestoreResults	- Number of archives read and logs restored and skipped.
+//	error			- Any error that occurs.
+//
+func (a *Archiver) Restore(ctx context.Context, from time.Time, to time.Time, logLevel string, days int) (RestoreResults, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	results := RestoreResults{}
+	manifest, err := a.loadManifest(ctx)
+	if err != nil {
+		return results, err
+	}
+
+	restoredAt := time.Now().UTC()
+	for _, entry := range manifest.Archives {
+		if (logLevel != "" && entry.LogLevel != logLevel) || entry.From.After(to) || entry.To.Before(from) {
+			continue
+		}
+
+		err := a.readArchive(ctx, entry, func(l *models.Log) error {
+			if l.CreatedAt.Before(from) || l.CreatedAt.After(to) {
+				return nil
+			}
+
+			count, err := models.GetLogStore().Count(ctx, models.LogSearchFields{ID: l.ID, LogLevel: l.LogLevel})
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				results.Skipped++
+				return nil
+			}
+
+			l.RestoredAt = &restoredAt
+			l.ExpiresAt = nil
+			if days > 0 && len(models.HoldsOf(l)) == 0 {
+				expiresAt := restoredAt.AddDate(0, 0, days)
+				l.ExpiresAt = &expiresAt
+			}
+			if err := models.GetLogStore().Create(ctx, l); err != nil {
+				return err
+			}
+			results.Restored++
+			return nil
+		})
+		if err != nil {
+			return results, err
+		}
+		results.Archives++
+	}
+
+	return results, nil
+}
+
+// Manifest returns the manifest listing every archive.
+//
+// Receiver:
+//	*Archiver	a
+//
+// Returns
+//	Manifest	- Manifest.
+//	error		- Any error that occurs.
+//
+func (a *Archiver) Manifest(ctx context.Context) (Manifest, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.loadManifest(ctx)
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// archiveDay archives the logs of a log level created within one day.
+func (a *Archiver) archiveDay(ctx context.Context, logLevel string, day string, from time.Time, to time.Time) (int64, error) {
+	fields := models.ExcludeHeld(models.LogSearchFields{LogLevel: logLevel, FromDate: &from, ToDate: &to, ExcludeRestored: true})
+
+	temporary, err := ioutil.TempFile("", "archive-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(temporary.Name())
+	defer temporary.Close()
+
+	hash := sha256.New()
+	compressor := gzip.NewWriter(io.MultiWriter(temporary, hash))
+	encoder := json.NewEncoder(compressor)
+	entry := ManifestEntry{LogLevel: logLevel, Day: day}
+	ids := []primitive.ObjectID{}
+
+	err = models.GetLogStore().Iterate(ctx, fields, func(l *models.Log) error {
+		if entry.Count == 0 || l.CreatedAt.Before(entry.From) {
+			entry.From = l.CreatedAt
+		}
+		if entry.Count == 0 || l.CreatedAt.After(entry.To) {
+			entry.To = l.CreatedAt
+		}
+		entry.Count++
+		ids = append(ids, l.ID)
+		return encoder.Encode(l)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if entry.Count == 0 {
+		return 0, nil
+	}
+	if err := compressor.Close(); err != nil {
+		return 0, err
+	}
+
+	entry.Size, err = temporary.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := temporary.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	entry.ArchivedAt = time.Now().UTC()
+	entry.Key = logLevel + "/" + strings.Replace(day, "-", "/", -1) + "/" + primitive.NewObjectID().Hex() + ".ndjson.gz"
+
+	if err := a.blobs.Put(ctx, entry.Key, temporary); err != nil {
+		return 0, err
+	}
+	manifest, err := a.loadManifest(ctx)
+	if err != nil {
+		return 0, err
+	}
+	manifest.Archives = append(manifest.Archives, entry)
+	if err := a.saveManifest(ctx, manifest); err != nil {
+		return 0, err
+	}
+
+	for start := 0; start < len(ids); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if _, err := models.GetLogStore().Delete(ctx, models.ExcludeHeld(models.LogSearchFields{LogLevel: logLevel, IDs: ids[start:end]})); err != nil {
+			return entry.Count, err
+		}
+	}
+
+	return entry.Count, nil
+}
+
+// readArchive calls fn for every log in an archive after checking the archive against its manifest entry.
+func (a *Archiver) readArchive(ctx context.Context, entry ManifestEntry, fn func(l *models.Log) error) error {
+	blob, err := a.blobs.Get(ctx, entry.Key)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	compressed, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(compressed)
+	if hex.EncodeToString(hash[:]) != entry.SHA256 {
+		return errors.New("archive: checksum mismatch for " + entry.Key)
+	}
+
+	decompressor, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return err
+	}
+	defer decompressor.Close()
+
+	scanner := bufio.NewScanner(decompressor)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		l := models.Log{}
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			return err
+		}
+		if err := fn(&l); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// loadManifest reads the manifest, returning an empty manifest if none has been written.
+func (a *Archiver) loadManifest(ctx context.Context) (Manifest, error) {
+	manifest := Manifest{Archives: []ManifestEntry{}}
+	blob, err := a.blobs.Get(ctx, manifestKey)
+	if err == ErrBlobNotFound {
+		return manifest, nil
+	} else if err != nil {
+		return manifest, err
+	}
+	defer blob.Close()
+
+	err = json.NewDecoder(blob).Decode(&manifest)
+	return manifest, err
+}
+
+// saveManifest writes the manifest.
+func (a *Archiver) saveManifest(ctx context.Context, manifest Manifest) error {
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return a.blobs.Put(ctx, manifestKey, bytes.NewReader(content))
+}
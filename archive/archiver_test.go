@@ -0,0 +1,133 @@
+package archive
+
+/*
+ *
+ * file: 		archiver_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests moving logs into archives and restoring them.
+ *
+ */
+
+import (
+	"context"
+	"io/ioutil"
+	"logging_service/database"
+	"logging_service/models"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveAndRestore(t *testing.T) {
+	fs, err := database.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	models.SetLogStore(fs)
+	directory := t.TempDir()
+	blobs, err := NewDirectoryBlobStore(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archiver := NewArchiver(blobs)
+	ctx := context.Background()
+
+	day := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	logs := []models.Log{
+		{CreatedAt: day.Add(time.Hour), LogLevel: "INFO", Location: "billing", Message: "first day"},
+		{CreatedAt: day.Add(2 * time.Hour), LogLevel: "INFO", Location: "billing", Message: "first day later"},
+		{CreatedAt: day.Add(25 * time.Hour), LogLevel: "INFO", Location: "billing", Message: "second day"},
+		{CreatedAt: day.Add(49 * time.Hour), LogLevel: "INFO", Location: "billing", Message: "after the cutoff"},
+		{CreatedAt: day.Add(time.Hour), LogLevel: "DEBUG", Location: "billing", Message: "other level"},
+	}
+	if err := fs.CreateMany(ctx, logs); err != nil {
+		t.Fatal(err)
+	}
+
+	// Logs of the level created before the cutoff are moved into one archive per day.
+	if archived, err := archiver.Archive(ctx, "INFO", day.Add(48*time.Hour)); err != nil || archived != 3 {
+		t.Fatalf("archived %d logs, %v, want 3", archived, err)
+	}
+	manifest, err := archiver.Manifest(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Archives) != 2 || manifest.Archives[0].Day != "2021-03-04" || manifest.Archives[0].Count != 2 ||
+		manifest.Archives[1].Day != "2021-03-05" || manifest.Archives[1].Count != 1 {
+		t.Fatalf("wrote the manifest %+v, want archives of 2021-03-04 and 2021-03-05", manifest.Archives)
+	}
+	if got := storedMessages(t, fs); strings.Join(got, ",") != "after the cutoff,other level" {
+		t.Errorf("kept %v, want the logs that were not archived", got)
+	}
+
+	// Restoring a range brings back only its logs, and restoring it again skips them.
+	tests := []struct {
+		name     string
+		from     time.Time
+		to       time.Time
+		want     RestoreResults
+		wantLogs string
+	}{
+		{"first day", day, day.Add(90 * time.Minute), RestoreResults{Archives: 1, Restored: 1}, "after the cutoff,first day,other level"},
+		{"both days", day, day.Add(48 * time.Hour), RestoreResults{Archives: 2, Restored: 2, Skipped: 1},
+			"after the cutoff,first day,first day later,other level,second day"},
+		{"again", day, day.Add(48 * time.Hour), RestoreResults{Archives: 2, Skipped: 3},
+			"after the cutoff,first day,first day later,other level,second day"},
+	}
+	for _, test := range tests {
+		results, err := archiver.Restore(ctx, test.from, test.to, "INFO", 7)
+		if err != nil || results != test.want {
+			t.Errorf("%s: restored %+v, %v, want %+v", test.name, results, err, test.want)
+		}
+		if got := storedMessages(t, fs); strings.Join(got, ",") != test.wantLogs {
+			t.Errorf("%s: stored %v, want %s", test.name, got, test.wantLogs)
+		}
+	}
+
+	// Restored logs are stamped with when they were restored and are not archived again.
+	restoredBefore := time.Now().UTC()
+	restored, _, err := fs.Find(ctx, models.LogSearchFields{RestoredBefore: &restoredBefore}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 3 {
+		t.Errorf("found %d restored logs, want 3", len(restored))
+	}
+	if archived, err := archiver.Archive(ctx, "INFO", day.Add(48*time.Hour)); err != nil || archived != 0 {
+		t.Errorf("archived %d restored logs, %v, want 0", archived, err)
+	}
+
+	// An archive that no longer matches its manifest entry is not restored.
+	path := filepath.Join(directory, filepath.FromSlash(manifest.Archives[1].Key))
+	if err := ioutil.WriteFile(path, []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := archiver.Restore(ctx, day, day.Add(48*time.Hour), "INFO", 0); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("restored a tampered archive with %v, want a checksum mismatch", err)
+	}
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// storedMessages returns the sorted messages of every stored log.
+func storedMessages(t *testing.T, fs *database.FileStore) []string {
+	found, _, err := fs.Find(context.Background(), models.LogSearchFields{}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := []string{}
+	for _, l := range found {
+		messages = append(messages, l.Message)
+	}
+	sort.Strings(messages)
+
+	return messages
+}
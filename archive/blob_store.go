@@ -0,0 +1,206 @@
+package archive
+
+/*
+ *
+ * file: 		blob_store.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the blob targets archives are written to: a local directory or an S3 compatible store.
+ *
+ */
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ErrBlobNotFound is returned by BlobStore.Get when no blob exists for the key.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore stores archive files by key. Keys use forward slashes to separate path segments.
+type BlobStore interface {
+	// Put stores the content under the key, replacing any existing blob.
+	Put(ctx context.Context, key string, content io.ReadSeeker) error
+
+	// Get opens the blob stored under the key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// DirectoryBlobStore stores blobs as files in a local directory.
+type DirectoryBlobStore struct {
+	directory string
+}
+
+// NewDirectoryBlobStore creates a blob store in a local directory, creating the directory if it does not exist.
+//
+// Parameters:
+//	string	directory	- Directory blobs are stored in.
+//
+// Returns
+//	*DirectoryBlobStore	- Directory blob store.
+//	error				- Any error that occurs.
+//
+func NewDirectoryBlobStore(directory string) (*DirectoryBlobStore, error) {
+	if directory == "" {
+		return nil, errors.New("archive: directory is required")
+	}
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+
+	return &DirectoryBlobStore{directory: directory}, nil
+}
+
+// Put writes the content to a temporary file and renames it into place so a blob is never partially written.
+//
+// Receiver:
+//	*DirectoryBlobStore		dbs
+//
+// Parameters:
+//	string			key		- Key of the blob.
+//	io.ReadSeeker	content	- Content of the blob.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (dbs *DirectoryBlobStore) Put(ctx context.Context, key string, content io.ReadSeeker) error {
+	path := filepath.Join(dbs.directory, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	temporary, err := ioutil.TempFile(filepath.Dir(path), ".upload-")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(temporary, content); err != nil {
+		temporary.Close()
+		os.Remove(temporary.Name())
+		return err
+	}
+	if err := temporary.Close(); err != nil {
+		os.Remove(temporary.Name())
+		return err
+	}
+
+	return os.Rename(temporary.Name(), path)
+}
+
+// Get opens the file stored under the key.
+//
+// Receiver:
+//	*DirectoryBlobStore		dbs
+//
+// Parameters:
+//	string	key	- Key of the blob.
+//
+// Returns
+//	io.ReadCloser	- Content of the blob.
+//	error			- ErrBlobNotFound if the blob does not exist, or any other error that occurs.
+//
+func (dbs *DirectoryBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(dbs.directory, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+
+	return file, err
+}
+
+// S3BlobStore stores blobs as objects in an S3 compatible bucket, such as AWS S3 or a local MinIO server.
+type S3BlobStore struct {
+	client *s3.S3
+	bucket string
+	prefix string
+}
+
+// NewS3BlobStore creates a blob store for an S3 compatible bucket. Path style addressing is used so any endpoint works.
+//
+// Parameters:
+//	string	endpoint		- Endpoint of the store, empty for AWS S3.
+//	string	region			- Region of the bucket.
+//	string	bucket			- Name of the bucket.
+//	string	prefix			- Prefix added to every key.
+//	string	accessKeyID		- Access key id.
+//	string	secretAccessKey	- Secret access key.
+//
+// Returns
+//	*S3BlobStore	- S3 blob store.
+//	error			- Any error that occurs.
+//
+func NewS3BlobStore(endpoint string, region string, bucket string, prefix string, accessKeyID string, secretAccessKey string) (*S3BlobStore, error) {
+	if bucket == "" {
+		return nil, errors.New("archive: s3 bucket is required")
+	}
+
+	awsConfig := aws.NewConfig().WithRegion(region).WithS3ForcePathStyle(true)
+	if endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(endpoint)
+	}
+	if accessKeyID != "" {
+		awsConfig = awsConfig.WithCredentials(credentials.NewStaticCredentials(accessKeyID, secretAccessKey, ""))
+	}
+	awsSession, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3BlobStore{client: s3.New(awsSession), bucket: bucket, prefix: prefix}, nil
+}
+
+// Put uploads the content as an object.
+//
+// Receiver:
+//	*S3BlobStore	sbs
+//
+// Parameters:
+//	string			key		- Key of the blob.
+//	io.ReadSeeker	content	- Content of the blob.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (sbs *S3BlobStore) Put(ctx context.Context, key string, content io.ReadSeeker) error {
+	_, err := sbs.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(sbs.bucket),
+		Key:    aws.String(sbs.prefix + key),
+		Body:   content,
+	})
+	return err
+}
+
+// Get downloads an object.
+//
+// Receiver:
+//	*S3BlobStore	sbs
+//
+// Parameters:
+//	string	key	- Key of the blob.
+//
+// Returns
+//	io.ReadCloser	- Content of the blob.
+//	error			- ErrBlobNotFound if the object does not exist, or any other error that occurs.
+//
+func (sbs *S3BlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	output, err := sbs.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(sbs.bucket),
+		Key:    aws.String(sbs.prefix + key),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, ErrBlobNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return output.Body, nil
+}
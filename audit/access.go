@@ -0,0 +1,109 @@
+package audit
+
+/*
+ *
+ * file: 		access.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the access trail of searches, counts and exports, kept apart from the audit trail.
+ *
+ */
+
+import (
+	"time"
+)
+
+// Query selects records of a trail. Empty fields select every record.
+type Query struct {
+	Action string
+	Actor  string
+	Tenant string
+	From   *time.Time
+	To     *time.Time
+	Limit  int
+}
+
+// accessTrail is the trail reads of logs are recorded in, nil when no access file is configured.
+var accessTrail *Trail
+
+// OpenAccess sets the trail reads of logs are recorded in. It is a separate file from the audit trail, so the volume of
+// reads does not bury privileged operations.
+//
+// Parameters:
+//	string	path	- Path of the access file, empty to not record reads.
+//
+func OpenAccess(path string) {
+	accessTrail = nil
+	if path != "" {
+		accessTrail = &Trail{path: path}
+	}
+}
+
+// GetAccessTrail returns the trail reads of logs are recorded in.
+//
+// Returns
+//	*Trail	- Access trail, nil if no access file is configured.
+//
+func GetAccessTrail() *Trail {
+	return accessTrail
+}
+
+// Search returns the most recent records of the trail matching a query, and whether every record still links to the
+// one before it. Records are streamed from the file keeping only the most recent matches, without blocking appends.
+//
+// Receiver:
+//	*Trail		t
+//
+// Parameters:
+//	Query	query	- Action, actor, tenant and time range of the records, and how many to return at most.
+//
+// Returns
+//	[]Record	- Matching records, newest first.
+//	bool		- True if no record has been changed or removed.
+//	error		- Any error that occurs.
+//
+func (t *Trail) Search(query Query) ([]Record, bool, error) {
+	size, err := t.lockedSize()
+	if err != nil {
+		return nil, false, err
+	}
+
+	recent := []Record{}
+	intact, err := t.scan(size, func(record Record) {
+		if !query.matches(record) {
+			return
+		}
+		recent = append(recent, record)
+		if query.Limit > 0 && len(recent) > query.Limit {
+			recent = recent[1:]
+		}
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	matching := make([]Record, 0, len(recent))
+	for i := len(recent) - 1; i >= 0; i-- {
+		matching = append(matching, recent[i])
+	}
+
+	return matching, intact, nil
+}
+
+// matches reports whether a record has the action, actor and tenant of the query and was written in its time range.
+func (query Query) matches(record Record) bool {
+	if query.Action != "" && record.Action != query.Action {
+		return false
+	}
+	if query.Actor != "" && record.Actor != query.Actor {
+		return false
+	}
+	if query.Tenant != "" && record.Tenant != query.Tenant {
+		return false
+	}
+	if query.From != nil && record.CreatedAt.Before(*query.From) {
+		return false
+	}
+
+	return query.To == nil || !record.CreatedAt.After(*query.To)
+}
@@ -0,0 +1,248 @@
+package audit
+
+/*
+ *
+ * file: 		trail.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the append only audit trail of privileged operations.
+ *
+ */
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Record is one entry of the audit trail. Each record holds the hash of the record before it, so removing or changing a
+// record breaks the trail. Records of operations within a tenant are stamped with it.
+type Record struct {
+	ID        string                 `json:"id"`
+	CreatedAt time.Time              `json:"created_at"`
+	Actor     string                 `json:"actor"`
+	Tenant    string                 `json:"tenant,omitempty"`
+	Action    string                 `json:"action"`
+	Filters   map[string]string      `json:"filters,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	PrevHash  string                 `json:"prev_hash"`
+	Hash      string                 `json:"hash"`
+}
+
+// Trail is an audit trail stored as one json record per line in a file that is only ever appended to.
+type Trail struct {
+	path     string
+	mutex    sync.Mutex
+	lastHash *string
+}
+
+// trail is the audit trail privileged operations are recorded in, nil when no audit file is configured.
+var trail *Trail
+
+// Open sets the audit trail privileged operations are recorded in.
+//
+// Parameters:
+//	string	path	- Path of the audit file, empty to disable the audit trail and the operations that require it.
+//
+func Open(path string) {
+	trail = nil
+	if path != "" {
+		trail = &Trail{path: path}
+	}
+}
+
+// GetTrail returns the audit trail privileged operations are recorded in.
+//
+// Returns
+//	*Trail	- Audit trail, nil if no audit file is configured.
+//
+func GetTrail() *Trail {
+	return trail
+}
+
+// Append records an operation in the audit trail. Operations must not go ahead when their record cannot be written.
+//
+// Parameters:
+//	Record	record	- Record of the operation. Its id, creation time and hashes are assigned.
+//
+// Returns
+//	Record	- Written record.
+//	error	- Error if no audit file is configured or the record cannot be written.
+//
+func Append(record Record) (Record, error) {
+	if trail == nil {
+		return record, errors.New("audit: no audit file is configured")
+	}
+
+	return trail.Append(record)
+}
+
+// Append writes a record to the end of the trail and syncs it to disk.
+//
+// Receiver:
+//	*Trail		t
+//
+// Parameters:
+//	Record	record	- Record of the operation. Its id, creation time and hashes are assigned.
+//
+// Returns
+//	Record	- Written record.
+//	error	- Any error that occurs.
+//
+func (t *Trail) Append(record Record) (Record, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.lastHash == nil {
+		size, err := t.size()
+		if err != nil {
+			return record, err
+		}
+		lastHash := ""
+		if _, err := t.scan(size, func(read Record) { lastHash = read.Hash }); err != nil {
+			return record, err
+		}
+		t.lastHash = &lastHash
+	}
+
+	record.ID = primitive.NewObjectID().Hex()
+	record.CreatedAt = time.Now().UTC()
+	record.PrevHash = *t.lastHash
+	hash, err := record.computeHash()
+	if err != nil {
+		return record, err
+	}
+	record.Hash = hash
+
+	content, err := json.Marshal(record)
+	if err != nil {
+		return record, err
+	}
+	file, err := os.OpenFile(t.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return record, err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(content, '\n')); err != nil {
+		return record, err
+	}
+	if err := file.Sync(); err != nil {
+		return record, err
+	}
+
+	*t.lastHash = record.Hash
+	return record, nil
+}
+
+// Records returns the records of the trail matching a query, and whether every record still links to the one before
+// it. The trail is read while records are appended, returning the records written before the call.
+//
+// Receiver:
+//	*Trail		t
+//
+// Parameters:
+//	Query	query	- Action, actor, tenant and time range of the records, empty for any. Limit is ignored.
+//
+// Returns
+//	[]Record	- Records in the order they were written.
+//	bool		- True if no record has been changed or removed.
+//	error		- Any error that occurs.
+//
+func (t *Trail) Records(query Query) ([]Record, bool, error) {
+	size, err := t.lockedSize()
+	if err != nil {
+		return nil, false, err
+	}
+
+	matching := []Record{}
+	intact, err := t.scan(size, func(record Record) {
+		if query.matches(record) {
+			matching = append(matching, record)
+		}
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return matching, intact, nil
+}
+
+// lockedSize returns the size of the file between appends, so it ends after a whole record.
+func (t *Trail) lockedSize() (int64, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.size()
+}
+
+// size returns the size of the file, zero if nothing has been written.
+func (t *Trail) size() (int64, error) {
+	info, err := os.Stat(t.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+// scan calls fn with every record in the first size bytes of the file, one at a time in the order they were written,
+// and reports whether every record links to the one before it.
+func (t *Trail) scan(size int64, fn func(record Record)) (bool, error) {
+	if size == 0 {
+		return true, nil
+	}
+	file, err := os.Open(t.path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	intact := true
+	prevHash := ""
+	scanner := bufio.NewScanner(io.LimitReader(file, size))
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		// Numbers are kept as written so the record hashes the same as when it was appended.
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.UseNumber()
+		record := Record{}
+		if err := decoder.Decode(&record); err != nil {
+			return false, err
+		}
+		hash, err := record.computeHash()
+		if err != nil || record.PrevHash != prevHash || record.Hash != hash {
+			intact = false
+		}
+		prevHash = record.Hash
+		fn(record)
+	}
+
+	return intact, scanner.Err()
+}
+
+// computeHash returns the hex sha256 of the record without its own hash.
+func (record Record) computeHash() (string, error) {
+	record.Hash = ""
+	content, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(content)
+
+	return hex.EncodeToString(hash[:]), nil
+}
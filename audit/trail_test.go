@@ -0,0 +1,84 @@
+package audit
+
+/*
+ *
+ * file: 		trail_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests reading and verifying trails.
+ *
+ */
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTrailSearch(t *testing.T) {
+	trail := &Trail{path: filepath.Join(t.TempDir(), "access.jsonl")}
+	for _, record := range []Record{
+		{Actor: "a", Tenant: "acme", Action: "search"},
+		{Actor: "b", Tenant: "globex", Action: "search"},
+		{Actor: "c", Tenant: "acme", Action: "export"},
+		{Actor: "d", Tenant: "acme", Action: "search"},
+		{Actor: "e", Tenant: "acme", Action: "search"},
+	} {
+		if _, err := trail.Append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  string
+	}{
+		{"every record", Query{}, "edcba"},
+		{"tenant", Query{Tenant: "acme"}, "edca"},
+		{"tenant and action", Query{Tenant: "acme", Action: "search"}, "eda"},
+		{"limit", Query{Tenant: "acme", Limit: 2}, "ed"},
+	}
+	for _, test := range tests {
+		records, intact, err := trail.Search(test.query)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := actorsOf(records); got != test.want || !intact {
+			t.Errorf("%s: found %s, intact %v, want %s intact", test.name, got, intact, test.want)
+		}
+	}
+
+	// Changing a record breaks the trail.
+	content, err := ioutil.ReadFile(trail.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(trail.path, []byte(strings.Replace(string(content), `"globex"`, `"acme"`, 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	records, intact, err := trail.Records(Query{Tenant: "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := actorsOf(records); got != "abcde" || intact {
+		t.Errorf("found %s, intact %v after a change, want abcde not intact", got, intact)
+	}
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// actorsOf returns the actors of records joined in order.
+func actorsOf(records []Record) string {
+	actors := ""
+	for _, record := range records {
+		actors += record.Actor
+	}
+
+	return actors
+}
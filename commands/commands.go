@@ -0,0 +1,168 @@
+package commands
+
+/*
+ *
+ * file: 		commands.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the command line commands the service binary runs instead of serving requests.
+ *
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"logging_service/config"
+	"logging_service/encryption"
+	"logging_service/importer"
+	"logging_service/jobs"
+	"logging_service/models"
+	"os"
+	"strings"
+)
+
+// usage lists the commands.
+const usage = `usage: logging_service [command]
+
+Without a command the service serves requests. Commands:
+  verify-chain [location]	verify the hash chains of a location, or of every location
+  import [flags] path...	import logs from ndjson files, json arrays and directories of legacy log files,
+				run 'import -h' for its flags
+`
+
+// Run runs the command named by the first argument.
+//
+// Parameters:
+//	[]string	args	- Command line arguments without the program name.
+//
+// Returns
+//	int - Exit code.
+//
+func Run(args []string) int {
+	var err error
+	switch args[0] {
+	case "verify-chain":
+		err = verifyChain(args[1:])
+	case "import":
+		err = importLogs(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		err = errors.New("unknown command " + args[0] + "\n" + usage)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// verifyChain prints the verification results of the hash chains, failing if any chain is broken.
+func verifyChain(args []string) error {
+	location := ""
+	if len(args) > 0 {
+		location = args[0]
+	}
+
+	results, err := jobs.VerifyHashChains(context.Background(), location)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(results); err != nil {
+		return err
+	}
+	if !results.Intact {
+		return errors.New("verify-chain: hash chains are broken")
+	}
+
+	return nil
+}
+
+// importLogs imports logs from files and prints a summary of the rows read, imported and rejected. Imported logs are
+// stamped with the tenant flag, given expiries, encrypted and chained as created logs are.
+func importLogs(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", importer.FormatAuto, "file format: auto, ndjson or json")
+	mapping := flags.String("map", "", "comma separated field=key pairs mapping "+strings.Join(importer.Fields, ", ")+" to row keys")
+	levels := flags.String("levels", "", "comma separated alias=LEVEL pairs normalizing log levels")
+	defaultLevel := flags.String("default-level", "", "log level of rows without one")
+	timeFormat := flags.String("time-format", "", "go time layout of created_at, tried before the default layouts")
+	keepUnmapped := flags.Bool("keep-unmapped", false, "add unmapped keys to extra as key=value")
+	batchSize := flags.Int("batch", 500, "number of logs written at a time")
+	dryRun := flags.Bool("dry-run", false, "validate rows without writing them")
+	maxRejected := flags.Int("max-rejected", 100, "number of rejected rows listed in the summary")
+	tenant := flags.String("tenant", "", "tenant the imported logs are stamped with")
+	serviceStopped := flags.Bool("service-stopped", false, "confirm the service is stopped, required to import while hash chaining is enabled")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("import: no files given")
+	}
+
+	options := importer.Options{
+		Format:       *format,
+		DefaultLevel: *defaultLevel,
+		KeepUnmapped: *keepUnmapped,
+		BatchSize:    *batchSize,
+		DryRun:       *dryRun,
+		MaxRejected:  *maxRejected,
+	}
+	var err error
+	if options.Mapping, err = importer.ParseMapping(*mapping); err != nil {
+		return err
+	}
+	if options.LevelAliases, err = importer.ParseLevelAliases(*levels); err != nil {
+		return err
+	}
+	if *timeFormat != "" {
+		options.TimeLayouts = []string{*timeFormat}
+	}
+	if !options.DryRun {
+		if config.GetConfig().Integrity.HashChain && !*serviceStopped {
+			return errors.New("import: hash chaining is enabled and the chain only allows one writer, stop the service and pass -service-stopped")
+		}
+		if err := prepareLogWrites(); err != nil {
+			return err
+		}
+	}
+
+	summary, err := importer.Import(models.WithTenant(context.Background(), *tenant), flags.Args(), options)
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if encodeErr := encoder.Encode(summary); err == nil {
+		err = encodeErr
+	}
+
+	return err
+}
+
+// prepareLogWrites loads the retention policy, keyring and hash chain that creating logs uses, without starting the
+// scheduled jobs that serving starts.
+func prepareLogWrites() error {
+	conf := config.GetConfig()
+	policy, err := models.NewRetentionPolicy(conf)
+	if err != nil {
+		return err
+	}
+	models.SetRetentionPolicy(policy)
+
+	if conf.Encryption.ActiveKeyID != "" {
+		keyring, err := encryption.LoadKeyring(conf.Encryption.KeyFile, conf.Encryption.ActiveKeyID)
+		if err != nil {
+			return err
+		}
+		models.SetKeyring(keyring)
+	}
+
+	return jobs.LoadHashChain()
+}
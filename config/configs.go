@@ -19,16 +19,43 @@ import (
 
 // Values contains all configuration values from the top parents.
 type Values struct {
-	Server   server   `yaml:"Server"`
-	IO       io       `yaml:"IO"`
-	Auth     auth     `yaml:"Auth"`
-	Database database `yaml:"Database"`
-	Results  results  `yaml:"Results"`
+	Server     server     `yaml:"Server"`
+	IO         io         `yaml:"IO"`
+	Auth       auth       `yaml:"Auth"`
+	Database   database   `yaml:"Database"`
+	Results    results    `yaml:"Results"`
+	Storage    storage    `yaml:"Storage"`
+	Retention  retention  `yaml:"Retention"`
+	Archive    archive    `yaml:"Archive"`
+	Integrity  integrity  `yaml:"Integrity"`
+	Encryption encryption `yaml:"Encryption"`
+	Audit      audit      `yaml:"Audit"`
+	Deletion   deletion   `yaml:"Deletion"`
+	LegalHold  legalHold  `yaml:"LegalHold"`
+	Jobs       jobs       `yaml:"Jobs"`
+	APIKeys    apiKeys    `yaml:"ApiKeys"`
+	Tenancy    tenancy    `yaml:"Tenancy"`
+	Access     access     `yaml:"Access"`
+	Masking    masking    `yaml:"Masking"`
+	Signing    signing    `yaml:"Signing"`
 }
 
 type server struct {
-	Port           string   `yaml:"PORT"`
-	AllowedOrigins []string `yaml:"ALLOWED_ORIGINS"`
+	Port           string    `yaml:"PORT"`
+	AllowedOrigins []string  `yaml:"ALLOWED_ORIGINS"`
+	TLS            serverTLS `yaml:"TLS"`
+}
+
+type serverTLS struct {
+	CertFile      string   `yaml:"CERT_FILE"`
+	KeyFile       string   `yaml:"KEY_FILE"`
+	MinVersion    string   `yaml:"MIN_VERSION"`
+	Ciphers       []string `yaml:"CIPHERS"`
+	ClientCAFile  string   `yaml:"CLIENT_CA_FILE"`
+	ClientAuth    string   `yaml:"CLIENT_AUTH"`
+	ReloadSeconds int      `yaml:"RELOAD_SECONDS"`
+	HTTPPort      string   `yaml:"HTTP_PORT"`
+	HTTPMode      string   `yaml:"HTTP_MODE"`
 }
 
 type io struct {
@@ -36,21 +63,197 @@ type io struct {
 }
 
 type auth struct {
-	Auth0Audience string `yaml:"AUTH_0_AUDIENCE"`
-	Auth0Domain   string `yaml:"AUTH_0_DOMAIN"`
+	Auth0Audience      string         `yaml:"AUTH_0_AUDIENCE"`
+	Auth0Domain        string         `yaml:"AUTH_0_DOMAIN"`
+	JWKSRefreshMinutes int            `yaml:"JWKS_REFRESH_MINUTES"`
+	JWKSRefetchSeconds int            `yaml:"JWKS_REFETCH_SECONDS"`
+	JWKSMaxStaleHours  int            `yaml:"JWKS_MAX_STALE_HOURS"`
+	ReadScope          string         `yaml:"READ_SCOPE"`
+	WriteScope         string         `yaml:"WRITE_SCOPE"`
+	AdminScope         string         `yaml:"ADMIN_SCOPE"`
+	Providers          []AuthProvider `yaml:"PROVIDERS"`
+}
+
+// AuthProvider configures one of the authentication providers tried in turn. Auth0 and oidc providers verify tokens
+// from an issuer, hs256 providers verify tokens signed with a shared secret, mtls providers grant scopes to tls client
+// certificates, and disabled providers grant scopes to requests from localhost.
+type AuthProvider struct {
+	Type       string   `yaml:"TYPE"`
+	Name       string   `yaml:"NAME"`
+	Issuer     string   `yaml:"ISSUER"`
+	Audience   string   `yaml:"AUDIENCE"`
+	Algorithm  string   `yaml:"ALGORITHM"`
+	Secret     string   `yaml:"SECRET"`
+	SecretFile string   `yaml:"SECRET_FILE"`
+	Subjects   []string `yaml:"SUBJECTS"`
+	Scopes     []string `yaml:"SCOPES"`
+	Tenant     string   `yaml:"TENANT"`
 }
 
 type database struct {
-	DatabaseUsername string `yaml:"DATABASE_USERNAME"`
-	DatabasePassword string `yaml:"DATABASE_PASSWORD"`
-	DatabaseName     string `yaml:"DATABASE_NAME"`
-	DatabaseURL      string `yaml:"DATABASE_URL"`
+	DatabaseUsername              string            `yaml:"DATABASE_USERNAME"`
+	DatabasePassword              string            `yaml:"DATABASE_PASSWORD"`
+	DatabaseName                  string            `yaml:"DATABASE_NAME"`
+	DatabaseURL                   string            `yaml:"DATABASE_URL"`
+	URI                           string            `yaml:"URI"`
+	Scheme                        string            `yaml:"SCHEME"`
+	AuthSource                    string            `yaml:"AUTH_SOURCE"`
+	ReplicaSet                    string            `yaml:"REPLICA_SET"`
+	TLS                           bool              `yaml:"TLS"`
+	TLSCAFile                     string            `yaml:"TLS_CA_FILE"`
+	TLSCertificateKeyFile         string            `yaml:"TLS_CERTIFICATE_KEY_FILE"`
+	MaxPoolSize                   int               `yaml:"MAX_POOL_SIZE"`
+	MinPoolSize                   int               `yaml:"MIN_POOL_SIZE"`
+	ConnectTimeoutSeconds         int               `yaml:"CONNECT_TIMEOUT_SECONDS"`
+	ServerSelectionTimeoutSeconds int               `yaml:"SERVER_SELECTION_TIMEOUT_SECONDS"`
+	SocketTimeoutSeconds          int               `yaml:"SOCKET_TIMEOUT_SECONDS"`
+	OperationTimeoutSeconds       int               `yaml:"OPERATION_TIMEOUT_SECONDS"`
+	StartupRetries                int               `yaml:"STARTUP_RETRIES"`
+	ReconnectIntervalSeconds      int               `yaml:"RECONNECT_INTERVAL_SECONDS"`
+	WriteConcern                  string            `yaml:"WRITE_CONCERN"`
+	LevelWriteConcerns            map[string]string `yaml:"LEVEL_WRITE_CONCERNS"`
+	WriteJournal                  bool              `yaml:"WRITE_JOURNAL"`
+	WriteTimeoutSeconds           int               `yaml:"WRITE_TIMEOUT_SECONDS"`
+	ReadPreference                string            `yaml:"READ_PREFERENCE"`
+	MaxStalenessSeconds           int               `yaml:"MAX_STALENESS_SECONDS"`
 }
 
 type results struct {
 	Limit int64 `yaml:"LIMIT"`
 }
 
+type retention struct {
+	PurgeIntervalMinutes int                 `yaml:"PURGE_INTERVAL_MINUTES"`
+	Levels               map[string]int      `yaml:"LEVELS"`
+	Overrides            []retentionOverride `yaml:"OVERRIDES"`
+}
+
+type retentionOverride struct {
+	LocationPrefix string   `yaml:"LOCATION_PREFIX"`
+	LogLevels      []string `yaml:"LOG_LEVELS"`
+	Days           int      `yaml:"DAYS"`
+}
+
+type archive struct {
+	IntervalMinutes   int            `yaml:"INTERVAL_MINUTES"`
+	Levels            map[string]int `yaml:"LEVELS"`
+	RestoreDays       int            `yaml:"RESTORE_DAYS"`
+	Target            string         `yaml:"TARGET"`
+	Directory         string         `yaml:"DIRECTORY"`
+	S3Endpoint        string         `yaml:"S3_ENDPOINT"`
+	S3Region          string         `yaml:"S3_REGION"`
+	S3Bucket          string         `yaml:"S3_BUCKET"`
+	S3Prefix          string         `yaml:"S3_PREFIX"`
+	S3AccessKeyID     string         `yaml:"S3_ACCESS_KEY_ID"`
+	S3SecretAccessKey string         `yaml:"S3_SECRET_ACCESS_KEY"`
+}
+
+type integrity struct {
+	HashChain                 bool   `yaml:"HASH_CHAIN"`
+	SigningKeyFile            string `yaml:"SIGNING_KEY_FILE"`
+	CheckpointFile            string `yaml:"CHECKPOINT_FILE"`
+	CheckpointIntervalMinutes int    `yaml:"CHECKPOINT_INTERVAL_MINUTES"`
+}
+
+type encryption struct {
+	KeyFile                 string `yaml:"KEY_FILE"`
+	ActiveKeyID             string `yaml:"ACTIVE_KEY_ID"`
+	DecryptPermission       string `yaml:"DECRYPT_PERMISSION"`
+	RotationIntervalMinutes int    `yaml:"ROTATION_INTERVAL_MINUTES"`
+}
+
+type audit struct {
+	File             string `yaml:"FILE"`
+	Permission       string `yaml:"PERMISSION"`
+	AccessFile       string `yaml:"ACCESS_FILE"`
+	AccessPermission string `yaml:"ACCESS_PERMISSION"`
+}
+
+type deletion struct {
+	Permission          string `yaml:"PERMISSION"`
+	ConfirmationMinutes int    `yaml:"CONFIRMATION_MINUTES"`
+	SampleSize          int    `yaml:"SAMPLE_SIZE"`
+}
+
+type legalHold struct {
+	File       string `yaml:"FILE"`
+	Permission string `yaml:"PERMISSION"`
+}
+
+type jobs struct {
+	Directory       string `yaml:"DIRECTORY"`
+	Workers         int    `yaml:"WORKERS"`
+	ArtifactHours   int    `yaml:"ARTIFACT_HOURS"`
+	AdminPermission string `yaml:"ADMIN_PERMISSION"`
+}
+
+type apiKeys struct {
+	File               string `yaml:"FILE"`
+	RotationGraceHours int    `yaml:"ROTATION_GRACE_HOURS"`
+	Permission         string `yaml:"PERMISSION"`
+}
+
+type tenancy struct {
+	Enabled         bool                    `yaml:"ENABLED"`
+	Claim           string                  `yaml:"CLAIM"`
+	SuperAdminScope string                  `yaml:"SUPER_ADMIN_SCOPE"`
+	WritesPerMinute int                     `yaml:"WRITES_PER_MINUTE"`
+	ReadsPerMinute  int                     `yaml:"READS_PER_MINUTE"`
+	Limits          map[string]tenantLimits `yaml:"LIMITS"`
+}
+
+// tenantLimits override the request limits of Tenancy for one tenant.
+type tenantLimits struct {
+	WritesPerMinute int `yaml:"WRITES_PER_MINUTE"`
+	ReadsPerMinute  int `yaml:"READS_PER_MINUTE"`
+}
+
+type access struct {
+	Unmatched string       `yaml:"UNMATCHED"`
+	Rules     []AccessRule `yaml:"RULES"`
+}
+
+// AccessRule allows callers whose CLAIM claim holds one of VALUES to read the logs whose location starts with one of
+// LOCATIONS and whose log level is one of LEVELS. Empty LOCATIONS or LEVELS allow every location or log level.
+type AccessRule struct {
+	Claim     string   `yaml:"CLAIM"`
+	Values    []string `yaml:"VALUES"`
+	Locations []string `yaml:"LOCATIONS"`
+	Levels    []string `yaml:"LEVELS"`
+}
+
+type masking struct {
+	UnmaskedPermission string          `yaml:"UNMASKED_PERMISSION"`
+	HashKey            string          `yaml:"HASH_KEY"`
+	Policies           []MaskingPolicy `yaml:"POLICIES"`
+}
+
+// MaskingPolicy redacts or hashes the substrings of messages matching PATTERN and the values of the extra fields
+// named by EXTRA_FIELDS when logs are read, unless the caller is granted one of EXEMPT or Masking.UNMASKED_PERMISSION.
+type MaskingPolicy struct {
+	Name        string   `yaml:"NAME"`
+	Pattern     string   `yaml:"PATTERN"`
+	ExtraFields []string `yaml:"EXTRA_FIELDS"`
+	Action      string   `yaml:"ACTION"`
+	Replacement string   `yaml:"REPLACEMENT"`
+	Exempt      []string `yaml:"EXEMPT"`
+}
+
+type signing struct {
+	File               string `yaml:"FILE"`
+	Required           bool   `yaml:"REQUIRED"`
+	WindowSeconds      int    `yaml:"WINDOW_SECONDS"`
+	RotationGraceHours int    `yaml:"ROTATION_GRACE_HOURS"`
+	Permission         string `yaml:"PERMISSION"`
+	MaxBodyBytes       int64  `yaml:"MAX_BODY_BYTES"`
+}
+
+type storage struct {
+	Backend   string `yaml:"BACKEND"`
+	DSN       string `yaml:"DSN"`
+	Partition string `yaml:"PARTITION"`
+}
+
 // GetConfig reads and unmarshals a yaml file to a config.Values struct.
 //
 // Returns
@@ -1,18 +1,56 @@
 package database
 
+/*
+ *
+ * file: 		connection.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the functions used for connecting the log model to its storage backend.
+ *
+ */
+
 import (
-	"fmt"
+	"context"
+	"log"
 	"logging_service/config"
-
-	"github.com/kamva/mgm/v3"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"logging_service/models"
 )
 
+// CreateConnectionConfig connects the log model to the storage backend selected by Storage.BACKEND in the config and
+// reconciles the backend's indexes. Mongodb is used when no backend is configured. Indexes are reconciled once
+// mongodb is reachable when the service starts degraded.
 func CreateConnectionConfig() {
 	var conf = config.GetConfig()
-	var connectionString = fmt.Sprintf("mongodb+srv://%s:%s@%s/%s?retryWrites=true&w=majority", conf.Database.DatabaseUsername, conf.Database.DatabasePassword, conf.Database.DatabaseURL, conf.Database.DatabaseName)
-	err := mgm.SetDefaultConfig(nil, "LoggingService", options.Client().ApplyURI(connectionString))
-	if err != nil {
-		panic("could not establish connection to database")
+	switch conf.Storage.Backend {
+	case "", "mongo":
+		createMongoConnection(conf)
+		if conf.Storage.Partition != "" {
+			partitionedStore, err := models.NewPartitionedMongoStore(conf.Storage.Partition)
+			if err != nil {
+				panic(err.Error())
+			}
+			models.SetLogStore(partitionedStore)
+		}
+	case "file":
+		fileStore, err := NewFileStore(conf.IO.LogDirectory)
+		if err != nil {
+			panic("could not open log directory: " + err.Error())
+		}
+		models.SetLogStore(fileStore)
+	case "postgres", "sqlite":
+		sqlStore, err := NewSQLStore(conf.Storage.Backend, conf.Storage.DSN)
+		if err != nil {
+			panic("could not open sql database: " + err.Error())
+		}
+		models.SetLogStore(sqlStore)
+	default:
+		panic("unknown storage backend: " + conf.Storage.Backend)
+	}
+
+	if status, ok := GetMongoStatus(); ok && !status.Connected {
+		return
+	}
+	if err := models.ReconcileIndexes(context.Background()); err != nil {
+		log.Println("indexes: could not reconcile indexes: " + err.Error())
 	}
 }
@@ -0,0 +1,1030 @@
+package database
+
+/*
+ *
+ * file: 		file_store.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines a storage backend that writes logs to daily rotating ndjson files for each log level.
+ *
+ */
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"logging_service/core"
+	"logging_service/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// dataFileExtension is the extension of the files holding the logs, one json document per line.
+const dataFileExtension = ".ndjson"
+
+// indexFileExtension is the extension of the sidecar files indexing the data files.
+const indexFileExtension = ".idx"
+
+// iterateBatchSize is the number of logs Iterate reads at a time.
+const iterateBatchSize = 500
+
+// indexRecordSize is the size of a single index record: created at (8), id (12), offset (8), length (4).
+const indexRecordSize = 32
+
+// FileStore stores logs in daily rotating ndjson files with one directory per log level. Every data file has a sidecar
+// index holding the creation time, id and position of each log so searches and counts only read log contents when they
+// filter or sort on fields the index does not have.
+type FileStore struct {
+	directory string
+	levels    map[string]*levelFiles
+}
+
+// levelFiles guards the files of one log level. Writers hold the write lock, searches hold the read lock.
+type levelFiles struct {
+	sync.RWMutex
+	writer *dayWriter
+}
+
+// dayWriter holds the open data and index files that logs for one log level are currently appended to.
+type dayWriter struct {
+	day   string
+	data  *os.File
+	index *os.File
+	size  int64
+}
+
+// dataFiles are open data files by path without extension. Searches open a day's data file before reading its index,
+// so a data file rewritten or removed by a delete after the search has released its read lock is still read as it was
+// when the index was read.
+type dataFiles map[string]*os.File
+
+// indexEntry describes where a log is stored and the fields that can be filtered on without reading it.
+type indexEntry struct {
+	path      string
+	logLevel  string
+	createdAt time.Time
+	id        primitive.ObjectID
+	offset    int64
+	length    uint32
+}
+
+// NewFileStore creates a file storage backend in the given directory. The directory for each log level is created if
+// it does not exist and the most recent file of each level is checked for a partial write left by a crash.
+//
+// Parameters:
+//	string	directory	- Directory logs are stored in.
+//
+// Returns
+//	*FileStore	- File storage backend.
+//	error		- Any error that occurs.
+//
+func NewFileStore(directory string) (*FileStore, error) {
+	fs := &FileStore{
+		directory: directory,
+		levels:    map[string]*levelFiles{},
+	}
+
+	for _, logLevel := range core.LogLevels {
+		fs.levels[logLevel] = &levelFiles{}
+		if err := os.MkdirAll(fs.levelDirectory(logLevel), 0755); err != nil {
+			return nil, err
+		}
+
+		days, err := fs.days(logLevel)
+		if err != nil {
+			return nil, err
+		}
+		if len(days) > 0 {
+			if err := repairDay(fs.dayPath(logLevel, days[len(days)-1])); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return fs, nil
+}
+
+// Create appends a log to the data file for its log level and creation day.
+//
+// Receiver:
+//	*FileStore		fs
+//
+// Parameters:
+//	*models.Log		l	- Log to create.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (fs *FileStore) Create(ctx context.Context, l *models.Log) error {
+	level, ok := fs.levels[l.LogLevel]
+	if !ok {
+		return errors.New("unknown log level: " + l.LogLevel)
+	}
+	if l.ID.IsZero() {
+		l.ID = primitive.NewObjectID()
+	}
+
+	line, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	level.Lock()
+	defer level.Unlock()
+
+	writer, err := fs.writer(level, l.LogLevel, l.CreatedAt.UTC().Format(core.ResourceFileNameDateFormat))
+	if err != nil {
+		return err
+	}
+
+	record := encodeIndexRecord(l.CreatedAt, l.ID, writer.size, uint32(len(line)))
+	if _, err := writer.data.Write(line); err != nil {
+		return err
+	}
+	if _, err := writer.index.Write(record); err != nil {
+		return err
+	}
+	writer.size += int64(len(line))
+
+	return nil
+}
+
+// CreateMany appends logs to the data files of their log levels and days. Logs are written grouped by log level and
+// day so each data file is opened once.
+//
+// Receiver:
+//	*FileStore		fs
+//
+// Parameters:
+//	[]models.Log	logs	- Logs to create.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (fs *FileStore) CreateMany(ctx context.Context, logs []models.Log) error {
+	order := make([]int, len(logs))
+	days := make([]string, len(logs))
+	for i := range logs {
+		order[i] = i
+		days[i] = logs[i].CreatedAt.UTC().Format(core.ResourceFileNameDateFormat)
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		if logs[order[a]].LogLevel != logs[order[b]].LogLevel {
+			return logs[order[a]].LogLevel < logs[order[b]].LogLevel
+		}
+		return days[order[a]] < days[order[b]]
+	})
+
+	for _, i := range order {
+		if err := fs.Create(ctx, &logs[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Find returns one page of logs matching the search fields along with the total number of matching logs.
+//
+// Receiver:
+//	*FileStore		fs
+//
+// Parameters:
+//	models.LogSearchFields	fields	- Search fields.
+//	int64					limit	- Maximum number of logs to return.
+//
+// Returns
+//	[]models.Log	- Page of logs.
+//	int64			- Total number of logs matching the search fields.
+//	error			- Any error that occurs.
+//
+func (fs *FileStore) Find(ctx context.Context, fields models.LogSearchFields, limit int64) ([]models.Log, int64, error) {
+	entries, files, err := fs.scan(ctx, fields)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer files.close()
+
+	start := limit * fields.Page
+	if needsContent(fields) || fields.OrderBy == "location" {
+		logs, err := files.load(entries)
+		if err != nil {
+			return nil, 0, err
+		}
+		logs = filterLogs(logs, fields)
+		models.SortLogs(logs, fields.OrderBy)
+		return pageLogs(logs, start, limit), int64(len(logs)), nil
+	}
+
+	sortEntries(entries, fields.OrderBy)
+	total := int64(len(entries))
+	if start >= total {
+		return []models.Log{}, total, nil
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+
+	logs, err := files.load(entries[start:end])
+	return logs, total, err
+}
+
+// Count returns the number of logs matching the search fields. Only the indexes are read unless filtering by fields the
+// index does not have.
+//
+// Receiver:
+//	*FileStore		fs
+//
+// Parameters:
+//	models.LogSearchFields	fields	- Search fields.
+//
+// Returns
+//	int64	- Number of matching logs.
+//	error	- Any error that occurs.
+//
+func (fs *FileStore) Count(ctx context.Context, fields models.LogSearchFields) (int64, error) {
+	entries, files, err := fs.scan(ctx, fields)
+	if err != nil {
+		return 0, err
+	}
+	defer files.close()
+	if !needsContent(fields) {
+		return int64(len(entries)), nil
+	}
+
+	logs, err := files.load(entries)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(filterLogs(logs, fields))), nil
+}
+
+// CountByDates returns the number of logs matching the search fields grouped by day and log level.
+//
+// Receiver:
+//	*FileStore		fs
+//
+// Parameters:
+//	models.LogSearchFields	fields	- Search fields.
+//
+// Returns
+//	[]core.CountResultsWithDate	- Counts for each day and log level.
+//	error						- Any error that occurs.
+//
+func (fs *FileStore) CountByDates(ctx context.Context, fields models.LogSearchFields) ([]core.CountResultsWithDate, error) {
+	entries, files, err := fs.scan(ctx, fields)
+	if err != nil {
+		return nil, err
+	}
+	defer files.close()
+	if needsContent(fields) {
+		logs, err := files.load(entries)
+		if err != nil {
+			return nil, err
+		}
+		return models.GroupByDates(filterLogs(logs, fields)), nil
+	}
+
+	counter := models.NewDateCounter()
+	for _, entry := range entries {
+		counter.Add(entry.createdAt.UTC().Format(core.CreatedDayFormat), entry.logLevel, 1)
+	}
+
+	return counter.Results(), nil
+}
+
+// Delete removes the logs matching the search fields. Data files left without logs are removed, other data files are
+// rewritten without the removed logs and have their index rebuilt.
+//
+// Receiver:
+//	*FileStore		fs
+//
+// Parameters:
+//	models.LogSearchFields	fields	- Search fields.
+//
+// Returns
+//	int64	- Number of logs removed.
+//	error	- Any error that occurs.
+//
+func (fs *FileStore) Delete(ctx context.Context, fields models.LogSearchFields) (int64, error) {
+	deleted := int64(0)
+	for _, logLevel := range core.LogLevels {
+		if !fields.MatchesLogLevel(logLevel) {
+			continue
+		}
+
+		level := fs.levels[logLevel]
+		level.Lock()
+		count, err := fs.deleteFromLevel(ctx, level, logLevel, fields)
+		level.Unlock()
+		deleted += count
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// Iterate calls fn for every log matching the search fields in ascending id order. Logs are read in batches so only
+// the index entries are held in memory.
+//
+// Receiver:
+//	*FileStore		fs
+//
+// Parameters:
+//	models.LogSearchFields		fields	- Search fields.
+//	func(l *models.Log) error	fn		- Called for each log.
+//
+// Returns
+//	error - Any error that occurs, including errors returned by fn.
+//
+func (fs *FileStore) Iterate(ctx context.Context, fields models.LogSearchFields, fn func(l *models.Log) error) error {
+	entries, files, err := fs.scan(ctx, fields)
+	if err != nil {
+		return err
+	}
+	defer files.close()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].id.Hex() < entries[j].id.Hex()
+	})
+
+	for start := 0; start < len(entries); start += iterateBatchSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := start + iterateBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		logs, err := files.load(entries[start:end])
+		if err != nil {
+			return err
+		}
+		for i := range logs {
+			if !fields.Matches(&logs[i]) {
+				continue
+			}
+			if err := fn(&logs[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// UpdateDataKeys stores the key id and wrapped data key of each log by rewriting the data files holding them.
+//
+// Receiver:
+//	*FileStore		fs
+//
+// Parameters:
+//	[]models.Log	logs	- Logs with rewrapped data keys.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (fs *FileStore) UpdateDataKeys(ctx context.Context, logs []models.Log) error {
+	byDay := map[string]map[string]map[primitive.ObjectID]*models.Log{}
+	for i := range logs {
+		logLevel, day := logs[i].LogLevel, logs[i].CreatedAt.UTC().Format(core.ResourceFileNameDateFormat)
+		if byDay[logLevel] == nil {
+			byDay[logLevel] = map[string]map[primitive.ObjectID]*models.Log{}
+		}
+		if byDay[logLevel][day] == nil {
+			byDay[logLevel][day] = map[primitive.ObjectID]*models.Log{}
+		}
+		byDay[logLevel][day][logs[i].ID] = &logs[i]
+	}
+
+	for logLevel, days := range byDay {
+		level, ok := fs.levels[logLevel]
+		if !ok {
+			return errors.New("unknown log level: " + logLevel)
+		}
+		if err := fs.updateLevelDataKeys(ctx, level, logLevel, days); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes the files currently being written to.
+//
+// Receiver:
+//	*FileStore		fs
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (fs *FileStore) Close() error {
+	var err error
+	for _, level := range fs.levels {
+		level.Lock()
+		if level.writer != nil {
+			if closeErr := level.writer.close(); closeErr != nil {
+				err = closeErr
+			}
+			level.writer = nil
+		}
+		level.Unlock()
+	}
+
+	return err
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// writer returns the writer for the log level's data file of the given day, rotating to a new file when the day
+// changes. The log level's write lock must be held.
+func (fs *FileStore) writer(level *levelFiles, logLevel string, day string) (*dayWriter, error) {
+	if level.writer != nil && level.writer.day == day {
+		return level.writer, nil
+	}
+
+	if level.writer != nil {
+		level.writer.close()
+		level.writer = nil
+	}
+
+	path := fs.dayPath(logLevel, day)
+	if err := repairDay(path); err != nil {
+		return nil, err
+	}
+
+	data, err := os.OpenFile(path+dataFileExtension, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	index, err := os.OpenFile(path+indexFileExtension, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	info, err := data.Stat()
+	if err != nil {
+		data.Close()
+		index.Close()
+		return nil, err
+	}
+
+	level.writer = &dayWriter{day: day, data: data, index: index, size: info.Size()}
+	return level.writer, nil
+}
+
+// deleteFromLevel removes the logs of one log level matching the search fields. The log level's write lock must be held.
+func (fs *FileStore) deleteFromLevel(ctx context.Context, level *levelFiles, logLevel string, fields models.LogSearchFields) (int64, error) {
+	filter := newEntryFilter(fields)
+	days, err := fs.days(logLevel)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := int64(0)
+	for _, day := range days {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		if !filter.dayInRange(day) {
+			continue
+		}
+
+		path := fs.dayPath(logLevel, day)
+		dayEntries, err := readIndex(path, logLevel)
+		if err != nil {
+			return deleted, err
+		}
+		matching := []indexEntry{}
+		for _, entry := range dayEntries {
+			if filter.matches(entry) {
+				matching = append(matching, entry)
+			}
+		}
+		if needsContent(fields) && len(matching) > 0 {
+			files := dataFiles{}
+			logs, err := files.load(matching)
+			files.close()
+			if err != nil {
+				return deleted, err
+			}
+			located := []indexEntry{}
+			for i := range logs {
+				if fields.Matches(&logs[i]) {
+					located = append(located, matching[i])
+				}
+			}
+			matching = located
+		}
+		if len(matching) == 0 {
+			continue
+		}
+
+		if level.writer != nil && level.writer.day == day {
+			level.writer.close()
+			level.writer = nil
+		}
+		if len(matching) == len(dayEntries) {
+			if err := os.Remove(path + dataFileExtension); err != nil {
+				return deleted, err
+			}
+			if err := os.Remove(path + indexFileExtension); err != nil && !os.IsNotExist(err) {
+				return deleted, err
+			}
+		} else {
+			removed := map[int64][]byte{}
+			for _, entry := range matching {
+				removed[entry.offset] = nil
+			}
+			if err := rewriteDay(path, removed); err != nil {
+				return deleted, err
+			}
+		}
+		deleted += int64(len(matching))
+	}
+
+	return deleted, nil
+}
+
+// updateLevelDataKeys rewrites the data files of a log level holding logs with rewrapped data keys.
+func (fs *FileStore) updateLevelDataKeys(ctx context.Context, level *levelFiles, logLevel string, days map[string]map[primitive.ObjectID]*models.Log) error {
+	level.Lock()
+	defer level.Unlock()
+
+	for day, updated := range days {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		path := fs.dayPath(logLevel, day)
+		dayEntries, err := readIndex(path, logLevel)
+		if err != nil {
+			return err
+		}
+		matching := []indexEntry{}
+		for _, entry := range dayEntries {
+			if _, ok := updated[entry.id]; ok {
+				matching = append(matching, entry)
+			}
+		}
+		if len(matching) == 0 {
+			continue
+		}
+		files := dataFiles{}
+		stored, err := files.load(matching)
+		files.close()
+		if err != nil {
+			return err
+		}
+
+		replacements := map[int64][]byte{}
+		for i := range stored {
+			stored[i].KeyID = updated[stored[i].ID].KeyID
+			stored[i].DataKey = updated[stored[i].ID].DataKey
+			line, err := json.Marshal(&stored[i])
+			if err != nil {
+				return err
+			}
+			replacements[matching[i].offset] = append(line, '\n')
+		}
+
+		if level.writer != nil && level.writer.day == day {
+			level.writer.close()
+			level.writer = nil
+		}
+		if err := rewriteDay(path, replacements); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scan reads the indexes of every data file that can hold logs matching the search fields and returns the entries
+// matching the log level, date and id conditions, along with the data files holding them, opened while the log
+// level's read lock is held. The data files must be closed once the logs have been loaded.
+func (fs *FileStore) scan(ctx context.Context, fields models.LogSearchFields) ([]indexEntry, dataFiles, error) {
+	filter := newEntryFilter(fields)
+	entries := []indexEntry{}
+	files := dataFiles{}
+
+	for _, logLevel := range core.LogLevels {
+		if !fields.MatchesLogLevel(logLevel) {
+			continue
+		}
+
+		level := fs.levels[logLevel]
+		level.RLock()
+		err := fs.scanLevel(ctx, logLevel, filter, &entries, files)
+		level.RUnlock()
+		if err != nil {
+			files.close()
+			return nil, nil, err
+		}
+	}
+
+	return entries, files, nil
+}
+
+// scanLevel appends the matching entries of a log level's indexes and opens the data files holding them. The log
+// level's read lock must be held.
+func (fs *FileStore) scanLevel(ctx context.Context, logLevel string, filter entryFilter, entries *[]indexEntry, files dataFiles) error {
+	days, err := fs.days(logLevel)
+	if err != nil {
+		return err
+	}
+
+	for _, day := range days {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !filter.dayInRange(day) {
+			continue
+		}
+
+		// The data file is opened before its index is read, so every index record points into the opened file.
+		path := fs.dayPath(logLevel, day)
+		file, err := os.Open(path + dataFileExtension)
+		if err != nil {
+			return err
+		}
+		dayEntries, err := readIndex(path, logLevel)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		matched := false
+		for _, entry := range dayEntries {
+			if filter.matches(entry) {
+				*entries = append(*entries, entry)
+				matched = true
+			}
+		}
+		if matched {
+			files[path] = file
+		} else {
+			file.Close()
+		}
+	}
+
+	return nil
+}
+
+// load reads the logs the index entries point to, in the order of the entries. Data files that are not open yet are
+// opened, which is only consistent with the entries while the log level's lock is held.
+func (df dataFiles) load(entries []indexEntry) ([]models.Log, error) {
+	logs := make([]models.Log, len(entries))
+	for i, entry := range entries {
+		file, ok := df[entry.path]
+		if !ok {
+			var err error
+			file, err = os.Open(entry.path + dataFileExtension)
+			if err != nil {
+				return nil, err
+			}
+			df[entry.path] = file
+		}
+
+		// Logs are only appended to an open data file. Deletes and key rewraps write a new file in its place, so the
+		// bytes of an entry read along with the file's index never change.
+		line := make([]byte, entry.length)
+		_, err := file.ReadAt(line, entry.offset)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(line, &logs[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return logs, nil
+}
+
+// close closes the data files.
+func (df dataFiles) close() {
+	for _, file := range df {
+		file.Close()
+	}
+}
+
+// days returns the days the log level has data files for in ascending order.
+func (fs *FileStore) days(logLevel string) ([]string, error) {
+	files, err := ioutil.ReadDir(fs.levelDirectory(logLevel))
+	if err != nil {
+		return nil, err
+	}
+
+	days := []string{}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), dataFileExtension) {
+			days = append(days, strings.TrimSuffix(file.Name(), dataFileExtension))
+		}
+	}
+	sort.Strings(days)
+
+	return days, nil
+}
+
+// levelDirectory returns the directory the log level's files are stored in.
+func (fs *FileStore) levelDirectory(logLevel string) string {
+	return filepath.Join(fs.directory, logLevel)
+}
+
+// dayPath returns the path of the log level's files for the day without a file extension.
+func (fs *FileStore) dayPath(logLevel string, day string) string {
+	return filepath.Join(fs.levelDirectory(logLevel), day)
+}
+
+// close closes the writer's files.
+func (dw *dayWriter) close() error {
+	dataErr := dw.data.Close()
+	if err := dw.index.Close(); err != nil {
+		return err
+	}
+
+	return dataErr
+}
+
+// entryFilter evaluates the date and id conditions of search fields against index entries.
+type entryFilter struct {
+	from     time.Time
+	to       time.Time
+	hasRange bool
+	id       primitive.ObjectID
+	ids      map[primitive.ObjectID]bool
+	afterID  primitive.ObjectID
+}
+
+// newEntryFilter creates an entry filter for the search fields.
+func newEntryFilter(fields models.LogSearchFields) entryFilter {
+	filter := entryFilter{id: fields.ID, afterID: fields.AfterID}
+	filter.from, filter.to, filter.hasRange = fields.TimeRange()
+	if fields.IDs != nil {
+		filter.ids = map[primitive.ObjectID]bool{}
+		for _, id := range fields.IDs {
+			filter.ids[id] = true
+		}
+	}
+
+	return filter
+}
+
+// dayInRange reports whether a data file's day can hold logs created within the filter's range.
+func (ef entryFilter) dayInRange(day string) bool {
+	dayStart, err := time.Parse(core.ResourceFileNameDateFormat, day)
+	if err != nil {
+		return false
+	}
+
+	return !ef.hasRange || (!dayStart.After(ef.to) && dayStart.Add(24*time.Hour).After(ef.from))
+}
+
+// matches reports whether an index entry satisfies the filter.
+func (ef entryFilter) matches(entry indexEntry) bool {
+	if ef.hasRange && (entry.createdAt.Before(ef.from) || entry.createdAt.After(ef.to)) {
+		return false
+	}
+	if ef.ids != nil && !ef.ids[entry.id] {
+		return false
+	}
+	if !ef.afterID.IsZero() && bytes.Compare(entry.id[:], ef.afterID[:]) <= 0 {
+		return false
+	}
+
+	return ef.id.IsZero() || entry.id == ef.id
+}
+
+// readIndex reads every complete record of a day's index. Records pointing past the end of the data file, which can
+// be left by an interrupted write, are ignored.
+func readIndex(path string, logLevel string) ([]indexEntry, error) {
+	content, err := ioutil.ReadFile(path + indexFileExtension)
+	if os.IsNotExist(err) {
+		return []indexEntry{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path + dataFileExtension)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]indexEntry, 0, len(content)/indexRecordSize)
+	for i := 0; i+indexRecordSize <= len(content); i += indexRecordSize {
+		entry := decodeIndexRecord(content[i : i+indexRecordSize])
+		if entry.offset+int64(entry.length) > info.Size() {
+			break
+		}
+		entry.path = path
+		entry.logLevel = logLevel
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// repairDay makes a day's data and index files consistent after an interrupted write. A trailing partial line is
+// removed from the data file and the index is rebuilt if it does not cover the data file exactly.
+func repairDay(path string) error {
+	info, err := os.Stat(path + dataFileExtension)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadFile(path + indexFileExtension)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(content)%indexRecordSize == 0 {
+		end := int64(0)
+		if len(content) > 0 {
+			last := decodeIndexRecord(content[len(content)-indexRecordSize:])
+			end = last.offset + int64(last.length)
+		}
+		if end == info.Size() {
+			return nil
+		}
+	}
+
+	return rebuildIndex(path)
+}
+
+// rebuildIndex rewrites a day's index from its data file.
+func rebuildIndex(path string) error {
+	data, err := os.OpenFile(path+dataFileExtension, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	index, err := os.Create(path + indexFileExtension)
+	if err != nil {
+		return err
+	}
+	defer index.Close()
+
+	reader := bufio.NewReader(data)
+	indexWriter := bufio.NewWriter(index)
+	offset := int64(0)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Drop a partial line so the next write starts on its own line.
+			if err := data.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		} else if err != nil {
+			return err
+		}
+
+		l := models.Log{}
+		if err := json.Unmarshal(line, &l); err == nil {
+			if _, err := indexWriter.Write(encodeIndexRecord(l.CreatedAt, l.ID, offset, uint32(len(line)))); err != nil {
+				return err
+			}
+		}
+		offset += int64(len(line))
+	}
+
+	return indexWriter.Flush()
+}
+
+// rewriteDay rewrites a day's data file and rebuilds its index. Lines are replaced by offset, and a nil replacement
+// removes the line.
+func rewriteDay(path string, replacements map[int64][]byte) error {
+	data, err := os.Open(path + dataFileExtension)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	temporary, err := os.Create(path + dataFileExtension + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(data)
+	writer := bufio.NewWriter(temporary)
+	offset := int64(0)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			temporary.Close()
+			return err
+		}
+		written := line
+		if replacement, ok := replacements[offset]; ok {
+			written = replacement
+		}
+		if _, err := writer.Write(written); err != nil {
+			temporary.Close()
+			return err
+		}
+		offset += int64(len(line))
+	}
+
+	if err := writer.Flush(); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+dataFileExtension+".tmp", path+dataFileExtension); err != nil {
+		return err
+	}
+
+	return rebuildIndex(path)
+}
+
+// encodeIndexRecord encodes an index record.
+func encodeIndexRecord(createdAt time.Time, id primitive.ObjectID, offset int64, length uint32) []byte {
+	record := make([]byte, indexRecordSize)
+	binary.BigEndian.PutUint64(record[0:8], uint64(createdAt.UnixNano()))
+	copy(record[8:20], id[:])
+	binary.BigEndian.PutUint64(record[20:28], uint64(offset))
+	binary.BigEndian.PutUint32(record[28:32], length)
+	return record
+}
+
+// decodeIndexRecord decodes an index record.
+func decodeIndexRecord(record []byte) indexEntry {
+	entry := indexEntry{}
+	entry.createdAt = time.Unix(0, int64(binary.BigEndian.Uint64(record[0:8]))).UTC()
+	copy(entry.id[:], record[8:20])
+	entry.offset = int64(binary.BigEndian.Uint64(record[20:28]))
+	entry.length = binary.BigEndian.Uint32(record[28:32])
+	return entry
+}
+
+// sortEntries sorts index entries the same way models.SortLogs sorts logs.
+func sortEntries(entries []indexEntry, orderBy string) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		switch orderBy {
+		case "created_at":
+			return entries[i].createdAt.After(entries[j].createdAt)
+		case "log_level":
+			return entries[i].logLevel > entries[j].logLevel
+		case "id":
+			return entries[i].id.Hex() > entries[j].id.Hex()
+		default:
+			return entries[i].createdAt.Before(entries[j].createdAt)
+		}
+	})
+}
+
+// needsContent reports whether the search fields filter on fields that are not in the index.
+func needsContent(fields models.LogSearchFields) bool {
+	return fields.HasLocationFilter() || fields.HasRestoredFilter() || fields.Chained || fields.NotKeyID != "" ||
+		len(fields.ExcludedHolds) > 0 || fields.Tenant != "" || fields.Grants != nil
+}
+
+// filterLogs returns the logs matching the search fields.
+func filterLogs(logs []models.Log, fields models.LogSearchFields) []models.Log {
+	matching := []models.Log{}
+	for i := range logs {
+		if fields.Matches(&logs[i]) {
+			matching = append(matching, logs[i])
+		}
+	}
+
+	return matching
+}
+
+// pageLogs returns the logs from start up to limit logs.
+func pageLogs(logs []models.Log, start int64, limit int64) []models.Log {
+	total := int64(len(logs))
+	if start >= total {
+		return []models.Log{}
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+
+	return logs[start:end]
+}
@@ -0,0 +1,60 @@
+package database
+
+/*
+ *
+ * file: 		file_store_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests the file storage backend.
+ *
+ */
+
+import (
+	"context"
+	"logging_service/models"
+	"testing"
+	"time"
+)
+
+func TestFileStoreLoadAfterConcurrentDelete(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	ctx := context.Background()
+	logs := []models.Log{
+		testLog("INFO", "billing", "a", 0),
+		testLog("INFO", "shipping", "b", time.Hour),
+		testLog("INFO", "billing", "c", 2*time.Hour),
+	}
+	if err := fs.CreateMany(ctx, logs); err != nil {
+		t.Fatal(err)
+	}
+
+	// A delete rewriting the day file between a search's scan and load must not change what the search reads.
+	entries, files, err := fs.scan(ctx, models.LogSearchFields{LogLevel: "INFO"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files.close()
+	if deleted, err := fs.Delete(ctx, models.LogSearchFields{LogLevel: "INFO", Location: "billing"}); err != nil || deleted != 2 {
+		t.Fatalf("deleted %d logs, %v, want 2", deleted, err)
+	}
+
+	loaded, err := files.load(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messagesOf(loaded); !equalStrings(got, []string{"a", "b", "c"}) {
+		t.Errorf("loaded %v, want [a b c]", got)
+	}
+
+	found, total, err := fs.Find(ctx, models.LogSearchFields{LogLevel: "INFO"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messagesOf(found); !equalStrings(got, []string{"b"}) || total != 1 {
+		t.Errorf("found %v of %d after the delete, want [b]", got, total)
+	}
+}
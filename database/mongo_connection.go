@@ -0,0 +1,358 @@
+package database
+
+/*
+ *
+ * file: 		mongo_connection.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the mongodb connection settings, startup retries and reconnection.
+ *
+ */
+
+import (
+	"context"
+	"errors"
+	"log"
+	"logging_service/config"
+	"logging_service/core"
+	"logging_service/models"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// mongoDatabase is the database logs are stored in.
+const mongoDatabase = "LoggingService"
+
+// defaultStartupRetries is used when Database.STARTUP_RETRIES is not set.
+const defaultStartupRetries = 5
+
+// defaultOperationTimeout is used when Database.OPERATION_TIMEOUT_SECONDS is not set.
+const defaultOperationTimeout = 10 * time.Second
+
+// defaultReconnectInterval is used when Database.RECONNECT_INTERVAL_SECONDS is not set.
+const defaultReconnectInterval = 30 * time.Second
+
+// maxStartupBackoff is the longest wait between startup attempts.
+const maxStartupBackoff = 30 * time.Second
+
+// MongoStatus describes the mongodb connection.
+type MongoStatus struct {
+	Connected     bool       `json:"connected"`
+	URI           string     `json:"uri"`
+	Attempts      int        `json:"attempts"`
+	ConnectedAt   *time.Time `json:"connected_at,omitempty"`
+	LastCheckedAt *time.Time `json:"last_checked_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+var mongoMutex sync.RWMutex
+var mongoStatus *MongoStatus
+var mongoConfigured bool
+
+// GetMongoStatus returns the mongodb connection status.
+//
+// Returns
+//	MongoStatus	- Connection status.
+//	bool		- False if the mongodb backend is not used.
+//
+func GetMongoStatus() (MongoStatus, bool) {
+	mongoMutex.RLock()
+	defer mongoMutex.RUnlock()
+	if mongoStatus == nil {
+		return MongoStatus{}, false
+	}
+
+	return *mongoStatus, true
+}
+
+// MongoURI returns the connection string for the Database config. Database.URI is used as given when it is set,
+// otherwise the connection string is built from the host, credentials and connection settings.
+//
+// Parameters:
+//	config.Values	conf	- Config values.
+//
+// Returns
+//	string	- Connection string.
+//	error	- Error if the settings are not valid.
+//
+func MongoURI(conf config.Values) (string, error) {
+	db := conf.Database
+	if db.URI != "" {
+		return db.URI, nil
+	}
+	if db.DatabaseURL == "" {
+		return "", errors.New("mongodb: Database.URI or Database.DATABASE_URL is required")
+	}
+
+	scheme := db.Scheme
+	if scheme == "" {
+		scheme = "mongodb+srv"
+	} else if scheme != "mongodb" && scheme != "mongodb+srv" {
+		return "", errors.New("mongodb: Database.SCHEME must be mongodb or mongodb+srv")
+	}
+
+	uri := url.URL{Scheme: scheme, Host: db.DatabaseURL, Path: "/" + db.DatabaseName}
+	if db.DatabaseUsername != "" {
+		uri.User = url.UserPassword(db.DatabaseUsername, db.DatabasePassword)
+	}
+
+	query := url.Values{}
+	query.Set("retryWrites", "true")
+	query.Set("w", "majority")
+	setQuery(query, "authSource", db.AuthSource)
+	setQuery(query, "replicaSet", db.ReplicaSet)
+	if db.TLS || db.TLSCAFile != "" || db.TLSCertificateKeyFile != "" {
+		query.Set("tls", "true")
+	}
+	setQuery(query, "tlsCAFile", db.TLSCAFile)
+	setQuery(query, "tlsCertificateKeyFile", db.TLSCertificateKeyFile)
+	setQueryInt(query, "maxPoolSize", db.MaxPoolSize, 1)
+	setQueryInt(query, "minPoolSize", db.MinPoolSize, 1)
+	setQueryInt(query, "connectTimeoutMS", db.ConnectTimeoutSeconds, 1000)
+	setQueryInt(query, "serverSelectionTimeoutMS", db.ServerSelectionTimeoutSeconds, 1000)
+	setQueryInt(query, "socketTimeoutMS", db.SocketTimeoutSeconds, 1000)
+	uri.RawQuery = query.Encode()
+
+	return uri.String(), nil
+}
+
+// ParseWriteConcern parses a write concern setting: majority, a number of members, or unacknowledged (also 0) for
+// fire and forget writes.
+//
+// Parameters:
+//	string			value	- Write concern setting.
+//	bool			journal	- Whether acknowledged writes wait for the journal.
+//	time.Duration	timeout	- How long acknowledged writes wait, zero to wait indefinitely.
+//
+// Returns
+//	*writeconcern.WriteConcern	- Write concern.
+//	error						- Error if the setting is not valid.
+//
+func ParseWriteConcern(value string, journal bool, timeout time.Duration) (*writeconcern.WriteConcern, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "unacknowledged" || value == "0" {
+		return writeconcern.New(writeconcern.W(0)), nil
+	}
+
+	opts := []writeconcern.Option{}
+	if value == "majority" {
+		opts = append(opts, writeconcern.WMajority())
+	} else if members, err := strconv.Atoi(value); err == nil && members > 0 {
+		opts = append(opts, writeconcern.W(members))
+	} else {
+		return nil, errors.New("mongodb: write concern " + value + " must be majority, a number or unacknowledged")
+	}
+	if journal {
+		opts = append(opts, writeconcern.J(true))
+	}
+	if timeout > 0 {
+		opts = append(opts, writeconcern.WTimeout(timeout))
+	}
+
+	return writeconcern.New(opts...), nil
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// configureMongoOptions sets the write concern of each log level and the read preference of searches and counts.
+func configureMongoOptions(conf config.Values) error {
+	db := conf.Database
+	timeout := time.Duration(db.WriteTimeoutSeconds) * time.Second
+	concerns := map[string]*writeconcern.WriteConcern{}
+	if db.WriteConcern != "" {
+		concern, err := ParseWriteConcern(db.WriteConcern, db.WriteJournal, timeout)
+		if err != nil {
+			return err
+		}
+		for _, logLevel := range core.LogLevels {
+			concerns[logLevel] = concern
+		}
+	}
+	for logLevel, value := range db.LevelWriteConcerns {
+		logLevel = strings.ToUpper(logLevel)
+		if valid, all := models.IsValidLogLevel(logLevel); !valid || all || logLevel == "" {
+			return errors.New("mongodb: unknown log level " + logLevel + " in Database.LEVEL_WRITE_CONCERNS")
+		}
+		if value == "" {
+			continue
+		}
+		concern, err := ParseWriteConcern(value, db.WriteJournal, timeout)
+		if err != nil {
+			return err
+		}
+		concerns[logLevel] = concern
+	}
+	models.SetWriteConcerns(concerns)
+
+	if db.ReadPreference == "" {
+		models.SetReadPreference(nil)
+		return nil
+	}
+	mode, err := readpref.ModeFromString(db.ReadPreference)
+	if err != nil {
+		return errors.New("mongodb: " + err.Error())
+	}
+	opts := []readpref.Option{}
+	if db.MaxStalenessSeconds > 0 {
+		opts = append(opts, readpref.WithMaxStaleness(time.Duration(db.MaxStalenessSeconds)*time.Second))
+	}
+	rp, err := readpref.New(mode, opts...)
+	if err != nil {
+		return errors.New("mongodb: " + err.Error())
+	}
+	models.SetReadPreference(rp)
+
+	return nil
+}
+
+// createMongoConnection connects to mongodb, retrying with backoff. The service starts degraded if mongodb cannot be
+// reached. Either way mongodb is pinged in the background once every reconnect interval, so the status follows the
+// connection being lost and regained. Settings that are not valid stop the service.
+func createMongoConnection(conf config.Values) {
+	uri, err := MongoURI(conf)
+	if err != nil {
+		panic(err.Error())
+	}
+	if err := configureMongoOptions(conf); err != nil {
+		panic(err.Error())
+	}
+
+	redacted := redactURI(uri)
+	mongoMutex.Lock()
+	mongoStatus = &MongoStatus{URI: redacted}
+	mongoMutex.Unlock()
+	log.Println("mongodb: connecting to " + redacted)
+
+	interval := time.Duration(conf.Database.ReconnectIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultReconnectInterval
+	}
+	retries := conf.Database.StartupRetries
+	if retries <= 0 {
+		retries = defaultStartupRetries
+	}
+	backoff := time.Second
+	for attempt := 1; attempt <= retries; attempt++ {
+		if err = connectMongo(conf, uri); err == nil {
+			go monitorMongo(conf, uri, interval)
+			return
+		}
+		log.Printf("mongodb: attempt %d of %d failed: %s\n", attempt, retries, err.Error())
+		if attempt == retries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxStartupBackoff {
+			backoff = maxStartupBackoff
+		}
+	}
+
+	log.Printf("mongodb: starting degraded, reconnecting every %s\n", interval)
+	go monitorMongo(conf, uri, interval)
+}
+
+// connectMongo configures the mongodb client if it has not been configured and pings the primary, recording whether
+// it answered. Attempts count the pings made while disconnected.
+func connectMongo(conf config.Values, uri string) error {
+	timeout := time.Duration(conf.Database.OperationTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultOperationTimeout
+	}
+
+	err := error(nil)
+	if !mongoConfigured {
+		if err = mgm.SetDefaultConfig(&mgm.Config{CtxTimeout: timeout}, mongoDatabase, options.Client().ApplyURI(uri)); err == nil {
+			mongoConfigured = true
+		}
+	}
+	if err == nil {
+		_, client, _, _ := mgm.DefaultConfigs()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err = client.Ping(ctx, readpref.Primary())
+		cancel()
+	}
+
+	mongoMutex.Lock()
+	checkedAt := time.Now().UTC()
+	wasConnected := mongoStatus.Connected
+	if !wasConnected {
+		mongoStatus.Attempts++
+	}
+	mongoStatus.LastCheckedAt = &checkedAt
+	mongoStatus.Connected = err == nil
+	mongoStatus.LastError = ""
+	if err != nil {
+		mongoStatus.LastError = err.Error()
+	} else if !wasConnected {
+		mongoStatus.ConnectedAt = &checkedAt
+	}
+	mongoMutex.Unlock()
+
+	if err == nil && !wasConnected {
+		log.Println("mongodb: connected")
+	}
+	return err
+}
+
+// monitorMongo pings mongodb once every interval, so the health check reports a lost connection, and reconciles the
+// indexes each time the connection is regained since they may not have been reconciled while it was down.
+func monitorMongo(conf config.Values, uri string, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		status, _ := GetMongoStatus()
+		if err := connectMongo(conf, uri); err != nil {
+			if status.Connected {
+				log.Println("mongodb: connection lost: " + err.Error())
+			} else {
+				log.Println("mongodb: reconnect failed: " + err.Error())
+			}
+			continue
+		}
+
+		if !status.Connected {
+			if err := models.ReconcileIndexes(context.Background()); err != nil {
+				log.Println("indexes: could not reconcile indexes: " + err.Error())
+			}
+		}
+	}
+}
+
+// redactURI hides the password of a connection string so it can be logged.
+func redactURI(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "(connection string could not be parsed)"
+	}
+	if _, ok := parsed.User.Password(); ok {
+		parsed.User = url.UserPassword(parsed.User.Username(), "xxxxx")
+	}
+
+	return parsed.String()
+}
+
+// setQuery sets a connection string option when it has a value.
+func setQuery(query url.Values, key string, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
+
+// setQueryInt sets a numeric connection string option, multiplied by a unit, when it is positive.
+func setQueryInt(query url.Values, key string, value int, unit int) {
+	if value > 0 {
+		query.Set(key, strconv.Itoa(value*unit))
+	}
+}
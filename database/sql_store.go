@@ -0,0 +1,666 @@
+package database
+
+/*
+ *
+ * file: 		sql_store.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines a storage backend that stores logs in PostgreSQL or SQLite.
+ *
+ */
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"logging_service/core"
+	"logging_service/models"
+	"strconv"
+	"strings"
+	"time"
+
+	// Registers the postgres and sqlite3 database/sql drivers.
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sqlDialect defines the differences between the supported sql databases.
+type sqlDialect struct {
+	driver string
+
+	// migrations are applied in order, the version of a migration is its index plus one.
+	migrations []string
+
+	// dayExpression formats the created_at column as a core.CreatedDayFormat date.
+	dayExpression string
+
+	// numberedPlaceholders is true when placeholders are written $1, $2... instead of ?.
+	numberedPlaceholders bool
+}
+
+var postgresDialect = sqlDialect{
+	driver: "postgres",
+	migrations: []string{
+		`CREATE TABLE logs (
+			id CHAR(24) PRIMARY KEY,
+			created_at TIMESTAMPTZ NOT NULL,
+			updated_at TIMESTAMPTZ,
+			log_level VARCHAR(16) NOT NULL,
+			message TEXT NOT NULL,
+			extra JSONB,
+			location TEXT NOT NULL
+		);
+		CREATE INDEX logs_created_at ON logs (created_at);
+		CREATE INDEX logs_log_level_created_at ON logs (log_level, created_at);
+		CREATE INDEX logs_location_created_at ON logs (location, created_at);`,
+		`ALTER TABLE logs ADD COLUMN restored_at TIMESTAMPTZ;`,
+		`ALTER TABLE logs ADD COLUMN sequence BIGINT;
+		ALTER TABLE logs ADD COLUMN prev_hash TEXT;
+		ALTER TABLE logs ADD COLUMN hash TEXT;`,
+		`ALTER TABLE logs ADD COLUMN key_id TEXT;
+		ALTER TABLE logs ADD COLUMN data_key TEXT;
+		ALTER TABLE logs ADD COLUMN ciphertext TEXT;`,
+		`ALTER TABLE logs ADD COLUMN tenant TEXT;
+		CREATE INDEX logs_tenant_created_at ON logs (tenant, created_at);`,
+	},
+	dayExpression:        "to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD')",
+	numberedPlaceholders: true,
+}
+
+var sqliteDialect = sqlDialect{
+	driver: "sqlite3",
+	migrations: []string{
+		`CREATE TABLE logs (
+			id CHAR(24) PRIMARY KEY,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP,
+			log_level VARCHAR(16) NOT NULL,
+			message TEXT NOT NULL,
+			extra TEXT CHECK (extra IS NULL OR json_valid(extra)),
+			location TEXT NOT NULL
+		);
+		CREATE INDEX logs_created_at ON logs (created_at);
+		CREATE INDEX logs_log_level_created_at ON logs (log_level, created_at);
+		CREATE INDEX logs_location_created_at ON logs (location, created_at);`,
+		`ALTER TABLE logs ADD COLUMN restored_at TIMESTAMP;`,
+		`ALTER TABLE logs ADD COLUMN sequence BIGINT;
+		ALTER TABLE logs ADD COLUMN prev_hash TEXT;
+		ALTER TABLE logs ADD COLUMN hash TEXT;`,
+		`ALTER TABLE logs ADD COLUMN key_id TEXT;
+		ALTER TABLE logs ADD COLUMN data_key TEXT;
+		ALTER TABLE logs ADD COLUMN ciphertext TEXT;`,
+		`ALTER TABLE logs ADD COLUMN tenant TEXT;
+		CREATE INDEX logs_tenant_created_at ON logs (tenant, created_at);`,
+	},
+	// Times are always stored in UTC, so the date is the start of the stored text.
+	dayExpression:        "substr(created_at, 1, 10)",
+	numberedPlaceholders: false,
+}
+
+// logColumns are the columns scanLog reads.
+const logColumns = "id, created_at, log_level, message, extra, location, restored_at, sequence, prev_hash, hash, key_id, data_key, ciphertext, tenant"
+
+// insertLogQuery inserts one log, taking the arguments returned by insertArgs.
+const insertLogQuery = "INSERT INTO logs (" + logColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+// SQLStore stores logs in a PostgreSQL or SQLite logs table. Extra fields are stored as a JSONB column in PostgreSQL
+// and as a JSON1 validated text column in SQLite.
+type SQLStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// NewSQLStore opens a sql storage backend and applies any schema migrations that have not been applied yet.
+//
+// Parameters:
+//	string	backend	- Either 'postgres' or 'sqlite'.
+//	string	dsn		- Data source name passed to the database driver.
+//
+// Returns
+//	*SQLStore	- SQL storage backend.
+//	error		- Any error that occurs.
+//
+func NewSQLStore(backend string, dsn string) (*SQLStore, error) {
+	var dialect sqlDialect
+	switch backend {
+	case "postgres":
+		dialect = postgresDialect
+	case "sqlite":
+		dialect = sqliteDialect
+	default:
+		return nil, errors.New("unknown sql backend: " + backend)
+	}
+
+	db, err := sql.Open(dialect.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dialect.driver == "sqlite3" {
+		// SQLite only allows one writer at a time.
+		db.SetMaxOpenConns(1)
+	}
+
+	ss := &SQLStore{db: db, dialect: dialect}
+	if err := ss.migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return ss, nil
+}
+
+// Create inserts a log into the logs table.
+//
+// Receiver:
+//	*SQLStore		ss
+//
+// Parameters:
+//	*models.Log		l	- Log to create.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (ss *SQLStore) Create(ctx context.Context, l *models.Log) error {
+	args, err := insertArgs(l)
+	if err != nil {
+		return err
+	}
+
+	_, err = ss.db.ExecContext(ctx, ss.rebind(insertLogQuery), args...)
+	return err
+}
+
+// CreateMany inserts logs into the logs table in one transaction.
+//
+// Receiver:
+//	*SQLStore		ss
+//
+// Parameters:
+//	[]models.Log	logs	- Logs to create.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (ss *SQLStore) CreateMany(ctx context.Context, logs []models.Log) error {
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	statement, err := tx.PrepareContext(ctx, ss.rebind(insertLogQuery))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer statement.Close()
+
+	for i := range logs {
+		args, err := insertArgs(&logs[i])
+		if err == nil {
+			_, err = statement.ExecContext(ctx, args...)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Find returns one page of logs matching the search fields along with the total number of matching logs.
+//
+// Receiver:
+//	*SQLStore		ss
+//
+// Parameters:
+//	models.LogSearchFields	fields	- Search fields.
+//	int64					limit	- Maximum number of logs to return.
+//
+// Returns
+//	[]models.Log	- Page of logs.
+//	int64			- Total number of logs matching the search fields.
+//	error			- Any error that occurs.
+//
+func (ss *SQLStore) Find(ctx context.Context, fields models.LogSearchFields, limit int64) ([]models.Log, int64, error) {
+	where, args := whereClause(fields)
+	query := "SELECT " + logColumns + " FROM logs" + where +
+		" ORDER BY " + orderByClause(fields.OrderBy) + " LIMIT ? OFFSET ?"
+	rows, err := ss.db.QueryContext(ctx, ss.rebind(query), append(args, limit, limit*fields.Page)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	logs := []models.Log{}
+	for rows.Next() {
+		l, err := scanLog(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		logs = append(logs, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := ss.Count(ctx, fields)
+	return logs, total, err
+}
+
+// Count returns the number of logs matching the search fields.
+//
+// Receiver:
+//	*SQLStore		ss
+//
+// Parameters:
+//	models.LogSearchFields	fields	- Search fields.
+//
+// Returns
+//	int64	- Number of matching logs.
+//	error	- Any error that occurs.
+//
+func (ss *SQLStore) Count(ctx context.Context, fields models.LogSearchFields) (int64, error) {
+	where, args := whereClause(fields)
+	var count int64
+	err := ss.db.QueryRowContext(ctx, ss.rebind("SELECT COUNT(*) FROM logs"+where), args...).Scan(&count)
+	return count, err
+}
+
+// CountByDates returns the number of logs matching the search fields grouped by day and log level.
+//
+// Receiver:
+//	*SQLStore		ss
+//
+// Parameters:
+//	models.LogSearchFields	fields	- Search fields.
+//
+// Returns
+//	[]core.CountResultsWithDate	- Counts for each day and log level.
+//	error						- Any error that occurs.
+//
+func (ss *SQLStore) CountByDates(ctx context.Context, fields models.LogSearchFields) ([]core.CountResultsWithDate, error) {
+	where, args := whereClause(fields)
+	query := "SELECT " + ss.dialect.dayExpression + " AS day, log_level, COUNT(*) FROM logs" + where +
+		" GROUP BY day, log_level ORDER BY day, log_level"
+	rows, err := ss.db.QueryContext(ctx, ss.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []core.CountResultsWithDate{}
+	for rows.Next() {
+		count := core.CountResultsWithDate{}
+		if err := rows.Scan(&count.ID.Date, &count.ID.LogLevel, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
+
+// Delete removes the logs matching the search fields from the logs table.
+//
+// Receiver:
+//	*SQLStore		ss
+//
+// Parameters:
+//	models.LogSearchFields	fields	- Search fields.
+//
+// Returns
+//	int64	- Number of logs removed.
+//	error	- Any error that occurs.
+//
+func (ss *SQLStore) Delete(ctx context.Context, fields models.LogSearchFields) (int64, error) {
+	where, args := whereClause(fields)
+	result, err := ss.db.ExecContext(ctx, ss.rebind("DELETE FROM logs"+where), args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Iterate calls fn for every log matching the search fields in ascending id order.
+//
+// Receiver:
+//	*SQLStore		ss
+//
+// Parameters:
+//	models.LogSearchFields		fields	- Search fields.
+//	func(l *models.Log) error	fn		- Called for each log.
+//
+// Returns
+//	error - Any error that occurs, including errors returned by fn.
+//
+func (ss *SQLStore) Iterate(ctx context.Context, fields models.LogSearchFields, fn func(l *models.Log) error) error {
+	where, args := whereClause(fields)
+	rows, err := ss.db.QueryContext(ctx, ss.rebind("SELECT "+logColumns+" FROM logs"+where+" ORDER BY id"), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		l, err := scanLog(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(&l); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// UpdateDataKeys stores the key id and wrapped data key of each log in one transaction.
+//
+// Receiver:
+//	*SQLStore		ss
+//
+// Parameters:
+//	[]models.Log	logs	- Logs with rewrapped data keys.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (ss *SQLStore) UpdateDataKeys(ctx context.Context, logs []models.Log) error {
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, l := range logs {
+		if _, err := tx.ExecContext(ctx, ss.rebind("UPDATE logs SET key_id = ?, data_key = ? WHERE id = ?"), l.KeyID, l.DataKey, l.ID.Hex()); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Close closes the database.
+//
+// Receiver:
+//	*SQLStore		ss
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (ss *SQLStore) Close() error {
+	return ss.db.Close()
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// insertArgs returns the values inserted for a log, assigning its id if it does not have one.
+func insertArgs(l *models.Log) ([]interface{}, error) {
+	if l.ID.IsZero() {
+		l.ID = primitive.NewObjectID()
+	}
+
+	var extra interface{}
+	if len(l.Extra) > 0 {
+		extraJSON, err := json.Marshal(l.Extra)
+		if err != nil {
+			return nil, err
+		}
+		extra = string(extraJSON)
+	}
+
+	var restoredAt interface{}
+	if l.RestoredAt != nil {
+		restoredAt = l.RestoredAt.UTC()
+	}
+
+	var sequence, prevHash, hash interface{}
+	if l.Sequence != 0 {
+		sequence, prevHash, hash = l.Sequence, l.PrevHash, l.Hash
+	}
+
+	var keyID, dataKey, ciphertext interface{}
+	if l.Ciphertext != "" {
+		keyID, dataKey, ciphertext = l.KeyID, l.DataKey, l.Ciphertext
+	}
+
+	var tenant interface{}
+	if l.Tenant != "" {
+		tenant = l.Tenant
+	}
+
+	return []interface{}{l.ID.Hex(), l.CreatedAt.UTC(), l.LogLevel, l.Message, extra, l.Location, restoredAt, sequence,
+		prevHash, hash, keyID, dataKey, ciphertext, tenant}, nil
+}
+
+// migrate applies the dialect's migrations that are newer than the version recorded in schema_migrations.
+func (ss *SQLStore) migrate(ctx context.Context) error {
+	if _, err := ss.db.ExecContext(ctx,
+		"CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, applied_at TIMESTAMP NOT NULL)"); err != nil {
+		return err
+	}
+
+	var version int
+	if err := ss.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(ss.dialect.migrations); i++ {
+		tx, err := ss.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, statement := range strings.Split(ss.dialect.migrations[i], ";") {
+			if strings.TrimSpace(statement) == "" {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				tx.Rollback()
+				return errors.New("migration " + strconv.Itoa(i+1) + ": " + err.Error())
+			}
+		}
+		if _, err := tx.ExecContext(ctx, ss.rebind("INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)"),
+			i+1, time.Now().UTC()); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rebind rewrites ? placeholders to the dialect's placeholder style.
+func (ss *SQLStore) rebind(query string) string {
+	if !ss.dialect.numberedPlaceholders {
+		return query
+	}
+
+	var builder strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			builder.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+
+	return builder.String()
+}
+
+// whereClause creates a sql where clause with the same conditions LogSearchFields.getFilters creates for mongodb.
+func whereClause(fields models.LogSearchFields) (string, []interface{}) {
+	conditions, args := whereConditions(fields)
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// whereConditions returns the conditions of the where clause for search fields and their arguments.
+func whereConditions(fields models.LogSearchFields) ([]string, []interface{}) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if from, to, hasRange := fields.TimeRange(); hasRange {
+		conditions = append(conditions, "created_at >= ? AND created_at <= ?")
+		args = append(args, from.UTC(), to.UTC())
+	}
+	if fields.Location != "" {
+		conditions = append(conditions, "location = ?")
+		args = append(args, fields.Location)
+	}
+	if fields.LocationPrefix != "" {
+		conditions = append(conditions, "location LIKE ? ESCAPE '\\'")
+		args = append(args, likePrefix(fields.LocationPrefix))
+	}
+	for _, prefix := range fields.ExcludedLocationPrefixes {
+		conditions = append(conditions, "location NOT LIKE ? ESCAPE '\\'")
+		args = append(args, likePrefix(prefix))
+	}
+	if fields.LogLevel != "" {
+		conditions = append(conditions, "log_level = ?")
+		args = append(args, fields.LogLevel)
+	} else {
+		conditions = append(conditions, "log_level IN (?"+strings.Repeat(", ?", len(core.LogLevels)-1)+")")
+		for _, logLevel := range core.LogLevels {
+			args = append(args, logLevel)
+		}
+	}
+	if !fields.ID.IsZero() {
+		conditions = append(conditions, "id = ?")
+		args = append(args, fields.ID.Hex())
+	}
+	if !fields.AfterID.IsZero() {
+		conditions = append(conditions, "id > ?")
+		args = append(args, fields.AfterID.Hex())
+	}
+	if fields.ExcludeRestored {
+		conditions = append(conditions, "restored_at IS NULL")
+	}
+	if fields.RestoredBefore != nil {
+		conditions = append(conditions, "restored_at <= ?")
+		args = append(args, fields.RestoredBefore.UTC())
+	}
+	if fields.Chained {
+		conditions = append(conditions, "sequence IS NOT NULL")
+	}
+	if fields.NotKeyID != "" {
+		conditions = append(conditions, "key_id IS NOT NULL AND key_id <> ?")
+		args = append(args, fields.NotKeyID)
+	}
+	if fields.Tenant != "" {
+		conditions = append(conditions, "tenant = ?")
+		args = append(args, fields.Tenant)
+	}
+	if fields.IDs != nil {
+		if len(fields.IDs) == 0 {
+			conditions = append(conditions, "1 = 0")
+		} else {
+			conditions = append(conditions, "id IN (?"+strings.Repeat(", ?", len(fields.IDs)-1)+")")
+			for _, id := range fields.IDs {
+				args = append(args, id.Hex())
+			}
+		}
+	}
+	if fields.Grants != nil {
+		grantCondition, grantArgs := grantConditions(fields.Grants)
+		conditions = append(conditions, grantCondition)
+		args = append(args, grantArgs...)
+	}
+	for _, hold := range fields.ExcludedHolds {
+		holdConditions, holdArgs := whereConditions(hold.SearchFields())
+		conditions = append(conditions, "NOT ("+strings.Join(holdConditions, " AND ")+")")
+		args = append(args, holdArgs...)
+	}
+
+	return conditions, args
+}
+
+// grantConditions returns the condition matching the logs allowed by any of the grants and its arguments.
+func grantConditions(grants []models.AccessGrant) (string, []interface{}) {
+	if len(grants) == 0 {
+		return "1 = 0", nil
+	}
+
+	allowed := []string{}
+	args := []interface{}{}
+	for _, grant := range grants {
+		conditions := []string{}
+		if len(grant.LocationPrefixes) > 0 {
+			locations := []string{}
+			for _, prefix := range grant.LocationPrefixes {
+				locations = append(locations, "location LIKE ? ESCAPE '\\'")
+				args = append(args, likePrefix(prefix))
+			}
+			conditions = append(conditions, "("+strings.Join(locations, " OR ")+")")
+		}
+		if len(grant.LogLevels) > 0 {
+			conditions = append(conditions, "log_level IN (?"+strings.Repeat(", ?", len(grant.LogLevels)-1)+")")
+			for _, logLevel := range grant.LogLevels {
+				args = append(args, logLevel)
+			}
+		}
+		if len(conditions) == 0 {
+			conditions = append(conditions, "1 = 1")
+		}
+		allowed = append(allowed, "("+strings.Join(conditions, " AND ")+")")
+	}
+
+	return "(" + strings.Join(allowed, " OR ") + ")", args
+}
+
+// likePrefix returns a like pattern matching values starting with the prefix.
+func likePrefix(prefix string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(prefix) + "%"
+}
+
+// orderByClause returns the sql order by clause for an order by search field.
+func orderByClause(orderBy string) string {
+	switch orderBy {
+	case "created_at", "log_level", "location", "id":
+		return orderBy + " DESC"
+	default:
+		return "created_at"
+	}
+}
+
+// scanLog scans a row selected by Find into a log.
+func scanLog(rows *sql.Rows) (models.Log, error) {
+	l := models.Log{}
+	var id string
+	var extra, prevHash, hash, keyID, dataKey, ciphertext, tenant sql.NullString
+	var sequence sql.NullInt64
+	if err := rows.Scan(&id, &l.CreatedAt, &l.LogLevel, &l.Message, &extra, &l.Location, &l.RestoredAt, &sequence, &prevHash, &hash,
+		&keyID, &dataKey, &ciphertext, &tenant); err != nil {
+		return l, err
+	}
+	l.Sequence, l.PrevHash, l.Hash = sequence.Int64, prevHash.String, hash.String
+	l.KeyID, l.DataKey, l.Ciphertext = keyID.String, dataKey.String, ciphertext.String
+	l.Tenant = tenant.String
+
+	var err error
+	if l.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return l, err
+	}
+	if extra.Valid {
+		if err := json.Unmarshal([]byte(extra.String), &l.Extra); err != nil {
+			return l, err
+		}
+	}
+	l.CreatedAt = l.CreatedAt.UTC()
+	if l.RestoredAt != nil {
+		restoredAt := l.RestoredAt.UTC()
+		l.RestoredAt = &restoredAt
+	}
+
+	return l, nil
+}
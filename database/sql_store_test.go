@@ -0,0 +1,229 @@
+package database
+
+/*
+ *
+ * file: 		sql_store_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests the sql storage backend against SQLite.
+ *
+ */
+
+import (
+	"context"
+	"database/sql"
+	"logging_service/models"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testDay is the day the test logs are created on.
+var testDay = time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+
+func TestSQLStoreMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.db")
+
+	// Apply only the first migration, as an older release would have.
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := &SQLStore{db: db, dialect: sqliteDialect}
+	old.dialect.migrations = sqliteDialect.migrations[:1]
+	if err := old.migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// Opening the store applies the rest, and opening it again applies nothing.
+	for i := 0; i < 2; i++ {
+		ss, err := NewSQLStore("sqlite", path)
+		if err != nil {
+			t.Fatalf("open %d: %v", i, err)
+		}
+		var version int
+		if err := ss.db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+			t.Fatal(err)
+		}
+		if version != len(sqliteDialect.migrations) {
+			t.Errorf("open %d: schema version %d, want %d", i, version, len(sqliteDialect.migrations))
+		}
+		if _, err := ss.db.Exec("SELECT tenant, key_id, sequence, restored_at FROM logs"); err != nil {
+			t.Errorf("open %d: migrated columns missing: %v", i, err)
+		}
+		ss.Close()
+	}
+}
+
+func TestSQLStoreCreateAndFind(t *testing.T) {
+	ss := newTestSQLStore(t)
+	ctx := context.Background()
+
+	created := testLog("INFO", "billing/invoices", "first", 0)
+	created.Extra = []string{"user=1"}
+	created.Tenant = "acme"
+	if err := ss.Create(ctx, &created); err != nil {
+		t.Fatal(err)
+	}
+
+	logs, total, err := ss.Find(ctx, models.LogSearchFields{ID: created.ID}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(logs) != 1 {
+		t.Fatalf("found %d logs of %d, want 1", len(logs), total)
+	}
+	found := logs[0]
+	if found.Message != "first" || found.Location != "billing/invoices" || found.LogLevel != "INFO" || found.Tenant != "acme" {
+		t.Errorf("found %+v, want %+v", found, created)
+	}
+	if len(found.Extra) != 1 || found.Extra[0] != "user=1" {
+		t.Errorf("extra %v, want [user=1]", found.Extra)
+	}
+	if !found.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("created at %v, want %v", found.CreatedAt, created.CreatedAt)
+	}
+}
+
+func TestSQLStoreFindFiltersAndPages(t *testing.T) {
+	ss := newTestSQLStore(t)
+	ctx := context.Background()
+	logs := []models.Log{
+		testLog("INFO", "billing/invoices", "a", 0),
+		testLog("ERROR", "billing/invoices", "b", time.Hour),
+		testLog("INFO", "billing/refunds", "c", 2*time.Hour),
+		testLog("INFO", "shipping", "d", 3*time.Hour),
+		testLog("INFO", "billing/invoices", "e", 24*time.Hour),
+	}
+	if err := ss.CreateMany(ctx, logs); err != nil {
+		t.Fatal(err)
+	}
+
+	from, to := testDay, testDay.Add(24*time.Hour-time.Second)
+	tests := []struct {
+		name   string
+		fields models.LogSearchFields
+		want   []string
+	}{
+		{"log level", models.LogSearchFields{LogLevel: "ERROR"}, []string{"b"}},
+		{"location", models.LogSearchFields{Location: "billing/invoices"}, []string{"a", "b", "e"}},
+		{"location prefix", models.LogSearchFields{LocationPrefix: "billing/"}, []string{"a", "b", "c", "e"}},
+		{"date range", models.LogSearchFields{FromDate: &from, ToDate: &to}, []string{"a", "b", "c", "d"}},
+		{"grants", models.LogSearchFields{Grants: []models.AccessGrant{{LocationPrefixes: []string{"billing/r", "ship"}}}}, []string{"c", "d"}},
+		{"no grants", models.LogSearchFields{Grants: []models.AccessGrant{}}, []string{}},
+	}
+	for _, test := range tests {
+		found, total, err := ss.Find(ctx, test.fields, 10)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := messagesOf(found); !equalStrings(got, test.want) || total != int64(len(test.want)) {
+			t.Errorf("%s: found %v of %d, want %v", test.name, got, total, test.want)
+		}
+	}
+
+	pages := [][]string{{"a", "b"}, {"c", "d"}, {"e"}}
+	for page, want := range pages {
+		found, total, err := ss.Find(ctx, models.LogSearchFields{Page: int64(page)}, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := messagesOf(found); !equalStrings(got, want) || total != 5 {
+			t.Errorf("page %d: found %v of %d, want %v of 5", page, got, total, want)
+		}
+	}
+}
+
+func TestSQLStoreCounts(t *testing.T) {
+	ss := newTestSQLStore(t)
+	ctx := context.Background()
+	logs := []models.Log{
+		testLog("INFO", "billing", "a", 0),
+		testLog("INFO", "billing", "b", time.Hour),
+		testLog("ERROR", "billing", "c", 2*time.Hour),
+		testLog("INFO", "shipping", "d", 25*time.Hour),
+	}
+	if err := ss.CreateMany(ctx, logs); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := ss.Count(ctx, models.LogSearchFields{Location: "billing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("counted %d billing logs, want 3", count)
+	}
+
+	days, err := ss.CountByDates(ctx, models.LogSearchFields{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		date     string
+		logLevel string
+		count    int64
+	}{
+		{"2021-03-04", "ERROR", 1},
+		{"2021-03-04", "INFO", 2},
+		{"2021-03-05", "INFO", 1},
+	}
+	if len(days) != len(want) {
+		t.Fatalf("counted %d days and log levels, want %d: %+v", len(days), len(want), days)
+	}
+	for i, day := range days {
+		if day.ID.Date != want[i].date || day.ID.LogLevel != want[i].logLevel || day.Count != want[i].count {
+			t.Errorf("count %d is %+v, want %+v", i, day, want[i])
+		}
+	}
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// newTestSQLStore opens a SQLite store in a temporary directory, closed when the test ends.
+func newTestSQLStore(t *testing.T) *SQLStore {
+	ss, err := NewSQLStore("sqlite", filepath.Join(t.TempDir(), "logs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ss.Close() })
+
+	return ss
+}
+
+// testLog returns a log created some time after the start of the test day.
+func testLog(logLevel string, location string, message string, after time.Duration) models.Log {
+	return models.Log{ID: primitive.NewObjectID(), CreatedAt: testDay.Add(after), LogLevel: logLevel, Location: location,
+		Message: message}
+}
+
+// messagesOf returns the messages of logs in order.
+func messagesOf(logs []models.Log) []string {
+	messages := []string{}
+	for _, l := range logs {
+		messages = append(messages, l.Message)
+	}
+
+	return messages
+}
+
+// equalStrings reports whether two lists hold the same strings in the same order.
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,256 @@
+package encryption
+
+/*
+ *
+ * file: 		keyring.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the keyring used for envelope encryption of log fields.
+ *
+ */
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// KeysEnvironmentVariable holds keys as comma separated id=base64 pairs, in addition to any key file.
+const KeysEnvironmentVariable = "LOGGING_SERVICE_ENCRYPTION_KEYS"
+
+// keySize is the size of key encryption keys and data keys, AES-256.
+const keySize = 32
+
+// Keyring holds the key encryption keys by id. Each value is encrypted with its own random data key, and the data key
+// is encrypted with the active key encryption key. Rotating keys only requires the data keys to be rewrapped.
+type Keyring struct {
+	keys     map[string][]byte
+	activeID string
+}
+
+// LoadKeyring loads key encryption keys from a yaml file mapping key ids to base64 encoded 32 byte keys, and from the
+// LOGGING_SERVICE_ENCRYPTION_KEYS environment variable. Keys in the environment variable replace keys in the file.
+//
+// Parameters:
+//	string	keyFile		- Path of the key file, empty to only use the environment variable.
+//	string	activeID	- Id of the key new values are encrypted with.
+//
+// Returns
+//	*Keyring	- Keyring.
+//	error		- Error if a key is not valid or the active key is missing.
+//
+func LoadKeyring(keyFile string, activeID string) (*Keyring, error) {
+	encodedKeys := map[string]string{}
+	if keyFile != "" {
+		content, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(content, &encodedKeys); err != nil {
+			return nil, err
+		}
+	}
+	for _, pair := range strings.Split(os.Getenv(KeysEnvironmentVariable), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("encryption: keys in " + KeysEnvironmentVariable + " must be id=base64 pairs")
+		}
+		encodedKeys[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	keyring := &Keyring{keys: map[string][]byte{}, activeID: activeID}
+	for id, encoded := range encodedKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != keySize {
+			return nil, errors.New("encryption: key " + id + " must be a base64 encoded 32 byte key")
+		}
+		keyring.keys[id] = key
+	}
+	if _, ok := keyring.keys[activeID]; !ok {
+		return nil, errors.New("encryption: active key " + activeID + " is not loaded")
+	}
+
+	return keyring, nil
+}
+
+// ActiveKeyID returns the id of the key new values are encrypted with.
+//
+// Receiver:
+//	*Keyring	k
+//
+// Returns
+//	string - Active key id.
+//
+func (k *Keyring) ActiveKeyID() string {
+	return k.activeID
+}
+
+// KeyIDs returns the id of every loaded key.
+//
+// Receiver:
+//	*Keyring	k
+//
+// Returns
+//	[]string - Sorted key ids.
+//
+func (k *Keyring) KeyIDs() []string {
+	ids := []string{}
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Seal encrypts a value with a new data key and wraps the data key with the active key. The associated data is
+// authenticated but not encrypted, binding the ciphertext to it.
+//
+// Receiver:
+//	*Keyring	k
+//
+// Parameters:
+//	[]byte	plaintext		- Value to encrypt.
+//	[]byte	associatedData	- Data the ciphertext is bound to.
+//
+// Returns
+//	string	- Id of the key the data key is wrapped with.
+//	string	- Base64 wrapped data key.
+//	string	- Base64 ciphertext.
+//	error	- Any error that occurs.
+//
+func (k *Keyring) Seal(plaintext []byte, associatedData []byte) (string, string, string, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", "", "", err
+	}
+
+	ciphertext, err := seal(dataKey, plaintext, associatedData)
+	if err != nil {
+		return "", "", "", err
+	}
+	wrappedKey, err := seal(k.keys[k.activeID], dataKey, []byte(k.activeID))
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return k.activeID, base64.StdEncoding.EncodeToString(wrappedKey), base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Open unwraps a data key and decrypts a value sealed with it.
+//
+// Receiver:
+//	*Keyring	k
+//
+// Parameters:
+//	string	keyID			- Id of the key the data key is wrapped with.
+//	string	wrappedKey		- Base64 wrapped data key.
+//	string	ciphertext		- Base64 ciphertext.
+//	[]byte	associatedData	- Data the ciphertext is bound to.
+//
+// Returns
+//	[]byte	- Decrypted value.
+//	error	- Error if the key is not loaded or the ciphertext is not authentic.
+//
+func (k *Keyring) Open(keyID string, wrappedKey string, ciphertext string, associatedData []byte) ([]byte, error) {
+	dataKey, err := k.unwrap(keyID, wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(dataKey, sealed, associatedData)
+}
+
+// Rewrap wraps a data key with the active key.
+//
+// Receiver:
+//	*Keyring	k
+//
+// Parameters:
+//	string	keyID		- Id of the key the data key is wrapped with.
+//	string	wrappedKey	- Base64 wrapped data key.
+//
+// Returns
+//	string	- Id of the active key.
+//	string	- Base64 data key wrapped with the active key.
+//	error	- Error if the key is not loaded or the wrapped key is not authentic.
+//
+func (k *Keyring) Rewrap(keyID string, wrappedKey string) (string, string, error) {
+	dataKey, err := k.unwrap(keyID, wrappedKey)
+	if err != nil {
+		return "", "", err
+	}
+	rewrapped, err := seal(k.keys[k.activeID], dataKey, []byte(k.activeID))
+	if err != nil {
+		return "", "", err
+	}
+
+	return k.activeID, base64.StdEncoding.EncodeToString(rewrapped), nil
+}
+
+// unwrap decrypts a data key.
+func (k *Keyring) unwrap(keyID string, wrappedKey string) ([]byte, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, errors.New("encryption: key " + keyID + " is not loaded")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(key, sealed, []byte(keyID))
+}
+
+// seal encrypts with AES-256-GCM, prefixing the ciphertext with its random nonce.
+func seal(key []byte, plaintext []byte, associatedData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, associatedData), nil
+}
+
+// open decrypts a value encrypted by seal.
+func open(key []byte, sealed []byte, associatedData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encryption: ciphertext is too short")
+	}
+
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], associatedData)
+}
+
+// newGCM creates an AES-GCM cipher.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
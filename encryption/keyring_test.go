@@ -0,0 +1,116 @@
+package encryption
+
+/*
+ *
+ * file: 		keyring_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests loading key encryption keys and sealing, opening and rewrapping values with them.
+ *
+ */
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadKeyring(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "keys.yaml")
+	if err := ioutil.WriteFile(keyFile, []byte("old: "+testKey("o")+"\nnew: "+testKey("n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		keyFile     string
+		environment string
+		activeID    string
+		wantIDs     string
+		wantErr     bool
+	}{
+		{"key file", keyFile, "", "new", "new,old", false},
+		{"environment", "", "env=" + testKey("e"), "env", "env", false},
+		{"environment added to the key file", keyFile, " env = " + testKey("e") + ",", "env", "env,new,old", false},
+		{"missing active key", keyFile, "", "env", "", true},
+		{"short key", "", "env=" + base64.StdEncoding.EncodeToString([]byte("short")), "env", "", true},
+		{"malformed pair", "", "env", "env", "", true},
+		{"missing key file", filepath.Join(t.TempDir(), "missing.yaml"), "", "new", "", true},
+	}
+	for _, test := range tests {
+		t.Setenv(KeysEnvironmentVariable, test.environment)
+		keyring, err := LoadKeyring(test.keyFile, test.activeID)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: returned %v, want an error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err == nil && (strings.Join(keyring.KeyIDs(), ",") != test.wantIDs || keyring.ActiveKeyID() != test.activeID) {
+			t.Errorf("%s: loaded %v active %s, want %s active %s", test.name, keyring.KeyIDs(), keyring.ActiveKeyID(), test.wantIDs, test.activeID)
+		}
+	}
+}
+
+func TestSealOpenAndRewrap(t *testing.T) {
+	t.Setenv(KeysEnvironmentVariable, "old="+testKey("o")+",new="+testKey("n"))
+	old, err := LoadKeyring("", "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := LoadKeyring("", "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(KeysEnvironmentVariable, "new="+testKey("n"))
+	retired, err := LoadKeyring("", "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyID, wrappedKey, ciphertext, err := old.Seal([]byte("card 4111"), []byte("log id"))
+	if err != nil || keyID != "old" {
+		t.Fatalf("sealed with %s, %v, want the active key old", keyID, err)
+	}
+	if strings.Contains(ciphertext, "4111") {
+		t.Fatalf("sealed %s, want the value hidden", ciphertext)
+	}
+	newID, rewrappedKey, err := rotated.Rewrap(keyID, wrappedKey)
+	if err != nil || newID != "new" || rewrappedKey == wrappedKey {
+		t.Fatalf("rewrapped with %s, %v, want a data key wrapped with new", newID, err)
+	}
+
+	tests := []struct {
+		name           string
+		keyring        *Keyring
+		keyID          string
+		wrappedKey     string
+		ciphertext     string
+		associatedData string
+		wantErr        bool
+	}{
+		{"sealing key", old, keyID, wrappedKey, ciphertext, "log id", false},
+		{"rewrapped key", retired, newID, rewrappedKey, ciphertext, "log id", false},
+		{"retired key", retired, keyID, wrappedKey, ciphertext, "log id", true},
+		{"other log", old, keyID, wrappedKey, ciphertext, "other id", true},
+		{"key id swapped", rotated, newID, wrappedKey, ciphertext, "log id", true},
+		{"other ciphertext", old, keyID, wrappedKey, ciphertext[:len(ciphertext)-4] + "AAA=", "log id", true},
+	}
+	for _, test := range tests {
+		plaintext, err := test.keyring.Open(test.keyID, test.wrappedKey, test.ciphertext, []byte(test.associatedData))
+		if (err != nil) != test.wantErr || (err == nil && string(plaintext) != "card 4111") {
+			t.Errorf("%s: opened %q, %v, want an error %v", test.name, plaintext, err, test.wantErr)
+		}
+	}
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// testKey returns a base64 encoded 32 byte key filled with a character.
+func testKey(fill string) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(fill, keySize)))
+}
@@ -0,0 +1,382 @@
+package export
+
+/*
+ *
+ * file: 		export.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the streaming export of logs matching a search as ndjson or csv.
+ *
+ */
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"logging_service/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats logs can be exported as.
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// flushInterval is the number of logs written between flushes to the underlying writer.
+const flushInterval = 500
+
+// Columns are the log fields an export can include.
+var Columns = []string{"id", "created_at", "log_level", "location", "message", "extra", "restored_at", "sequence", "prev_hash", "hash", "key_id", "legal_holds", "tenant"}
+
+// DefaultColumns are exported when no columns are chosen.
+var DefaultColumns = []string{"id", "created_at", "log_level", "location", "message", "extra"}
+
+// Options describes the format of an export.
+type Options struct {
+	Format  string   `json:"format"`
+	Columns []string `json:"columns"`
+	Gzip    bool     `json:"gzip"`
+}
+
+// Result describes how far an export got. LastID is the id to resume the export after.
+type Result struct {
+	Exported int64  `json:"exported"`
+	LastID   string `json:"last_id,omitempty"`
+}
+
+// ParseOptions validates the format and comma separated columns of an export.
+//
+// Parameters:
+//	string	format	- ndjson or csv, empty for ndjson.
+//	string	columns	- Comma separated columns, empty for the default columns.
+//	bool	gz		- Whether the export is gzip compressed.
+//
+// Returns
+//	Options	- Export options.
+//	error	- Error if the format or a column is unknown.
+//
+func ParseOptions(format string, columns string, gz bool) (Options, error) {
+	options := Options{Format: strings.ToLower(format), Columns: DefaultColumns, Gzip: gz}
+	if options.Format == "" {
+		options.Format = FormatNDJSON
+	}
+	if options.Format != FormatNDJSON && options.Format != FormatCSV {
+		return options, errors.New("format: must be 'ndjson' or 'csv'")
+	}
+
+	if strings.TrimSpace(columns) != "" {
+		options.Columns = []string{}
+		for _, column := range strings.Split(columns, ",") {
+			column = strings.ToLower(strings.TrimSpace(column))
+			if !isColumn(column) {
+				return options, errors.New("columns: unknown column '" + column + "'")
+			}
+			options.Columns = append(options.Columns, column)
+		}
+	}
+
+	return options, nil
+}
+
+// ContentType returns the media type of an export.
+//
+// Receiver:
+//	Options		o
+//
+// Returns
+//	string - Media type.
+//
+func (o Options) ContentType() string {
+	if o.Gzip {
+		return "application/gzip"
+	}
+	if o.Format == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+
+	return "application/x-ndjson"
+}
+
+// FileName returns the name an export is saved as.
+//
+// Receiver:
+//	Options		o
+//
+// Parameters:
+//	string	name	- Name without an extension.
+//
+// Returns
+//	string - File name.
+//
+func (o Options) FileName(name string) string {
+	name += "." + o.Format
+	if o.Gzip {
+		name += ".gz"
+	}
+
+	return name
+}
+
+// Write streams every log matching the search fields, within the tenant and grants of the context when it is scoped
+// to them, to a writer in ascending id order, reading them from a cursor so the result is never held in memory. Logs
+// are passed to prepare before they are written so callers can decrypt or mark them, and progress is called each time
+// written logs are flushed. Everything written before an error is flushed, so the export can be resumed after the
+// returned LastID. A resumed csv export has no header row, and a gzip export ends a gzip member at every flush, so a
+// resumed export can be appended to what was written up to any flush.
+//
+// Parameters:
+//	io.Writer					w			- Writer the export is written to.
+//	models.LogSearchFields		fields		- Search fields, AfterID resumes an earlier export.
+//	Options						options		- Export options.
+//	func(l *models.Log) error	prepare		- Called for each log before it is written, may be nil.
+//	func(Result) error			progress	- Called after each flush, may be nil.
+//
+// Returns
+//	Result	- Number of logs written and the id of the last one.
+//	error	- Any error that occurs, including errors returned by prepare or progress.
+//
+func Write(ctx context.Context, w io.Writer, fields models.LogSearchFields, options Options, prepare func(l *models.Log) error, progress func(Result) error) (Result, error) {
+	fields = models.ScopeToCaller(ctx, fields)
+	result := Result{}
+	if !fields.AfterID.IsZero() {
+		result.LastID = fields.AfterID.Hex()
+	}
+
+	var compressor *gzip.Writer
+	out := w
+	if options.Gzip {
+		compressor = gzip.NewWriter(w)
+		out = compressor
+	}
+	buffer := bufio.NewWriter(out)
+	encoder := newEncoder(buffer, options)
+
+	flush := func(last bool) error {
+		if err := encoder.flush(); err != nil {
+			return err
+		}
+		if err := buffer.Flush(); err != nil {
+			return err
+		}
+		if compressor == nil {
+			return nil
+		}
+		if err := compressor.Close(); err != nil || last {
+			return err
+		}
+		compressor.Reset(w)
+		return nil
+	}
+
+	var err error
+	if fields.AfterID.IsZero() {
+		err = encoder.header()
+	}
+	if err == nil {
+		err = models.GetLogStore().Iterate(ctx, fields, func(l *models.Log) error {
+			if prepare != nil {
+				if err := prepare(l); err != nil {
+					return err
+				}
+			}
+			if err := encoder.encode(l); err != nil {
+				return err
+			}
+			result.Exported++
+			result.LastID = l.ID.Hex()
+
+			if result.Exported%flushInterval != 0 {
+				return nil
+			}
+			if err := flush(false); err != nil {
+				return err
+			}
+			if progress != nil {
+				return progress(result)
+			}
+			return nil
+		})
+	}
+
+	if flushErr := flush(true); err == nil {
+		err = flushErr
+	}
+	if err == nil && progress != nil {
+		err = progress(result)
+	}
+
+	return result, err
+}
+
+// Reveal returns a prepare function for Write that marks the legal holds of each log, decrypts it when the caller
+// may read encrypted logs and masks it, as search results are.
+//
+// Parameters:
+//	bool		decrypt		- Whether the caller may read encrypted logs.
+//	[]string	unmasked	- Names of the masking policies the caller may see through.
+//
+// Returns
+//	func(l *models.Log) error - Prepare function.
+//
+func Reveal(decrypt bool, unmasked []string) func(l *models.Log) error {
+	return func(l *models.Log) error {
+		logs := []models.Log{*l}
+		models.MarkHeldLogs(logs)
+		if err := models.RevealLogs(logs, decrypt); err != nil {
+			return err
+		}
+		models.MaskLogs(logs, unmasked)
+		*l = logs[0]
+		return nil
+	}
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// encoder writes logs in the format of an export.
+type encoder struct {
+	options Options
+	w       io.Writer
+	csv     *csv.Writer
+}
+
+// newEncoder creates an encoder for the export options.
+func newEncoder(w io.Writer, options Options) *encoder {
+	e := &encoder{options: options, w: w}
+	if options.Format == FormatCSV {
+		e.csv = csv.NewWriter(w)
+	}
+
+	return e
+}
+
+// header writes the header row of a csv export.
+func (e *encoder) header() error {
+	if e.csv == nil {
+		return nil
+	}
+
+	return e.csv.Write(e.options.Columns)
+}
+
+// encode writes a log as an ndjson object or csv row holding the export's columns in order.
+func (e *encoder) encode(l *models.Log) error {
+	if e.csv != nil {
+		record := make([]string, len(e.options.Columns))
+		for i, column := range e.options.Columns {
+			record[i] = csvValue(columnValue(l, column))
+		}
+		return e.csv.Write(record)
+	}
+
+	line := bytes.Buffer{}
+	line.WriteByte('{')
+	for i, column := range e.options.Columns {
+		if i > 0 {
+			line.WriteByte(',')
+		}
+		value, err := json.Marshal(columnValue(l, column))
+		if err != nil {
+			return err
+		}
+		line.WriteString(strconv.Quote(column))
+		line.WriteByte(':')
+		line.Write(value)
+	}
+	line.WriteString("}\n")
+
+	_, err := e.w.Write(line.Bytes())
+	return err
+}
+
+// flush writes buffered csv rows.
+func (e *encoder) flush() error {
+	if e.csv == nil {
+		return nil
+	}
+	e.csv.Flush()
+
+	return e.csv.Error()
+}
+
+// columnValue returns the value of a column of a log.
+func columnValue(l *models.Log, column string) interface{} {
+	switch column {
+	case "id":
+		return l.ID.Hex()
+	case "created_at":
+		return l.CreatedAt.UTC()
+	case "log_level":
+		return l.LogLevel
+	case "location":
+		return l.Location
+	case "message":
+		return l.Message
+	case "extra":
+		return l.Extra
+	case "restored_at":
+		return l.RestoredAt
+	case "sequence":
+		return l.Sequence
+	case "prev_hash":
+		return l.PrevHash
+	case "hash":
+		return l.Hash
+	case "key_id":
+		return l.KeyID
+	case "legal_holds":
+		return l.LegalHolds
+	case "tenant":
+		return l.Tenant
+	}
+
+	return nil
+}
+
+// csvValue formats a column value as a csv field. Lists are written as json arrays so they fit in one field.
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return v.UTC().Format(time.RFC3339Nano)
+	case []string:
+		if len(v) == 0 {
+			return ""
+		}
+		list, _ := json.Marshal(v)
+		return string(list)
+	}
+
+	return ""
+}
+
+// isColumn reports whether a column can be exported.
+func isColumn(column string) bool {
+	for _, val := range Columns {
+		if val == column {
+			return true
+		}
+	}
+
+	return false
+}
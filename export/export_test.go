@@ -0,0 +1,118 @@
+package export
+
+/*
+ *
+ * file: 		export_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests resuming an interrupted export after the last log it wrote.
+ *
+ */
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"logging_service/database"
+	"logging_service/models"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestResumeAnInterruptedExport(t *testing.T) {
+	fs, err := database.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	models.SetLogStore(fs)
+	ctx := context.Background()
+
+	// More logs than one flush, so an export can be interrupted after flushing some of them.
+	start := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	logs := []models.Log{}
+	for i := 0; i < flushInterval+20; i++ {
+		logs = append(logs, models.Log{CreatedAt: start.Add(time.Duration(i) * time.Second), LogLevel: "INFO", Location: "billing", Message: "log " + strconv.Itoa(i)})
+	}
+	if err := fs.CreateMany(ctx, logs); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		format        string
+		gz            bool
+		interruptedAt int
+	}{
+		{"ndjson", FormatNDJSON, false, 10},
+		{"csv", FormatCSV, false, flushInterval + 5},
+		{"gzip csv", FormatCSV, true, flushInterval + 5},
+		{"gzip ndjson before the first flush", FormatNDJSON, true, 3},
+	}
+	for _, test := range tests {
+		options, err := ParseOptions(test.format, "", test.gz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		complete := bytes.Buffer{}
+		if result, err := Write(ctx, &complete, models.LogSearchFields{}, options, nil, nil); err != nil || result.Exported != int64(len(logs)) {
+			t.Fatalf("%s: exported %+v, %v, want %d logs", test.name, result, err, len(logs))
+		}
+
+		// The interrupted export keeps what it wrote before the error and returns the last log written.
+		interrupted := bytes.Buffer{}
+		prepared := 0
+		interrupt := errors.New("interrupted")
+		result, err := Write(ctx, &interrupted, models.LogSearchFields{}, options, func(l *models.Log) error {
+			if prepared++; prepared > test.interruptedAt {
+				return interrupt
+			}
+			return nil
+		}, nil)
+		if err != interrupt || result.Exported != int64(test.interruptedAt) || result.LastID != logs[test.interruptedAt-1].ID.Hex() {
+			t.Errorf("%s: interrupted with %+v, %v, want %d logs up to %s", test.name, result, err, test.interruptedAt, logs[test.interruptedAt-1].ID.Hex())
+			continue
+		}
+
+		// Resuming after the last log and appending gives the complete export.
+		afterID, err := primitive.ObjectIDFromHex(result.LastID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resumed, err := Write(ctx, &interrupted, models.LogSearchFields{AfterID: afterID}, options, nil, nil)
+		if err != nil || resumed.Exported != int64(len(logs)-test.interruptedAt) || resumed.LastID != logs[len(logs)-1].ID.Hex() {
+			t.Errorf("%s: resumed %+v, %v, want the remaining %d logs", test.name, resumed, err, len(logs)-test.interruptedAt)
+		}
+		if got, want := decompress(t, interrupted.Bytes(), test.gz), decompress(t, complete.Bytes(), test.gz); !bytes.Equal(got, want) {
+			t.Errorf("%s: appended %d bytes, want the %d bytes of the complete export", test.name, len(got), len(want))
+		}
+	}
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// decompress returns the content of an export, reading every gzip member of a gzip export.
+func decompress(t *testing.T, data []byte, gz bool) []byte {
+	if !gz {
+		return data
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return content
+}
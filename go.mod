@@ -5,6 +5,7 @@ go 1.12
 require (
 	github.com/auth0-community/go-auth0 v1.0.0
 	github.com/auth0/go-jwt-middleware v0.0.0-20201030150249-d783b5c46b39
+	github.com/aws/aws-sdk-go v1.34.28
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/extemporalgenome/curio v0.0.0-20130429052410-601d010607b7
 	github.com/fatih/structs v1.1.0
@@ -19,6 +20,8 @@ require (
 	github.com/jinzhu/configor v1.2.0
 	github.com/json-iterator/go v1.1.10 // indirect
 	github.com/kamva/mgm/v3 v3.1.0
+	github.com/lib/pq v1.9.0
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/maxwellhealth/go-dotaccess v0.0.0-20190924013105-74ea4f4ca4eb // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.1 // indirect
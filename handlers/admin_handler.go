@@ -0,0 +1,51 @@
+package handlers
+
+/*
+ *
+ * file: 		admin_handler.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the handlers for administrative endpoints.
+ *
+ */
+
+import (
+	"log"
+	"logging_service/models"
+	"logging_service/security"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleGetIndexes responds with usage statistics for every index of the log collection.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetIndexes(c *gin.Context) {
+	indexedStore, ok := models.GetLogStore().(models.IndexedLogStore)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{"Error": "the storage backend does not report index usage"})
+		return
+	}
+
+	usage, err := indexedStore.IndexUsage(c.Request.Context())
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+// HandleGetAuthKeys responds with the status of the cached key set of every authentication provider that verifies
+// tokens against one, including its key ids and the outcome of the last refresh.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetAuthKeys(c *gin.Context) {
+	c.JSON(http.StatusOK, security.GetKeySetStatuses())
+}
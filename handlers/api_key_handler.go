@@ -0,0 +1,231 @@
+package handlers
+
+/*
+ *
+ * file: 		api_key_handler.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the handlers for creating, listing, rotating and revoking api keys.
+ *
+ */
+
+import (
+	"log"
+	"logging_service/config"
+	"logging_service/models"
+	"logging_service/security"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAPIKeyPermission is required to manage api keys when ApiKeys.PERMISSION is not set.
+const defaultAPIKeyPermission = "manage:keys"
+
+// HandleGetAPIKeys responds with the api keys of the request's tenant, or every api key when it is not scoped to one,
+// without their secrets.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetAPIKeys(c *gin.Context) {
+	if !requireAPIKeys(c) {
+		return
+	}
+
+	tenant, _ := models.TenantOf(c.Request.Context())
+	c.JSON(http.StatusOK, security.ListAPIKeys(tenant))
+}
+
+// HandlePostAPIKey creates an api key from a json payload with a name, scopes, locations, tenant and expires_at. A
+// request scoped to a tenant always creates a key of its tenant, and a key can only be granted scopes and locations the
+// caller holds. The key's secret is only included in this response.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandlePostAPIKey(c *gin.Context) {
+	if !requireAPIKeys(c) {
+		return
+	}
+	key := security.APIKey{}
+	if err := c.ShouldBindJSON(&key); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+	if err := key.Validate(grantableScopes()); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+	if !requireHeldGrants(c, key) {
+		return
+	}
+	if tenant, ok := models.TenantOf(c.Request.Context()); ok {
+		key.Tenant = tenant
+	}
+
+	issued, err := security.CreateAPIKey(key, security.GetClaims(c).Subject)
+	if !respondAPIKeyError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusCreated, issued)
+}
+
+// HandlePostAPIKeyRotation gives the api key of the id parameter, within the request's tenant, a new secret, keeping
+// its current secret valid for ApiKeys.ROTATION_GRACE_HOURS. Only callers holding every scope and location of the key
+// may rotate it, since they receive its secret. The new secret is only included in this response.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandlePostAPIKeyRotation(c *gin.Context) {
+	if !requireAPIKeys(c) {
+		return
+	}
+
+	tenant, _ := models.TenantOf(c.Request.Context())
+	key, err := security.GetAPIKey(c.Param("id"), tenant)
+	if !respondAPIKeyError(c, err) || !requireHeldGrants(c, key) {
+		return
+	}
+	issued, err := security.RotateAPIKey(key.ID, tenant, security.GetClaims(c).Subject)
+	if !respondAPIKeyError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, issued)
+}
+
+// HandleDeleteAPIKey revokes the api key of the id parameter within the request's tenant.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleDeleteAPIKey(c *gin.Context) {
+	if !requireAPIKeys(c) {
+		return
+	}
+
+	tenant, _ := models.TenantOf(c.Request.Context())
+	key, err := security.RevokeAPIKey(c.Param("id"), tenant, security.GetClaims(c).Subject)
+	if !respondAPIKeyError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// requireAPIKeys aborts the request unless api keys are configured and its token may manage them.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+// Returns
+//	bool - True if the request may continue.
+//
+func requireAPIKeys(c *gin.Context) bool {
+	if !security.APIKeysEnabled() {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": "api keys are not enabled"})
+		return false
+	}
+
+	return requirePermission(c, config.GetConfig().APIKeys.Permission, defaultAPIKeyPermission)
+}
+
+// grantableScopes returns the scopes routes require and the permissions handlers check, the only scopes an api key can
+// be granted.
+//
+// Returns
+//	[]string - Scopes and permissions.
+//
+func grantableScopes() []string {
+	conf := config.GetConfig()
+	scopes := security.GetScopes(conf)
+	grantable := []string{scopes.Read, scopes.Write, scopes.Admin, security.GetSuperAdminScope(conf)}
+	for _, permission := range []struct {
+		configured   string
+		defaultValue string
+	}{
+		{conf.APIKeys.Permission, defaultAPIKeyPermission},
+		{conf.Audit.Permission, defaultAuditPermission},
+		{conf.Audit.AccessPermission, defaultAccessPermission},
+		{conf.Deletion.Permission, defaultDeletePermission},
+		{conf.Encryption.DecryptPermission, defaultDecryptPermission},
+		{conf.Jobs.AdminPermission, defaultJobAdminPermission},
+		{conf.LegalHold.Permission, defaultLegalHoldPermission},
+		{conf.Masking.UnmaskedPermission, defaultUnmaskedPermission},
+		{conf.Signing.Permission, defaultProducerPermission},
+	} {
+		if permission.configured == "" {
+			permission.configured = permission.defaultValue
+		}
+		grantable = append(grantable, permission.configured)
+	}
+	for _, policy := range conf.Masking.Policies {
+		grantable = append(grantable, policy.Exempt...)
+	}
+
+	return grantable
+}
+
+// requireHeldGrants aborts the request unless the caller holds every scope of an api key and may use every location
+// it is bound to, so callers cannot issue or rotate a key more privileged than themselves.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//	security.APIKey	key	- Key being issued or rotated.
+//
+// Returns
+//	bool - True if the request may continue.
+//
+func requireHeldGrants(c *gin.Context, key security.APIKey) bool {
+	for _, scope := range key.Scopes {
+		if !security.HasPermission(c, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"Error": "scopes: the caller does not hold " + scope})
+			return false
+		}
+	}
+	if len(security.GetClaims(c).Locations) > 0 && len(key.Locations) == 0 {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"Error": "locations: the caller is bound to locations, so the key must be too"})
+		return false
+	}
+	for _, location := range key.Locations {
+		if !security.AllowsLocation(c, location) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"Error": "locations: the caller may not use " + location})
+			return false
+		}
+	}
+
+	return true
+}
+
+// respondAPIKeyError aborts the request if creating, rotating or revoking an api key failed.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//	error			err	- Error that occurred or nil.
+//
+// Returns
+//	bool - True if there was no error.
+//
+func respondAPIKeyError(c *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	if err == security.ErrAPIKeyNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": err.Error()})
+	} else {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "internal server error"})
+	}
+
+	return false
+}
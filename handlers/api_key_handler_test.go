@@ -0,0 +1,126 @@
+package handlers
+
+/*
+ *
+ * file: 		api_key_handler_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests that api keys can only be issued and rotated within the caller's own scopes, locations and tenant.
+ *
+ */
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"logging_service/security"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAPIKeysStayWithinTheCallersGrants(t *testing.T) {
+	useConfig(t, "Tenancy:\n    ENABLED: true\n")
+	if err := security.LoadAPIKeys(filepath.Join(t.TempDir(), "api_keys.json"), 0); err != nil {
+		t.Fatal(err)
+	}
+	defer security.LoadAPIKeys("", 0)
+
+	admin := issueCallerKey(t, security.APIKey{Name: "admin", Scopes: []string{"logs:admin", "manage:keys", "logs:read"}, Tenant: "acme"})
+	bound := issueCallerKey(t, security.APIKey{Name: "bound", Scopes: []string{"logs:admin", "manage:keys", "logs:read"},
+		Locations: []string{"billing/"}, Tenant: "acme"})
+	router := newAPIKeyRouter()
+
+	tests := []struct {
+		name       string
+		caller     string
+		payload    string
+		wantStatus int
+	}{
+		{"held scope", admin, `{"name": "cron", "scopes": ["logs:read"]}`, http.StatusCreated},
+		{"scope not held", admin, `{"name": "cron", "scopes": ["logs:write"]}`, http.StatusForbidden},
+		{"super-admin scope", admin, `{"name": "cron", "scopes": ["logs:super-admin"]}`, http.StatusForbidden},
+		{"unknown scope", admin, `{"name": "cron", "scopes": ["everything"]}`, http.StatusBadRequest},
+		{"location within the caller's", bound, `{"name": "cron", "scopes": ["logs:read"], "locations": ["billing/invoices"]}`, http.StatusCreated},
+		{"location outside the caller's", bound, `{"name": "cron", "scopes": ["logs:read"], "locations": ["shipping"]}`, http.StatusForbidden},
+		{"no locations from a bound caller", bound, `{"name": "cron", "scopes": ["logs:read"]}`, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		recorder := serveAPIKeyRequest(router, http.MethodPost, "/admin/api-keys", test.caller, test.payload)
+		if recorder.Code != test.wantStatus {
+			t.Errorf("%s: responded %d %s, want %d", test.name, recorder.Code, recorder.Body.String(), test.wantStatus)
+		}
+	}
+
+	// A key requested for another tenant still belongs to the caller's.
+	recorder := serveAPIKeyRequest(router, http.MethodPost, "/admin/api-keys", admin, `{"name": "cron", "scopes": ["logs:read"], "tenant": "globex"}`)
+	issued := security.IssuedAPIKey{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &issued); err != nil || issued.Tenant != "acme" {
+		t.Errorf("created %s, want a key of tenant acme", recorder.Body.String())
+	}
+
+	// Other tenants cannot be chosen without the super-admin scope.
+	if recorder := serveAPIKeyRequest(router, http.MethodGet, "/admin/api-keys?tenant=globex", admin, ""); recorder.Code != http.StatusForbidden {
+		t.Errorf("listed another tenant's keys with %d, want 403", recorder.Code)
+	}
+
+	// Rotating a key hands out its secret, so it needs every scope of the key.
+	writer, err := security.CreateAPIKey(security.APIKey{Name: "writer", Scopes: []string{"logs:write"}, Tenant: "acme"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder := serveAPIKeyRequest(router, http.MethodPost, "/admin/api-keys/"+writer.ID+"/rotate", admin, ""); recorder.Code != http.StatusForbidden {
+		t.Errorf("rotated a key with a scope the caller lacks with %d, want 403", recorder.Code)
+	}
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// useConfig points the service at a config file holding the yaml for the rest of the test.
+func useConfig(t *testing.T, yaml string) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LOGGING_SERVICE_CONFIG_PATH", path)
+}
+
+// issueCallerKey creates an api key to call the handlers with and returns its secret.
+func issueCallerKey(t *testing.T, key security.APIKey) string {
+	issued, err := security.CreateAPIKey(key, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return issued.Key
+}
+
+// newAPIKeyRouter returns a router serving the api key handlers behind authentication and tenant scoping.
+func newAPIKeyRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(security.Authenticate(), security.ScopeTenant())
+	router.GET("/admin/api-keys", HandleGetAPIKeys)
+	router.POST("/admin/api-keys", HandlePostAPIKey)
+	router.POST("/admin/api-keys/:id/rotate", HandlePostAPIKeyRotation)
+
+	return router
+}
+
+// serveAPIKeyRequest serves a request authenticated with an api key.
+func serveAPIKeyRequest(router *gin.Engine, method string, target string, key string, payload string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(payload))
+	request.Header.Set("X-API-Key", key)
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	return recorder
+}
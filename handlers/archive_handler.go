@@ -0,0 +1,96 @@
+package handlers
+
+/*
+ *
+ * file: 		archive_handler.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the handlers for the cold archive status, manifest and restores.
+ *
+ */
+
+import (
+	"log"
+	"logging_service/core"
+	"logging_service/jobs"
+	"logging_service/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleGetArchive responds with the archive configuration and the outcome of the last archive run.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetArchive(c *gin.Context) {
+	if jobs.GetArchiver() == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": "archiving is not configured"})
+		return
+	}
+
+	c.JSON(http.StatusOK, jobs.GetArchiveStatus())
+}
+
+// HandleGetArchiveManifest responds with the manifest listing every archive.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetArchiveManifest(c *gin.Context) {
+	archiver := jobs.GetArchiver()
+	if archiver == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": "archiving is not configured"})
+		return
+	}
+
+	manifest, err := archiver.Manifest(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, manifest)
+}
+
+// HandlePostArchiveRestore restores archived logs created between the from and to query parameters, optionally only
+// for the log_level query parameter.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandlePostArchiveRestore(c *gin.Context) {
+	archiver := jobs.GetArchiver()
+	if archiver == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": "archiving is not configured"})
+		return
+	}
+
+	from, err := time.Parse(core.LogDateFormat, c.Query("from"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": "from: invalid date time format"})
+		return
+	}
+	to, err := time.Parse(core.LogDateFormat, c.Query("to"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": "to: invalid date time format"})
+		return
+	}
+	logLevel := strings.ToUpper(c.Query("log_level"))
+	if valid, all := models.IsValidLogLevel(logLevel); !valid || all {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": "log_level: unknown log level"})
+		return
+	}
+
+	results, err := archiver.Restore(c.Request.Context(), from, to, logLevel, jobs.GetArchiveStatus().RestoreDays)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
@@ -0,0 +1,134 @@
+package handlers
+
+/*
+ *
+ * file: 		audit_handler.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the handlers for reading the audit and access trails.
+ *
+ */
+
+import (
+	"log"
+	"logging_service/audit"
+	"logging_service/config"
+	"logging_service/core"
+	"logging_service/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAuditPermission is required to read the audit trail when Audit.PERMISSION is not set.
+const defaultAuditPermission = "read:audit"
+
+// defaultAccessPermission is required to read the access trail when Audit.ACCESS_PERMISSION is not set.
+const defaultAccessPermission = "read:access"
+
+// defaultAccessLimit is the number of access records returned when no limit is given.
+const defaultAccessLimit = 100
+
+// HandleGetAudit responds with the records of the audit trail, optionally only those of the action query parameter,
+// and whether any record has been changed or removed. Callers scoped to a tenant only see the records of their tenant.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetAudit(c *gin.Context) {
+	trail := audit.GetTrail()
+	if trail == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": "no audit file is configured"})
+		return
+	}
+	if !requirePermission(c, config.GetConfig().Audit.Permission, defaultAuditPermission) {
+		return
+	}
+
+	tenant, _ := models.TenantOf(c.Request.Context())
+	records, intact, err := trail.Records(audit.Query{Action: c.Query("action"), Tenant: tenant})
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"intact": intact, "records": records})
+}
+
+// HandleGetAccessTrail responds with the most recent records of the access trail, newest first, and whether any record
+// has been changed or removed. Callers scoped to a tenant only see the records of their tenant. The action, actor, from and to query parameters select records, and limit caps how many
+// are returned.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetAccessTrail(c *gin.Context) {
+	trail := audit.GetAccessTrail()
+	if trail == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": "no access file is configured"})
+		return
+	}
+	if !requirePermission(c, config.GetConfig().Audit.AccessPermission, defaultAccessPermission) {
+		return
+	}
+
+	tenant, _ := models.TenantOf(c.Request.Context())
+	query := audit.Query{Action: c.Query("action"), Actor: c.Query("actor"), Tenant: tenant, Limit: defaultAccessLimit}
+	var ok bool
+	if query.From, ok = getQueryTime(c, "from"); !ok {
+		return
+	}
+	if query.To, ok = getQueryTime(c, "to"); !ok {
+		return
+	}
+	if limit := c.Query("limit"); limit != "" {
+		number, err := strconv.Atoi(limit)
+		if err != nil || number <= 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": "limit: must be a positive number"})
+			return
+		}
+		query.Limit = number
+	}
+
+	records, intact, err := trail.Search(query)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"intact": intact, "records": records})
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// getQueryTime reads an optional date time query parameter, aborting the request if it is not valid.
+//
+// Parameters:
+//	*gin.Context	c		- Handler context from gin.
+//	string			name	- Name of the query parameter.
+//
+// Returns
+//	*time.Time	- Date time, nil if it is not given.
+//	bool		- Whether the request may continue.
+//
+func getQueryTime(c *gin.Context, name string) (*time.Time, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, true
+	}
+	date, err := time.Parse(core.LogDateFormat, value)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": name + ": invalid date time format"})
+		return nil, false
+	}
+
+	return &date, true
+}
@@ -0,0 +1,166 @@
+package handlers
+
+/*
+ *
+ * file: 		deletion_handler.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the handler for deleting logs by filter.
+ *
+ */
+
+import (
+	"log"
+	"logging_service/audit"
+	"logging_service/config"
+	"logging_service/jobs"
+	"logging_service/models"
+	"logging_service/security"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultDeletePermission is required to delete logs when Deletion.PERMISSION is not set.
+const defaultDeletePermission = "delete:logs"
+
+// defaultConfirmationMinutes is used when Deletion.CONFIRMATION_MINUTES is not set.
+const defaultConfirmationMinutes = 15
+
+// defaultDeletionSampleSize is used when Deletion.SAMPLE_SIZE is not set.
+const defaultDeletionSampleSize = 10
+
+// HandlePostLogDelete deletes the logs matching the same filters as a search. With dry_run=true it responds with the
+// number of matching logs, a sample of them, masked as searches are, and a confirmation token. Repeating the request
+// with the same filters and confirmation_token set to the token removes the logs and records the deletion in the audit
+// trail.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandlePostLogDelete(c *gin.Context) {
+	conf := config.GetConfig()
+	fields, filters, ok := getDeletionRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	actor := security.GetClaims(c).Subject
+	if c.Query("dry_run") == "true" {
+		sampleSize := int64(conf.Deletion.SampleSize)
+		if sampleSize <= 0 {
+			sampleSize = defaultDeletionSampleSize
+		}
+		confirmationMinutes := conf.Deletion.ConfirmationMinutes
+		if confirmationMinutes <= 0 {
+			confirmationMinutes = defaultConfirmationMinutes
+		}
+
+		plan, err := models.PlanDeletion(ctx, fields, filters, actor, sampleSize, time.Duration(confirmationMinutes)*time.Minute)
+		if err == nil {
+			models.MarkHeldLogs(plan.Samples)
+			err = models.RevealLogs(plan.Samples, canDecrypt(c))
+			models.MaskLogs(plan.Samples, unmaskedPolicies(c))
+		}
+		if err != nil {
+			log.Println(err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "internal server error"})
+			return
+		}
+		c.JSON(http.StatusOK, plan)
+		return
+	}
+
+	token := c.Query("confirmation_token")
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": "a dry run is required, repeat the request with dry_run=true"})
+		return
+	}
+	result, err := models.ConfirmDeletion(ctx, token, filters, actor)
+	if err == nil && result.Deleted > 0 {
+		err = jobs.TrimHashChains(ctx)
+	}
+	if err == models.ErrDeletionNotConfirmed {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"Error": err.Error()})
+		return
+	} else if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// getDeletionRequest checks the caller may delete logs and reads the search fields and filters of a deletion request,
+// aborting it if they are not valid.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+// Returns
+//	models.LogSearchFields	- Search fields of the logs to delete.
+//	map[string]string		- Filters that were given.
+//	bool					- Whether the request may go ahead.
+//
+func getDeletionRequest(c *gin.Context) (models.LogSearchFields, map[string]string, bool) {
+	fields := models.LogSearchFields{}
+	if audit.GetTrail() == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": "deleting logs requires an audit file"})
+		return fields, nil, false
+	}
+	if !requirePermission(c, config.GetConfig().Deletion.Permission, defaultDeletePermission) {
+		return fields, nil, false
+	}
+
+	if err := fields.GetSearchFields(c); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return fields, nil, false
+	}
+	if _, all := models.IsValidLogLevel(fields.LogLevel); all {
+		fields.LogLevel = ""
+	}
+	filters := deletionFilters(c, fields)
+	if len(filters) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": "at least one filter is required"})
+		return fields, nil, false
+	}
+
+	return fields, filters, true
+}
+
+// deletionFilters returns the filters of a deletion request as they were given, leaving out paging and ordering, and
+// the tenant the request is scoped to, so a deletion is confirmed within the tenant it was planned in.
+//
+// Parameters:
+//	*gin.Context			c		- Handler context from gin.
+//	models.LogSearchFields	fields	- Parsed search fields.
+//
+// Returns
+//	map[string]string - Filters that were given.
+//
+func deletionFilters(c *gin.Context, fields models.LogSearchFields) map[string]string {
+	filters := map[string]string{}
+	if fields.LogLevel != "" {
+		filters["log_level"] = fields.LogLevel
+	}
+	for _, name := range []string{"created_at", "from", "to", "id", "location"} {
+		if value := strings.TrimSpace(c.Query(name)); value != "" {
+			filters[name] = value
+		}
+	}
+	if tenant, ok := models.TenantOf(c.Request.Context()); ok {
+		filters["tenant"] = tenant
+	}
+
+	return filters
+}
@@ -0,0 +1,194 @@
+package handlers
+
+/*
+ *
+ * file: 		deletion_handler_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests deleting logs by filter after a dry run.
+ *
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"logging_service/audit"
+	"logging_service/config"
+	"logging_service/database"
+	"logging_service/models"
+	"logging_service/security"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestDeleteLogsAfterADryRun(t *testing.T) {
+	useConfig(t, `Auth:
+    PROVIDERS:
+        - TYPE: hs256
+          SECRET: `+testTokenSecret+`
+Masking:
+    POLICIES:
+        - NAME: cards
+          PATTERN: '\d{16}'
+`)
+	conf := config.GetConfig().Masking
+	if err := models.LoadMaskingPolicies(conf.Policies, conf.HashKey); err != nil {
+		t.Fatal(err)
+	}
+	defer models.LoadMaskingPolicies(nil, "")
+	fs, err := database.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	models.SetLogStore(fs)
+	audit.Open(filepath.Join(t.TempDir(), "audit.log"))
+	defer audit.Open("")
+	if err := models.LoadLegalHolds(filepath.Join(t.TempDir(), "legal_holds.json")); err != nil {
+		t.Fatal(err)
+	}
+	defer models.LoadLegalHolds("")
+
+	ctx := context.Background()
+	start := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	heldAt := start.Add(2 * time.Hour)
+	if _, err := models.CreateLegalHold(ctx, models.LegalHold{Name: "dispute", Location: "billing", From: &heldAt, To: &heldAt}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	logs := []models.Log{
+		{CreatedAt: start, LogLevel: "INFO", Location: "billing", Message: "paid with 4111111111111111"},
+		{CreatedAt: start.Add(time.Hour), LogLevel: "INFO", Location: "billing", Message: "refunded"},
+		{CreatedAt: heldAt, LogLevel: "INFO", Location: "billing", Message: "disputed"},
+		{CreatedAt: start, LogLevel: "INFO", Location: "shipping", Message: "shipped"},
+	}
+	if err := fs.CreateMany(ctx, logs); err != nil {
+		t.Fatal(err)
+	}
+	router := newDeletionRouter()
+	deleter := signTestToken(t, "deleter", "delete:logs")
+
+	// Requests that cannot be planned are refused before a dry run is issued.
+	refused := []struct {
+		name       string
+		token      string
+		target     string
+		wantStatus int
+	}{
+		{"missing permission", signTestToken(t, "reader", "logs:read"), "/log/INFO/delete?dry_run=true&location=billing", http.StatusForbidden},
+		{"no filters", deleter, "/log/ALL/delete?dry_run=true", http.StatusBadRequest},
+		{"no dry run", deleter, "/log/INFO/delete?location=billing", http.StatusBadRequest},
+		{"unknown token", deleter, "/log/INFO/delete?location=billing&confirmation_token=guess", http.StatusConflict},
+	}
+	for _, test := range refused {
+		if recorder := serveDeletion(router, test.token, test.target); recorder.Code != test.wantStatus {
+			t.Errorf("%s: responded %d %s, want %d", test.name, recorder.Code, recorder.Body.String(), test.wantStatus)
+		}
+	}
+
+	// A dry run counts the logs, leaving out held ones, and masks its samples.
+	recorder := serveDeletion(router, deleter, "/log/INFO/delete?dry_run=true&location=billing")
+	plan := models.DeletionPlan{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &plan); err != nil || plan.Matched != 2 || plan.Held != 1 || len(plan.Samples) != 2 {
+		t.Fatalf("planned %s, want 2 matched and 1 held", recorder.Body.String())
+	}
+	for _, sample := range plan.Samples {
+		if strings.Contains(sample.Message, "4111") {
+			t.Errorf("sampled %q, want it masked", sample.Message)
+		}
+	}
+
+	// A confirmation must come from the caller of the dry run with the same filters, and uses up the token.
+	confirmations := []struct {
+		name       string
+		token      string
+		filters    string
+		wantStatus int
+	}{
+		{"other filters", deleter, "location=shipping", http.StatusConflict},
+		{"other caller", signTestToken(t, "other", "delete:logs"), "location=billing", http.StatusConflict},
+		{"same caller and filters", deleter, "location=billing", http.StatusOK},
+	}
+	for _, test := range confirmations {
+		plan := models.DeletionPlan{}
+		if err := json.Unmarshal(serveDeletion(router, deleter, "/log/INFO/delete?dry_run=true&location=billing").Body.Bytes(), &plan); err != nil {
+			t.Fatal(err)
+		}
+		target := "/log/INFO/delete?" + test.filters + "&confirmation_token=" + plan.ConfirmationToken
+		if recorder := serveDeletion(router, test.token, target); recorder.Code != test.wantStatus {
+			t.Errorf("%s: responded %d %s, want %d", test.name, recorder.Code, recorder.Body.String(), test.wantStatus)
+		}
+		if recorder := serveDeletion(router, test.token, target); recorder.Code != http.StatusConflict {
+			t.Errorf("%s: reused the token with %d, want 409", test.name, recorder.Code)
+		}
+	}
+
+	found, _, err := fs.Find(ctx, models.LogSearchFields{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := []string{}
+	for _, l := range found {
+		messages = append(messages, l.Message)
+	}
+	sort.Strings(messages)
+	if strings.Join(messages, ",") != "disputed,shipped" {
+		t.Errorf("kept %v, want the held and unmatched logs", messages)
+	}
+	records, _, err := audit.GetTrail().Records(audit.Query{Action: "delete_result"})
+	if err != nil || len(records) != 1 || records[0].Actor != "deleter" {
+		t.Errorf("audited %+v, %v, want one deletion by deleter", records, err)
+	}
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// testTokenSecret signs the tokens of tests authenticating with the hs256 provider.
+const testTokenSecret = "a secret of at least thirty-two characters"
+
+// signTestToken returns a token for the hs256 provider granting a subject permissions.
+func signTestToken(t *testing.T, subject string, permissions ...string) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: []byte(testTokenSecret)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(security.Claims{Subject: subject, Scope: strings.Join(permissions, " "), Permissions: permissions}).
+		CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+// newDeletionRouter returns a router serving the deletion handler behind authentication.
+func newDeletionRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(security.Authenticate(), security.ScopeTenant())
+	router.POST("/log/:log_level/delete", HandlePostLogDelete)
+
+	return router
+}
+
+// serveDeletion serves a deletion request authenticated with a token.
+func serveDeletion(router *gin.Engine, token string, target string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, target, nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	return recorder
+}
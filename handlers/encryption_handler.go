@@ -0,0 +1,26 @@
+package handlers
+
+/*
+ *
+ * file: 		encryption_handler.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the handler for the encryption status.
+ *
+ */
+
+import (
+	"logging_service/jobs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleGetEncryption responds with the loaded key ids and the outcome of the last key rotation.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetEncryption(c *gin.Context) {
+	c.JSON(http.StatusOK, jobs.GetEncryptionStatus())
+}
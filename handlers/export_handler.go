@@ -0,0 +1,117 @@
+package handlers
+
+/*
+ *
+ * file: 		export_handler.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the handler for streaming an export of the logs matching a search.
+ *
+ */
+
+import (
+	"log"
+	"logging_service/export"
+	"logging_service/models"
+	"logging_service/security"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// HandleGetLogExport streams every log matching the same filters as a search as ndjson or csv, in ascending id order.
+// The format, columns and gzip query parameters choose how logs are written, and after resumes an export after the id
+// it last returned. Since the status is sent before the first log, the X-Last-Exported-ID, X-Exported-Count and
+// X-Export-Error trailers report how far the export got.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetLogExport(c *gin.Context) {
+	fields, options, ok := getExportRequest(c)
+	if !ok {
+		return
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", options.ContentType())
+	header.Set("Content-Disposition", `attachment; filename="`+options.FileName(exportName(fields))+`"`)
+	header.Set("Trailer", "X-Last-Exported-ID, X-Exported-Count, X-Export-Error")
+	c.Status(http.StatusOK)
+
+	progress := func(export.Result) error {
+		c.Writer.Flush()
+		return nil
+	}
+
+	result, err := export.Write(c.Request.Context(), c.Writer, fields, options, export.Reveal(canDecrypt(c), unmaskedPolicies(c)), progress)
+	c.Writer.Flush()
+	header.Set("X-Last-Exported-ID", result.LastID)
+	header.Set("X-Exported-Count", strconv.FormatInt(result.Exported, 10))
+	security.SetResultSize(c, result.Exported)
+	if err != nil {
+		log.Println(err)
+		header.Set("X-Export-Error", "export stopped after "+strconv.FormatInt(result.Exported, 10)+" logs")
+	}
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// getExportRequest reads the search fields and export options of an export request, aborting it if they are not valid.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+// Returns
+//	models.LogSearchFields	- Search fields of the logs to export.
+//	export.Options			- Export options.
+//	bool					- Whether the request is valid.
+//
+func getExportRequest(c *gin.Context) (models.LogSearchFields, export.Options, bool) {
+	fields := models.LogSearchFields{}
+	if err := fields.GetSearchFields(c); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return fields, export.Options{}, false
+	}
+	if !requireAllowedLocation(c, fields) {
+		return fields, export.Options{}, false
+	}
+	if _, all := models.IsValidLogLevel(fields.LogLevel); all {
+		fields.LogLevel = ""
+	}
+	if after := c.Query("after"); after != "" {
+		afterID, err := primitive.ObjectIDFromHex(after)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": "after: invalid id"})
+			return fields, export.Options{}, false
+		}
+		fields.AfterID = afterID
+	}
+	fields.UseReadPreference = true
+	// Scoped now, so an export run as a background job stays within the caller's tenant and grants.
+	fields = models.ScopeToCaller(c.Request.Context(), fields)
+
+	options, err := export.ParseOptions(c.Query("format"), c.Query("columns"), c.Query("gzip") == "true")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return fields, options, false
+	}
+
+	return fields, options, true
+}
+
+// exportName returns the file name of an export without its extension.
+func exportName(fields models.LogSearchFields) string {
+	if fields.LogLevel == "" {
+		return "logs"
+	}
+
+	return "logs-" + strings.ToLower(fields.LogLevel)
+}
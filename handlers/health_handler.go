@@ -0,0 +1,48 @@
+package handlers
+
+/*
+ *
+ * file: 		health_handler.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the handlers reporting whether the service can reach its storage backend.
+ *
+ */
+
+import (
+	"logging_service/database"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleGetHealth responds with 200 when the storage backend is reachable and 503 while the service is degraded. It
+// does not require a token, so it leaves out connection details.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetHealth(c *gin.Context) {
+	if status, ok := database.GetMongoStatus(); ok && !status.Connected {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "degraded"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// HandleGetDatabase responds with the mongodb connection status, including the connection string without its
+// password and the last connection error.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetDatabase(c *gin.Context) {
+	status, ok := database.GetMongoStatus()
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": "the mongodb backend is not used"})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,71 @@
+package handlers
+
+/*
+ *
+ * file: 		integrity_handler.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the handlers for the hash chain status, checkpoints and verification.
+ *
+ */
+
+import (
+	"log"
+	"logging_service/config"
+	"logging_service/jobs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleGetIntegrity responds with the hash chain configuration and the outcome of the last checkpoint.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetIntegrity(c *gin.Context) {
+	c.JSON(http.StatusOK, jobs.GetHashChainStatus())
+}
+
+// HandlePostIntegrityCheckpoint writes a signed checkpoint immediately.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandlePostIntegrityCheckpoint(c *gin.Context) {
+	if !jobs.GetHashChainStatus().Enabled {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": "hash chaining is not enabled"})
+		return
+	}
+
+	checkpoint, err := jobs.WriteCheckpoint(c.Request.Context())
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, checkpoint)
+}
+
+// HandleGetIntegrityVerify verifies the hash chains of the location query parameter, or of every location, and
+// responds with where each chain is broken.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetIntegrityVerify(c *gin.Context) {
+	if config.GetConfig().Integrity.SigningKeyFile == "" {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": "hash chaining is not configured"})
+		return
+	}
+
+	results, err := jobs.VerifyHashChains(c.Request.Context(), c.Query("location"))
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
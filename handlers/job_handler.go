@@ -0,0 +1,246 @@
+package handlers
+
+/*
+ *
+ * file: 		job_handler.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the handlers for submitting, following, cancelling and downloading background jobs.
+ *
+ */
+
+import (
+	"log"
+	"logging_service/config"
+	"logging_service/jobs"
+	"logging_service/models"
+	"logging_service/security"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultJobAdminPermission is required to re-index and to see and cancel every caller's jobs when
+// Jobs.ADMIN_PERMISSION is not set.
+const defaultJobAdminPermission = "manage:jobs"
+
+// HandlePostExportJob submits a job exporting the logs matching the same filters and options as an export request.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandlePostExportJob(c *gin.Context) {
+	if !requireBackgroundJobs(c) {
+		return
+	}
+	fields, options, ok := getExportRequest(c)
+	if !ok {
+		return
+	}
+
+	job, err := jobs.SubmitExportJob(c.Request.Context(), fields, options, canDecrypt(c), unmaskedPolicies(c), security.GetClaims(c).Subject)
+	respondSubmittedJob(c, job, err)
+}
+
+// HandlePostReindexJob submits a job reconciling the storage backend's indexes.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandlePostReindexJob(c *gin.Context) {
+	if !requireBackgroundJobs(c) || !requirePermission(c, config.GetConfig().Jobs.AdminPermission, defaultJobAdminPermission) {
+		return
+	}
+	if _, ok := models.GetLogStore().(models.IndexedLogStore); !ok {
+		c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{"Error": "the storage backend does not manage its indexes"})
+		return
+	}
+
+	job, err := jobs.SubmitReindexJob(c.Request.Context(), security.GetClaims(c).Subject)
+	respondSubmittedJob(c, job, err)
+}
+
+// HandlePostDeleteJob submits a job running a deletion confirmed by the confirmation_token of its dry run. It takes
+// the same filters as a deletion request.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandlePostDeleteJob(c *gin.Context) {
+	if !requireBackgroundJobs(c) {
+		return
+	}
+	_, filters, ok := getDeletionRequest(c)
+	if !ok {
+		return
+	}
+	token := c.Query("confirmation_token")
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": "a dry run is required, run POST /log/:log_level/delete with dry_run=true"})
+		return
+	}
+
+	actor := security.GetClaims(c).Subject
+	deletion, err := models.ClaimDeletion(token, filters, actor)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"Error": err.Error()})
+		return
+	}
+
+	job, err := jobs.SubmitDeleteJob(c.Request.Context(), deletion, filters, actor)
+	respondSubmittedJob(c, job, err)
+}
+
+// HandleGetJobs responds with the caller's jobs, or every job of the caller's tenant for callers with
+// Jobs.ADMIN_PERMISSION.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetJobs(c *gin.Context) {
+	if !requireBackgroundJobs(c) {
+		return
+	}
+
+	actor := security.GetClaims(c).Subject
+	if isJobAdmin(c) {
+		actor = ""
+	}
+	c.JSON(http.StatusOK, jobs.ListJobs(c.Request.Context(), actor))
+}
+
+// HandleGetJob responds with the status and progress of the job of the id parameter.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetJob(c *gin.Context) {
+	job, ok := getCallerJob(c, true)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// HandleGetJobArtifact downloads the artifact of the finished job of the id parameter. Only the submitter may download
+// it, since the artifact holds what their tenant, grants and masking let them read.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetJobArtifact(c *gin.Context) {
+	job, ok := getCallerJob(c, false)
+	if !ok {
+		return
+	}
+	path, ok := jobs.JobArtifactPath(job)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": "the job has no artifact"})
+		return
+	}
+
+	c.Header("Content-Type", job.Artifact.ContentType)
+	c.Header("Content-Disposition", `attachment; filename="`+job.Artifact.Name+`"`)
+	c.File(path)
+}
+
+// HandleDeleteJob cancels the queued or running job of the id parameter.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleDeleteJob(c *gin.Context) {
+	job, ok := getCallerJob(c, true)
+	if !ok {
+		return
+	}
+
+	job, err := jobs.CancelJob(job.ID)
+	if err == jobs.ErrJobFinished {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"Error": err.Error()})
+		return
+	} else if err == jobs.ErrJobNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": err.Error()})
+		return
+	} else if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// requireBackgroundJobs aborts the request unless background jobs are configured.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+// Returns
+//	bool - Whether background jobs are configured.
+//
+func requireBackgroundJobs(c *gin.Context) bool {
+	if !jobs.BackgroundJobsEnabled() {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": "background jobs are not enabled"})
+		return false
+	}
+
+	return true
+}
+
+// isJobAdmin reports whether the caller may see and cancel every job of their tenant.
+func isJobAdmin(c *gin.Context) bool {
+	permission := config.GetConfig().Jobs.AdminPermission
+	if permission == "" {
+		permission = defaultJobAdminPermission
+	}
+
+	return security.HasPermission(c, permission)
+}
+
+// getCallerJob returns the job of the id parameter, aborting the request if it does not exist or the caller may not
+// access it. A job is the caller's when both its submitter and tenant are the caller's. Job admins may also access the
+// jobs of their tenant, or of every tenant when they are not scoped to one, if admins are allowed.
+//
+// Parameters:
+//	*gin.Context	c			- Handler context from gin.
+//	bool			allowAdmin	- Whether job admins may access other callers' jobs.
+//
+// Returns
+//	jobs.Job	- Job.
+//	bool		- Whether the caller may access the job.
+//
+func getCallerJob(c *gin.Context, allowAdmin bool) (jobs.Job, bool) {
+	if !requireBackgroundJobs(c) {
+		return jobs.Job{}, false
+	}
+
+	tenant, scoped := models.TenantOf(c.Request.Context())
+	job, ok := jobs.GetJob(c.Param("id"))
+	submitter := job.CreatedBy == security.GetClaims(c).Subject && job.Tenant == tenant
+	admin := allowAdmin && isJobAdmin(c) && (!scoped || job.Tenant == tenant)
+	if !ok || (!submitter && !admin) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": jobs.ErrJobNotFound.Error()})
+		return jobs.Job{}, false
+	}
+
+	return job, true
+}
+
+// respondSubmittedJob responds with a submitted job, or an error if it could not be submitted.
+func respondSubmittedJob(c *gin.Context, job jobs.Job, err error) {
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
@@ -0,0 +1,194 @@
+package handlers
+
+/*
+ *
+ * file: 		legal_hold_handler.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the handlers for placing, changing and releasing legal holds.
+ *
+ */
+
+import (
+	"log"
+	"logging_service/audit"
+	"logging_service/config"
+	"logging_service/models"
+	"logging_service/security"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultLegalHoldPermission is required to manage legal holds when LegalHold.PERMISSION is not set.
+const defaultLegalHoldPermission = "manage:holds"
+
+// HandleGetLegalHolds responds with the active legal holds of the caller's tenant.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetLegalHolds(c *gin.Context) {
+	if !requireLegalHolds(c) {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ListLegalHolds(c.Request.Context()))
+}
+
+// HandleGetLegalHold responds with the legal hold of the id parameter.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetLegalHold(c *gin.Context) {
+	if !requireLegalHolds(c) {
+		return
+	}
+
+	hold, err := models.GetLegalHold(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, hold)
+}
+
+// HandlePostLegalHold places a legal hold within the caller's tenant from a json payload with a name, reason, location,
+// log_level, from and to. Callers not scoped to a tenant may also give the hold's tenant.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandlePostLegalHold(c *gin.Context) {
+	if !requireLegalHolds(c) {
+		return
+	}
+	hold, ok := bindLegalHold(c)
+	if !ok {
+		return
+	}
+
+	hold, err := models.CreateLegalHold(c.Request.Context(), hold, security.GetClaims(c).Subject)
+	if !respondLegalHoldError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusCreated, hold)
+}
+
+// HandlePutLegalHold replaces the name, reason and conditions of the legal hold of the id parameter.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandlePutLegalHold(c *gin.Context) {
+	if !requireLegalHolds(c) {
+		return
+	}
+	hold, ok := bindLegalHold(c)
+	if !ok {
+		return
+	}
+
+	hold, err := models.UpdateLegalHold(c.Request.Context(), c.Param("id"), hold, security.GetClaims(c).Subject)
+	if !respondLegalHoldError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, hold)
+}
+
+// HandleDeleteLegalHold releases the legal hold of the id parameter.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleDeleteLegalHold(c *gin.Context) {
+	if !requireLegalHolds(c) {
+		return
+	}
+
+	hold, err := models.ReleaseLegalHold(c.Request.Context(), c.Param("id"), security.GetClaims(c).Subject)
+	if !respondLegalHoldError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, hold)
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// requireLegalHolds aborts the request unless legal holds and the audit trail are configured and its token may manage
+// legal holds.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+// Returns
+//	bool - True if the request may continue.
+//
+func requireLegalHolds(c *gin.Context) bool {
+	if !models.LegalHoldsEnabled() {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": "legal holds are not enabled"})
+		return false
+	}
+	if audit.GetTrail() == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": "legal holds require an audit file"})
+		return false
+	}
+
+	return requirePermission(c, config.GetConfig().LegalHold.Permission, defaultLegalHoldPermission)
+}
+
+// bindLegalHold converts a json payload to a legal hold and validates it.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+// Returns
+//	models.LegalHold	- Legal hold.
+//	bool				- False if the payload is not valid and the request was aborted.
+//
+func bindLegalHold(c *gin.Context) (models.LegalHold, bool) {
+	hold := models.LegalHold{}
+	if err := c.ShouldBindJSON(&hold); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return hold, false
+	}
+	if err := hold.Validate(); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return hold, false
+	}
+
+	return hold, true
+}
+
+// respondLegalHoldError aborts the request if placing, changing or releasing a legal hold failed.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//	error			err	- Error that occurred or nil.
+//
+// Returns
+//	bool - True if there was no error.
+//
+func respondLegalHoldError(c *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	if err == models.ErrLegalHoldNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": err.Error()})
+	} else {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "internal server error"})
+	}
+
+	return false
+}
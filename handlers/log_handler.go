@@ -11,15 +11,24 @@ package handlers
 
 import (
 	"log"
+	"logging_service/config"
+	"logging_service/core"
 	"logging_service/models"
+	"logging_service/security"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// defaultDecryptPermission is required to read encrypted log fields when Encryption.DECRYPT_PERMISSION is not set.
+const defaultDecryptPermission = "read:decrypted"
+
+// defaultUnmaskedPermission sees through every masking policy when Masking.UNMASKED_PERMISSION is not set.
+const defaultUnmaskedPermission = "read:unmasked"
+
 // HandlePostLog handles all post requests for any log type.
 //
 // Parameters:
@@ -33,11 +42,19 @@ func HandlePostLog(c *gin.Context) {
 		return
 	}
 
-	if err := logData.Create(); err != nil {
+	if err := logData.Create(c.Request.Context()); err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
-	} else {
-		c.JSON(200, logData)
+		return
+	}
+
+	created := []models.Log{*logData}
+	models.MarkHeldLogs(created)
+	if err := models.RevealLogs(created, canDecrypt(c)); err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "internal server error"})
+		return
 	}
+	c.JSON(200, created[0])
 }
 
 // HandleGetLog handles all get requests for any log type.
@@ -50,11 +67,23 @@ func HandleGetLog(c *gin.Context) {
 	err := fields.GetSearchFields(c)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+
+	if !requireAllowedLocation(c, fields) {
+		return
 	}
+	fields.UseReadPreference = true
 
-	ctx := mgm.Ctx()
+	ctx := c.Request.Context()
 	log := models.Log{}
 	results, err := log.Find(ctx, fields)
+	if err == nil {
+		models.MarkHeldLogs(results.Data.([]models.Log))
+		err = models.RevealLogs(results.Data.([]models.Log), canDecrypt(c))
+		models.MaskLogs(results.Data.([]models.Log), unmaskedPolicies(c))
+		security.SetResultSize(c, int64(len(results.Data.([]models.Log))))
+	}
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "internal server error"})
 	} else {
@@ -72,23 +101,39 @@ func HandleGetLogCount(c *gin.Context) {
 	err := fields.GetSearchFields(c)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+
+	if !requireAllowedLocation(c, fields) {
+		return
 	}
+	fields.UseReadPreference = true
 
 	_log := models.Log{}
-	ctx := mgm.Ctx()
+	ctx := c.Request.Context()
 	countType := strings.Trim(c.Param("type"), "/")
 	var count interface{}
 	switch countType {
 	case "date":
-		count, err = _log.CountByDates(ctx, fields)
+		var days []core.CountResultsWithDate
+		days, err = _log.CountByDates(ctx, fields)
+		total := int64(0)
+		for _, day := range days {
+			total += day.Count
+		}
+		security.SetResultSize(c, total)
+		count = days
 		break
 	default:
-		count, err = _log.Count(ctx, fields)
+		var results core.CountResults
+		results, err = _log.Count(ctx, fields)
+		security.SetResultSize(c, results.Count)
+		count = results
 		break
 	}
 
 	if err != nil {
-		log.Fatal(err.Error())
+		log.Println(err)
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "internal server error"})
 	} else {
 		c.JSON(200, count)
@@ -101,7 +146,8 @@ func HandleGetLogCount(c *gin.Context) {
  *
  */
 
-// getNewLog converts a json payload to a log model.
+// getNewLog converts a json payload to a log model. Fields the service manages, such as the id and tenant, are
+// cleared.
 //
 // Parameters:
 //	*gin.Context	c	- Handler context from gin.
@@ -138,7 +184,111 @@ func getNewLog(c *gin.Context) (*models.Log, error) {
 		return nil, nil
 	}
 
+	if !security.AllowsLocation(c, logData.Location) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"Error": "location: not allowed for this api key"})
+		return nil, nil
+	}
+
+	logData.ID = primitive.NilObjectID
 	logData.CreatedAt = time.Now()
+	logData.RestoredAt = nil
+	logData.Sequence = 0
+	logData.PrevHash = ""
+	logData.Hash = ""
+	logData.KeyID = ""
+	logData.DataKey = ""
+	logData.Ciphertext = ""
+	logData.LegalHolds = nil
+	logData.Tenant = ""
 
 	return logData, nil
 }
+
+// canDecrypt reports whether the request may read encrypted log fields.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+// Returns
+//	bool - True if the request's token grants the decrypt permission.
+//
+func canDecrypt(c *gin.Context) bool {
+	permission := config.GetConfig().Encryption.DecryptPermission
+	if permission == "" {
+		permission = defaultDecryptPermission
+	}
+
+	return security.HasPermission(c, permission)
+}
+
+// unmaskedPolicies returns the names of the masking policies the request may see through, every policy when its
+// token grants Masking.UNMASKED_PERMISSION and otherwise those exempting one of its scopes or permissions.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+// Returns
+//	[]string - Names of the policies.
+//
+func unmaskedPolicies(c *gin.Context) []string {
+	conf := config.GetConfig().Masking
+	permission := conf.UnmaskedPermission
+	if permission == "" {
+		permission = defaultUnmaskedPermission
+	}
+	all := security.HasPermission(c, permission)
+
+	unmasked := []string{}
+	for _, policy := range conf.Policies {
+		exempt := all
+		for _, granted := range policy.Exempt {
+			exempt = exempt || security.HasPermission(c, granted)
+		}
+		if exempt {
+			unmasked = append(unmasked, policy.Name)
+		}
+	}
+
+	return unmasked
+}
+
+// requireAllowedLocation aborts the request unless it may read the location it searches. Requests authenticated with
+// an api key bound to locations must search one of them.
+//
+// Parameters:
+//	*gin.Context			c		- Handler context from gin.
+//	models.LogSearchFields	fields	- Search fields of the request.
+//
+// Returns
+//	bool - True if the request may continue.
+//
+func requireAllowedLocation(c *gin.Context, fields models.LogSearchFields) bool {
+	if !security.AllowsLocation(c, fields.Location) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"Error": "location: the api key may only read its own locations"})
+		return false
+	}
+
+	return true
+}
+
+// requirePermission aborts the request unless its token grants a permission.
+//
+// Parameters:
+//	*gin.Context	c					- Handler context from gin.
+//	string			permission			- Configured permission.
+//	string			defaultPermission	- Permission required when none is configured.
+//
+// Returns
+//	bool - True if the permission is granted.
+//
+func requirePermission(c *gin.Context, permission string, defaultPermission string) bool {
+	if permission == "" {
+		permission = defaultPermission
+	}
+	if !security.HasPermission(c, permission) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"Error": "missing permission " + permission})
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,104 @@
+package handlers
+
+/*
+ *
+ * file: 		log_handler_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests masking the logs read by callers according to their scopes and permissions.
+ *
+ */
+
+import (
+	"logging_service/config"
+	"logging_service/models"
+	"logging_service/security"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLogsAreMaskedUnlessTheCallerIsExempt(t *testing.T) {
+	policies := `Masking:
+    HASH_KEY: secret
+    POLICIES:
+        - NAME: cards
+          PATTERN: '\d{16}'
+        - NAME: emails
+          EXTRA_FIELDS: [email]
+          ACTION: hash
+          EXEMPT: [read:emails]
+`
+	tests := []struct {
+		name         string
+		scopes       string
+		wantUnmasked []string
+	}{
+		{"reader", "[logs:read]", []string{}},
+		{"exempt from one policy", "[logs:read, read:emails]", []string{"emails"}},
+		{"unmasked permission", "[logs:read, read:unmasked]", []string{"cards", "emails"}},
+	}
+	for _, test := range tests {
+		useConfig(t, policies+"Auth:\n    PROVIDERS:\n        - TYPE: disabled\n          SCOPES: "+test.scopes+"\n")
+		conf := config.GetConfig().Masking
+		if err := models.LoadMaskingPolicies(conf.Policies, conf.HashKey); err != nil {
+			t.Fatal(err)
+		}
+
+		logs := []models.Log{{Message: "paid with 4111111111111111", Extra: []string{"email=someone@example.com"}}}
+		unmasked := servePolicies(t)
+		models.MaskLogs(logs, unmasked)
+		if !reflect.DeepEqual(unmasked, test.wantUnmasked) {
+			t.Errorf("%s: saw through %q, want %q", test.name, unmasked, test.wantUnmasked)
+		}
+		if revealed := logs[0].Message == "paid with 4111111111111111"; revealed != containsPolicy(test.wantUnmasked, "cards") {
+			t.Errorf("%s: read the message %q", test.name, logs[0].Message)
+		}
+		if revealed := logs[0].Extra[0] == "email=someone@example.com"; revealed != containsPolicy(test.wantUnmasked, "emails") {
+			t.Errorf("%s: read the extra field %q", test.name, logs[0].Extra[0])
+		}
+	}
+	models.LoadMaskingPolicies(nil, "")
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// servePolicies serves a request from localhost and returns the masking policies it may see through.
+func servePolicies(t *testing.T) []string {
+	var unmasked []string
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(security.Authenticate())
+	router.GET("/logs", func(c *gin.Context) {
+		unmasked = unmaskedPolicies(c)
+		c.Status(http.StatusOK)
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/logs", nil)
+	request.RemoteAddr = "127.0.0.1:1234"
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("responded %d", recorder.Code)
+	}
+
+	return unmasked
+}
+
+// containsPolicy reports whether a list of policy names holds a name.
+func containsPolicy(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
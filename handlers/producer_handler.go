@@ -0,0 +1,155 @@
+package handlers
+
+/*
+ *
+ * file: 		producer_handler.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the handlers for creating, listing, rotating and revoking the producers signing ingestion requests.
+ *
+ */
+
+import (
+	"log"
+	"logging_service/config"
+	"logging_service/models"
+	"logging_service/security"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultProducerPermission is required to manage producers when Signing.PERMISSION is not set.
+const defaultProducerPermission = "manage:producers"
+
+// HandleGetProducers responds with the producers of the request's tenant, or every producer when it is not scoped to
+// one, without their secrets.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetProducers(c *gin.Context) {
+	if !requireProducers(c) {
+		return
+	}
+
+	tenant, _ := models.TenantOf(c.Request.Context())
+	c.JSON(http.StatusOK, security.ListProducers(tenant))
+}
+
+// HandlePostProducer creates a producer from a json payload with a name and tenant. A request scoped to a tenant
+// always creates a producer of its tenant. The producer's secret is only included in this response.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandlePostProducer(c *gin.Context) {
+	if !requireProducers(c) {
+		return
+	}
+	producer := security.Producer{}
+	if err := c.ShouldBindJSON(&producer); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+	if err := producer.Validate(); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+	if tenant, ok := models.TenantOf(c.Request.Context()); ok {
+		producer.Tenant = tenant
+	}
+
+	issued, err := security.CreateProducer(producer, security.GetClaims(c).Subject)
+	if !respondProducerError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusCreated, issued)
+}
+
+// HandlePostProducerRotation gives the producer of the id parameter, within the request's tenant, a new secret,
+// keeping its current secret valid for Signing.ROTATION_GRACE_HOURS. The new secret is only included in this response.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandlePostProducerRotation(c *gin.Context) {
+	if !requireProducers(c) {
+		return
+	}
+
+	tenant, _ := models.TenantOf(c.Request.Context())
+	issued, err := security.RotateProducer(c.Param("id"), tenant, security.GetClaims(c).Subject)
+	if !respondProducerError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, issued)
+}
+
+// HandleDeleteProducer revokes the producer of the id parameter within the request's tenant.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleDeleteProducer(c *gin.Context) {
+	if !requireProducers(c) {
+		return
+	}
+
+	tenant, _ := models.TenantOf(c.Request.Context())
+	producer, err := security.RevokeProducer(c.Param("id"), tenant, security.GetClaims(c).Subject)
+	if !respondProducerError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, producer)
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// requireProducers aborts the request unless request signing is configured and its token may manage producers.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+// Returns
+//	bool - True if the request may continue.
+//
+func requireProducers(c *gin.Context) bool {
+	if !security.ProducersEnabled() {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": "request signing is not enabled"})
+		return false
+	}
+
+	return requirePermission(c, config.GetConfig().Signing.Permission, defaultProducerPermission)
+}
+
+// respondProducerError aborts the request if creating, rotating or revoking a producer failed.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//	error			err	- Error that occurred or nil.
+//
+// Returns
+//	bool - True if there was no error.
+//
+func respondProducerError(c *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	if err == security.ErrProducerNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"Error": err.Error()})
+	} else {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "internal server error"})
+	}
+
+	return false
+}
@@ -0,0 +1,26 @@
+package handlers
+
+/*
+ *
+ * file: 		retention_handler.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the handler reporting the retention policy and purge status.
+ *
+ */
+
+import (
+	"logging_service/jobs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleGetRetention responds with the effective retention policy, how it is enforced and when logs were last purged.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+func HandleGetRetention(c *gin.Context) {
+	c.JSON(http.StatusOK, jobs.GetRetentionStatus())
+}
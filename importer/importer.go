@@ -0,0 +1,599 @@
+package importer
+
+/*
+ *
+ * file: 		importer.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the import of logs from ndjson files, json arrays and legacy log files.
+ *
+ */
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"logging_service/core"
+	"logging_service/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Formats logs can be imported from.
+const (
+	FormatAuto   = "auto"
+	FormatNDJSON = "ndjson"
+	FormatJSON   = "json"
+)
+
+// defaultBatchSize is used when Options.BatchSize is not set.
+const defaultBatchSize = 500
+
+// maxLineSize is the longest ndjson line that can be read.
+const maxLineSize = 16 * 1024 * 1024
+
+// legacyExtensions are the extensions of the files imported from a directory.
+var legacyExtensions = []string{".json", ".ndjson", ".jsonl", ".log"}
+
+// Fields are the log fields a row's keys can be mapped to.
+var Fields = []string{"created_at", "log_level", "message", "location", "extra"}
+
+// DefaultMapping lists the keys each field is read from when it is not mapped, in order of preference. It covers the
+// keys of the legacy file logs and of json dumps such as tests/MOCK_DATA.json.
+var DefaultMapping = map[string][]string{
+	"created_at": {"created_at", "created_date", "CreatedDate", "createdAt", "timestamp", "time"},
+	"log_level":  {"log_level", "type", "level", "LogLevel"},
+	"message":    {"message", "msg", "Message"},
+	"location":   {"location", "Location"},
+	"extra":      {"extra", "Extra"},
+}
+
+// DefaultLevelAliases normalizes log levels used by other loggers.
+var DefaultLevelAliases = map[string]string{
+	"WARN":        "WARNING",
+	"ERR":         "ERROR",
+	"CRIT":        "FATAL",
+	"CRITICAL":    "FATAL",
+	"PANIC":       "FATAL",
+	"TRACE":       "DEBUG",
+	"NOTICE":      "INFO",
+	"INFORMATION": "INFO",
+}
+
+// defaultTimeLayouts are tried in order when parsing created_at strings.
+var defaultTimeLayouts = []string{time.RFC3339Nano, core.LogDateFormat, "2006-01-02T15:04:05", "2006-01-02 15:04:05", core.CreatedDayFormat}
+
+// Options describes how rows are read and converted to logs. Mapping maps a field to the key it is read from, and
+// LevelAliases maps upper case log levels to one of the log levels. DefaultLevel is used for rows without a log level
+// when the file name does not name one. TimeLayouts are tried before the default layouts.
+type Options struct {
+	Format       string
+	Mapping      map[string]string
+	LevelAliases map[string]string
+	DefaultLevel string
+	TimeLayouts  []string
+	KeepUnmapped bool
+	BatchSize    int
+	DryRun       bool
+	MaxRejected  int
+}
+
+// Rejection describes a row that was not imported.
+type Rejection struct {
+	Source  string   `json:"source"`
+	Reasons []string `json:"reasons"`
+}
+
+// Summary describes the outcome of an import. Reasons counts rejected rows by reason, and Rejections lists the first
+// rejected rows.
+type Summary struct {
+	Files      int              `json:"files"`
+	Read       int64            `json:"read"`
+	Valid      int64            `json:"valid"`
+	Imported   int64            `json:"imported"`
+	Rejected   int64            `json:"rejected"`
+	DryRun     bool             `json:"dry_run,omitempty"`
+	Reasons    map[string]int64 `json:"reasons"`
+	Rejections []Rejection      `json:"rejections"`
+}
+
+// importer holds the state of one import.
+type importer struct {
+	options Options
+	summary Summary
+	batch   []models.Log
+}
+
+// ParseMapping parses comma separated field=key pairs.
+//
+// Parameters:
+//	string	value	- Pairs such as 'created_at=ts,message=msg'.
+//
+// Returns
+//	map[string]string	- Key of each mapped field.
+//	error				- Error if a pair is malformed or names an unknown field.
+//
+func ParseMapping(value string) (map[string]string, error) {
+	pairs, err := parsePairs(value)
+	if err != nil {
+		return nil, errors.New("map: " + err.Error())
+	}
+	for field := range pairs {
+		if !isField(field) {
+			return nil, errors.New("map: unknown field " + field)
+		}
+	}
+
+	return pairs, nil
+}
+
+// ParseLevelAliases parses comma separated alias=LEVEL pairs, which are added to the default aliases.
+//
+// Parameters:
+//	string	value	- Pairs such as 'warn=WARNING,severe=ERROR'.
+//
+// Returns
+//	map[string]string	- Log level of each upper case alias.
+//	error				- Error if a pair is malformed or names an unknown log level.
+//
+func ParseLevelAliases(value string) (map[string]string, error) {
+	pairs, err := parsePairs(value)
+	if err != nil {
+		return nil, errors.New("levels: " + err.Error())
+	}
+
+	aliases := map[string]string{}
+	for alias, level := range DefaultLevelAliases {
+		aliases[alias] = level
+	}
+	for alias, level := range pairs {
+		level = strings.ToUpper(level)
+		if !isLogLevel(level) {
+			return nil, errors.New("levels: unknown log level " + level)
+		}
+		aliases[strings.ToUpper(alias)] = level
+	}
+
+	return aliases, nil
+}
+
+// Import reads every row of the files, and the files with a known extension under the directories, validates each with
+// the rules of the create endpoint and creates the valid rows in batches, keeping their original creation times. Rows
+// that are not valid are counted in the summary and the import goes on. Logs are given ids from their creation times so
+// they sort in the order they were created.
+//
+// Parameters:
+//	context.Context	ctx		- Context of the import.
+//	[]string		paths	- Files and directories to import.
+//	Options			options	- Import options.
+//
+// Returns
+//	Summary	- Numbers of rows read, imported and rejected.
+//	error	- Error if a file cannot be read or logs cannot be stored.
+//
+func Import(ctx context.Context, paths []string, options Options) (Summary, error) {
+	if options.Format == "" {
+		options.Format = FormatAuto
+	}
+	if options.Format != FormatAuto && options.Format != FormatNDJSON && options.Format != FormatJSON {
+		return Summary{}, errors.New("format: must be 'auto', 'ndjson' or 'json'")
+	}
+	if options.LevelAliases == nil {
+		options.LevelAliases = DefaultLevelAliases
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultBatchSize
+	}
+	options.DefaultLevel = strings.ToUpper(options.DefaultLevel)
+	if options.DefaultLevel != "" && !isLogLevel(options.DefaultLevel) {
+		return Summary{}, errors.New("default level: unknown log level " + options.DefaultLevel)
+	}
+
+	files, err := listFiles(paths)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	im := &importer{
+		options: options,
+		summary: Summary{Files: len(files), DryRun: options.DryRun, Reasons: map[string]int64{}, Rejections: []Rejection{}},
+	}
+	for _, file := range files {
+		if err := im.importFile(ctx, file); err != nil {
+			return im.summary, err
+		}
+	}
+	err = im.flush(ctx)
+
+	return im.summary, err
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// importFile reads the rows of a json array or ndjson file.
+func (im *importer) importFile(ctx context.Context, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	format := im.options.Format
+	if format == FormatAuto {
+		format = detectFormat(reader)
+	}
+	fileLevel := levelFromName(filepath.Base(path))
+
+	if format == FormatJSON {
+		return im.readArray(ctx, path, reader, fileLevel)
+	}
+	return im.readLines(ctx, path, reader, fileLevel)
+}
+
+// readLines reads a row from every line of an ndjson file, rejecting lines that are not json objects.
+func (im *importer) readLines(ctx context.Context, path string, reader io.Reader, fileLevel string) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		content := bytes.TrimSpace(scanner.Bytes())
+		if len(content) == 0 {
+			continue
+		}
+
+		source := path + ":" + strconv.Itoa(line)
+		row := map[string]interface{}{}
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		if err := decoder.Decode(&row); err != nil {
+			im.summary.Read++
+			im.reject(source, []string{"invalid json"})
+			continue
+		}
+		if err := im.add(ctx, source, row, fileLevel); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// readArray reads a row from every element of a json array, one element at a time. A malformed element rejects the rest
+// of the file, since the array cannot be read past it.
+func (im *importer) readArray(ctx context.Context, path string, reader io.Reader, fileLevel string) error {
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		im.reject(path, []string{"invalid json: not an array"})
+		return nil
+	}
+
+	for index := 0; decoder.More(); index++ {
+		source := path + "[" + strconv.Itoa(index) + "]"
+		row := map[string]interface{}{}
+		if err := decoder.Decode(&row); err != nil {
+			im.summary.Read++
+			im.reject(source, []string{"invalid json: rest of file skipped"})
+			return nil
+		}
+		if err := im.add(ctx, source, row, fileLevel); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// add converts a row to a log and adds it to the batch, or records why it was rejected.
+func (im *importer) add(ctx context.Context, source string, row map[string]interface{}, fileLevel string) error {
+	im.summary.Read++
+	l, reasons := im.convert(row, fileLevel)
+	if len(reasons) > 0 {
+		im.reject(source, reasons)
+		return nil
+	}
+
+	im.summary.Valid++
+	if im.options.DryRun {
+		return nil
+	}
+	im.batch = append(im.batch, l)
+	if len(im.batch) >= im.options.BatchSize {
+		return im.flush(ctx)
+	}
+
+	return nil
+}
+
+// flush creates the logs of the batch.
+func (im *importer) flush(ctx context.Context) error {
+	if len(im.batch) == 0 {
+		return nil
+	}
+	if err := models.CreateLogs(ctx, im.batch); err != nil {
+		return err
+	}
+
+	im.summary.Imported += int64(len(im.batch))
+	im.batch = nil
+	return nil
+}
+
+// reject records a rejected row.
+func (im *importer) reject(source string, reasons []string) {
+	im.summary.Rejected++
+	for _, reason := range reasons {
+		im.summary.Reasons[reason]++
+	}
+	if len(im.summary.Rejections) < im.options.MaxRejected {
+		im.summary.Rejections = append(im.summary.Rejections, Rejection{Source: source, Reasons: reasons})
+	}
+}
+
+// convert maps a row to a new log and returns the reasons it is not valid.
+func (im *importer) convert(row map[string]interface{}, fileLevel string) (models.Log, []string) {
+	l := models.Log{}
+	reasons := []string{}
+	used := map[string]bool{}
+
+	createdAt, key := im.lookup(row, "created_at")
+	used[key] = true
+	if createdAt == nil {
+		reasons = append(reasons, "missing field: created_at")
+	} else if t, ok := im.parseTime(createdAt); ok {
+		l.CreatedAt = t
+		if models.GetHashChain() == nil {
+			l.ID = objectIDAt(t)
+		}
+	} else {
+		reasons = append(reasons, "invalid created_at")
+	}
+
+	level, key := im.lookup(row, "log_level")
+	used[key] = true
+	l.LogLevel = im.normalizeLevel(stringValue(level))
+	if l.LogLevel == "" {
+		l.LogLevel = fileLevel
+	}
+	if l.LogLevel == "" {
+		l.LogLevel = im.options.DefaultLevel
+	}
+
+	message, key := im.lookup(row, "message")
+	used[key] = true
+	l.Message = stringValue(message)
+
+	location, key := im.lookup(row, "location")
+	used[key] = true
+	l.Location = stringValue(location)
+
+	extra, key := im.lookup(row, "extra")
+	used[key] = true
+	switch v := extra.(type) {
+	case nil:
+	case []interface{}:
+		for _, value := range v {
+			l.Extra = append(l.Extra, stringValue(value))
+		}
+	default:
+		l.Extra = append(l.Extra, stringValue(v))
+	}
+
+	if im.options.KeepUnmapped {
+		keys := []string{}
+		for k := range row {
+			if !used[k] {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			l.Extra = append(l.Extra, k+"="+stringValue(row[k]))
+		}
+	}
+
+	return l, append(reasons, l.CreateErrors()...)
+}
+
+// lookup returns the value of a field in a row and the key it was read from.
+func (im *importer) lookup(row map[string]interface{}, field string) (interface{}, string) {
+	if key, ok := im.options.Mapping[field]; ok {
+		return row[key], key
+	}
+	for _, key := range DefaultMapping[field] {
+		if value, ok := row[key]; ok {
+			return value, key
+		}
+	}
+
+	return nil, ""
+}
+
+// parseTime parses a creation time string with the configured and default layouts, or a number of unix seconds or
+// milliseconds.
+func (im *importer) parseTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case string:
+		for _, layout := range append(im.options.TimeLayouts, defaultTimeLayouts...) {
+			if t, err := time.Parse(layout, strings.TrimSpace(v)); err == nil {
+				return t.UTC(), true
+			}
+		}
+	case json.Number:
+		seconds, err := v.Int64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		if seconds > 1e12 || seconds < -1e12 {
+			return time.Unix(0, seconds*int64(time.Millisecond)).UTC(), true
+		}
+		return time.Unix(seconds, 0).UTC(), true
+	}
+
+	return time.Time{}, false
+}
+
+// normalizeLevel returns the upper case log level, replaced by its alias if it has one.
+func (im *importer) normalizeLevel(level string) string {
+	level = strings.ToUpper(strings.TrimSpace(level))
+	if alias, ok := im.options.LevelAliases[level]; ok {
+		return alias
+	}
+
+	return level
+}
+
+// listFiles returns the files to import, walking directories for files with a known extension.
+func listFiles(paths []string) ([]string, error) {
+	files := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && hasLegacyExtension(file) {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// detectFormat reports whether a file holds a json array by its first character.
+func detectFormat(reader *bufio.Reader) string {
+	for size := 1; size <= 4096; size *= 2 {
+		peeked, _ := reader.Peek(size)
+		if trimmed := bytes.TrimLeft(peeked, " \t\r\n"); len(trimmed) > 0 {
+			if trimmed[0] == '[' {
+				return FormatJSON
+			}
+			return FormatNDJSON
+		}
+		if len(peeked) < size {
+			break
+		}
+	}
+
+	return FormatNDJSON
+}
+
+// levelFromName returns the log level named in a legacy log file's name, such as DEBUG_2020-11-10.log.
+func levelFromName(name string) string {
+	upper := strings.ToUpper(name)
+	for _, level := range core.LogLevels {
+		if strings.Contains(upper, level) {
+			return level
+		}
+	}
+
+	return ""
+}
+
+// objectIDAt returns a new id whose timestamp is the given time, so imported logs sort by creation time. Chained logs
+// are not given one, as the chain is walked in id order and imported logs are appended to its head.
+func objectIDAt(t time.Time) primitive.ObjectID {
+	id := primitive.NewObjectID()
+	if t.Unix() < 0 || t.Unix() > int64(^uint32(0)) {
+		return id
+	}
+	seconds := uint32(t.Unix())
+	id[0], id[1], id[2], id[3] = byte(seconds>>24), byte(seconds>>16), byte(seconds>>8), byte(seconds)
+
+	return id
+}
+
+// stringValue returns a row value as a string, encoding values that are not strings as json.
+func stringValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+// parsePairs parses comma separated key=value pairs.
+func parsePairs(value string) (map[string]string, error) {
+	pairs := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, errors.New("'" + pair + "' must be a key=value pair")
+		}
+		pairs[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return pairs, nil
+}
+
+// hasLegacyExtension reports whether a file in an imported directory should be read.
+func hasLegacyExtension(file string) bool {
+	extension := strings.ToLower(filepath.Ext(file))
+	for _, val := range legacyExtensions {
+		if val == extension {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isField reports whether a field can be mapped.
+func isField(field string) bool {
+	for _, val := range Fields {
+		if val == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isLogLevel reports whether a log level is one of the log levels.
+func isLogLevel(level string) bool {
+	for _, val := range core.LogLevels {
+		if val == level {
+			return true
+		}
+	}
+
+	return false
+}
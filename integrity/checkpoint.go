@@ -0,0 +1,193 @@
+package integrity
+
+/*
+ *
+ * file: 		checkpoint.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the signed checkpoints recording the head of every hash chain.
+ *
+ */
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"logging_service/models"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Checkpoint records the head of every hash chain at a point in time, signed with the service's ed25519 key.
+type Checkpoint struct {
+	CreatedAt time.Time          `json:"created_at"`
+	Heads     []models.ChainHead `json:"heads"`
+	Signature string             `json:"signature,omitempty"`
+}
+
+// LoadSigningKey reads an ed25519 private key from a file holding its base64 encoded 32 byte seed.
+//
+// Parameters:
+//	string	path	- Path of the key file.
+//
+// Returns
+//	ed25519.PrivateKey	- Signing key.
+//	error				- Any error that occurs.
+//
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	if path == "" {
+		return nil, errors.New("integrity: signing key file is required")
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, errors.New("integrity: signing key must be base64 encoded")
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.New("integrity: signing key must be a 32 byte ed25519 seed")
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// Sign signs the checkpoint.
+//
+// Receiver:
+//	*Checkpoint		c
+//
+// Parameters:
+//	ed25519.PrivateKey	key	- Signing key.
+//
+func (c *Checkpoint) Sign(key ed25519.PrivateKey) {
+	c.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, c.signedContent()))
+}
+
+// Verify checks the checkpoint's signature.
+//
+// Receiver:
+//	*Checkpoint		c
+//
+// Parameters:
+//	ed25519.PublicKey	key	- Public key of the signing key.
+//
+// Returns
+//	bool - True if the signature is valid.
+//
+func (c *Checkpoint) Verify(key ed25519.PublicKey) bool {
+	signature, err := base64.StdEncoding.DecodeString(c.Signature)
+	if err != nil {
+		return false
+	}
+
+	return ed25519.Verify(key, c.signedContent(), signature)
+}
+
+// signedContent is the checkpoint encoded without its signature.
+func (c *Checkpoint) signedContent() []byte {
+	unsigned := *c
+	unsigned.Signature = ""
+	content, _ := json.Marshal(unsigned)
+	return content
+}
+
+// CheckpointFile is an append only ndjson file of checkpoints.
+type CheckpointFile struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// NewCheckpointFile creates a checkpoint file at a path. The file is created when the first checkpoint is appended.
+//
+// Parameters:
+//	string	path	- Path of the file.
+//
+// Returns
+//	*CheckpointFile	- Checkpoint file.
+//	error			- Error if no path is given.
+//
+func NewCheckpointFile(path string) (*CheckpointFile, error) {
+	if path == "" {
+		return nil, errors.New("integrity: checkpoint file is required")
+	}
+
+	return &CheckpointFile{path: path}, nil
+}
+
+// Append writes a checkpoint to the end of the file and syncs it to disk.
+//
+// Receiver:
+//	*CheckpointFile		cf
+//
+// Parameters:
+//	Checkpoint	checkpoint	- Checkpoint to write.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (cf *CheckpointFile) Append(checkpoint Checkpoint) error {
+	cf.mutex.Lock()
+	defer cf.mutex.Unlock()
+
+	content, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(cf.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(content, '\n')); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// Last returns the last checkpoint in the file.
+//
+// Receiver:
+//	*CheckpointFile		cf
+//
+// Returns
+//	*Checkpoint	- Last checkpoint, nil if none has been written.
+//	error		- Any error that occurs.
+//
+func (cf *CheckpointFile) Last() (*Checkpoint, error) {
+	cf.mutex.Lock()
+	defer cf.mutex.Unlock()
+
+	file, err := os.Open(cf.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var last *Checkpoint
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		checkpoint := Checkpoint{}
+		if err := json.Unmarshal(scanner.Bytes(), &checkpoint); err != nil {
+			return nil, err
+		}
+		last = &checkpoint
+	}
+
+	return last, scanner.Err()
+}
@@ -0,0 +1,175 @@
+package integrity
+
+/*
+ *
+ * file: 		verify.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the verification of hash chains against the stored logs and the last checkpoint.
+ *
+ */
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"logging_service/models"
+	"sort"
+	"time"
+)
+
+// maxBreaksPerChain is the number of breaks listed for each chain. Further breaks are only counted.
+const maxBreaksPerChain = 100
+
+// ChainBreak describes where a chain is broken.
+type ChainBreak struct {
+	Sequence int64  `json:"sequence"`
+	ID       string `json:"id,omitempty"`
+	Problem  string `json:"problem"`
+}
+
+// ChainReport describes the verification of one chain.
+type ChainReport struct {
+	Location      string       `json:"location"`
+	LogLevel      string       `json:"log_level"`
+	FirstSequence int64        `json:"first_sequence"`
+	LastSequence  int64        `json:"last_sequence"`
+	Verified      int64        `json:"verified"`
+	BreakCount    int64        `json:"break_count"`
+	Breaks        []ChainBreak `json:"breaks"`
+}
+
+// VerifyResults describes the verification of every chain.
+type VerifyResults struct {
+	Intact       bool          `json:"intact"`
+	CheckpointAt *time.Time    `json:"checkpoint_at,omitempty"`
+	Problems     []string      `json:"problems"`
+	Chains       []ChainReport `json:"chains"`
+}
+
+// chainState tracks a chain while its logs are read.
+type chainState struct {
+	report   ChainReport
+	lastHash string
+}
+
+// Verify reads every chained log in ascending id order, recomputing each hash and checking that sequences and previous
+// hashes link up. Chains are then compared with the last checkpoint to find logs removed from the end of a chain, and
+// with the first sequence it recorded to find logs removed from the start. Retention, archiving and confirmed
+// deletions write a checkpoint after removing logs, so only removals made since the last checkpoint by anything else
+// are reported. A chain starting after sequence 1 is only a break if the checkpoint recorded an earlier start.
+//
+// Parameters:
+//	string				location	- Location to verify, empty for every location.
+//	*Checkpoint			checkpoint	- Last checkpoint, nil if none has been written.
+//	ed25519.PublicKey	key			- Public key checkpoints are signed with.
+//
+// Returns
+//	VerifyResults	- Where each chain is broken.
+//	error			- Any error that occurs.
+//
+func Verify(ctx context.Context, location string, checkpoint *Checkpoint, key ed25519.PublicKey) (VerifyResults, error) {
+	results := VerifyResults{Problems: []string{}, Chains: []ChainReport{}}
+	checkpointHeads := map[string]models.ChainHead{}
+	if checkpoint != nil {
+		results.CheckpointAt = &checkpoint.CreatedAt
+		if checkpoint.Verify(key) {
+			for _, head := range checkpoint.Heads {
+				if location == "" || head.Location == location {
+					checkpointHeads[head.LogLevel+"\x00"+head.Location] = head
+				}
+			}
+		} else {
+			results.Problems = append(results.Problems, "the last checkpoint's signature is invalid")
+		}
+	}
+
+	chains := map[string]*chainState{}
+	err := models.GetLogStore().Iterate(ctx, models.LogSearchFields{Location: location, Chained: true}, func(l *models.Log) error {
+		key := l.LogLevel + "\x00" + l.Location
+		advance := true
+		state, ok := chains[key]
+		if !ok {
+			state = &chainState{report: ChainReport{Location: l.Location, LogLevel: l.LogLevel, FirstSequence: l.Sequence, Breaks: []ChainBreak{}}}
+			chains[key] = state
+			if l.Sequence == 1 && l.PrevHash != "" {
+				state.addBreak(l, "the first log has a previous hash")
+			}
+		} else if l.Sequence <= state.report.LastSequence {
+			state.addBreak(l, fmt.Sprintf("sequence %d follows sequence %d, the log is out of order or repeated", l.Sequence, state.report.LastSequence))
+			advance = false
+		} else if l.Sequence == state.report.LastSequence+2 {
+			state.addBreak(l, fmt.Sprintf("sequence %d is missing", l.Sequence-1))
+		} else if l.Sequence > state.report.LastSequence+2 {
+			state.addBreak(l, fmt.Sprintf("sequences %d to %d are missing", state.report.LastSequence+1, l.Sequence-1))
+		} else if l.PrevHash != state.lastHash {
+			state.addBreak(l, fmt.Sprintf("the previous hash does not match the hash of sequence %d", state.report.LastSequence))
+		}
+
+		if models.ComputeLogHash(l) != l.Hash {
+			state.addBreak(l, "the hash does not match the log's content, the log was modified")
+		}
+		if head, ok := checkpointHeads[key]; ok && head.Sequence == l.Sequence && head.Hash != l.Hash {
+			state.addBreak(l, "the hash does not match the last checkpoint")
+		}
+
+		state.report.Verified++
+		if advance {
+			state.report.LastSequence = l.Sequence
+			state.lastHash = l.Hash
+		}
+		return nil
+	})
+	if err != nil {
+		return results, err
+	}
+
+	for key, head := range checkpointHeads {
+		state, ok := chains[key]
+		if !ok {
+			state = &chainState{report: ChainReport{Location: head.Location, LogLevel: head.LogLevel, Breaks: []ChainBreak{}}}
+			chains[key] = state
+		}
+		if head.FirstSequence > 0 && state.report.FirstSequence > head.FirstSequence {
+			state.report.BreakCount++
+			state.report.Breaks = append(state.report.Breaks, ChainBreak{
+				Sequence: head.FirstSequence,
+				Problem: fmt.Sprintf("sequences %d to %d were removed from the start of the chain, the last checkpoint recorded its first sequence %d",
+					head.FirstSequence, state.report.FirstSequence-1, head.FirstSequence),
+			})
+		}
+		// A checkpoint recording a first sequence past the head was taken after every log of the chain was removed.
+		emptied := head.FirstSequence > head.Sequence
+		if state.report.LastSequence < head.Sequence && (ok || !emptied) {
+			state.report.BreakCount++
+			state.report.Breaks = append(state.report.Breaks, ChainBreak{
+				Sequence: state.report.LastSequence + 1,
+				Problem:  fmt.Sprintf("the chain is truncated, the last checkpoint recorded sequence %d", head.Sequence),
+			})
+		}
+	}
+
+	results.Intact = len(results.Problems) == 0
+	for _, state := range chains {
+		results.Chains = append(results.Chains, state.report)
+		if state.report.BreakCount > 0 {
+			results.Intact = false
+		}
+	}
+	sort.Slice(results.Chains, func(i, j int) bool {
+		if results.Chains[i].Location != results.Chains[j].Location {
+			return results.Chains[i].Location < results.Chains[j].Location
+		}
+		return results.Chains[i].LogLevel < results.Chains[j].LogLevel
+	})
+
+	return results, nil
+}
+
+// addBreak records a break at a log.
+func (cs *chainState) addBreak(l *models.Log, problem string) {
+	cs.report.BreakCount++
+	if len(cs.report.Breaks) < maxBreaksPerChain {
+		cs.report.Breaks = append(cs.report.Breaks, ChainBreak{Sequence: l.Sequence, ID: l.ID.Hex(), Problem: problem})
+	}
+}
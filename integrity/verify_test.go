@@ -0,0 +1,132 @@
+package integrity
+
+/*
+ *
+ * file: 		verify_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests the verification of hash chains against the last checkpoint and of chains holding imported logs.
+ *
+ */
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"logging_service/database"
+	"logging_service/importer"
+	"logging_service/models"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestVerifyDetectsRemovedChainStart(t *testing.T) {
+	fs, err := database.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	models.SetLogStore(fs)
+	ctx := context.Background()
+
+	chain := models.NewHashChain(nil)
+	start := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	ids := []primitive.ObjectID{}
+	for i, message := range []string{"a", "b", "c", "d"} {
+		l := models.Log{CreatedAt: start.Add(time.Duration(i) * time.Hour), LogLevel: "INFO", Location: "billing", Message: message}
+		if err := chain.Append(ctx, &l); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, l.ID)
+	}
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpointOf := func() *Checkpoint {
+		heads, err := chain.Heads(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkpoint := &Checkpoint{CreatedAt: time.Now().UTC(), Heads: heads}
+		checkpoint.Sign(private)
+		return checkpoint
+	}
+	checkpoint := checkpointOf()
+	if first := checkpoint.Heads[0].FirstSequence; first != 1 {
+		t.Fatalf("checkpoint recorded first sequence %d, want 1", first)
+	}
+
+	// Removing the oldest logs without trimming the chain is reported.
+	if deleted, err := fs.Delete(ctx, models.LogSearchFields{LogLevel: "INFO", IDs: ids[:2]}); err != nil || deleted != 2 {
+		t.Fatalf("deleted %d logs, %v, want 2", deleted, err)
+	}
+	results, err := Verify(ctx, "", checkpoint, public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Intact || len(results.Chains) != 1 || results.Chains[0].BreakCount != 1 {
+		t.Fatalf("verified %+v, want one break", results)
+	}
+
+	// A removal followed by a trim and a checkpoint, as retention and archiving do, is accepted.
+	if err := chain.Trim(ctx); err != nil {
+		t.Fatal(err)
+	}
+	results, err = Verify(ctx, "", checkpointOf(), public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results.Intact || results.Chains[0].FirstSequence != 3 {
+		t.Errorf("verified %+v after the trim, want an intact chain starting at sequence 3", results)
+	}
+}
+
+func TestVerifyImportedLogs(t *testing.T) {
+	fs, err := database.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	models.SetLogStore(fs)
+	models.SetHashChain(models.NewHashChain(nil))
+	defer models.SetHashChain(nil)
+	ctx := context.Background()
+
+	appendLog := func(message string) {
+		l := models.Log{CreatedAt: time.Now().UTC(), LogLevel: "INFO", Location: "billing", Message: message}
+		if err := models.GetHashChain().Append(ctx, &l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendLog("live")
+
+	// Logs created years ago are appended to the head of the chain.
+	path := filepath.Join(t.TempDir(), "legacy.ndjson")
+	rows := `{"created_at": "2019-05-01T10:00:00Z", "log_level": "INFO", "location": "billing", "message": "old"}
+{"created_at": "2019-05-02T10:00:00Z", "log_level": "INFO", "location": "billing", "message": "older"}
+`
+	if err := ioutil.WriteFile(path, []byte(rows), 0644); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := importer.Import(ctx, []string{path}, importer.Options{})
+	if err != nil || summary.Imported != 2 {
+		t.Fatalf("imported %+v, %v, want 2 logs", summary, err)
+	}
+
+	// After a restart the head is read back from the store, so the next log must follow the imported ones.
+	models.SetHashChain(models.NewHashChain(nil))
+	appendLog("after restart")
+
+	results, err := Verify(ctx, "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results.Intact || len(results.Chains) != 1 || results.Chains[0].Verified != 4 || results.Chains[0].LastSequence != 4 {
+		t.Errorf("verified %+v, want one intact chain of 4 logs", results)
+	}
+}
@@ -0,0 +1,192 @@
+package jobs
+
+/*
+ *
+ * file: 		archive_job.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the scheduled job that moves old logs into the cold archive.
+ *
+ */
+
+import (
+	"context"
+	"errors"
+	"log"
+	"logging_service/archive"
+	"logging_service/config"
+	"logging_service/models"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultArchiveInterval is used when Archive.INTERVAL_MINUTES is not set.
+const defaultArchiveInterval = 24 * time.Hour
+
+// ArchiveStatus describes the archive configuration and the outcome of the last archive run.
+type ArchiveStatus struct {
+	Levels        map[string]int `json:"levels"`
+	RestoreDays   int            `json:"restore_days"`
+	Target        string         `json:"target"`
+	Interval      string         `json:"interval"`
+	LastRunAt     *time.Time     `json:"last_run_at,omitempty"`
+	LastArchived  int64          `json:"last_archived"`
+	LastRemoved   int64          `json:"last_removed_restored"`
+	LastError     string         `json:"last_error,omitempty"`
+	NextRunAt     *time.Time     `json:"next_run_at,omitempty"`
+	archiveLevels []string
+}
+
+var archiveMutex sync.RWMutex
+var archiver *archive.Archiver
+var archiveStatus ArchiveStatus
+
+// StartArchive creates the archiver from the config and schedules it. Nothing is scheduled when no log level has a
+// number of days after which it is archived.
+//
+// Returns
+//	error - Error if the archive config is not valid.
+//
+func StartArchive() error {
+	conf := config.GetConfig()
+	status := ArchiveStatus{Levels: map[string]int{}, RestoreDays: conf.Archive.RestoreDays, Target: conf.Archive.Target}
+	for logLevel, days := range conf.Archive.Levels {
+		logLevel = strings.ToUpper(logLevel)
+		if valid, all := models.IsValidLogLevel(logLevel); !valid || all || logLevel == "" {
+			return errors.New("archive: unknown log level " + logLevel)
+		}
+		if days > 0 {
+			status.Levels[logLevel] = days
+			status.archiveLevels = append(status.archiveLevels, logLevel)
+		}
+	}
+	if len(status.archiveLevels) == 0 {
+		return nil
+	}
+	sort.Strings(status.archiveLevels)
+
+	var blobs archive.BlobStore
+	var err error
+	switch conf.Archive.Target {
+	case "", "directory":
+		status.Target = "directory"
+		blobs, err = archive.NewDirectoryBlobStore(conf.Archive.Directory)
+	case "s3":
+		blobs, err = archive.NewS3BlobStore(conf.Archive.S3Endpoint, conf.Archive.S3Region, conf.Archive.S3Bucket,
+			conf.Archive.S3Prefix, conf.Archive.S3AccessKeyID, conf.Archive.S3SecretAccessKey)
+	default:
+		err = errors.New("archive: unknown target " + conf.Archive.Target)
+	}
+	if err != nil {
+		return err
+	}
+
+	interval := time.Duration(conf.Archive.IntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = defaultArchiveInterval
+	}
+	status.Interval = interval.String()
+	nextRunAt := time.Now().UTC()
+	status.NextRunAt = &nextRunAt
+
+	archiveMutex.Lock()
+	archiver = archive.NewArchiver(blobs)
+	archiveStatus = status
+	archiveMutex.Unlock()
+
+	go runArchive(interval)
+	return nil
+}
+
+// GetArchiver returns the archiver, or nil if archiving is not configured.
+//
+// Returns
+//	*archive.Archiver	- Archiver.
+//
+func GetArchiver() *archive.Archiver {
+	archiveMutex.RLock()
+	defer archiveMutex.RUnlock()
+	return archiver
+}
+
+// GetArchiveStatus returns the archive configuration and the outcome of the last archive run.
+//
+// Returns
+//	ArchiveStatus	- Archive status.
+//
+func GetArchiveStatus() ArchiveStatus {
+	archiveMutex.RLock()
+	defer archiveMutex.RUnlock()
+	return archiveStatus
+}
+
+// ArchiveOldLogs archives the logs of every configured log level that are older than the level's number of days, then
+// removes restored logs that have been kept for the configured number of restore days.
+//
+// Parameters:
+//	context.Context	ctx	- Context of the run.
+//
+// Returns
+//	int64	- Number of logs archived.
+//	int64	- Number of restored logs removed.
+//	error	- Any error that occurs.
+//
+func ArchiveOldLogs(ctx context.Context) (int64, int64, error) {
+	status := GetArchiveStatus()
+	activeArchiver := GetArchiver()
+	if activeArchiver == nil {
+		return 0, 0, errors.New("archive: archiving is not configured")
+	}
+
+	now := time.Now().UTC()
+	archived := int64(0)
+	for _, logLevel := range status.archiveLevels {
+		count, err := activeArchiver.Archive(ctx, logLevel, now.AddDate(0, 0, -status.Levels[logLevel]))
+		archived += count
+		if err != nil {
+			return archived, 0, err
+		}
+	}
+
+	removed := int64(0)
+	if status.RestoreDays > 0 {
+		restoredBefore := now.AddDate(0, 0, -status.RestoreDays)
+		var err error
+		removed, err = models.GetLogStore().Delete(ctx, models.ExcludeHeld(models.LogSearchFields{RestoredBefore: &restoredBefore}))
+		if err != nil {
+			return archived, removed, err
+		}
+	}
+	if archived > 0 || removed > 0 {
+		return archived, removed, TrimHashChains(ctx)
+	}
+
+	return archived, removed, nil
+}
+
+// runArchive archives old logs immediately and then once every interval.
+func runArchive(interval time.Duration) {
+	for {
+		archived, removed, err := ArchiveOldLogs(context.Background())
+		if err != nil {
+			log.Println("archive: run failed: " + err.Error())
+		}
+
+		archiveMutex.Lock()
+		lastRunAt := time.Now().UTC()
+		nextRunAt := lastRunAt.Add(interval)
+		archiveStatus.LastRunAt = &lastRunAt
+		archiveStatus.NextRunAt = &nextRunAt
+		archiveStatus.LastArchived = archived
+		archiveStatus.LastRemoved = removed
+		archiveStatus.LastError = ""
+		if err != nil {
+			archiveStatus.LastError = err.Error()
+		}
+		archiveMutex.Unlock()
+
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,643 @@
+package jobs
+
+/*
+ *
+ * file: 		background_job.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the persisted queue of background jobs for exports, re-indexing and bulk deletes.
+ *
+ */
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"logging_service/config"
+	"logging_service/export"
+	"logging_service/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Job types.
+const (
+	JobTypeExport  = "export"
+	JobTypeReindex = "reindex"
+	JobTypeDelete  = "delete"
+)
+
+// Job statuses.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// defaultJobWorkers is used when Jobs.WORKERS is not set.
+const defaultJobWorkers = 2
+
+// defaultArtifactHours is used when Jobs.ARTIFACT_HOURS is not set.
+const defaultArtifactHours = 24
+
+// jobExpiryInterval is how often finished jobs are checked for expiry.
+const jobExpiryInterval = 10 * time.Minute
+
+// jobsFileName is the file in the jobs directory holding every job.
+const jobsFileName = "jobs.json"
+
+// ErrJobNotFound is returned when no job has the given id.
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrJobFinished is returned when cancelling a job that has already finished.
+var ErrJobFinished = errors.New("job has already finished")
+
+// Job describes a background job. Progress is a percentage. Jobs belong to the subject and tenant that submitted them.
+// Finished jobs, and their artifacts, are removed once they expire.
+type Job struct {
+	ID         string                 `json:"id"`
+	Type       string                 `json:"type"`
+	Status     string                 `json:"status"`
+	Progress   float64                `json:"progress"`
+	Error      string                 `json:"error,omitempty"`
+	Filters    map[string]string      `json:"filters,omitempty"`
+	Result     map[string]interface{} `json:"result,omitempty"`
+	Artifact   *JobArtifact           `json:"artifact,omitempty"`
+	CreatedBy  string                 `json:"created_by"`
+	Tenant     string                 `json:"tenant,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+	StartedAt  *time.Time             `json:"started_at,omitempty"`
+	FinishedAt *time.Time             `json:"finished_at,omitempty"`
+	ExpiresAt  *time.Time             `json:"expires_at,omitempty"`
+}
+
+// JobArtifact describes the file a finished job produced.
+type JobArtifact struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// jobRecord is a job as it is saved, along with what it needs to run and resume.
+type jobRecord struct {
+	Job
+	Spec jobSpec `json:"spec"`
+}
+
+// jobSpec holds the parameters of a job and, for exports, the checkpoint they resume from. Offset is the size of the
+// artifact at the checkpoint.
+type jobSpec struct {
+	Fields   models.LogSearchFields `json:"fields"`
+	Export   export.Options         `json:"export"`
+	Decrypt  bool                   `json:"decrypt"`
+	Unmasked []string               `json:"unmasked,omitempty"`
+	Total    int64                  `json:"total"`
+	Exported int64                  `json:"exported"`
+	LastID   string                 `json:"last_id,omitempty"`
+	Offset   int64                  `json:"offset"`
+}
+
+var jobsMutex sync.Mutex
+var jobsDirectory string
+var artifactTTL time.Duration
+var backgroundJobs = map[string]*jobRecord{}
+var jobCancels = map[string]context.CancelFunc{}
+var jobDeletions = map[string]*models.Deletion{}
+var jobSlots chan struct{}
+
+// StartBackgroundJobs loads the saved jobs and starts running queued jobs. Exports and re-indexes that were queued or
+// running when the service stopped are resumed, exports from their last checkpoint. Deletes cannot be resumed since
+// their confirmation is not saved, so they are marked failed. Nothing is started when Jobs.DIRECTORY is not set.
+//
+// Returns
+//	error - Error if the jobs directory or file cannot be read.
+//
+func StartBackgroundJobs() error {
+	conf := config.GetConfig().Jobs
+	if conf.Directory == "" {
+		return nil
+	}
+	if err := os.MkdirAll(conf.Directory, 0700); err != nil {
+		return err
+	}
+
+	workers := conf.Workers
+	if workers <= 0 {
+		workers = defaultJobWorkers
+	}
+	artifactHours := conf.ArtifactHours
+	if artifactHours <= 0 {
+		artifactHours = defaultArtifactHours
+	}
+
+	records := []*jobRecord{}
+	content, err := ioutil.ReadFile(filepath.Join(conf.Directory, jobsFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, &records); err != nil {
+			return err
+		}
+	}
+
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	jobsDirectory = conf.Directory
+	artifactTTL = time.Duration(artifactHours) * time.Hour
+	jobSlots = make(chan struct{}, workers)
+	backgroundJobs = map[string]*jobRecord{}
+
+	resumed := []*jobRecord{}
+	for _, record := range records {
+		backgroundJobs[record.ID] = record
+		if record.Status != JobQueued && record.Status != JobRunning {
+			continue
+		}
+		if record.Type == JobTypeDelete {
+			finishJob(record, JobFailed, errors.New("interrupted by a restart, run a new dry run and submit the delete again"))
+			continue
+		}
+		record.Status = JobQueued
+		resumed = append(resumed, record)
+	}
+	if err := saveJobs(); err != nil {
+		return err
+	}
+
+	sort.Slice(resumed, func(i, j int) bool {
+		return resumed[i].CreatedAt.Before(resumed[j].CreatedAt)
+	})
+	for _, record := range resumed {
+		go runJob(record.ID)
+	}
+	go runJobExpiry()
+
+	return nil
+}
+
+// BackgroundJobsEnabled reports whether background jobs are configured.
+//
+// Returns
+//	bool - Whether jobs can be submitted.
+//
+func BackgroundJobsEnabled() bool {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	return jobsDirectory != ""
+}
+
+// SubmitExportJob queues an export of the logs matching the search fields to a downloadable artifact.
+//
+// Parameters:
+//	context.Context			ctx			- Context of the request, whose tenant the job belongs to.
+//	models.LogSearchFields	fields		- Search fields of the logs to export.
+//	export.Options			options		- Export options.
+//	bool					decrypt		- Whether the submitter may read encrypted logs.
+//	[]string				unmasked	- Names of the masking policies the submitter may see through.
+//	string					actor		- Submitter.
+//
+// Returns
+//	Job		- Queued job.
+//	error	- Any error that occurs while saving the job.
+//
+func SubmitExportJob(ctx context.Context, fields models.LogSearchFields, options export.Options, decrypt bool, unmasked []string, actor string) (Job, error) {
+	return submitJob(ctx, JobTypeExport, nil, jobSpec{Fields: fields, Export: options, Decrypt: decrypt, Unmasked: unmasked}, actor, nil)
+}
+
+// SubmitReindexJob queues a reconciliation of the storage backend's indexes.
+//
+// Parameters:
+//	context.Context	ctx		- Context of the request, whose tenant the job belongs to.
+//	string			actor	- Submitter.
+//
+// Returns
+//	Job		- Queued job.
+//	error	- Any error that occurs while saving the job.
+//
+func SubmitReindexJob(ctx context.Context, actor string) (Job, error) {
+	return submitJob(ctx, JobTypeReindex, nil, jobSpec{}, actor, nil)
+}
+
+// SubmitDeleteJob queues a confirmed deletion, which removes logs in batches.
+//
+// Parameters:
+//	context.Context		ctx			- Context of the request, whose tenant the job belongs to.
+//	*models.Deletion	deletion	- Confirmed deletion.
+//	map[string]string	filters		- Filters of the deletion as the caller gave them.
+//	string				actor		- Submitter.
+//
+// Returns
+//	Job		- Queued job.
+//	error	- Any error that occurs while saving the job.
+//
+func SubmitDeleteJob(ctx context.Context, deletion *models.Deletion, filters map[string]string, actor string) (Job, error) {
+	return submitJob(ctx, JobTypeDelete, filters, jobSpec{}, actor, deletion)
+}
+
+// ListJobs returns the jobs of the tenant of a context, or of every tenant when the context is not scoped to one,
+// newest first.
+//
+// Parameters:
+//	context.Context	ctx		- Context of the request.
+//	string			actor	- Only jobs submitted by this caller are returned, empty for every job of the tenant.
+//
+// Returns
+//	[]Job - Jobs.
+//
+func ListJobs(ctx context.Context, actor string) []Job {
+	tenant, scoped := models.TenantOf(ctx)
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	jobs := []Job{}
+	for _, record := range backgroundJobs {
+		if (actor == "" || record.CreatedBy == actor) && (!scoped || record.Tenant == tenant) {
+			jobs = append(jobs, record.Job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+
+	return jobs
+}
+
+// GetJob returns a job by id.
+//
+// Parameters:
+//	string	id	- Job id.
+//
+// Returns
+//	Job		- Job.
+//	bool	- Whether the job exists.
+//
+func GetJob(id string) (Job, bool) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	record, ok := backgroundJobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return record.Job, true
+}
+
+// CancelJob cancels a queued or running job. A running job stops at its next checkpoint.
+//
+// Parameters:
+//	string	id	- Job id.
+//
+// Returns
+//	Job		- Job after cancelling.
+//	error	- ErrJobNotFound or ErrJobFinished.
+//
+func CancelJob(id string) (Job, error) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	record, ok := backgroundJobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	switch record.Status {
+	case JobQueued:
+		finishJob(record, JobCancelled, nil)
+		if err := saveJobs(); err != nil {
+			return record.Job, err
+		}
+	case JobRunning:
+		jobCancels[id]()
+	default:
+		return record.Job, ErrJobFinished
+	}
+
+	return record.Job, nil
+}
+
+// JobArtifactPath returns the path of a finished job's artifact.
+//
+// Parameters:
+//	Job	job	- Job.
+//
+// Returns
+//	string	- Path of the artifact.
+//	bool	- Whether the job has an artifact that can be downloaded.
+//
+func JobArtifactPath(job Job) (string, bool) {
+	if job.Status != JobSucceeded || job.Artifact == nil {
+		return "", false
+	}
+
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	return artifactPath(job.ID), true
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// submitJob saves a new queued job and starts it once a worker is free.
+func submitJob(ctx context.Context, jobType string, filters map[string]string, spec jobSpec, actor string, deletion *models.Deletion) (Job, error) {
+	tenant, _ := models.TenantOf(ctx)
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return Job{}, err
+	}
+
+	record := &jobRecord{
+		Job: Job{
+			ID:        hex.EncodeToString(id),
+			Type:      jobType,
+			Status:    JobQueued,
+			Filters:   filters,
+			CreatedBy: actor,
+			Tenant:    tenant,
+			CreatedAt: time.Now().UTC(),
+		},
+		Spec: spec,
+	}
+
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	if jobsDirectory == "" {
+		return Job{}, errors.New("jobs: background jobs are not configured")
+	}
+	backgroundJobs[record.ID] = record
+	if err := saveJobs(); err != nil {
+		delete(backgroundJobs, record.ID)
+		return Job{}, err
+	}
+	if deletion != nil {
+		jobDeletions[record.ID] = deletion
+	}
+
+	go runJob(record.ID)
+	return record.Job, nil
+}
+
+// runJob waits for a free worker, then runs a queued job and records its outcome.
+func runJob(id string) {
+	jobSlots <- struct{}{}
+	defer func() { <-jobSlots }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	jobsMutex.Lock()
+	record, ok := backgroundJobs[id]
+	if !ok || record.Status != JobQueued {
+		delete(jobDeletions, id)
+		jobsMutex.Unlock()
+		return
+	}
+	startedAt := time.Now().UTC()
+	record.Status = JobRunning
+	record.StartedAt = &startedAt
+	jobCancels[id] = cancel
+	deletion := jobDeletions[id]
+	delete(jobDeletions, id)
+	spec := record.Spec
+	if err := saveJobs(); err != nil {
+		log.Println("jobs: " + err.Error())
+	}
+	jobsMutex.Unlock()
+
+	var result map[string]interface{}
+	var artifact *JobArtifact
+	var err error
+	switch record.Type {
+	case JobTypeExport:
+		artifact, result, err = runExportJob(ctx, id, spec)
+	case JobTypeReindex:
+		err = models.ReconcileIndexes(ctx)
+	case JobTypeDelete:
+		result, err = runDeleteJob(ctx, id, deletion)
+	default:
+		err = errors.New("unknown job type " + record.Type)
+	}
+
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	delete(jobCancels, id)
+	record.Result = result
+	record.Artifact = artifact
+	switch {
+	case err == nil:
+		record.Progress = 100
+		finishJob(record, JobSucceeded, nil)
+	case ctx.Err() != nil:
+		finishJob(record, JobCancelled, nil)
+	default:
+		log.Println("jobs: " + record.Type + " job " + id + " failed: " + err.Error())
+		finishJob(record, JobFailed, err)
+	}
+	if err := saveJobs(); err != nil {
+		log.Println("jobs: " + err.Error())
+	}
+}
+
+// runExportJob writes an export to the job's artifact, saving a checkpoint at every flush so the export can resume
+// from it after a restart.
+func runExportJob(ctx context.Context, id string, spec jobSpec) (*JobArtifact, map[string]interface{}, error) {
+	fields := spec.Fields
+	if spec.LastID == "" {
+		total, err := models.GetLogStore().Count(ctx, fields)
+		if err != nil {
+			return nil, nil, err
+		}
+		spec.Total = total
+		updateJob(id, func(record *jobRecord) {
+			record.Spec.Total = total
+		})
+	} else {
+		lastID, err := primitive.ObjectIDFromHex(spec.LastID)
+		if err != nil {
+			return nil, nil, err
+		}
+		fields.AfterID = lastID
+	}
+
+	path := artifactPath(id)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	if err := file.Truncate(spec.Offset); err != nil {
+		return nil, nil, err
+	}
+	if _, err := file.Seek(spec.Offset, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+
+	out := &countingWriter{w: file, n: spec.Offset}
+	progress := func(r export.Result) error {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+		exported := spec.Exported + r.Exported
+		updateJob(id, func(record *jobRecord) {
+			record.Spec.Exported = exported
+			record.Spec.LastID = r.LastID
+			record.Spec.Offset = out.n
+			record.Progress = percentOf(exported, spec.Total)
+		})
+		return nil
+	}
+	result, err := export.Write(ctx, out, fields, spec.Export, export.Reveal(spec.Decrypt, spec.Unmasked), progress)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	artifact := &JobArtifact{Name: spec.Export.FileName(id), ContentType: spec.Export.ContentType(), Size: out.n}
+	return artifact, map[string]interface{}{"exported": spec.Exported + result.Exported}, nil
+}
+
+// runDeleteJob runs a confirmed deletion in batches, reporting progress against the number of logs its dry run matched.
+func runDeleteJob(ctx context.Context, id string, deletion *models.Deletion) (map[string]interface{}, error) {
+	if deletion == nil {
+		return nil, errors.New("the confirmation of the delete was lost")
+	}
+
+	result, err := deletion.Run(ctx, func(deleted int64) error {
+		updateJob(id, func(record *jobRecord) {
+			record.Progress = percentOf(deleted, deletion.Matched())
+		})
+		return nil
+	})
+	if result.Deleted > 0 {
+		if trimErr := TrimHashChains(context.Background()); trimErr != nil && err == nil {
+			err = trimErr
+		}
+	}
+
+	return map[string]interface{}{"matched": result.Matched, "deleted": result.Deleted, "audit_id": result.AuditID}, err
+}
+
+// runJobExpiry removes finished jobs and their artifacts once they expire.
+func runJobExpiry() {
+	for {
+		time.Sleep(jobExpiryInterval)
+
+		jobsMutex.Lock()
+		now := time.Now()
+		expired := false
+		for id, record := range backgroundJobs {
+			if record.ExpiresAt == nil || now.Before(*record.ExpiresAt) {
+				continue
+			}
+			if err := os.Remove(artifactPath(id)); err != nil && !os.IsNotExist(err) {
+				log.Println("jobs: " + err.Error())
+				continue
+			}
+			delete(backgroundJobs, id)
+			expired = true
+		}
+		if expired {
+			if err := saveJobs(); err != nil {
+				log.Println("jobs: " + err.Error())
+			}
+		}
+		jobsMutex.Unlock()
+	}
+}
+
+// updateJob applies a change to a job and saves it. Errors saving are logged, since the job keeps running.
+func updateJob(id string, change func(record *jobRecord)) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	record, ok := backgroundJobs[id]
+	if !ok {
+		return
+	}
+	change(record)
+	if err := saveJobs(); err != nil {
+		log.Println("jobs: " + err.Error())
+	}
+}
+
+// finishJob marks a job finished. Jobs that did not succeed have their partial artifact removed. jobsMutex must be held.
+func finishJob(record *jobRecord, status string, err error) {
+	finishedAt := time.Now().UTC()
+	expiresAt := finishedAt.Add(artifactTTL)
+	record.Status = status
+	record.FinishedAt = &finishedAt
+	record.ExpiresAt = &expiresAt
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if status != JobSucceeded {
+		record.Artifact = nil
+		os.Remove(artifactPath(record.ID))
+	}
+}
+
+// saveJobs writes every job to the jobs file, replacing it atomically. jobsMutex must be held.
+func saveJobs() error {
+	records := []*jobRecord{}
+	for _, record := range backgroundJobs {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+
+	content, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(jobsDirectory, jobsFileName)
+	if err := ioutil.WriteFile(path+".tmp", content, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// artifactPath returns the path of a job's artifact.
+func artifactPath(id string) string {
+	return filepath.Join(jobsDirectory, id+".artifact")
+}
+
+// percentOf returns done as a percentage of total, kept below 100 until the job finishes.
+func percentOf(done int64, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	percent := float64(done) * 100 / float64(total)
+	if percent > 99 {
+		percent = 99
+	}
+
+	return percent
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write writes to the underlying writer and counts the bytes written.
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,181 @@
+package jobs
+
+/*
+ *
+ * file: 		background_job_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests recovering the background jobs that were queued or running when the service stopped.
+ *
+ */
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"logging_service/database"
+	"logging_service/export"
+	"logging_service/models"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStartBackgroundJobsRecoversInterruptedJobs(t *testing.T) {
+	fs, err := database.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	models.SetLogStore(fs)
+	ctx := context.Background()
+	directory := t.TempDir()
+	useConfig(t, "Jobs:\n    DIRECTORY: "+directory+"\n")
+
+	start := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	logs := []models.Log{}
+	for i := 0; i < 30; i++ {
+		logs = append(logs, models.Log{CreatedAt: start.Add(time.Duration(i) * time.Second), LogLevel: "INFO", Location: "billing", Message: "log " + strconv.Itoa(i)})
+	}
+	if err := fs.CreateMany(ctx, logs); err != nil {
+		t.Fatal(err)
+	}
+	options, err := export.ParseOptions(export.FormatNDJSON, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	complete := bytes.Buffer{}
+	if _, err := export.Write(ctx, &complete, models.LogSearchFields{}, options, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The running export stopped after a checkpoint at the tenth log, with part of a later log written past it.
+	checkpoint := bytes.Buffer{}
+	prepared := 0
+	interrupt := errors.New("interrupted")
+	result, err := export.Write(ctx, &checkpoint, models.LogSearchFields{}, options, func(l *models.Log) error {
+		if prepared++; prepared > 10 {
+			return interrupt
+		}
+		return nil
+	}, nil)
+	if err != interrupt {
+		t.Fatal(err)
+	}
+	offset := int64(checkpoint.Len())
+	if err := ioutil.WriteFile(filepath.Join(directory, "running-export.artifact"), append(checkpoint.Bytes(), `{"id":"`...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	created := start
+	records := []*jobRecord{}
+	for _, job := range []struct {
+		id      string
+		jobType string
+		status  string
+		spec    jobSpec
+	}{
+		{"running-export", JobTypeExport, JobRunning, jobSpec{Export: options, Total: 30, Exported: 10, LastID: result.LastID, Offset: offset}},
+		{"queued-export", JobTypeExport, JobQueued, jobSpec{Export: options}},
+		{"queued-reindex", JobTypeReindex, JobQueued, jobSpec{}},
+		{"running-delete", JobTypeDelete, JobRunning, jobSpec{}},
+		{"failed-export", JobTypeExport, JobFailed, jobSpec{Export: options}},
+	} {
+		created = created.Add(time.Minute)
+		records = append(records, &jobRecord{Job: Job{ID: job.id, Type: job.jobType, Status: job.status, CreatedAt: created}, Spec: job.spec})
+	}
+	content, err := json.Marshal(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(directory, jobsFileName), content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := StartBackgroundJobs(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Exports and re-indexes run again, exports from their checkpoint, while deletes fail and finished jobs are kept.
+	tests := []struct {
+		id         string
+		wantStatus string
+		wantExport bool
+	}{
+		{"running-export", JobSucceeded, true},
+		{"queued-export", JobSucceeded, true},
+		{"queued-reindex", JobSucceeded, false},
+		{"running-delete", JobFailed, false},
+		{"failed-export", JobFailed, false},
+	}
+	for _, test := range tests {
+		job := waitForJob(t, test.id)
+		if job.Status != test.wantStatus {
+			t.Errorf("%s: finished %s %s, want %s", test.id, job.Status, job.Error, test.wantStatus)
+			continue
+		}
+		if !test.wantExport {
+			continue
+		}
+		path, ok := JobArtifactPath(job)
+		if !ok {
+			t.Errorf("%s: finished without an artifact", test.id)
+			continue
+		}
+		artifact, err := ioutil.ReadFile(path)
+		if err != nil || !bytes.Equal(artifact, complete.Bytes()) || job.Result["exported"] != int64(30) {
+			t.Errorf("%s: exported %v logs to %d bytes, %v, want the 30 logs of the complete export", test.id, job.Result["exported"], len(artifact), err)
+		}
+	}
+
+	// The outcome of every job is saved.
+	content, err = ioutil.ReadFile(filepath.Join(directory, jobsFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := []*jobRecord{}
+	if err := json.Unmarshal(content, &saved); err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range saved {
+		if record.Status == JobQueued || record.Status == JobRunning {
+			t.Errorf("%s: saved %s, want it finished", record.ID, record.Status)
+		}
+	}
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// useConfig points the service at a config file holding the yaml for the rest of the test.
+func useConfig(t *testing.T, yaml string) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LOGGING_SERVICE_CONFIG_PATH", path)
+}
+
+// waitForJob returns a job once it has finished, failing the test if it takes too long.
+func waitForJob(t *testing.T, id string) Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, ok := GetJob(id)
+		if !ok {
+			t.Fatalf("%s: job not found", id)
+		}
+		if job.Status != JobQueued && job.Status != JobRunning {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: still %s, want it finished", id, job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,174 @@
+package jobs
+
+/*
+ *
+ * file: 		encryption_job.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the scheduled job that rewraps the data keys of logs encrypted with a retired key.
+ *
+ */
+
+import (
+	"context"
+	"errors"
+	"log"
+	"logging_service/config"
+	"logging_service/encryption"
+	"logging_service/models"
+	"sync"
+	"time"
+)
+
+// defaultRotationInterval is used when Encryption.ROTATION_INTERVAL_MINUTES is not set.
+const defaultRotationInterval = time.Hour
+
+// rewrapBatchSize is the number of logs rewrapped per update.
+const rewrapBatchSize = 500
+
+// errRewrapBatchFull stops iterating once a batch of logs to rewrap is collected.
+var errRewrapBatchFull = errors.New("encryption: rewrap batch is full")
+
+// EncryptionStatus describes the loaded keys and the outcome of the last key rotation.
+type EncryptionStatus struct {
+	Enabled        bool       `json:"enabled"`
+	ActiveKeyID    string     `json:"active_key_id,omitempty"`
+	KeyIDs         []string   `json:"key_ids,omitempty"`
+	Interval       string     `json:"interval,omitempty"`
+	LastRotationAt *time.Time `json:"last_rotation_at,omitempty"`
+	LastRewrapped  int64      `json:"last_rewrapped"`
+	LastError      string     `json:"last_error,omitempty"`
+	NextRotationAt *time.Time `json:"next_rotation_at,omitempty"`
+}
+
+var encryptionMutex sync.RWMutex
+var encryptionStatus EncryptionStatus
+
+// StartEncryption loads the keyring when Encryption.ACTIVE_KEY_ID is set so created logs are encrypted. Logs whose data
+// key is wrapped with a key other than the active key are rewrapped once every interval.
+//
+// Returns
+//	error - Error if a key is not valid or the active key is missing.
+//
+func StartEncryption() error {
+	conf := config.GetConfig()
+	if conf.Encryption.ActiveKeyID == "" {
+		return nil
+	}
+
+	keyring, err := encryption.LoadKeyring(conf.Encryption.KeyFile, conf.Encryption.ActiveKeyID)
+	if err != nil {
+		return err
+	}
+	models.SetKeyring(keyring)
+
+	status := EncryptionStatus{Enabled: true, ActiveKeyID: keyring.ActiveKeyID(), KeyIDs: keyring.KeyIDs()}
+	if _, ok := models.GetLogStore().(models.RewrappingLogStore); !ok {
+		log.Println("encryption: the log store cannot rewrap data keys, logs keep the key they were encrypted with")
+		encryptionMutex.Lock()
+		encryptionStatus = status
+		encryptionMutex.Unlock()
+		return nil
+	}
+
+	interval := time.Duration(conf.Encryption.RotationIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = defaultRotationInterval
+	}
+	nextRotationAt := time.Now().UTC()
+	status.Interval = interval.String()
+	status.NextRotationAt = &nextRotationAt
+
+	encryptionMutex.Lock()
+	encryptionStatus = status
+	encryptionMutex.Unlock()
+
+	go runRotation(interval)
+	return nil
+}
+
+// GetEncryptionStatus returns the loaded keys and the outcome of the last key rotation.
+//
+// Returns
+//	EncryptionStatus	- Encryption status.
+//
+func GetEncryptionStatus() EncryptionStatus {
+	encryptionMutex.RLock()
+	defer encryptionMutex.RUnlock()
+	return encryptionStatus
+}
+
+// RewrapStaleLogs wraps the data key of every log encrypted with a retired key with the active key. The ciphertext is
+// not changed, so chained hashes stay valid.
+//
+// Parameters:
+//	context.Context	ctx	- Context of the rotation.
+//
+// Returns
+//	int64	- Number of logs rewrapped.
+//	error	- Any error that occurs.
+//
+func RewrapStaleLogs(ctx context.Context) (int64, error) {
+	keyring := models.GetKeyring()
+	if keyring == nil {
+		return 0, errors.New("encryption: encryption is not enabled")
+	}
+	rewrapping, ok := models.GetLogStore().(models.RewrappingLogStore)
+	if !ok {
+		return 0, errors.New("encryption: the log store cannot rewrap data keys")
+	}
+
+	fields := models.LogSearchFields{NotKeyID: keyring.ActiveKeyID()}
+	var rewrapped int64
+	for {
+		batch := []models.Log{}
+		err := rewrapping.Iterate(ctx, fields, func(l *models.Log) error {
+			batch = append(batch, *l)
+			if len(batch) == rewrapBatchSize {
+				return errRewrapBatchFull
+			}
+			return nil
+		})
+		if err != nil && err != errRewrapBatchFull {
+			return rewrapped, err
+		}
+		if len(batch) == 0 {
+			return rewrapped, nil
+		}
+
+		for i := range batch {
+			batch[i].KeyID, batch[i].DataKey, err = keyring.Rewrap(batch[i].KeyID, batch[i].DataKey)
+			if err != nil {
+				return rewrapped, errors.New("encryption: log " + batch[i].ID.Hex() + ": " + err.Error())
+			}
+		}
+		if err := rewrapping.UpdateDataKeys(ctx, batch); err != nil {
+			return rewrapped, err
+		}
+		rewrapped += int64(len(batch))
+	}
+}
+
+// runRotation rewraps stale data keys immediately and then once every interval.
+func runRotation(interval time.Duration) {
+	for {
+		rewrapped, err := RewrapStaleLogs(context.Background())
+		if err != nil {
+			log.Println("encryption: key rotation failed: " + err.Error())
+		}
+
+		encryptionMutex.Lock()
+		lastRotationAt := time.Now().UTC()
+		nextRotationAt := lastRotationAt.Add(interval)
+		encryptionStatus.LastRotationAt = &lastRotationAt
+		encryptionStatus.NextRotationAt = &nextRotationAt
+		encryptionStatus.LastRewrapped = rewrapped
+		encryptionStatus.LastError = ""
+		if err != nil {
+			encryptionStatus.LastError = err.Error()
+		}
+		encryptionMutex.Unlock()
+
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,93 @@
+package jobs
+
+/*
+ *
+ * file: 		encryption_job_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests rewrapping the data keys of logs encrypted with a retired key.
+ *
+ */
+
+import (
+	"context"
+	"encoding/base64"
+	"logging_service/database"
+	"logging_service/encryption"
+	"logging_service/models"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRewrapStaleLogs(t *testing.T) {
+	fs, err := database.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	models.SetLogStore(fs)
+	defer models.SetKeyring(nil)
+	ctx := context.Background()
+
+	oldKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("o", 32)))
+	newKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("n", 32)))
+	t.Setenv(encryption.KeysEnvironmentVariable, "old="+oldKey+",new="+newKey)
+	old, err := encryption.LoadKeyring("", "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := encryption.LoadKeyring("", "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(encryption.KeysEnvironmentVariable, "new="+newKey)
+	retired, err := encryption.LoadKeyring("", "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// More logs than one batch are encrypted with the old key, and one with the new key.
+	start := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	logs := []models.Log{}
+	for i := 0; i <= rewrapBatchSize; i++ {
+		l := models.Log{CreatedAt: start.Add(time.Duration(i) * time.Second), LogLevel: "INFO", Location: "billing", Message: "log " + strconv.Itoa(i)}
+		if err := l.Encrypt(old); err != nil {
+			t.Fatal(err)
+		}
+		logs = append(logs, l)
+	}
+	current := models.Log{CreatedAt: start, LogLevel: "ERROR", Location: "billing", Message: "current"}
+	if err := current.Encrypt(rotated); err != nil {
+		t.Fatal(err)
+	}
+	logs = append(logs, current)
+	if err := fs.CreateMany(ctx, logs); err != nil {
+		t.Fatal(err)
+	}
+
+	models.SetKeyring(rotated)
+	if rewrapped, err := RewrapStaleLogs(ctx); err != nil || rewrapped != rewrapBatchSize+1 {
+		t.Fatalf("rewrapped %d logs, %v, want %d", rewrapped, err, rewrapBatchSize+1)
+	}
+	if rewrapped, err := RewrapStaleLogs(ctx); err != nil || rewrapped != 0 {
+		t.Errorf("rewrapped %d logs again, %v, want 0", rewrapped, err)
+	}
+
+	// Once rewrapped, the logs are read with the old key retired and keep their ciphertext.
+	found, total, err := fs.Find(ctx, models.LogSearchFields{}, int64(len(logs)))
+	if err != nil || total != int64(len(logs)) {
+		t.Fatalf("found %d logs, %v, want %d", total, err, len(logs))
+	}
+	ciphertexts := map[string]string{}
+	for _, l := range logs {
+		ciphertexts[l.ID.Hex()] = l.Ciphertext
+	}
+	for _, l := range found {
+		ciphertext := l.Ciphertext
+		if err := l.Decrypt(retired); err != nil || l.KeyID != "new" || ciphertext != ciphertexts[l.ID.Hex()] {
+			t.Fatalf("read %s wrapped with %s, %v, want it decrypted with new and its ciphertext unchanged", l.ID.Hex(), l.KeyID, err)
+		}
+	}
+}
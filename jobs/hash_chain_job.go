@@ -0,0 +1,235 @@
+package jobs
+
+/*
+ *
+ * file: 		hash_chain_job.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the scheduled job that writes signed checkpoints of the hash chains.
+ *
+ */
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"log"
+	"logging_service/config"
+	"logging_service/integrity"
+	"logging_service/models"
+	"sync"
+	"time"
+)
+
+// defaultCheckpointInterval is used when Integrity.CHECKPOINT_INTERVAL_MINUTES is not set.
+const defaultCheckpointInterval = time.Hour
+
+// HashChainStatus describes the hash chain configuration and the outcome of the last checkpoint.
+type HashChainStatus struct {
+	Enabled          bool       `json:"enabled"`
+	Interval         string     `json:"interval,omitempty"`
+	LastCheckpointAt *time.Time `json:"last_checkpoint_at,omitempty"`
+	LastChains       int        `json:"last_chains"`
+	LastError        string     `json:"last_error,omitempty"`
+	NextCheckpointAt *time.Time `json:"next_checkpoint_at,omitempty"`
+}
+
+var hashChainMutex sync.RWMutex
+var hashChainStatus HashChainStatus
+var signingKey ed25519.PrivateKey
+var checkpointFile *integrity.CheckpointFile
+
+// StartHashChain enables hash chaining when Integrity.HASH_CHAIN is set. The chain is seeded with the heads of the last
+// checkpoint and a signed checkpoint is written once every interval.
+//
+// Returns
+//	error - Error if the integrity config is not valid.
+//
+func StartHashChain() error {
+	conf := config.GetConfig()
+	if !conf.Integrity.HashChain {
+		return nil
+	}
+
+	key, file, err := loadHashChain(conf)
+	if err != nil {
+		return err
+	}
+
+	interval := time.Duration(conf.Integrity.CheckpointIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = defaultCheckpointInterval
+	}
+	nextCheckpointAt := time.Now().UTC().Add(interval)
+
+	hashChainMutex.Lock()
+	signingKey = key
+	checkpointFile = file
+	hashChainStatus = HashChainStatus{Enabled: true, Interval: interval.String(), NextCheckpointAt: &nextCheckpointAt}
+	hashChainMutex.Unlock()
+
+	go runCheckpoints(interval)
+	return nil
+}
+
+// LoadHashChain enables hash chaining when Integrity.HASH_CHAIN is set, seeding the chain like StartHashChain but
+// without scheduling checkpoints. Commands that create logs use it so their logs are chained.
+//
+// Returns
+//	error - Error if the integrity config is not valid.
+//
+func LoadHashChain() error {
+	conf := config.GetConfig()
+	if !conf.Integrity.HashChain {
+		return nil
+	}
+
+	_, _, err := loadHashChain(conf)
+	return err
+}
+
+// GetHashChainStatus returns the hash chain configuration and the outcome of the last checkpoint.
+//
+// Returns
+//	HashChainStatus	- Hash chain status.
+//
+func GetHashChainStatus() HashChainStatus {
+	hashChainMutex.RLock()
+	defer hashChainMutex.RUnlock()
+	return hashChainStatus
+}
+
+// WriteCheckpoint signs the head and first sequence of every chain and appends them to the checkpoint file.
+//
+// Parameters:
+//	context.Context	ctx	- Context of the checkpoint.
+//
+// Returns
+//	integrity.Checkpoint	- Written checkpoint.
+//	error					- Any error that occurs.
+//
+func WriteCheckpoint(ctx context.Context) (integrity.Checkpoint, error) {
+	hashChainMutex.RLock()
+	key, file := signingKey, checkpointFile
+	hashChainMutex.RUnlock()
+
+	chain := models.GetHashChain()
+	if chain == nil || file == nil {
+		return integrity.Checkpoint{}, errors.New("integrity: hash chaining is not enabled")
+	}
+
+	heads, err := chain.Heads(ctx)
+	if err != nil {
+		return integrity.Checkpoint{}, err
+	}
+	checkpoint := integrity.Checkpoint{CreatedAt: time.Now().UTC(), Heads: heads}
+	checkpoint.Sign(key)
+	return checkpoint, file.Append(checkpoint)
+}
+
+// TrimHashChains records the new first sequence of every chain after logs are removed by retention, archiving or a
+// confirmed deletion, and writes a checkpoint so verification accepts their removal. Nothing is done when hash
+// chaining is disabled.
+//
+// Parameters:
+//	context.Context	ctx	- Context of the removal.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func TrimHashChains(ctx context.Context) error {
+	chain := models.GetHashChain()
+	if chain == nil {
+		return nil
+	}
+	if err := chain.Trim(ctx); err != nil {
+		return err
+	}
+
+	_, err := WriteCheckpoint(ctx)
+	return err
+}
+
+// VerifyHashChains verifies the chains of a location, or every location, against the last checkpoint. Only the
+// integrity config is needed, so chains can be verified while hash chaining is disabled.
+//
+// Parameters:
+//	context.Context	ctx			- Context of the verification.
+//	string			location	- Location to verify, empty for every location.
+//
+// Returns
+//	integrity.VerifyResults	- Where each chain is broken.
+//	error					- Any error that occurs.
+//
+func VerifyHashChains(ctx context.Context, location string) (integrity.VerifyResults, error) {
+	key, file, err := loadIntegrityConfig(config.GetConfig())
+	if err != nil {
+		return integrity.VerifyResults{}, err
+	}
+	last, err := file.Last()
+	if err != nil {
+		return integrity.VerifyResults{}, err
+	}
+
+	return integrity.Verify(ctx, location, last, key.Public().(ed25519.PublicKey))
+}
+
+// loadIntegrityConfig loads the signing key and opens the checkpoint file named in the config.
+func loadIntegrityConfig(conf config.Values) (ed25519.PrivateKey, *integrity.CheckpointFile, error) {
+	key, err := integrity.LoadSigningKey(conf.Integrity.SigningKeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := integrity.NewCheckpointFile(conf.Integrity.CheckpointFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, file, nil
+}
+
+// loadHashChain loads the integrity config and sets the hash chain logs are appended to, seeded with the heads of the
+// last checkpoint if its signature is valid.
+func loadHashChain(conf config.Values) (ed25519.PrivateKey, *integrity.CheckpointFile, error) {
+	key, file, err := loadIntegrityConfig(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	last, err := file.Last()
+	if err != nil {
+		return nil, nil, err
+	}
+	seeds := []models.ChainHead{}
+	if last != nil && last.Verify(key.Public().(ed25519.PublicKey)) {
+		seeds = last.Heads
+	} else if last != nil {
+		log.Println("integrity: the last checkpoint's signature is invalid, chains are not seeded from it")
+	}
+	models.SetHashChain(models.NewHashChain(seeds))
+
+	return key, file, nil
+}
+
+// runCheckpoints writes a checkpoint once every interval.
+func runCheckpoints(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		checkpoint, err := WriteCheckpoint(context.Background())
+		if err != nil {
+			log.Println("integrity: checkpoint failed: " + err.Error())
+		}
+
+		hashChainMutex.Lock()
+		lastCheckpointAt := time.Now().UTC()
+		nextCheckpointAt := lastCheckpointAt.Add(interval)
+		hashChainStatus.LastCheckpointAt = &lastCheckpointAt
+		hashChainStatus.NextCheckpointAt = &nextCheckpointAt
+		hashChainStatus.LastChains = len(checkpoint.Heads)
+		hashChainStatus.LastError = ""
+		if err != nil {
+			hashChainStatus.LastError = err.Error()
+		}
+		hashChainMutex.Unlock()
+	}
+}
@@ -0,0 +1,180 @@
+package jobs
+
+/*
+ *
+ * file: 		retention_job.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the scheduled job that purges logs which have outlived the retention policy.
+ *
+ */
+
+import (
+	"context"
+	"log"
+	"logging_service/config"
+	"logging_service/models"
+	"sync"
+	"time"
+)
+
+// defaultPurgeInterval is used when Retention.PURGE_INTERVAL_MINUTES is not set.
+const defaultPurgeInterval = time.Hour
+
+// RetentionStatus describes how the retention policy is enforced and the outcome of the last purge.
+type RetentionStatus struct {
+	Policy                models.RetentionPolicy `json:"policy"`
+	Enforcement           []string               `json:"enforcement"`
+	Interval              string                 `json:"interval,omitempty"`
+	LastPurgeAt           *time.Time             `json:"last_purge_at,omitempty"`
+	LastPurged            int64                  `json:"last_purged"`
+	LastDroppedPartitions []string               `json:"last_dropped_partitions,omitempty"`
+	LastError             string                 `json:"last_error,omitempty"`
+	NextPurgeAt           *time.Time             `json:"next_purge_at,omitempty"`
+}
+
+var retentionMutex sync.RWMutex
+var retentionStatus = RetentionStatus{Enforcement: []string{}}
+
+// StartRetention applies the retention policy from the config. Backends that can expire logs themselves are prepared
+// to do so, and a purge job is scheduled to remove any logs they have not.
+//
+// Returns
+//	error - Error if the retention config is not valid.
+//
+func StartRetention() error {
+	conf := config.GetConfig()
+	policy, err := models.NewRetentionPolicy(conf)
+	if err != nil {
+		return err
+	}
+	models.SetRetentionPolicy(policy)
+
+	retentionMutex.Lock()
+	defer retentionMutex.Unlock()
+	retentionStatus = RetentionStatus{Policy: policy, Enforcement: []string{}}
+	if policy.IsEmpty() {
+		return nil
+	}
+
+	if expiringStore, ok := models.GetLogStore().(models.ExpiringLogStore); ok {
+		if err := expiringStore.EnsureExpiryIndex(context.Background()); err != nil {
+			log.Println("retention: could not create expiry index: " + err.Error())
+		} else {
+			retentionStatus.Enforcement = append(retentionStatus.Enforcement, "ttl_index")
+		}
+	}
+
+	if _, ok := models.GetLogStore().(models.PartitionedLogStore); ok {
+		if _, ok := policy.MaxDays(); ok {
+			retentionStatus.Enforcement = append(retentionStatus.Enforcement, "partition_drop")
+		}
+	}
+
+	interval := time.Duration(conf.Retention.PurgeIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = defaultPurgeInterval
+	}
+	retentionStatus.Enforcement = append(retentionStatus.Enforcement, "scheduled_purge")
+	retentionStatus.Interval = interval.String()
+	nextPurgeAt := time.Now().UTC()
+	retentionStatus.NextPurgeAt = &nextPurgeAt
+
+	go runRetentionPurge(interval)
+	return nil
+}
+
+// GetRetentionStatus returns the retention policy and the outcome of the last purge.
+//
+// Returns
+//	RetentionStatus	- Retention status.
+//
+func GetRetentionStatus() RetentionStatus {
+	retentionMutex.RLock()
+	defer retentionMutex.RUnlock()
+	return retentionStatus
+}
+
+// PurgeExpiredLogs removes every log that has outlived the retention policy, then trims the hash chains if any were.
+//
+// Parameters:
+//	context.Context	ctx	- Context of the purge.
+//
+// Returns
+//	int64	- Number of logs removed.
+//	error	- Any error that occurs.
+//
+func PurgeExpiredLogs(ctx context.Context) (int64, error) {
+	purged := int64(0)
+	for _, fields := range models.GetRetentionPolicy().PurgeFilters(time.Now().UTC()) {
+		count, err := models.GetLogStore().Delete(ctx, models.ExcludeHeld(fields))
+		purged += count
+		if err != nil {
+			return purged, err
+		}
+	}
+	if purged > 0 {
+		return purged, TrimHashChains(ctx)
+	}
+
+	return purged, nil
+}
+
+// DropExpiredPartitions drops every partition whose logs have all outlived the retention policy, then trims the hash
+// chains if any were. Nothing is dropped if the store is not partitioned or the policy keeps some logs forever.
+//
+// Parameters:
+//	context.Context	ctx	- Context of the purge.
+//
+// Returns
+//	[]string	- Names of the dropped partitions.
+//	error		- Any error that occurs.
+//
+func DropExpiredPartitions(ctx context.Context) ([]string, error) {
+	partitionedStore, ok := models.GetLogStore().(models.PartitionedLogStore)
+	if !ok {
+		return []string{}, nil
+	}
+	maxDays, ok := models.GetRetentionPolicy().MaxDays()
+	if !ok {
+		return []string{}, nil
+	}
+
+	dropped, err := partitionedStore.DropPartitionsBefore(ctx, time.Now().UTC().AddDate(0, 0, -maxDays))
+	if err == nil && len(dropped) > 0 {
+		err = TrimHashChains(ctx)
+	}
+
+	return dropped, err
+}
+
+// runRetentionPurge drops expired partitions and purges expired logs immediately and then once every interval.
+func runRetentionPurge(interval time.Duration) {
+	for {
+		dropped, dropErr := DropExpiredPartitions(context.Background())
+		if dropErr != nil {
+			log.Println("retention: partition drop failed: " + dropErr.Error())
+		}
+		purged, err := PurgeExpiredLogs(context.Background())
+		if err != nil {
+			log.Println("retention: purge failed: " + err.Error())
+		} else {
+			err = dropErr
+		}
+
+		retentionMutex.Lock()
+		lastPurgeAt := time.Now().UTC()
+		nextPurgeAt := lastPurgeAt.Add(interval)
+		retentionStatus.LastPurgeAt = &lastPurgeAt
+		retentionStatus.NextPurgeAt = &nextPurgeAt
+		retentionStatus.LastPurged = purged
+		retentionStatus.LastDroppedPartitions = dropped
+		retentionStatus.LastError = ""
+		if err != nil {
+			retentionStatus.LastError = err.Error()
+		}
+		retentionMutex.Unlock()
+
+		time.Sleep(interval)
+	}
+}
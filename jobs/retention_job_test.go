@@ -0,0 +1,125 @@
+package jobs
+
+/*
+ *
+ * file: 		retention_job_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests purging the logs that have outlived the retention policy.
+ *
+ */
+
+import (
+	"context"
+	"logging_service/audit"
+	"logging_service/database"
+	"logging_service/models"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPurgeExpiredLogs(t *testing.T) {
+	fs, err := database.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	models.SetLogStore(fs)
+	if err := models.LoadLegalHolds(""); err != nil {
+		t.Fatal(err)
+	}
+	defer models.SetRetentionPolicy(models.RetentionPolicy{Levels: map[string]int{}})
+	models.SetRetentionPolicy(models.RetentionPolicy{
+		Levels:    map[string]int{"INFO": 30},
+		Overrides: []models.RetentionOverride{{LocationPrefix: "billing/", Days: 365}, {LocationPrefix: "billing/cards", Days: 7}},
+	})
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	logs := []models.Log{
+		{CreatedAt: now.AddDate(0, 0, -40), LogLevel: "INFO", Location: "shipping", Message: "expired"},
+		{CreatedAt: now.AddDate(0, 0, -10), LogLevel: "INFO", Location: "shipping", Message: "recent"},
+		{CreatedAt: now.AddDate(0, 0, -400), LogLevel: "ERROR", Location: "shipping", Message: "kept forever"},
+		{CreatedAt: now.AddDate(0, 0, -40), LogLevel: "INFO", Location: "billing/invoices", Message: "overridden"},
+		{CreatedAt: now.AddDate(0, 0, -400), LogLevel: "INFO", Location: "billing/invoices", Message: "overridden expired"},
+		{CreatedAt: now.AddDate(0, 0, -10), LogLevel: "INFO", Location: "billing/cards", Message: "longer prefix expired"},
+	}
+	if err := fs.CreateMany(ctx, logs); err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := PurgeExpiredLogs(ctx); err != nil || purged != 3 {
+		t.Fatalf("purged %d logs, %v, want 3", purged, err)
+	}
+	want := []string{"kept forever", "overridden", "recent"}
+	if got := storedMessages(t, fs); !equalMessages(got, want) {
+		t.Errorf("kept %v, want %v", got, want)
+	}
+
+	// Logs under a legal hold are kept past their retention until the hold is released.
+	audit.Open(filepath.Join(t.TempDir(), "audit.log"))
+	defer audit.Open("")
+	if err := models.LoadLegalHolds(filepath.Join(t.TempDir(), "legal_holds.json")); err != nil {
+		t.Fatal(err)
+	}
+	defer models.LoadLegalHolds("")
+	hold, err := models.CreateLegalHold(ctx, models.LegalHold{Name: "dispute", Location: "shipping"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	held := []models.Log{
+		{CreatedAt: now.AddDate(0, 0, -40), LogLevel: "INFO", Location: "shipping", Message: "held"},
+		{CreatedAt: now.AddDate(0, 0, -40), LogLevel: "INFO", Location: "shipping/returns", Message: "not held"},
+	}
+	if err := fs.CreateMany(ctx, held); err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := PurgeExpiredLogs(ctx); err != nil || purged != 1 {
+		t.Fatalf("purged %d logs under a hold, %v, want 1", purged, err)
+	}
+	if _, err := models.ReleaseLegalHold(ctx, hold.ID, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := PurgeExpiredLogs(ctx); err != nil || purged != 1 {
+		t.Fatalf("purged %d logs after the hold was released, %v, want 1", purged, err)
+	}
+	if got := storedMessages(t, fs); !equalMessages(got, want) {
+		t.Errorf("kept %v after the hold was released, want %v", got, want)
+	}
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// storedMessages returns the sorted messages of every stored log.
+func storedMessages(t *testing.T, fs *database.FileStore) []string {
+	found, _, err := fs.Find(context.Background(), models.LogSearchFields{}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := []string{}
+	for _, l := range found {
+		messages = append(messages, l.Message)
+	}
+	sort.Strings(messages)
+
+	return messages
+}
+
+// equalMessages reports whether two lists of messages are equal.
+func equalMessages(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"logging_service/audit"
+	"logging_service/commands"
+	"logging_service/config"
 	"logging_service/database"
+	"logging_service/jobs"
+	"logging_service/models"
 	"logging_service/routes"
+	"logging_service/security"
 	"os"
 
 	"github.com/gin-gonic/gin"
@@ -13,10 +19,43 @@ var router *gin.Engine
 func init() {
 	router = gin.Default()
 	database.CreateConnectionConfig()
+	audit.Open(config.GetConfig().Audit.File)
+	audit.OpenAccess(config.GetConfig().Audit.AccessFile)
+	if err := models.LoadLegalHolds(config.GetConfig().LegalHold.File); err != nil {
+		panic(err)
+	}
+	if err := security.LoadAPIKeys(config.GetConfig().APIKeys.File, config.GetConfig().APIKeys.RotationGraceHours); err != nil {
+		panic(err)
+	}
+	if err := security.LoadProducers(config.GetConfig().Signing.File, config.GetConfig().Signing.RotationGraceHours); err != nil {
+		panic(err)
+	}
+	if err := models.LoadMaskingPolicies(config.GetConfig().Masking.Policies, config.GetConfig().Masking.HashKey); err != nil {
+		panic(err)
+	}
 }
 
 func main() {
 	// Set the timezone to UTC so incoming datetimes can be compared to the log file's datetimes which do not have a timezone.
 	os.Setenv("TZ", "UTC")
+	if len(os.Args) > 1 {
+		os.Exit(commands.Run(os.Args[1:]))
+	}
+
+	if err := jobs.StartRetention(); err != nil {
+		panic(err)
+	}
+	if err := jobs.StartArchive(); err != nil {
+		panic(err)
+	}
+	if err := jobs.StartHashChain(); err != nil {
+		panic(err)
+	}
+	if err := jobs.StartEncryption(); err != nil {
+		panic(err)
+	}
+	if err := jobs.StartBackgroundJobs(); err != nil {
+		panic(err)
+	}
 	routes.Setup(router)
 }
@@ -0,0 +1,188 @@
+package masking
+
+/*
+ *
+ * file: 		policy.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the masking policies redacting or hashing sensitive parts of logs when they are read.
+ *
+ */
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Actions of a policy.
+const (
+	ActionRedact = "redact"
+	ActionHash   = "hash"
+)
+
+// defaultReplacement replaces redacted values when a policy has no replacement.
+const defaultReplacement = "[REDACTED]"
+
+// hashLength is the number of hex characters of a hash kept in a masked value.
+const hashLength = 16
+
+// Policy masks the parts of a log's message and extra entries matching a pattern, and the values of extra entries
+// named by its extra fields. Extra entries are named by the key before their first "=". Masked values are either
+// replaced or replaced by a keyed hash, so equal values can still be correlated without being revealed.
+type Policy struct {
+	name        string
+	pattern     *regexp.Regexp
+	extraFields []string
+	action      string
+	replacement string
+	hashKey     []byte
+}
+
+// NewPolicy creates a masking policy.
+//
+// Parameters:
+//	string		name		- Name of the policy.
+//	string		pattern		- Regular expression of the substrings to mask, empty to only mask extra fields.
+//	[]string	extraFields	- Keys of the extra entries whose values are masked.
+//	string		action		- ActionRedact or ActionHash, ActionRedact when empty.
+//	string		replacement	- Replacement of redacted values, "[REDACTED]" when empty.
+//	string		hashKey		- Key of the hmac hashed values are replaced with, required by ActionHash.
+//
+// Returns
+//	*Policy	- Policy.
+//	error	- Error if the policy is not valid.
+//
+func NewPolicy(name string, pattern string, extraFields []string, action string, replacement string, hashKey string) (*Policy, error) {
+	if name == "" {
+		return nil, errors.New("masking: a policy name is required")
+	}
+	p := &Policy{name: name, extraFields: extraFields, action: strings.ToLower(action), replacement: replacement, hashKey: []byte(hashKey)}
+	if p.action == "" {
+		p.action = ActionRedact
+	}
+	if p.action != ActionRedact && p.action != ActionHash {
+		return nil, errors.New("masking: " + name + ": action must be redact or hash")
+	}
+	// An unkeyed hash of a short value, such as a card or phone number, is reversed by hashing every candidate.
+	if p.action == ActionHash && hashKey == "" {
+		return nil, errors.New("masking: " + name + ": the hash action requires Masking.HASH_KEY")
+	}
+	if p.replacement == "" {
+		p.replacement = defaultReplacement
+	}
+	if pattern == "" && len(extraFields) == 0 {
+		return nil, errors.New("masking: " + name + ": a pattern or extra fields are required")
+	}
+	if pattern != "" {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.New("masking: " + name + ": " + err.Error())
+		}
+		p.pattern = compiled
+	}
+
+	return p, nil
+}
+
+// Name returns the name of the policy.
+//
+// Receiver:
+//	*Policy		p
+//
+// Returns
+//	string - Name of the policy.
+//
+func (p *Policy) Name() string {
+	return p.name
+}
+
+// MaskMessage masks the substrings of a message matching the policy's pattern.
+//
+// Receiver:
+//	*Policy		p
+//
+// Parameters:
+//	string	message	- Message to mask.
+//
+// Returns
+//	string - Masked message.
+//
+func (p *Policy) MaskMessage(message string) string {
+	if p.pattern == nil {
+		return message
+	}
+
+	return p.pattern.ReplaceAllStringFunc(message, p.mask)
+}
+
+// MaskExtra masks the values of the extra entries named by the policy's extra fields, and the substrings of the other
+// entries matching its pattern.
+//
+// Receiver:
+//	*Policy		p
+//
+// Parameters:
+//	[]string	extra	- Extra entries to mask.
+//
+// Returns
+//	[]string - Masked extra entries.
+//
+func (p *Policy) MaskExtra(extra []string) []string {
+	if len(extra) == 0 {
+		return extra
+	}
+
+	masked := make([]string, len(extra))
+	for i, entry := range extra {
+		if key, value, ok := splitEntry(entry); ok && p.masksField(key) {
+			masked[i] = key + "=" + p.mask(value)
+			continue
+		}
+		masked[i] = p.MaskMessage(entry)
+	}
+
+	return masked
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// mask returns the replacement of a value.
+func (p *Policy) mask(value string) string {
+	if p.action != ActionHash {
+		return p.replacement
+	}
+
+	mac := hmac.New(sha256.New, p.hashKey)
+	mac.Write([]byte(value))
+
+	return "hash:" + hex.EncodeToString(mac.Sum(nil))[:hashLength]
+}
+
+// masksField reports whether the policy masks the extra entry of a key.
+func (p *Policy) masksField(key string) bool {
+	for _, field := range p.extraFields {
+		if field == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitEntry splits an extra entry into the key and value either side of its first "=".
+func splitEntry(entry string) (string, string, bool) {
+	index := strings.Index(entry, "=")
+	if index <= 0 {
+		return "", "", false
+	}
+
+	return entry[:index], entry[index+1:], true
+}
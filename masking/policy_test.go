@@ -0,0 +1,93 @@
+package masking
+
+/*
+ *
+ * file: 		policy_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests redacting and hashing the sensitive parts of messages and extra fields.
+ *
+ */
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      string
+		pattern     string
+		extraFields []string
+		action      string
+		hashKey     string
+		wantErr     bool
+	}{
+		{"redact by default", "cards", `\d{16}`, nil, "", "", false},
+		{"hash with a key", "cards", `\d{16}`, nil, "HASH", "secret", false},
+		{"hash without a key", "cards", `\d{16}`, nil, "hash", "", true},
+		{"unknown action", "cards", `\d{16}`, nil, "drop", "", true},
+		{"no name", "", `\d{16}`, nil, "", "", true},
+		{"nothing to mask", "cards", "", nil, "", "", true},
+		{"only extra fields", "emails", "", []string{"email"}, "", "", false},
+		{"malformed pattern", "cards", `[0-9`, nil, "", "", true},
+	}
+	for _, test := range tests {
+		if _, err := NewPolicy(test.policy, test.pattern, test.extraFields, test.action, "", test.hashKey); (err != nil) != test.wantErr {
+			t.Errorf("%s: returned %v, want an error %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestMaskMessage(t *testing.T) {
+	redact, _ := NewPolicy("cards", `\d{16}`, nil, ActionRedact, "", "")
+	replace, _ := NewPolicy("cards", `\d{16}`, nil, ActionRedact, "****", "")
+	hash, _ := NewPolicy("cards", `\d{16}`, nil, ActionHash, "", "secret")
+	otherKey, _ := NewPolicy("cards", `\d{16}`, nil, ActionHash, "", "other")
+
+	tests := []struct {
+		name    string
+		policy  *Policy
+		message string
+		want    string
+	}{
+		{"redacted", redact, "paid with 4111111111111111 today", "paid with [REDACTED] today"},
+		{"replaced", replace, "paid with 4111111111111111", "paid with ****"},
+		{"nothing matches", redact, "paid in cash", "paid in cash"},
+	}
+	for _, test := range tests {
+		if got := test.policy.MaskMessage(test.message); got != test.want {
+			t.Errorf("%s: masked %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	// Hashes hide the value but stay equal for equal values under the same key.
+	first := hash.MaskMessage("4111111111111111")
+	if !strings.HasPrefix(first, "hash:") || len(first) != len("hash:")+hashLength || strings.Contains(first, "4111") {
+		t.Errorf("hashed %q, want hash: and %d hex characters", first, hashLength)
+	}
+	if second := hash.MaskMessage("4111111111111111"); second != first {
+		t.Errorf("hashed the same value to %q and %q", first, second)
+	}
+	if different := hash.MaskMessage("4222222222222222"); different == first {
+		t.Errorf("hashed different values to %q", first)
+	}
+	if keyed := otherKey.MaskMessage("4111111111111111"); keyed == first {
+		t.Errorf("hashed the same value to %q under different keys", first)
+	}
+}
+
+func TestMaskExtra(t *testing.T) {
+	policy, _ := NewPolicy("contacts", `\d{3}-\d{4}`, []string{"email"}, ActionRedact, "", "")
+
+	extra := []string{"email=someone@example.com", "phone 555-1234", "note=email=kept", "emailed=yes"}
+	want := []string{"email=[REDACTED]", "phone [REDACTED]", "note=email=kept", "emailed=yes"}
+	if got := policy.MaskExtra(extra); !reflect.DeepEqual(got, want) {
+		t.Errorf("masked %q, want %q", got, want)
+	}
+	if extra[0] != "email=someone@example.com" {
+		t.Errorf("changed the entries masked to %q", extra[0])
+	}
+}
@@ -0,0 +1,113 @@
+package models
+
+/*
+ *
+ * file: 		access_model.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the location and log level grants of a request's context, which restrict what it can read.
+ *
+ */
+
+import (
+	"context"
+	"strings"
+)
+
+// AccessGrant allows reading the logs whose location starts with one of its location prefixes and whose log level is
+// one of its log levels. A grant without location prefixes allows every location, and one without log levels every
+// log level.
+type AccessGrant struct {
+	LocationPrefixes []string `json:"location_prefixes,omitempty"`
+	LogLevels        []string `json:"log_levels,omitempty"`
+}
+
+// accessKey is the context key the grants of a request are stored under.
+type accessKey struct{}
+
+// WithAccess returns a context restricted to the logs allowed by any of the grants. Searches, counts and exports run
+// with it silently leave out every other log.
+//
+// Parameters:
+//	context.Context	ctx		- Parent context.
+//	[]AccessGrant	grants	- Grants, nil to leave the context unrestricted and empty to allow no logs.
+//
+// Returns
+//	context.Context - Restricted context.
+//
+func WithAccess(ctx context.Context, grants []AccessGrant) context.Context {
+	if grants == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, accessKey{}, grants)
+}
+
+// AccessOf returns the grants a context is restricted to.
+//
+// Parameters:
+//	context.Context	ctx	- Context.
+//
+// Returns
+//	[]AccessGrant	- Grants.
+//	bool			- False if the context is not restricted.
+//
+func AccessOf(ctx context.Context) ([]AccessGrant, bool) {
+	grants, ok := ctx.Value(accessKey{}).([]AccessGrant)
+	return grants, ok
+}
+
+// ScopeToCaller returns the search fields restricted to the tenant and grants of a context, so a read only sees what
+// the caller may read.
+//
+// Parameters:
+//	context.Context	ctx		- Context.
+//	LogSearchFields	fields	- Search fields.
+//
+// Returns
+//	LogSearchFields - Scoped search fields.
+//
+func ScopeToCaller(ctx context.Context, fields LogSearchFields) LogSearchFields {
+	fields = ScopeToTenant(ctx, fields)
+	if grants, ok := AccessOf(ctx); ok {
+		fields.Grants = grants
+	}
+
+	return fields
+}
+
+// Matches reports whether the grant allows reading a log.
+//
+// Receiver:
+//	AccessGrant		g
+//
+// Parameters:
+//	*Log	l	- Log to check.
+//
+// Returns
+//	bool - True if the log is allowed.
+//
+func (g AccessGrant) Matches(l *Log) bool {
+	if len(g.LocationPrefixes) > 0 {
+		allowed := false
+		for _, prefix := range g.LocationPrefixes {
+			if strings.HasPrefix(l.Location, prefix) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	if len(g.LogLevels) == 0 {
+		return true
+	}
+	for _, logLevel := range g.LogLevels {
+		if l.LogLevel == logLevel {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,304 @@
+package models
+
+/*
+ *
+ * file: 		hash_chain_model.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the hash chain that links each stored log to the previous log of its location and log level.
+ *
+ */
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ChainHead is the last log of a chain and the sequence of its first stored log, which is one past the last log when
+// every log of the chain has been removed.
+type ChainHead struct {
+	Location      string `json:"location"`
+	LogLevel      string `json:"log_level"`
+	Sequence      int64  `json:"sequence"`
+	Hash          string `json:"hash"`
+	FirstSequence int64  `json:"first_sequence,omitempty"`
+}
+
+// HashChain links every log to the previous log with the same location and log level. Chains are split by log level
+// as well as location so retention and archiving, which both work per log level, only ever remove the oldest part of a
+// chain. Heads are cached by the process appending to them, so only one process may write to a chained store: the
+// service must not run replicas, and logs are only imported while it is stopped. A second writer would fork the chain.
+//
+// The first sequence of each chain only advances when Trim is called after logs are removed by retention, archiving
+// or a confirmed deletion, so checkpoints reveal logs removed from the start of a chain by anything else.
+type HashChain struct {
+	mutex  sync.Mutex
+	heads  map[string]ChainHead
+	seeded map[string]ChainHead
+	first  map[string]int64
+}
+
+// errFirstFound stops the read of a chain once its first log is found.
+var errFirstFound = errors.New("first chained log found")
+
+// hashChain is the chain logs are appended to when created, nil when hash chaining is disabled.
+var hashChain *HashChain
+
+// NewHashChain creates a hash chain. Heads from the last checkpoint are used for chains whose logs are no longer in the
+// store, and for chains whose stored logs end before the checkpoint so the missing logs are still reported.
+//
+// Parameters:
+//	[]ChainHead	seeds	- Heads recorded by the last checkpoint.
+//
+// Returns
+//	*HashChain	- Hash chain.
+//
+func NewHashChain(seeds []ChainHead) *HashChain {
+	hc := &HashChain{heads: map[string]ChainHead{}, seeded: map[string]ChainHead{}, first: map[string]int64{}}
+	for _, seed := range seeds {
+		key := chainKey(seed.Location, seed.LogLevel)
+		hc.seeded[key] = seed
+		if seed.FirstSequence > 0 {
+			hc.first[key] = seed.FirstSequence
+		}
+	}
+
+	return hc
+}
+
+// SetHashChain sets the chain logs are appended to when created.
+//
+// Parameters:
+//	*HashChain	hc	- Hash chain, nil to disable hash chaining.
+//
+func SetHashChain(hc *HashChain) {
+	hashChain = hc
+}
+
+// GetHashChain returns the chain logs are appended to when created.
+//
+// Returns
+//	*HashChain	- Hash chain, nil if hash chaining is disabled.
+//
+func GetHashChain() *HashChain {
+	return hashChain
+}
+
+// Append links a log to the head of its chain and stores it. The log's id is assigned before hashing and its creation
+// time is truncated to the millisecond precision mongodb stores.
+//
+// Receiver:
+//	*HashChain		hc
+//
+// Parameters:
+//	*Log	l	- Log to store.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (hc *HashChain) Append(ctx context.Context, l *Log) error {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	head, err := hc.head(ctx, l.Location, l.LogLevel)
+	if err != nil {
+		return err
+	}
+
+	if l.ID.IsZero() {
+		l.ID = primitive.NewObjectID()
+	}
+	l.CreatedAt = l.CreatedAt.UTC().Truncate(time.Millisecond)
+	l.Sequence = head.Sequence + 1
+	l.PrevHash = head.Hash
+	l.Hash = ComputeLogHash(l)
+	if err := store.Create(ctx, l); err != nil {
+		return err
+	}
+
+	key := chainKey(l.Location, l.LogLevel)
+	hc.heads[key] = ChainHead{Location: l.Location, LogLevel: l.LogLevel, Sequence: l.Sequence, Hash: l.Hash}
+	if _, ok := hc.first[key]; !ok && l.Sequence == 1 {
+		hc.first[key] = 1
+	}
+	return nil
+}
+
+// Heads returns the head of every chain known to the hash chain, including the seeded heads, with the first sequence
+// of each. The first sequence of a chain not seen since the service started is read from the store.
+//
+// Receiver:
+//	*HashChain		hc
+//
+// Parameters:
+//	context.Context	ctx	- Context of the read.
+//
+// Returns
+//	[]ChainHead	- Heads ordered by location and log level.
+//	error		- Any error that occurs.
+//
+func (hc *HashChain) Heads(ctx context.Context) ([]ChainHead, error) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	heads := hc.merged()
+	for i := range heads {
+		key := chainKey(heads[i].Location, heads[i].LogLevel)
+		if _, ok := hc.first[key]; !ok {
+			first, err := hc.firstSequence(ctx, heads[i])
+			if err != nil {
+				return nil, err
+			}
+			hc.first[key] = first
+		}
+		heads[i].FirstSequence = hc.first[key]
+	}
+
+	return heads, nil
+}
+
+// Trim reads the first sequence of every chain from the store again. It is called after logs are removed by
+// retention, archiving or a confirmed deletion so the next checkpoint accepts their removal.
+//
+// Receiver:
+//	*HashChain		hc
+//
+// Parameters:
+//	context.Context	ctx	- Context of the read.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (hc *HashChain) Trim(ctx context.Context) error {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	for _, head := range hc.merged() {
+		first, err := hc.firstSequence(ctx, head)
+		if err != nil {
+			return err
+		}
+		hc.first[chainKey(head.Location, head.LogLevel)] = first
+	}
+
+	return nil
+}
+
+// ComputeLogHash returns the hex sha256 of a chained log's content, sequence and previous hash. Encrypted logs are hashed
+// by their ciphertext. Metadata that changes after a log is stored, such as its expiry, restore time and wrapped data
+// key, is not hashed.
+//
+// Parameters:
+//	*Log	l	- Log to hash.
+//
+// Returns
+//	string - Hex encoded hash.
+//
+func ComputeLogHash(l *Log) string {
+	extra := l.Extra
+	if len(extra) == 0 {
+		extra = nil
+	}
+
+	content, _ := json.Marshal(struct {
+		PrevHash   string   `json:"prev_hash"`
+		Sequence   int64    `json:"sequence"`
+		ID         string   `json:"id"`
+		CreatedAt  string   `json:"created_at"`
+		LogLevel   string   `json:"log_level"`
+		Location   string   `json:"location"`
+		Message    string   `json:"message"`
+		Extra      []string `json:"extra"`
+		Ciphertext string   `json:"ciphertext,omitempty"`
+		Tenant     string   `json:"tenant,omitempty"`
+	}{
+		PrevHash:   l.PrevHash,
+		Sequence:   l.Sequence,
+		ID:         l.ID.Hex(),
+		CreatedAt:  l.CreatedAt.UTC().Format(time.RFC3339Nano),
+		LogLevel:   l.LogLevel,
+		Location:   l.Location,
+		Message:    l.Message,
+		Extra:      extra,
+		Ciphertext: l.Ciphertext,
+		Tenant:     l.Tenant,
+	})
+	hash := sha256.Sum256(content)
+
+	return hex.EncodeToString(hash[:])
+}
+
+// head returns the head of a chain, reading the last chained log from the store the first time the chain is used.
+func (hc *HashChain) head(ctx context.Context, location string, logLevel string) (ChainHead, error) {
+	key := chainKey(location, logLevel)
+	if head, ok := hc.heads[key]; ok {
+		return head, nil
+	}
+
+	head := ChainHead{Location: location, LogLevel: logLevel}
+	logs, _, err := store.Find(ctx, LogSearchFields{Location: location, LogLevel: logLevel, Chained: true, OrderBy: "id"}, 1)
+	if err != nil {
+		return head, err
+	}
+	if len(logs) > 0 {
+		head.Sequence = logs[0].Sequence
+		head.Hash = logs[0].Hash
+	}
+	if seed, ok := hc.seeded[key]; ok && seed.Sequence > head.Sequence {
+		head = seed
+	}
+
+	hc.heads[key] = head
+	return head, nil
+}
+
+// merged returns the appended and seeded heads, ordered by location and log level.
+func (hc *HashChain) merged() []ChainHead {
+	merged := map[string]ChainHead{}
+	for key, head := range hc.seeded {
+		merged[key] = head
+	}
+	for key, head := range hc.heads {
+		merged[key] = head
+	}
+
+	heads := []ChainHead{}
+	for _, head := range merged {
+		heads = append(heads, head)
+	}
+	sort.Slice(heads, func(i, j int) bool {
+		if heads[i].Location != heads[j].Location {
+			return heads[i].Location < heads[j].Location
+		}
+		return heads[i].LogLevel < heads[j].LogLevel
+	})
+
+	return heads
+}
+
+// firstSequence returns the sequence of the first stored log of a chain, or one past its head if none are stored.
+func (hc *HashChain) firstSequence(ctx context.Context, head ChainHead) (int64, error) {
+	first := head.Sequence + 1
+	err := store.Iterate(ctx, LogSearchFields{Location: head.Location, LogLevel: head.LogLevel, Chained: true}, func(l *Log) error {
+		first = l.Sequence
+		return errFirstFound
+	})
+	if err != nil && err != errFirstFound {
+		return 0, err
+	}
+
+	return first, nil
+}
+
+// chainKey identifies the chain of a location and log level.
+func chainKey(location string, logLevel string) string {
+	return logLevel + "\x00" + location
+}
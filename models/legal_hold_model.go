@@ -0,0 +1,475 @@
+package models
+
+/*
+ *
+ * file: 		legal_hold_model.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines legal holds, which exempt the logs they match from every purge, archive and delete.
+ *
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"logging_service/audit"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrLegalHoldNotFound is returned when no legal hold has the given id.
+var ErrLegalHoldNotFound = errors.New("legal hold not found")
+
+// LegalHold freezes the logs matching its location, log level and time range. Empty conditions match every log, and
+// a hold without an end keeps matching new logs until it is released. A hold placed within a tenant only matches and is
+// only visible to that tenant, while a hold without a tenant matches the logs of every tenant.
+type LegalHold struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name" binding:"required"`
+	Reason    string     `json:"reason,omitempty"`
+	Tenant    string     `json:"tenant,omitempty"`
+	Location  string     `json:"location,omitempty"`
+	LogLevel  string     `json:"log_level,omitempty"`
+	From      *time.Time `json:"from,omitempty"`
+	To        *time.Time `json:"to,omitempty"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedBy string     `json:"updated_by,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// legalHoldFile holds the active legal holds, saved as a json array in a file.
+type legalHoldFile struct {
+	path  string
+	mutex sync.RWMutex
+	holds []LegalHold
+}
+
+// legalHolds are the active legal holds, nil when no legal hold file is configured.
+var legalHolds *legalHoldFile
+
+// LoadLegalHolds loads the active legal holds from a file. The file is created when the first hold is placed.
+//
+// Parameters:
+//	string	path	- Path of the legal hold file, empty to disable legal holds.
+//
+// Returns
+//	error - Error if the file cannot be read.
+//
+func LoadLegalHolds(path string) error {
+	legalHolds = nil
+	if path == "" {
+		return nil
+	}
+
+	file := &legalHoldFile{path: path, holds: []LegalHold{}}
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, &file.holds); err != nil {
+			return err
+		}
+	}
+
+	legalHolds = file
+	return nil
+}
+
+// LegalHoldsEnabled reports whether a legal hold file is configured.
+//
+// Returns
+//	bool - True if legal holds can be placed.
+//
+func LegalHoldsEnabled() bool {
+	return legalHolds != nil
+}
+
+// ListLegalHolds returns the active legal holds of the tenant of a context, or every active hold when the context is
+// not scoped to a tenant.
+//
+// Parameters:
+//	context.Context	ctx	- Context of the request.
+//
+// Returns
+//	[]LegalHold	- Active legal holds in the order they were placed.
+//
+func ListLegalHolds(ctx context.Context) []LegalHold {
+	holds := []LegalHold{}
+	for _, hold := range activeLegalHolds() {
+		if ownsHold(ctx, hold) {
+			holds = append(holds, hold)
+		}
+	}
+
+	return holds
+}
+
+// GetLegalHold returns an active legal hold of the tenant of a context.
+//
+// Parameters:
+//	context.Context	ctx	- Context of the request.
+//	string			id	- Id of the hold.
+//
+// Returns
+//	LegalHold	- Legal hold.
+//	error		- ErrLegalHoldNotFound if no active hold of the tenant has the id.
+//
+func GetLegalHold(ctx context.Context, id string) (LegalHold, error) {
+	for _, hold := range ListLegalHolds(ctx) {
+		if hold.ID == id {
+			return hold, nil
+		}
+	}
+
+	return LegalHold{}, ErrLegalHoldNotFound
+}
+
+// CreateLegalHold places a legal hold within the tenant of a context. Callers not scoped to a tenant may name the
+// tenant of the hold, or leave it out to hold the logs of every tenant. The hold is recorded in the audit trail before
+// it is saved, and matching logs stop expiring.
+//
+// Parameters:
+//	context.Context	ctx		- Context of the request.
+//	LegalHold		hold	- Hold to place.
+//	string			actor	- Caller placing the hold.
+//
+// Returns
+//	LegalHold	- Placed hold.
+//	error		- Error if the hold is not valid or cannot be audited or saved.
+//
+func CreateLegalHold(ctx context.Context, hold LegalHold, actor string) (LegalHold, error) {
+	if legalHolds == nil {
+		return hold, errors.New("legal holds: no legal hold file is configured")
+	}
+	if err := hold.Validate(); err != nil {
+		return hold, err
+	}
+
+	hold.ID = primitive.NewObjectID().Hex()
+	if tenant, ok := TenantOf(ctx); ok {
+		hold.Tenant = tenant
+	}
+	hold.CreatedBy = actor
+	hold.CreatedAt = time.Now().UTC()
+	hold.UpdatedBy = ""
+	hold.UpdatedAt = nil
+
+	legalHolds.mutex.Lock()
+	defer legalHolds.mutex.Unlock()
+	if err := hold.audit("hold_create", actor); err != nil {
+		return hold, err
+	}
+	if err := legalHolds.save(append(append([]LegalHold{}, legalHolds.holds...), hold)); err != nil {
+		return hold, err
+	}
+
+	return hold, clearHeldExpiry(ctx, hold)
+}
+
+// UpdateLegalHold replaces the name, reason and conditions of a legal hold of the tenant of a context. The hold keeps
+// its tenant unless the caller is not scoped to one. The change is recorded in the audit trail before it is saved, and
+// logs matching the new conditions stop expiring.
+//
+// Parameters:
+//	context.Context	ctx		- Context of the request.
+//	string			id		- Id of the hold.
+//	LegalHold		hold	- New name, reason and conditions.
+//	string			actor	- Caller changing the hold.
+//
+// Returns
+//	LegalHold	- Changed hold.
+//	error		- ErrLegalHoldNotFound, or an error if the hold is not valid or cannot be audited or saved.
+//
+func UpdateLegalHold(ctx context.Context, id string, hold LegalHold, actor string) (LegalHold, error) {
+	if legalHolds == nil {
+		return hold, ErrLegalHoldNotFound
+	}
+	if err := hold.Validate(); err != nil {
+		return hold, err
+	}
+
+	legalHolds.mutex.Lock()
+	defer legalHolds.mutex.Unlock()
+	holds := append([]LegalHold{}, legalHolds.holds...)
+	index := indexOfHold(holds, id)
+	if index < 0 || !ownsHold(ctx, holds[index]) {
+		return hold, ErrLegalHoldNotFound
+	}
+
+	updatedAt := time.Now().UTC()
+	hold.ID = id
+	if _, ok := TenantOf(ctx); ok {
+		hold.Tenant = holds[index].Tenant
+	}
+	hold.CreatedBy = holds[index].CreatedBy
+	hold.CreatedAt = holds[index].CreatedAt
+	hold.UpdatedBy = actor
+	hold.UpdatedAt = &updatedAt
+	if err := hold.audit("hold_update", actor); err != nil {
+		return hold, err
+	}
+	holds[index] = hold
+	if err := legalHolds.save(holds); err != nil {
+		return hold, err
+	}
+
+	return hold, clearHeldExpiry(ctx, hold)
+}
+
+// ReleaseLegalHold removes a legal hold of the tenant of a context. The release is recorded in the audit trail before
+// the hold is removed. Logs that were only held by it are removed by the next purge if they have outlived the retention
+// policy.
+//
+// Parameters:
+//	context.Context	ctx		- Context of the request.
+//	string			id		- Id of the hold.
+//	string			actor	- Caller releasing the hold.
+//
+// Returns
+//	LegalHold	- Released hold.
+//	error		- ErrLegalHoldNotFound, or an error if the release cannot be audited or saved.
+//
+func ReleaseLegalHold(ctx context.Context, id string, actor string) (LegalHold, error) {
+	if legalHolds == nil {
+		return LegalHold{}, ErrLegalHoldNotFound
+	}
+
+	legalHolds.mutex.Lock()
+	defer legalHolds.mutex.Unlock()
+	holds := append([]LegalHold{}, legalHolds.holds...)
+	index := indexOfHold(holds, id)
+	if index < 0 || !ownsHold(ctx, holds[index]) {
+		return LegalHold{}, ErrLegalHoldNotFound
+	}
+
+	hold := holds[index]
+	if err := hold.audit("hold_release", actor); err != nil {
+		return hold, err
+	}
+
+	return hold, legalHolds.save(append(holds[:index], holds[index+1:]...))
+}
+
+// ExcludeHeld returns the search fields restricted to logs that are not under any active legal hold. Every path that
+// removes logs passes its search fields through it. A hold of a tenant only excludes the logs of that tenant.
+//
+// Parameters:
+//	LogSearchFields	fields	- Search fields.
+//
+// Returns
+//	LogSearchFields	- Search fields excluding held logs.
+//
+func ExcludeHeld(fields LogSearchFields) LogSearchFields {
+	fields.ExcludedHolds = append(append([]LegalHold{}, fields.ExcludedHolds...), activeLegalHolds()...)
+	return fields
+}
+
+// HoldsOf returns the ids of the active legal holds matching a log.
+//
+// Parameters:
+//	*Log	l	- Log to check.
+//
+// Returns
+//	[]string - Ids of the matching holds.
+//
+func HoldsOf(l *Log) []string {
+	ids := []string{}
+	for _, hold := range activeLegalHolds() {
+		if hold.Matches(l) {
+			ids = append(ids, hold.ID)
+		}
+	}
+
+	return ids
+}
+
+// MarkHeldLogs sets the legal holds of each log so search results show which logs are under hold.
+//
+// Parameters:
+//	[]Log	logs	- Logs to mark.
+//
+func MarkHeldLogs(logs []Log) {
+	holds := activeLegalHolds()
+	for i := range logs {
+		logs[i].LegalHolds = nil
+		for _, hold := range holds {
+			if hold.Matches(&logs[i]) {
+				logs[i].LegalHolds = append(logs[i].LegalHolds, hold.ID)
+			}
+		}
+	}
+}
+
+// Matches reports whether a log is under the hold.
+//
+// Receiver:
+//	LegalHold		h
+//
+// Parameters:
+//	*Log	l	- Log to check.
+//
+// Returns
+//	bool - True if the log matches every condition of the hold.
+//
+func (h LegalHold) Matches(l *Log) bool {
+	fields := h.SearchFields()
+	return fields.Matches(l)
+}
+
+// SearchFields returns search fields matching the logs under the hold.
+//
+// Receiver:
+//	LegalHold		h
+//
+// Returns
+//	LogSearchFields	- Search fields.
+//
+func (h LegalHold) SearchFields() LogSearchFields {
+	from := time.Unix(0, 0).UTC()
+	if h.From != nil {
+		from = *h.From
+	}
+	to := time.Date(9999, time.December, 31, 23, 59, 59, 0, time.UTC)
+	if h.To != nil {
+		to = *h.To
+	}
+
+	return LogSearchFields{Location: h.Location, LogLevel: h.LogLevel, FromDate: &from, ToDate: &to, Tenant: h.Tenant}
+}
+
+// Validate normalises the log level of the hold and checks its name and conditions.
+//
+// Receiver:
+//	*LegalHold		h
+//
+// Returns
+//	error - Error describing the first field that is not valid.
+//
+func (h *LegalHold) Validate() error {
+	h.Name = strings.TrimSpace(h.Name)
+	if h.Name == "" {
+		return errors.New("name: required")
+	}
+	h.LogLevel = strings.ToUpper(h.LogLevel)
+	if valid, all := IsValidLogLevel(h.LogLevel); !valid {
+		return errors.New("log_level: unknown log level")
+	} else if all {
+		h.LogLevel = ""
+	}
+	if h.From != nil && h.To != nil && h.From.After(*h.To) {
+		return errors.New("from: must not be after to")
+	}
+
+	return nil
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// audit records a change to the hold in the audit trail.
+func (h LegalHold) audit(action string, actor string) error {
+	filters := map[string]string{}
+	if h.Location != "" {
+		filters["location"] = h.Location
+	}
+	if h.LogLevel != "" {
+		filters["log_level"] = h.LogLevel
+	}
+	if h.From != nil {
+		filters["from"] = h.From.UTC().Format(time.RFC3339Nano)
+	}
+	if h.To != nil {
+		filters["to"] = h.To.UTC().Format(time.RFC3339Nano)
+	}
+	_, err := audit.Append(audit.Record{
+		Actor:   actor,
+		Tenant:  h.Tenant,
+		Action:  action,
+		Filters: filters,
+		Details: map[string]interface{}{"hold_id": h.ID, "name": h.Name, "reason": h.Reason},
+	})
+
+	return err
+}
+
+// save writes the holds to a temporary file and renames it over the legal hold file, then replaces the loaded holds.
+func (lhf *legalHoldFile) save(holds []LegalHold) error {
+	content, err := json.MarshalIndent(holds, "", "  ")
+	if err != nil {
+		return err
+	}
+	temporary, err := ioutil.TempFile(filepath.Dir(lhf.path), filepath.Base(lhf.path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporary.Name())
+	if _, err := temporary.Write(content); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Sync(); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temporary.Name(), lhf.path); err != nil {
+		return err
+	}
+
+	lhf.holds = holds
+	return nil
+}
+
+// clearHeldExpiry stops the logs under a hold from expiring in backends that remove expired logs themselves.
+func clearHeldExpiry(ctx context.Context, hold LegalHold) error {
+	expiringStore, ok := store.(ExpiringLogStore)
+	if !ok {
+		return nil
+	}
+
+	_, err := expiringStore.ClearExpiry(ctx, hold.SearchFields())
+	return err
+}
+
+// activeLegalHolds returns the active legal holds of every tenant.
+func activeLegalHolds() []LegalHold {
+	holds := []LegalHold{}
+	if legalHolds == nil {
+		return holds
+	}
+
+	legalHolds.mutex.RLock()
+	defer legalHolds.mutex.RUnlock()
+	return append(holds, legalHolds.holds...)
+}
+
+// ownsHold reports whether a hold is visible to the tenant of a context.
+func ownsHold(ctx context.Context, hold LegalHold) bool {
+	tenant, ok := TenantOf(ctx)
+	return !ok || hold.Tenant == tenant
+}
+
+// indexOfHold returns the index of the hold with the id, -1 if there is none.
+func indexOfHold(holds []LegalHold, id string) int {
+	for i, hold := range holds {
+		if hold.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,130 @@
+package models
+
+/*
+ *
+ * file: 		legal_hold_model_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests placing, changing and releasing legal holds within tenants and marking the logs they hold.
+ *
+ */
+
+import (
+	"context"
+	"fmt"
+	"logging_service/audit"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidateLegalHold(t *testing.T) {
+	from := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		hold      LegalHold
+		wantErr   bool
+		wantLevel string
+	}{
+		{"valid", LegalHold{Name: " dispute ", LogLevel: "error", From: &from, To: &to}, false, "ERROR"},
+		{"every level", LegalHold{Name: "dispute", LogLevel: "ALL"}, false, ""},
+		{"no name", LegalHold{Name: " "}, true, ""},
+		{"unknown level", LegalHold{Name: "dispute", LogLevel: "LOUD"}, true, ""},
+		{"from after to", LegalHold{Name: "dispute", From: &to, To: &from}, true, ""},
+	}
+	for _, test := range tests {
+		err := test.hold.Validate()
+		if (err != nil) != test.wantErr || (err == nil && (test.hold.LogLevel != test.wantLevel || test.hold.Name != "dispute")) {
+			t.Errorf("%s: validated %+v, %v, want an error %v", test.name, test.hold, err, test.wantErr)
+		}
+	}
+}
+
+func TestLegalHoldsStayWithinTheirTenant(t *testing.T) {
+	audit.Open(filepath.Join(t.TempDir(), "audit.log"))
+	defer audit.Open("")
+	path := filepath.Join(t.TempDir(), "legal_holds.json")
+	if err := LoadLegalHolds(path); err != nil {
+		t.Fatal(err)
+	}
+	defer LoadLegalHolds("")
+	// Without a store that expires logs itself, placing a hold only saves it.
+	defer SetLogStore(GetLogStore())
+	SetLogStore(nil)
+
+	acme := WithTenant(context.Background(), "acme")
+	globex := WithTenant(context.Background(), "globex")
+	operator := context.Background()
+	acmeHold, err := CreateLegalHold(acme, LegalHold{Name: "dispute", Tenant: "globex", Location: "billing"}, "acme-admin")
+	if err != nil || acmeHold.Tenant != "acme" {
+		t.Fatalf("placed %+v, %v, want a hold of acme", acmeHold, err)
+	}
+	everyHold, err := CreateLegalHold(operator, LegalHold{Name: "audit", Location: "shipping"}, "operator")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Holds are only visible to their tenant, and holds of every tenant only to callers outside tenants.
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"acme", acme, "[dispute]"},
+		{"globex", globex, "[]"},
+		{"operator", operator, "[dispute audit]"},
+	}
+	for _, test := range tests {
+		names := []string{}
+		for _, hold := range ListLegalHolds(test.ctx) {
+			names = append(names, hold.Name)
+		}
+		if fmt.Sprint(names) != test.want {
+			t.Errorf("%s: listed %v, want %s", test.name, names, test.want)
+		}
+	}
+	if _, err := UpdateLegalHold(globex, acmeHold.ID, LegalHold{Name: "taken"}, "globex-admin"); err != ErrLegalHoldNotFound {
+		t.Errorf("changed another tenant's hold with %v, want %v", err, ErrLegalHoldNotFound)
+	}
+	if _, err := ReleaseLegalHold(globex, acmeHold.ID, "globex-admin"); err != ErrLegalHoldNotFound {
+		t.Errorf("released another tenant's hold with %v, want %v", err, ErrLegalHoldNotFound)
+	}
+
+	// A hold of a tenant only holds the tenant's logs, while a hold of every tenant holds any tenant's.
+	now := time.Now().UTC()
+	logs := []Log{
+		{CreatedAt: now, Tenant: "acme", LogLevel: "INFO", Location: "billing"},
+		{CreatedAt: now, Tenant: "globex", LogLevel: "INFO", Location: "billing"},
+		{CreatedAt: now, Tenant: "globex", LogLevel: "INFO", Location: "shipping"},
+	}
+	MarkHeldLogs(logs)
+	want := fmt.Sprint([][]string{{acmeHold.ID}, nil, {everyHold.ID}})
+	if got := fmt.Sprint([][]string{logs[0].LegalHolds, logs[1].LegalHolds, logs[2].LegalHolds}); got != want {
+		t.Errorf("marked %s, want %s", got, want)
+	}
+
+	// Changes keep the hold's tenant and are saved, and every change is audited.
+	updated, err := UpdateLegalHold(acme, acmeHold.ID, LegalHold{Name: "dispute", Tenant: "globex", Location: "invoices"}, "acme-admin")
+	if err != nil || updated.Tenant != "acme" || updated.CreatedBy != "acme-admin" || updated.UpdatedBy != "acme-admin" {
+		t.Errorf("changed the hold to %+v, %v, want it kept in acme", updated, err)
+	}
+	if _, err := ReleaseLegalHold(operator, everyHold.ID, "operator"); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadLegalHolds(path); err != nil {
+		t.Fatal(err)
+	}
+	if holds := ListLegalHolds(operator); len(holds) != 1 || holds[0].Location != "invoices" {
+		t.Errorf("reloaded %+v, want the changed acme hold", holds)
+	}
+	records, _, err := audit.GetTrail().Records(audit.Query{})
+	actions := []string{}
+	for _, record := range records {
+		actions = append(actions, record.Action)
+	}
+	if err != nil || len(actions) != 4 {
+		t.Errorf("audited %v, %v, want two placements, a change and a release", actions, err)
+	}
+}
@@ -0,0 +1,292 @@
+package models
+
+/*
+ *
+ * file: 		log_deletion_model.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines deleting logs by filter, which requires a dry run and is recorded in the audit trail.
+ *
+ */
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"logging_service/audit"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrDeletionNotConfirmed is returned when a confirmation token is unknown, expired, already used, or was issued for
+// different filters or to a different caller.
+var ErrDeletionNotConfirmed = errors.New("confirmation token is not valid for these filters, run a dry run first")
+
+// DeletionPlan is the result of a dry run, describing the logs a deletion would remove. Logs under a legal hold are
+// counted as held and are not removed.
+type DeletionPlan struct {
+	Matched           int64     `json:"matched"`
+	Held              int64     `json:"held"`
+	Samples           []Log     `json:"samples"`
+	ConfirmationToken string    `json:"confirmation_token"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+// DeletionResult describes the logs removed by a confirmed deletion.
+type DeletionResult struct {
+	Matched int64  `json:"matched"`
+	Deleted int64  `json:"deleted"`
+	AuditID string `json:"audit_id"`
+}
+
+// pendingDeletion is a dry run waiting to be confirmed.
+type pendingDeletion struct {
+	fields      LogSearchFields
+	actor       string
+	fingerprint string
+	matched     int64
+	expiresAt   time.Time
+}
+
+// Deletion is a confirmed deletion waiting to run.
+type Deletion struct {
+	pending pendingDeletion
+	filters map[string]string
+}
+
+// deleteBatchSize is the number of logs a deletion run in batches removes at a time.
+const deleteBatchSize = 1000
+
+// errDeleteBatchFull stops iterating once a batch of logs to delete is full.
+var errDeleteBatchFull = errors.New("delete batch is full")
+
+var deletionMutex sync.Mutex
+var pendingDeletions = map[string]pendingDeletion{}
+
+// PlanDeletion counts and samples the logs matching the search fields and issues a token confirming their deletion.
+// The search fields are restricted to what the caller may read and stored with the token, so a confirmed deletion
+// removes what the dry run described even when a relative time range was given.
+//
+// Parameters:
+//	context.Context		ctx			- Context of the dry run.
+//	LogSearchFields		fields		- Search fields of the logs to delete.
+//	map[string]string	filters		- Filters as the caller gave them, which the confirmation must repeat.
+//	string				actor		- Caller the token is issued to.
+//	int64				sampleSize	- Number of matching logs to return.
+//	time.Duration		ttl			- How long the token is valid for.
+//
+// Returns
+//	DeletionPlan	- Matching count, samples and confirmation token.
+//	error			- Any error that occurs.
+//
+func PlanDeletion(ctx context.Context, fields LogSearchFields, filters map[string]string, actor string, sampleSize int64, ttl time.Duration) (DeletionPlan, error) {
+	fields = ScopeToCaller(ctx, fields)
+	fields.Page = 0
+	fields.OrderBy = ""
+	samples, matched, err := store.Find(ctx, ExcludeHeld(fields), sampleSize)
+	if err != nil {
+		return DeletionPlan{}, err
+	}
+	total, err := store.Count(ctx, fields)
+	if err != nil {
+		return DeletionPlan{}, err
+	}
+
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return DeletionPlan{}, err
+	}
+	held := total - matched
+	if held < 0 {
+		held = 0
+	}
+	plan := DeletionPlan{
+		Matched:           matched,
+		Held:              held,
+		Samples:           samples,
+		ConfirmationToken: hex.EncodeToString(token),
+		ExpiresAt:         time.Now().UTC().Add(ttl),
+	}
+
+	deletionMutex.Lock()
+	defer deletionMutex.Unlock()
+	now := time.Now()
+	for token, pending := range pendingDeletions {
+		if now.After(pending.expiresAt) {
+			delete(pendingDeletions, token)
+		}
+	}
+	pendingDeletions[plan.ConfirmationToken] = pendingDeletion{
+		fields:      fields,
+		actor:       actor,
+		fingerprint: filterFingerprint(filters),
+		matched:     matched,
+		expiresAt:   plan.ExpiresAt,
+	}
+
+	return plan, nil
+}
+
+// ConfirmDeletion removes the logs described by a dry run, except logs under a legal hold. The deletion is recorded in
+// the audit trail before any log is removed and does not go ahead if the record cannot be written. The outcome is
+// recorded once the logs are removed.
+//
+// Parameters:
+//	context.Context		ctx		- Context of the deletion.
+//	string				token	- Confirmation token from the dry run.
+//	map[string]string	filters	- Filters as the caller gave them, which must match the dry run's.
+//	string				actor	- Caller confirming the deletion, who must have run the dry run.
+//
+// Returns
+//	DeletionResult	- Number of logs removed and the id of the audit record.
+//	error			- ErrDeletionNotConfirmed if the token is not valid, or any other error that occurs.
+//
+func ConfirmDeletion(ctx context.Context, token string, filters map[string]string, actor string) (DeletionResult, error) {
+	deletion, err := ClaimDeletion(token, filters, actor)
+	if err != nil {
+		return DeletionResult{}, err
+	}
+
+	return deletion.Run(ctx, nil)
+}
+
+// ClaimDeletion checks a confirmation token and uses it up, returning the deletion of the dry run so it can be run
+// later, such as by a background job.
+//
+// Parameters:
+//	string				token	- Confirmation token from the dry run.
+//	map[string]string	filters	- Filters as the caller gave them, which must match the dry run's.
+//	string				actor	- Caller confirming the deletion, who must have run the dry run.
+//
+// Returns
+//	*Deletion	- Confirmed deletion.
+//	error		- ErrDeletionNotConfirmed if the token is not valid.
+//
+func ClaimDeletion(token string, filters map[string]string, actor string) (*Deletion, error) {
+	deletionMutex.Lock()
+	pending, ok := pendingDeletions[token]
+	if ok {
+		delete(pendingDeletions, token)
+	}
+	deletionMutex.Unlock()
+	if !ok || time.Now().After(pending.expiresAt) || pending.actor != actor || pending.fingerprint != filterFingerprint(filters) {
+		return nil, ErrDeletionNotConfirmed
+	}
+
+	return &Deletion{pending: pending, filters: filters}, nil
+}
+
+// Matched returns the number of logs the dry run of a deletion matched.
+//
+// Receiver:
+//	*Deletion	d
+//
+// Returns
+//	int64 - Number of matched logs.
+//
+func (d *Deletion) Matched() int64 {
+	return d.pending.matched
+}
+
+// Run removes the logs of a confirmed deletion as described by ConfirmDeletion. When progress is given, logs are
+// removed in batches in ascending id order and progress is called with the number removed after each batch, so a long
+// deletion can be followed and cancelled between batches.
+//
+// Receiver:
+//	*Deletion	d
+//
+// Parameters:
+//	context.Context				ctx			- Context of the deletion.
+//	func(deleted int64) error	progress	- Called after each batch, nil to remove every log at once.
+//
+// Returns
+//	DeletionResult	- Number of logs removed and the id of the audit record.
+//	error			- Any error that occurs, including errors returned by progress.
+//
+func (d *Deletion) Run(ctx context.Context, progress func(deleted int64) error) (DeletionResult, error) {
+	record, err := audit.Append(audit.Record{
+		Actor:   d.pending.actor,
+		Tenant:  d.pending.fields.Tenant,
+		Action:  "delete",
+		Filters: d.filters,
+		Details: map[string]interface{}{"matched": d.pending.matched},
+	})
+	if err != nil {
+		return DeletionResult{}, err
+	}
+
+	result := DeletionResult{Matched: d.pending.matched, AuditID: record.ID}
+	if progress == nil {
+		result.Deleted, err = store.Delete(ctx, ExcludeHeld(d.pending.fields))
+	} else {
+		result.Deleted, err = deleteInBatches(ctx, ExcludeHeld(d.pending.fields), progress)
+	}
+	details := map[string]interface{}{"delete_id": record.ID, "deleted": result.Deleted}
+	if err != nil {
+		details["error"] = err.Error()
+	}
+	if _, auditErr := audit.Append(audit.Record{Actor: d.pending.actor, Tenant: d.pending.fields.Tenant, Action: "delete_result", Filters: d.filters, Details: details}); auditErr != nil && err == nil {
+		err = auditErr
+	}
+
+	return result, err
+}
+
+// deleteInBatches removes the logs matching the search fields a batch of ids at a time, calling progress after each.
+func deleteInBatches(ctx context.Context, fields LogSearchFields, progress func(deleted int64) error) (int64, error) {
+	deleted := int64(0)
+	for {
+		ids := []primitive.ObjectID{}
+		err := store.Iterate(ctx, fields, func(l *Log) error {
+			ids = append(ids, l.ID)
+			if len(ids) >= deleteBatchSize {
+				return errDeleteBatchFull
+			}
+			return nil
+		})
+		if err != nil && err != errDeleteBatchFull {
+			return deleted, err
+		}
+		if len(ids) == 0 {
+			return deleted, nil
+		}
+
+		batch := fields
+		batch.IDs = ids
+		count, err := store.Delete(ctx, batch)
+		deleted += count
+		if err != nil {
+			return deleted, err
+		}
+		if err := progress(deleted); err != nil {
+			return deleted, err
+		}
+		if len(ids) < deleteBatchSize {
+			return deleted, nil
+		}
+		fields.AfterID = ids[len(ids)-1]
+	}
+}
+
+// filterFingerprint returns a canonical form of filters so a confirmation can be compared with its dry run.
+func filterFingerprint(filters map[string]string) string {
+	keys := []string{}
+	for key, value := range filters {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	pairs := []string{}
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+filters[key])
+	}
+
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,144 @@
+package models
+
+/*
+ *
+ * file: 		log_encryption_model.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the envelope encryption of a log's message and extra fields.
+ *
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"logging_service/encryption"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RewrappingLogStore is implemented by backends that can replace the wrapped data key of encrypted logs in place.
+type RewrappingLogStore interface {
+	LogStore
+
+	// UpdateDataKeys stores the KeyID and DataKey of each log, matched by id.
+	UpdateDataKeys(ctx context.Context, logs []Log) error
+}
+
+// encryptedFields are the fields sealed into a log's ciphertext.
+type encryptedFields struct {
+	Message string   `json:"message"`
+	Extra   []string `json:"extra,omitempty"`
+}
+
+// keyring encrypts the message and extra fields of created logs, nil when encryption is disabled.
+var keyring *encryption.Keyring
+
+// SetKeyring sets the keyring used to encrypt created logs and decrypt logs on read.
+//
+// Parameters:
+//	*encryption.Keyring	k	- Keyring, nil to disable encryption.
+//
+func SetKeyring(k *encryption.Keyring) {
+	keyring = k
+}
+
+// GetKeyring returns the keyring used to encrypt created logs and decrypt logs on read.
+//
+// Returns
+//	*encryption.Keyring	- Keyring, nil if encryption is disabled.
+//
+func GetKeyring() *encryption.Keyring {
+	return keyring
+}
+
+// Encrypt seals the log's message and extra fields into its ciphertext and clears them. The ciphertext is bound to the
+// log's id, so the log is given an id if it does not have one.
+//
+// Receiver:
+//	*Log		l
+//
+// Parameters:
+//	*encryption.Keyring	k	- Keyring.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (l *Log) Encrypt(k *encryption.Keyring) error {
+	if l.ID.IsZero() {
+		l.ID = primitive.NewObjectID()
+	}
+
+	plaintext, err := json.Marshal(encryptedFields{Message: l.Message, Extra: l.Extra})
+	if err != nil {
+		return err
+	}
+	l.KeyID, l.DataKey, l.Ciphertext, err = k.Seal(plaintext, []byte(l.ID.Hex()))
+	if err != nil {
+		return err
+	}
+	l.Message = ""
+	l.Extra = nil
+
+	return nil
+}
+
+// Decrypt restores the message and extra fields of an encrypted log. The key id is kept to show the log was encrypted.
+//
+// Receiver:
+//	*Log		l
+//
+// Parameters:
+//	*encryption.Keyring	k	- Keyring.
+//
+// Returns
+//	error - Error if the key is not loaded or the ciphertext is not authentic.
+//
+func (l *Log) Decrypt(k *encryption.Keyring) error {
+	if l.Ciphertext == "" {
+		return nil
+	}
+
+	plaintext, err := k.Open(l.KeyID, l.DataKey, l.Ciphertext, []byte(l.ID.Hex()))
+	if err != nil {
+		return err
+	}
+	fields := encryptedFields{}
+	if err := json.Unmarshal(plaintext, &fields); err != nil {
+		return err
+	}
+	l.Message = fields.Message
+	l.Extra = fields.Extra
+	l.DataKey = ""
+	l.Ciphertext = ""
+
+	return nil
+}
+
+// RevealLogs prepares logs for a response. Encrypted logs are decrypted when the caller may read them and otherwise
+// have their ciphertext removed, leaving the key id to show the message is encrypted.
+//
+// Parameters:
+//	[]Log	logs	- Logs to prepare.
+//	bool	decrypt	- Whether the caller may read encrypted logs.
+//
+// Returns
+//	error - Any error that occurs while decrypting.
+//
+func RevealLogs(logs []Log, decrypt bool) error {
+	for i := range logs {
+		if logs[i].Ciphertext == "" {
+			continue
+		}
+		if decrypt && keyring != nil {
+			if err := logs[i].Decrypt(keyring); err != nil {
+				return err
+			}
+			continue
+		}
+		logs[i].DataKey = ""
+		logs[i].Ciphertext = ""
+	}
+
+	return nil
+}
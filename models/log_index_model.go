@@ -0,0 +1,239 @@
+package models
+
+/*
+ *
+ * file: 		log_index_model.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the indexes the log collection requires and reconciles them with the indexes that exist.
+ *
+ */
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IndexedLogStore is implemented by backends whose indexes are managed by the service rather than by migrations.
+type IndexedLogStore interface {
+	LogStore
+
+	// EnsureIndexes creates any missing declared index, recreates declared indexes whose keys have drifted and reports
+	// indexes that are not declared.
+	EnsureIndexes(ctx context.Context) ([]IndexDrift, error)
+
+	// IndexUsage returns usage statistics for every index.
+	IndexUsage(ctx context.Context) ([]IndexUsage, error)
+}
+
+// IndexDrift describes a difference between a declared index and the indexes that exist.
+type IndexDrift struct {
+	Collection string `json:"collection,omitempty"`
+	Name       string `json:"name"`
+	Problem    string `json:"problem"`
+	Declared   string `json:"declared,omitempty"`
+	Actual     string `json:"actual,omitempty"`
+}
+
+// IndexUsage describes how often an index has been used since the server started tracking it.
+type IndexUsage struct {
+	Collection string    `json:"collection,omitempty"`
+	Name       string    `json:"name"`
+	Keys       string    `json:"keys"`
+	Declared   bool      `json:"declared"`
+	Ops        int64     `json:"ops"`
+	Since      time.Time `json:"since"`
+}
+
+// logIndexes are the indexes declared for the log collection. Together they cover every filter getFilters creates and
+// every order by field.
+var logIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "created_at", Value: 1}}, Options: options.Index().SetName("created_at")},
+	{Keys: bson.D{{Key: "log_level", Value: 1}, {Key: "created_at", Value: 1}}, Options: options.Index().SetName("log_level_created_at")},
+	{Keys: bson.D{{Key: "location", Value: 1}, {Key: "created_at", Value: 1}}, Options: options.Index().SetName("location_created_at")},
+	{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "created_at", Value: 1}}, Options: options.Index().SetName("tenant_created_at")},
+}
+
+// managedIndexNames are indexes created by the service or by mongodb that are not in logIndexes.
+var managedIndexNames = map[string]bool{"_id_": true, "expires_at_ttl": true}
+
+// EnsureIndexes reconciles the log collection's indexes with the declared indexes.
+//
+// Receiver:
+//	*mongoStore		ms
+//
+// Returns
+//	[]IndexDrift	- Every difference found before reconciling.
+//	error			- Any error that occurs.
+//
+func (ms *mongoStore) EnsureIndexes(ctx context.Context) ([]IndexDrift, error) {
+	coll, err := logsCollection()
+	if err != nil {
+		return nil, err
+	}
+
+	return ensureIndexesOn(ctx, coll)
+}
+
+// IndexUsage returns the $indexStats of the log collection.
+//
+// Receiver:
+//	*mongoStore		ms
+//
+// Returns
+//	[]IndexUsage	- Usage of each index.
+//	error			- Any error that occurs.
+//
+func (ms *mongoStore) IndexUsage(ctx context.Context) ([]IndexUsage, error) {
+	coll, err := logsCollection()
+	if err != nil {
+		return nil, err
+	}
+
+	return indexUsageOn(ctx, coll)
+}
+
+// ReconcileIndexes reconciles the indexes of the storage backend if it manages its own indexes, logging any drift.
+//
+// Parameters:
+//	context.Context	ctx	- Context of the reconciliation.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func ReconcileIndexes(ctx context.Context) error {
+	indexedStore, ok := store.(IndexedLogStore)
+	if !ok {
+		return nil
+	}
+
+	drift, err := indexedStore.EnsureIndexes(ctx)
+	for _, d := range drift {
+		log.Printf("indexes: %s: %s: %s (declared %s, actual %s)\n", d.Collection, d.Name, d.Problem, d.Declared, d.Actual)
+	}
+
+	return err
+}
+
+// ensureIndexesOn reconciles a collection's indexes with the declared indexes, returning every difference found.
+func ensureIndexesOn(ctx context.Context, coll *mgm.Collection) ([]IndexDrift, error) {
+	indexes := coll.Indexes()
+	existing, err := listIndexKeys(ctx, indexes)
+	if err != nil {
+		return nil, err
+	}
+
+	drift := []IndexDrift{}
+	declared := map[string]bool{}
+	for _, index := range logIndexes {
+		name := *index.Options.Name
+		declaredKeys := formatIndexKeys(index.Keys.(bson.D))
+		declared[name] = true
+
+		actualKeys, ok := existing[name]
+		if ok && actualKeys == declaredKeys {
+			continue
+		}
+		if ok {
+			drift = append(drift, IndexDrift{Collection: coll.Name(), Name: name, Problem: "keys differ, recreating", Declared: declaredKeys, Actual: actualKeys})
+			if _, err := indexes.DropOne(ctx, name); err != nil {
+				return drift, err
+			}
+		} else {
+			drift = append(drift, IndexDrift{Collection: coll.Name(), Name: name, Problem: "missing, creating", Declared: declaredKeys})
+		}
+		if _, err := indexes.CreateOne(ctx, index); err != nil {
+			return drift, err
+		}
+	}
+
+	for name, actualKeys := range existing {
+		if !declared[name] && !managedIndexNames[name] {
+			drift = append(drift, IndexDrift{Collection: coll.Name(), Name: name, Problem: "not declared", Actual: actualKeys})
+		}
+	}
+
+	return drift, nil
+}
+
+// indexUsageOn returns the $indexStats of a collection.
+func indexUsageOn(ctx context.Context, coll *mgm.Collection) ([]IndexUsage, error) {
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{bson.D{{Key: "$indexStats", Value: bson.M{}}}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	declared := map[string]bool{}
+	for _, index := range logIndexes {
+		declared[*index.Options.Name] = true
+	}
+
+	usage := []IndexUsage{}
+	for cursor.Next(ctx) {
+		stats := struct {
+			Name     string `bson:"name"`
+			Key      bson.D `bson:"key"`
+			Accesses struct {
+				Ops   int64     `bson:"ops"`
+				Since time.Time `bson:"since"`
+			} `bson:"accesses"`
+		}{}
+		if err := cursor.Decode(&stats); err != nil {
+			return nil, err
+		}
+		usage = append(usage, IndexUsage{
+			Collection: coll.Name(),
+			Name:       stats.Name,
+			Keys:       formatIndexKeys(stats.Key),
+			Declared:   declared[stats.Name] || managedIndexNames[stats.Name],
+			Ops:        stats.Accesses.Ops,
+			Since:      stats.Accesses.Since,
+		})
+	}
+
+	return usage, cursor.Err()
+}
+
+// listIndexKeys returns the formatted keys of every index on a collection by name.
+func listIndexKeys(ctx context.Context, indexes mongo.IndexView) (map[string]string, error) {
+	cursor, err := indexes.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	existing := map[string]string{}
+	for cursor.Next(ctx) {
+		index := struct {
+			Name string `bson:"name"`
+			Key  bson.D `bson:"key"`
+		}{}
+		if err := cursor.Decode(&index); err != nil {
+			return nil, err
+		}
+		existing[index.Name] = formatIndexKeys(index.Key)
+	}
+
+	return existing, cursor.Err()
+}
+
+// formatIndexKeys formats index keys so declared and listed keys can be compared regardless of numeric type.
+func formatIndexKeys(keys bson.D) string {
+	formatted := ""
+	for i, key := range keys {
+		if i > 0 {
+			formatted += ", "
+		}
+		formatted += fmt.Sprintf("%s: %v", key.Key, key.Value)
+	}
+
+	return "{" + formatted + "}"
+}
@@ -0,0 +1,66 @@
+package models
+
+/*
+ *
+ * file: 		log_index_model_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests the declared indexes of the log collection and the comparison of their keys.
+ *
+ */
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFormatIndexKeys(t *testing.T) {
+	declared := formatIndexKeys(bson.D{{Key: "log_level", Value: 1}, {Key: "created_at", Value: 1}})
+
+	tests := []struct {
+		name   string
+		keys   bson.D
+		wantEq bool
+	}{
+		{"int32 from the server", bson.D{{Key: "log_level", Value: int32(1)}, {Key: "created_at", Value: int32(1)}}, true},
+		{"double from the server", bson.D{{Key: "log_level", Value: 1.0}, {Key: "created_at", Value: 1.0}}, true},
+		{"descending", bson.D{{Key: "log_level", Value: 1}, {Key: "created_at", Value: -1}}, false},
+		{"reordered", bson.D{{Key: "created_at", Value: 1}, {Key: "log_level", Value: 1}}, false},
+		{"prefix only", bson.D{{Key: "log_level", Value: 1}}, false},
+	}
+	for _, test := range tests {
+		if got := formatIndexKeys(test.keys); (got == declared) != test.wantEq {
+			t.Errorf("%s: formatted %s, equal to the declared %s %v, want %v", test.name, got, declared, got == declared, test.wantEq)
+		}
+	}
+}
+
+func TestLogIndexesCoverSearches(t *testing.T) {
+	leading := map[string]bool{}
+	names := map[string]bool{}
+	for _, index := range logIndexes {
+		keys := index.Keys.(bson.D)
+		leading[keys[0].Key] = true
+		if names[*index.Options.Name] || managedIndexNames[*index.Options.Name] {
+			t.Errorf("index %s is declared twice or clashes with a managed index", *index.Options.Name)
+		}
+		names[*index.Options.Name] = true
+		if last := keys[len(keys)-1].Key; last != "created_at" {
+			t.Errorf("index %s ends with %s, want created_at so searches are sorted by the index", *index.Options.Name, last)
+		}
+	}
+
+	// Every field a search is made on leads an index.
+	from := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+	fields := LogSearchFields{FromDate: &from, ToDate: &to, Location: "billing", LogLevel: "INFO", Tenant: "acme"}
+	for _, filter := range fields.getFilters() {
+		for key := range filter {
+			if !leading[key] {
+				t.Errorf("no index leads with %s", key)
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package models
+
+/*
+ *
+ * file: 		log_masking_model.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the read-time masking of a log's message and extra fields.
+ *
+ */
+
+import (
+	"errors"
+	"logging_service/config"
+	"logging_service/masking"
+)
+
+// maskingPolicies mask the message and extra fields of logs when they are read, empty when masking is disabled.
+var maskingPolicies []*masking.Policy
+
+// LoadMaskingPolicies loads the policies used to mask logs on read.
+//
+// Parameters:
+//	[]config.MaskingPolicy	settings	- Policies of Masking.POLICIES, empty to disable masking.
+//	string					hashKey		- Key of the hmac hashed values are replaced with.
+//
+// Returns
+//	error - Error if a policy is not valid or two policies have the same name.
+//
+func LoadMaskingPolicies(settings []config.MaskingPolicy, hashKey string) error {
+	policies := []*masking.Policy{}
+	names := []string{}
+	for _, setting := range settings {
+		if containsString(names, setting.Name) {
+			return errors.New("masking: policy " + setting.Name + " is defined twice")
+		}
+		policy, err := masking.NewPolicy(setting.Name, setting.Pattern, setting.ExtraFields, setting.Action, setting.Replacement, hashKey)
+		if err != nil {
+			return err
+		}
+		policies = append(policies, policy)
+		names = append(names, setting.Name)
+	}
+
+	maskingPolicies = policies
+	return nil
+}
+
+// MaskLogs prepares logs for a response by applying every masking policy except those the caller may see through.
+// Logs are masked after they are decrypted, and stored logs are never changed.
+//
+// Parameters:
+//	[]Log		logs		- Logs to prepare.
+//	[]string	unmasked	- Names of the policies the caller may see through.
+//
+func MaskLogs(logs []Log, unmasked []string) {
+	for _, policy := range maskingPolicies {
+		if containsString(unmasked, policy.Name()) {
+			continue
+		}
+		for i := range logs {
+			logs[i].Message = policy.MaskMessage(logs[i].Message)
+			logs[i].Extra = policy.MaskExtra(logs[i].Extra)
+		}
+	}
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// containsString reports whether a list holds a value.
+func containsString(values []string, value string) bool {
+	for _, val := range values {
+		if val == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -16,25 +16,30 @@ import (
 	"strings"
 	"time"
 
-	"go.mongodb.org/mongo-driver/mongo"
-
 	"github.com/kamva/mgm/v3/operator"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
-
-	"github.com/kamva/mgm/v3"
 )
 
 // Log defines the contents of a log
 type Log struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty" binding:"-"`
-	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time          `bson:"updated_at,omitempty" json:"-" form:"-"`
-	LogLevel  string             `bson:"log_level" json:"log_level,omitempty" form:"log_level,omitempty" validate:"DEBUG|WARNING|INFO|ERROR|FATAL"`
-	Message   string             `bson:"message" json:"message" form:",omitempty"`
-	Extra     []string           `bson:"extra,omitempty" json:"extra,omitempty"`
-	Location  string             `bson:"location" json:"location" form:"location,omitempty"`
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty" binding:"-"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time          `bson:"updated_at,omitempty" json:"-" form:"-"`
+	LogLevel   string             `bson:"log_level" json:"log_level,omitempty" form:"log_level,omitempty" validate:"DEBUG|WARNING|INFO|ERROR|FATAL"`
+	Message    string             `bson:"message" json:"message" form:",omitempty"`
+	Extra      []string           `bson:"extra,omitempty" json:"extra,omitempty"`
+	Location   string             `bson:"location" json:"location" form:"location,omitempty"`
+	ExpiresAt  *time.Time         `bson:"expires_at,omitempty" json:"-" form:"-"`
+	RestoredAt *time.Time         `bson:"restored_at,omitempty" json:"restored_at,omitempty" form:"-" binding:"-"`
+	Sequence   int64              `bson:"sequence,omitempty" json:"sequence,omitempty" form:"-" binding:"-"`
+	PrevHash   string             `bson:"prev_hash,omitempty" json:"prev_hash,omitempty" form:"-" binding:"-"`
+	Hash       string             `bson:"hash,omitempty" json:"hash,omitempty" form:"-" binding:"-"`
+	KeyID      string             `bson:"key_id,omitempty" json:"key_id,omitempty" form:"-" binding:"-"`
+	DataKey    string             `bson:"data_key,omitempty" json:"data_key,omitempty" form:"-" binding:"-"`
+	Ciphertext string             `bson:"ciphertext,omitempty" json:"ciphertext,omitempty" form:"-" binding:"-"`
+	LegalHolds []string           `bson:"-" json:"legal_holds,omitempty" form:"-" binding:"-"`
+	Tenant     string             `bson:"tenant,omitempty" json:"tenant,omitempty" form:"-" binding:"-"`
 }
 
 // PrepareID method prepares by creating an object id from a string id.
@@ -82,7 +87,10 @@ func (l *Log) SetID(id interface{}) {
 	l.ID = id.(primitive.ObjectID)
 }
 
-// Create creates a log in the mongodb log collection.
+// Create creates a log using the configured storage backend, stamped with the tenant of the context when it is scoped
+// to one. Backends that expire logs themselves are given the log's expiry under the retention policy unless the log
+// already has one. The message and extra fields are encrypted when
+// encryption is enabled, and the log is linked into its hash chain when hash chaining is enabled.
 //
 // Receiver:
 //	*Log				l
@@ -90,12 +98,94 @@ func (l *Log) SetID(id interface{}) {
 // Returns
 //	error - Any error that occurs.
 //
-func (l *Log) Create() error {
-	err := mgm.Coll(l, &options.CollectionOptions{}).Create(l)
-	return err
+func (l *Log) Create(ctx context.Context) error {
+	if err := l.prepareCreate(ctx); err != nil {
+		return err
+	}
+	if hashChain != nil {
+		return hashChain.Append(ctx, l)
+	}
+
+	return store.Create(ctx, l)
+}
+
+// CreateLogs creates logs in bulk, preparing each the way Create does. Logs are stored in one operation when the
+// backend supports it, and one at a time otherwise or when hash chaining is enabled, since each chained log is linked
+// to the one before it.
+//
+// Parameters:
+//	[]Log	logs	- Logs to create, given ids as they are stored.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func CreateLogs(ctx context.Context, logs []Log) error {
+	for i := range logs {
+		if err := logs[i].prepareCreate(ctx); err != nil {
+			return err
+		}
+	}
+
+	bulkStore, ok := store.(BulkLogStore)
+	if ok && hashChain == nil {
+		return bulkStore.CreateMany(ctx, logs)
+	}
+	for i := range logs {
+		var err error
+		if hashChain != nil {
+			err = hashChain.Append(ctx, &logs[i])
+		} else {
+			err = store.Create(ctx, &logs[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateErrors returns the reasons a new log would be rejected by the create endpoint: a log level that is not one of
+// the log levels and the missing fields reported by IsEmptyCreate.
+//
+// Receiver:
+//	*Log		l
+//
+// Returns
+//	[]string - Reasons the log is not valid, empty if it is.
+//
+func (l *Log) CreateErrors() []string {
+	reasons := []string{}
+	if valid, all := IsValidLogLevel(l.LogLevel); !valid || all {
+		reasons = append(reasons, "invalid log level")
+	} else if l.LogLevel == "" {
+		reasons = append(reasons, "missing field: log_level")
+	}
+	if missingFields, empty := l.IsEmptyCreate(); empty {
+		reasons = append(reasons, missingFields...)
+	}
+
+	return reasons
+}
+
+// prepareCreate stamps a new log with the tenant of the context, gives it its expiry under the retention policy, when
+// the backend expires logs itself and the log is not held, and encrypts it when encryption is enabled.
+func (l *Log) prepareCreate(ctx context.Context) error {
+	if tenant, ok := TenantOf(ctx); ok {
+		l.Tenant = tenant
+	}
+	if _, ok := store.(ExpiringLogStore); ok && l.ExpiresAt == nil && len(HoldsOf(l)) == 0 {
+		l.ExpiresAt = retentionPolicy.ExpiresAt(l)
+	}
+	if keyring != nil {
+		return l.Encrypt(keyring)
+	}
+
+	return nil
 }
 
-// Find searches the log collection to find any logs that match the search criteria.
+// Find searches the log collection to find any logs that match the search criteria, within the tenant and grants of
+// the context when it is scoped to them.
 //
 // Receiver:
 //	*Log				l
@@ -104,7 +194,8 @@ func (l *Log) Create() error {
 //	LogSearchFields		fields - Search fields.
 //
 // Returns
-//	[]map[string]interface{} - List of maps containing mongodb filters.
+//	core.FindResults	- Page of logs with the total and remaining document counts.
+//	error				- Any error that occurs.
 //
 func (l *Log) Find(ctx context.Context, fields LogSearchFields) (core.FindResults, error) {
 	configs := config.GetConfig()
@@ -115,24 +206,17 @@ func (l *Log) Find(ctx context.Context, fields LogSearchFields) (core.FindResult
 		limit = suppliedLimit
 	}
 
-	findOptions := fields.getFindOptions()
-	findOptions.SetLimit(limit)
-	findOptions.SetSkip(limit * fields.Page)
-	logsColl := mgm.Coll(l)
-	logs := []Log{}
-
-	filter := GetFilter(fields)
-	err := logsColl.SimpleFind(&logs, filter, findOptions)
-
-	countOptions := options.Count()
-	totalDocuments, err := logsColl.CountDocuments(ctx, filter, countOptions)
-	countOptions.SetSkip(limit * (fields.Page + 1))
-	remainingDocumentCount, err := logsColl.CountDocuments(ctx, filter, countOptions)
+	logs, totalDocuments, err := store.Find(ctx, ScopeToCaller(ctx, fields), limit)
+	remainingDocumentCount := totalDocuments - limit*(fields.Page+1)
+	if remainingDocumentCount < 0 {
+		remainingDocumentCount = 0
+	}
 	results := core.FindResults{Data: logs, Remaining: remainingDocumentCount, Total: totalDocuments, Limit: configs.Results.Limit}
 	return results, err
 }
 
-// Count returns the count of logs based on the provided log search fields.
+// Count returns the count of logs based on the provided log search fields, within the tenant and grants of the context
+// when it is scoped to them.
 //
 // Receiver:
 //	*Log				l
@@ -145,22 +229,19 @@ func (l *Log) Find(ctx context.Context, fields LogSearchFields) (core.FindResult
 //  error
 //
 func (l *Log) Count(ctx context.Context, fields LogSearchFields) (core.CountResults, error) {
-	log := Log{}
-	logsColl := mgm.Coll(&log)
-	countOptions := options.Count()
 	_, all := IsValidLogLevel(fields.LogLevel)
 	if all {
 		fields.LogLevel = ""
 	}
 
-	filter := bson.M{operator.And: fields.getFilters()}
-	totalDocuments, err := logsColl.CountDocuments(ctx, filter, countOptions)
+	totalDocuments, err := store.Count(ctx, ScopeToCaller(ctx, fields))
 	results := core.CountResults{}
 	results.Count = totalDocuments
 	return results, err
 }
 
-// CountByDates returns the count of logs based on the provided log search fields by date (i.e. count of all logs for each day of the year if any).
+// CountByDates returns the count of logs based on the provided log search fields by date (i.e. count of all logs for each day of the year if any),
+// within the tenant and grants of the context when it is scoped to them.
 //
 // Receiver:
 //	*Log				l
@@ -173,39 +254,12 @@ func (l *Log) Count(ctx context.Context, fields LogSearchFields) (core.CountResu
 //  error
 //
 func (l *Log) CountByDates(ctx context.Context, fields LogSearchFields) ([]core.CountResultsWithDate, error) {
-	log := Log{}
-	logsColl := mgm.Coll(&log)
 	_, all := IsValidLogLevel(fields.LogLevel)
 	if all {
 		fields.LogLevel = ""
 	}
 
-	filter := GetFilter(fields)
-	matchStage := bson.D{{operator.Match, filter}}
-	groupStage := bson.D{
-		{
-			operator.Group, bson.M{
-				"_id": bson.M{
-					"date": bson.M{
-						operator.DateToString: bson.M{
-							"format": "%Y-%m-%d", "date": "$created_at",
-						},
-					},
-					"log_level": "$log_level",
-				},
-				"count": bson.M{operator.Sum: 1},
-			},
-		},
-	}
-
-	counts := []core.CountResultsWithDate{}
-	countByDatesCursor, err := logsColl.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage})
-	if err != nil {
-		return counts, err
-	}
-	countByDatesCursor.All(ctx, &counts)
-
-	return counts, nil
+	return store.CountByDates(ctx, ScopeToCaller(ctx, fields))
 }
 
 // IsEmptyCreate checks that the struct is not nil, and that the message and location are not empty.
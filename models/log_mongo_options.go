@@ -0,0 +1,65 @@
+package models
+
+/*
+ *
+ * file: 		log_mongo_options.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the write concern of each log level and the read preference of searches in the mongodb backends.
+ *
+ */
+
+import (
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// writeConcerns are the write concerns of log levels that do not use the client's write concern.
+var writeConcerns = map[string]*writeconcern.WriteConcern{}
+
+// readPreference is used by searches and counts that allow it, nil to read from the primary.
+var readPreference *readpref.ReadPref
+
+// SetWriteConcerns sets the write concern logs of each log level are created with.
+//
+// Parameters:
+//	map[string]*writeconcern.WriteConcern	concerns	- Write concern of each log level, levels without one use the
+//														  client's write concern.
+//
+func SetWriteConcerns(concerns map[string]*writeconcern.WriteConcern) {
+	writeConcerns = concerns
+}
+
+// SetReadPreference sets the read preference of searches and counts that allow it.
+//
+// Parameters:
+//	*readpref.ReadPref	rp	- Read preference, nil to read from the primary.
+//
+func SetReadPreference(rp *readpref.ReadPref) {
+	readPreference = rp
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// writeOptions returns the collection options creating a log of a log level.
+func writeOptions(logLevel string) []*options.CollectionOptions {
+	if concern, ok := writeConcerns[logLevel]; ok {
+		return []*options.CollectionOptions{options.Collection().SetWriteConcern(concern)}
+	}
+
+	return nil
+}
+
+// readOptions returns the collection options reading the logs matching the search fields.
+func readOptions(fields LogSearchFields) []*options.CollectionOptions {
+	if fields.UseReadPreference && readPreference != nil {
+		return []*options.CollectionOptions{options.Collection().SetReadPreference(readPreference)}
+	}
+
+	return nil
+}
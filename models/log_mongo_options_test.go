@@ -0,0 +1,69 @@
+package models
+
+/*
+ *
+ * file: 		log_mongo_options_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests the collection options of writes at each log level and of searches allowing the read preference.
+ *
+ */
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+func TestWriteOptions(t *testing.T) {
+	defer SetWriteConcerns(nil)
+	majority := writeconcern.New(writeconcern.WMajority())
+	SetWriteConcerns(map[string]*writeconcern.WriteConcern{"ERROR": majority})
+
+	tests := []struct {
+		name     string
+		logLevel string
+		want     *writeconcern.WriteConcern
+	}{
+		{"level with a write concern", "ERROR", majority},
+		{"level using the client's", "DEBUG", nil},
+	}
+	for _, test := range tests {
+		opts := writeOptions(test.logLevel)
+		var got *writeconcern.WriteConcern
+		if len(opts) == 1 {
+			got = opts[0].WriteConcern
+		}
+		if got != test.want || (test.want == nil && opts != nil) {
+			t.Errorf("%s: wrote with %v, want %v", test.name, opts, test.want)
+		}
+	}
+}
+
+func TestReadOptions(t *testing.T) {
+	defer SetReadPreference(nil)
+	secondary := readpref.SecondaryPreferred()
+
+	tests := []struct {
+		name       string
+		preference *readpref.ReadPref
+		fields     LogSearchFields
+		want       *readpref.ReadPref
+	}{
+		{"search allowing it", secondary, LogSearchFields{UseReadPreference: true}, secondary},
+		{"search reading the primary", secondary, LogSearchFields{}, nil},
+		{"no read preference", nil, LogSearchFields{UseReadPreference: true}, nil},
+	}
+	for _, test := range tests {
+		SetReadPreference(test.preference)
+		opts := readOptions(test.fields)
+		var got *readpref.ReadPref
+		if len(opts) == 1 {
+			got = opts[0].ReadPreference
+		}
+		if got != test.want || (test.want == nil && opts != nil) {
+			t.Errorf("%s: read with %v, want %v", test.name, opts, test.want)
+		}
+	}
+}
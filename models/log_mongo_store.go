@@ -0,0 +1,415 @@
+package models
+
+/*
+ *
+ * file: 		log_mongo_store.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the mongodb storage backend for logs.
+ *
+ */
+
+import (
+	"context"
+	"errors"
+	"logging_service/core"
+
+	"github.com/kamva/mgm/v3"
+	"github.com/kamva/mgm/v3/operator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoStore stores logs in the mongodb logs collection configured through mgm.
+type mongoStore struct{}
+
+// Create creates a log in the mongodb log collection.
+//
+// Receiver:
+//	*mongoStore		ms
+//
+// Parameters:
+//	*Log			l	- Log to create.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (ms *mongoStore) Create(ctx context.Context, l *Log) error {
+	coll, err := logsCollection(writeOptions(l.LogLevel)...)
+	if err != nil {
+		return err
+	}
+
+	return createIn(ctx, coll, l)
+}
+
+// CreateMany creates logs in the mongodb log collection with one insert for each log level, so each level's write
+// concern is used.
+//
+// Receiver:
+//	*mongoStore		ms
+//
+// Parameters:
+//	[]Log	logs	- Logs to create.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (ms *mongoStore) CreateMany(ctx context.Context, logs []Log) error {
+	byLevel, levels := groupLogs(logs, func(l *Log) string { return l.LogLevel })
+	for _, logLevel := range levels {
+		coll, err := logsCollection(writeOptions(logLevel)...)
+		if err != nil {
+			return err
+		}
+		if err := createManyIn(ctx, coll, byLevel[logLevel]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Find searches the log collection to find any logs that match the search criteria.
+//
+// Receiver:
+//	*mongoStore		ms
+//
+// Parameters:
+//	LogSearchFields		fields	- Search fields.
+//	int64				limit	- Maximum number of logs to return.
+//
+// Returns
+//	[]Log	- Page of logs.
+//	int64	- Total number of logs matching the search fields.
+//	error	- Any error that occurs.
+//
+func (ms *mongoStore) Find(ctx context.Context, fields LogSearchFields, limit int64) ([]Log, int64, error) {
+	findOptions := fields.getFindOptions()
+	findOptions.SetLimit(limit)
+	findOptions.SetSkip(limit * fields.Page)
+	logsColl, err := logsCollection(readOptions(fields)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	logs, err := findIn(ctx, logsColl, fields, findOptions)
+	if err != nil {
+		return logs, 0, err
+	}
+
+	totalDocuments, err := countIn(ctx, logsColl, fields)
+	return logs, totalDocuments, err
+}
+
+// Count returns the count of logs based on the provided log search fields.
+//
+// Receiver:
+//	*mongoStore		ms
+//
+// Parameters:
+//	LogSearchFields		fields - Search fields.
+//
+// Returns
+//	int64	- Number of matching logs.
+//	error	- Any error that occurs.
+//
+func (ms *mongoStore) Count(ctx context.Context, fields LogSearchFields) (int64, error) {
+	coll, err := logsCollection(readOptions(fields)...)
+	if err != nil {
+		return 0, err
+	}
+
+	return countIn(ctx, coll, fields)
+}
+
+// CountByDates returns the count of logs based on the provided log search fields grouped by date and log level.
+//
+// Receiver:
+//	*mongoStore		ms
+//
+// Parameters:
+//	LogSearchFields		fields - Search fields.
+//
+// Returns
+//	[]core.CountResultsWithDate	- Counts for each day and log level.
+//	error						- Any error that occurs.
+//
+func (ms *mongoStore) CountByDates(ctx context.Context, fields LogSearchFields) ([]core.CountResultsWithDate, error) {
+	coll, err := logsCollection(readOptions(fields)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return countByDatesIn(ctx, coll, fields)
+}
+
+// Delete removes the logs matching the search fields from the log collection.
+//
+// Receiver:
+//	*mongoStore		ms
+//
+// Parameters:
+//	LogSearchFields		fields - Search fields.
+//
+// Returns
+//	int64	- Number of logs removed.
+//	error	- Any error that occurs.
+//
+func (ms *mongoStore) Delete(ctx context.Context, fields LogSearchFields) (int64, error) {
+	coll, err := logsCollection()
+	if err != nil {
+		return 0, err
+	}
+
+	return deleteIn(ctx, coll, fields)
+}
+
+// Iterate calls fn for every log matching the search fields in ascending id order using a cursor.
+//
+// Receiver:
+//	*mongoStore		ms
+//
+// Parameters:
+//	LogSearchFields		fields	- Search fields.
+//	func(l *Log) error	fn		- Called for each log.
+//
+// Returns
+//	error - Any error that occurs, including errors returned by fn.
+//
+func (ms *mongoStore) Iterate(ctx context.Context, fields LogSearchFields, fn func(l *Log) error) error {
+	coll, err := logsCollection()
+	if err != nil {
+		return err
+	}
+	cursor, err := iterateIn(ctx, coll, fields)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		l := Log{}
+		if err := cursor.Decode(&l); err != nil {
+			return err
+		}
+		if err := fn(&l); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// EnsureExpiryIndex creates a TTL index on expires_at so mongodb removes logs once they expire.
+//
+// Receiver:
+//	*mongoStore		ms
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (ms *mongoStore) EnsureExpiryIndex(ctx context.Context) error {
+	coll, err := logsCollection()
+	if err != nil {
+		return err
+	}
+
+	return ensureExpiryIndexOn(ctx, coll)
+}
+
+// ClearExpiry removes the expiry of the logs matching the search fields so mongodb keeps them.
+//
+// Receiver:
+//	*mongoStore		ms
+//
+// Parameters:
+//	LogSearchFields	fields	- Search fields.
+//
+// Returns
+//	int64	- Number of logs changed.
+//	error	- Any error that occurs.
+//
+func (ms *mongoStore) ClearExpiry(ctx context.Context, fields LogSearchFields) (int64, error) {
+	coll, err := logsCollection()
+	if err != nil {
+		return 0, err
+	}
+
+	return clearExpiryIn(ctx, coll, fields)
+}
+
+// UpdateDataKeys stores the key id and wrapped data key of each log.
+//
+// Receiver:
+//	*mongoStore		ms
+//
+// Parameters:
+//	[]Log	logs	- Logs with rewrapped data keys.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (ms *mongoStore) UpdateDataKeys(ctx context.Context, logs []Log) error {
+	coll, err := logsCollection()
+	if err != nil {
+		return err
+	}
+	for i := range logs {
+		if _, err := updateDataKeyIn(ctx, coll, &logs[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ *
+ * Helpers shared by the mongodb backends.
+ *
+ */
+
+// logsCollection returns the log collection of the default mongodb database.
+func logsCollection(opts ...*options.CollectionOptions) (*mgm.Collection, error) {
+	_, _, db, err := mgm.DefaultConfigs()
+	if err != nil {
+		return nil, errors.New("mongodb is not connected")
+	}
+
+	return mgm.NewCollection(db, mgm.CollName(&Log{}), opts...), nil
+}
+
+// createIn creates a log in a collection. The log's id is assigned before inserting, because unacknowledged writes do
+// not return it.
+func createIn(ctx context.Context, coll *mgm.Collection, l *Log) error {
+	if l.ID.IsZero() {
+		l.ID = primitive.NewObjectID()
+	}
+	if err := coll.CreateWithCtx(ctx, l); err != nil && err != mongo.ErrUnacknowledgedWrite {
+		return err
+	}
+
+	return nil
+}
+
+// createManyIn inserts logs into a collection with one unordered insert, assigning any missing ids first.
+func createManyIn(ctx context.Context, coll *mgm.Collection, logs []*Log) error {
+	documents := make([]interface{}, len(logs))
+	for i, l := range logs {
+		if l.ID.IsZero() {
+			l.ID = primitive.NewObjectID()
+		}
+		documents[i] = l
+	}
+	if _, err := coll.InsertMany(ctx, documents, options.InsertMany().SetOrdered(false)); err != nil && err != mongo.ErrUnacknowledgedWrite {
+		return err
+	}
+
+	return nil
+}
+
+// groupLogs groups logs by a key, returning the keys in the order they first appear.
+func groupLogs(logs []Log, key func(l *Log) string) (map[string][]*Log, []string) {
+	groups := map[string][]*Log{}
+	keys := []string{}
+	for i := range logs {
+		k := key(&logs[i])
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], &logs[i])
+	}
+
+	return groups, keys
+}
+
+// findIn finds the logs matching the search fields in a collection.
+func findIn(ctx context.Context, coll *mgm.Collection, fields LogSearchFields, findOptions *options.FindOptions) ([]Log, error) {
+	logs := []Log{}
+	err := coll.SimpleFindWithCtx(ctx, &logs, GetFilter(fields), findOptions)
+	return logs, err
+}
+
+// countIn counts the logs matching the search fields in a collection.
+func countIn(ctx context.Context, coll *mgm.Collection, fields LogSearchFields) (int64, error) {
+	filter := bson.M{operator.And: fields.getFilters()}
+	return coll.CountDocuments(ctx, filter, options.Count())
+}
+
+// countByDatesIn counts the logs matching the search fields in a collection by day and log level.
+func countByDatesIn(ctx context.Context, coll *mgm.Collection, fields LogSearchFields) ([]core.CountResultsWithDate, error) {
+	filter := GetFilter(fields)
+	matchStage := bson.D{{Key: operator.Match, Value: filter}}
+	groupStage := bson.D{
+		{
+			Key: operator.Group, Value: bson.M{
+				"_id": bson.M{
+					"date": bson.M{
+						operator.DateToString: bson.M{
+							"format": "%Y-%m-%d", "date": "$created_at",
+						},
+					},
+					"log_level": "$log_level",
+				},
+				"count": bson.M{operator.Sum: 1},
+			},
+		},
+	}
+
+	counts := []core.CountResultsWithDate{}
+	countByDatesCursor, err := coll.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage})
+	if err != nil {
+		return counts, err
+	}
+	err = countByDatesCursor.All(ctx, &counts)
+
+	return counts, err
+}
+
+// deleteIn removes the logs matching the search fields from a collection.
+func deleteIn(ctx context.Context, coll *mgm.Collection, fields LogSearchFields) (int64, error) {
+	result, err := coll.DeleteMany(ctx, GetFilter(fields))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
+
+// iterateIn opens a cursor over the logs matching the search fields in a collection in ascending id order.
+func iterateIn(ctx context.Context, coll *mgm.Collection, fields LogSearchFields) (*mongo.Cursor, error) {
+	return coll.Find(ctx, GetFilter(fields), options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+}
+
+// ensureExpiryIndexOn creates the expires_at TTL index on a collection.
+func ensureExpiryIndexOn(ctx context.Context, coll *mgm.Collection) error {
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// clearExpiryIn removes the expiry of the logs matching the search fields in a collection.
+func clearExpiryIn(ctx context.Context, coll *mgm.Collection, fields LogSearchFields) (int64, error) {
+	filter := bson.M{operator.And: append(fields.getFilters(), map[string]interface{}{"expires_at": bson.M{operator.Exists: true}})}
+	result, err := coll.UpdateMany(ctx, filter, bson.M{operator.Unset: bson.M{"expires_at": ""}})
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// updateDataKeyIn stores the key id and wrapped data key of a log in a collection, returning whether the log was found.
+func updateDataKeyIn(ctx context.Context, coll *mgm.Collection, l *Log) (bool, error) {
+	result, err := coll.UpdateOne(ctx, bson.M{"_id": l.ID}, bson.M{operator.Set: bson.M{"key_id": l.KeyID, "data_key": l.DataKey}})
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+}
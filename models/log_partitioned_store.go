@@ -0,0 +1,756 @@
+package models
+
+/*
+ *
+ * file: 		log_partitioned_store.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the mongodb storage backend that writes logs to monthly or weekly collections.
+ *
+ */
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"logging_service/core"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PartitionedLogStore is implemented by backends that split logs into partitions by creation time.
+type PartitionedLogStore interface {
+	LogStore
+
+	// DropPartitionsBefore removes every partition that only holds logs created before the time and returns their
+	// names. Partitions holding logs restored from an archive or under a legal hold are kept.
+	DropPartitionsBefore(ctx context.Context, before time.Time) ([]string, error)
+}
+
+// Partition periods supported by the partitioned store.
+const (
+	MonthlyPartitions = "monthly"
+	WeeklyPartitions  = "weekly"
+)
+
+var monthlyPartitionName = regexp.MustCompile(`^_(\d{4})_(\d{2})$`)
+var weeklyPartitionName = regexp.MustCompile(`^_(\d{4})_w(\d{2})$`)
+
+// partition is one collection of the partitioned store. The unpartitioned logs collection has no bounds.
+type partition struct {
+	name    string
+	start   time.Time
+	end     time.Time
+	bounded bool
+}
+
+// partitionPage is the part of a page of logs read from one partition.
+type partitionPage struct {
+	index int
+	skip  int64
+	limit int64
+}
+
+// partitionedMongoStore stores logs in one collection per month or week, named after the logs collection with the
+// year and month (logs_2021_03) or ISO year and week (logs_2021_w09) appended. Queries fan out to the partitions
+// overlapping their date range, including the unpartitioned logs collection so logs written before partitioning was
+// enabled are still found.
+type partitionedMongoStore struct {
+	period   string
+	mutex    sync.Mutex
+	prepared map[string]bool
+	expiry   bool
+}
+
+// NewPartitionedMongoStore creates a mongodb backend partitioned by the given period. mgm must already be configured.
+//
+// Parameters:
+//	string	period	- 'monthly' or 'weekly'.
+//
+// Returns
+//	LogStore	- Partitioned store.
+//	error		- Error if the period is unknown.
+//
+func NewPartitionedMongoStore(period string) (LogStore, error) {
+	if period != MonthlyPartitions && period != WeeklyPartitions {
+		return nil, errors.New("unknown partition period: " + period)
+	}
+
+	return &partitionedMongoStore{period: period, prepared: map[string]bool{}}, nil
+}
+
+// Create creates a log in the partition for its creation time, creating the partition's indexes the first time it is
+// written to.
+//
+// Receiver:
+//	*partitionedMongoStore		pms
+//
+// Parameters:
+//	*Log	l	- Log to create.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (pms *partitionedMongoStore) Create(ctx context.Context, l *Log) error {
+	coll, err := pms.collection(partitionName(pms.period, l.CreatedAt), writeOptions(l.LogLevel)...)
+	if err != nil {
+		return err
+	}
+	if err := pms.prepare(ctx, coll); err != nil {
+		return err
+	}
+
+	return createIn(ctx, coll, l)
+}
+
+// CreateMany creates logs in the partitions for their creation times with one insert for each partition and log
+// level.
+//
+// Receiver:
+//	*partitionedMongoStore		pms
+//
+// Parameters:
+//	[]Log	logs	- Logs to create.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (pms *partitionedMongoStore) CreateMany(ctx context.Context, logs []Log) error {
+	groups, keys := groupLogs(logs, func(l *Log) string {
+		return partitionName(pms.period, l.CreatedAt) + "\x00" + l.LogLevel
+	})
+	for _, key := range keys {
+		group := groups[key]
+		coll, err := pms.collection(partitionName(pms.period, group[0].CreatedAt), writeOptions(group[0].LogLevel)...)
+		if err != nil {
+			return err
+		}
+		if err := pms.prepare(ctx, coll); err != nil {
+			return err
+		}
+		if err := createManyIn(ctx, coll, group); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Find finds one page of logs across the partitions overlapping the search fields. Logs ordered by creation date are
+// read from one partition after another; any other order reads enough logs from each partition to merge the page.
+//
+// Receiver:
+//	*partitionedMongoStore		pms
+//
+// Parameters:
+//	LogSearchFields		fields	- Search fields.
+//	int64				limit	- Maximum number of logs to return.
+//
+// Returns
+//	[]Log	- Page of logs.
+//	int64	- Total number of logs matching the search fields.
+//	error	- Any error that occurs.
+//
+func (pms *partitionedMongoStore) Find(ctx context.Context, fields LogSearchFields, limit int64) ([]Log, int64, error) {
+	partitions, err := pms.partitions(ctx, fields)
+	if err != nil {
+		return []Log{}, 0, err
+	}
+
+	counts := make([]int64, len(partitions))
+	total := int64(0)
+	sequential := fields.OrderBy == "" || fields.OrderBy == "created_at"
+	for i, p := range partitions {
+		coll, err := pms.collection(p.name, readOptions(fields)...)
+		if err != nil {
+			return []Log{}, 0, err
+		}
+		if counts[i], err = countIn(ctx, coll, fields); err != nil {
+			return []Log{}, 0, err
+		}
+		total += counts[i]
+		if !p.bounded && counts[i] > 0 {
+			sequential = false
+		}
+	}
+
+	skip := limit * fields.Page
+	if sequential {
+		logs, err := pms.findSequential(ctx, fields, partitions, counts, skip, limit)
+		return logs, total, err
+	}
+
+	logs := []Log{}
+	for i, p := range partitions {
+		if counts[i] == 0 {
+			continue
+		}
+		coll, err := pms.collection(p.name, readOptions(fields)...)
+		if err != nil {
+			return []Log{}, 0, err
+		}
+		found, err := findIn(ctx, coll, fields, partitionFindOptions(fields.OrderBy).SetLimit(skip+limit))
+		if err != nil {
+			return []Log{}, 0, err
+		}
+		logs = append(logs, found...)
+	}
+	SortLogs(logs, fields.OrderBy)
+
+	if skip >= int64(len(logs)) {
+		return []Log{}, total, nil
+	}
+	end := skip + limit
+	if end > int64(len(logs)) {
+		end = int64(len(logs))
+	}
+
+	return logs[skip:end], total, nil
+}
+
+// Count counts the logs matching the search fields across the overlapping partitions.
+//
+// Receiver:
+//	*partitionedMongoStore		pms
+//
+// Parameters:
+//	LogSearchFields		fields - Search fields.
+//
+// Returns
+//	int64	- Number of matching logs.
+//	error	- Any error that occurs.
+//
+func (pms *partitionedMongoStore) Count(ctx context.Context, fields LogSearchFields) (int64, error) {
+	total := int64(0)
+	err := pms.each(ctx, fields, func(coll *mgm.Collection) error {
+		count, err := countIn(ctx, coll, fields)
+		total += count
+		return err
+	})
+
+	return total, err
+}
+
+// CountByDates counts the logs matching the search fields by day and log level across the overlapping partitions.
+//
+// Receiver:
+//	*partitionedMongoStore		pms
+//
+// Parameters:
+//	LogSearchFields		fields - Search fields.
+//
+// Returns
+//	[]core.CountResultsWithDate	- Counts for each day and log level.
+//	error						- Any error that occurs.
+//
+func (pms *partitionedMongoStore) CountByDates(ctx context.Context, fields LogSearchFields) ([]core.CountResultsWithDate, error) {
+	counter := NewDateCounter()
+	err := pms.each(ctx, fields, func(coll *mgm.Collection) error {
+		counts, err := countByDatesIn(ctx, coll, fields)
+		for _, count := range counts {
+			counter.Add(count.ID.Date, count.ID.LogLevel, count.Count)
+		}
+		return err
+	})
+	if err != nil {
+		return []core.CountResultsWithDate{}, err
+	}
+
+	return counter.Results(), nil
+}
+
+// Delete removes the logs matching the search fields from the overlapping partitions.
+//
+// Receiver:
+//	*partitionedMongoStore		pms
+//
+// Parameters:
+//	LogSearchFields		fields - Search fields.
+//
+// Returns
+//	int64	- Number of logs removed.
+//	error	- Any error that occurs.
+//
+func (pms *partitionedMongoStore) Delete(ctx context.Context, fields LogSearchFields) (int64, error) {
+	deleted := int64(0)
+	err := pms.each(ctx, fields, func(coll *mgm.Collection) error {
+		count, err := deleteIn(ctx, coll, fields)
+		deleted += count
+		return err
+	})
+
+	return deleted, err
+}
+
+// Iterate calls fn for every log matching the search fields in ascending id order by merging a cursor on each
+// overlapping partition.
+//
+// Receiver:
+//	*partitionedMongoStore		pms
+//
+// Parameters:
+//	LogSearchFields		fields	- Search fields.
+//	func(l *Log) error	fn		- Called for each log.
+//
+// Returns
+//	error - Any error that occurs, including errors returned by fn.
+//
+func (pms *partitionedMongoStore) Iterate(ctx context.Context, fields LogSearchFields, fn func(l *Log) error) error {
+	cursors := []*mongo.Cursor{}
+	heads := []*Log{}
+	defer func() {
+		for _, cursor := range cursors {
+			cursor.Close(ctx)
+		}
+	}()
+
+	err := pms.each(ctx, fields, func(coll *mgm.Collection) error {
+		cursor, err := iterateIn(ctx, coll, fields)
+		if err != nil {
+			return err
+		}
+		cursors = append(cursors, cursor)
+		heads = append(heads, nil)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, cursor := range cursors {
+		if heads[i], err = nextLog(ctx, cursor); err != nil {
+			return err
+		}
+	}
+
+	for {
+		next := -1
+		for i, head := range heads {
+			if head != nil && (next == -1 || bytes.Compare(head.ID[:], heads[next].ID[:]) < 0) {
+				next = i
+			}
+		}
+		if next == -1 {
+			return nil
+		}
+
+		if err := fn(heads[next]); err != nil {
+			return err
+		}
+		if heads[next], err = nextLog(ctx, cursors[next]); err != nil {
+			return err
+		}
+	}
+}
+
+// EnsureExpiryIndex creates the expires_at TTL index on every partition, and on partitions created later.
+//
+// Receiver:
+//	*partitionedMongoStore		pms
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (pms *partitionedMongoStore) EnsureExpiryIndex(ctx context.Context) error {
+	pms.mutex.Lock()
+	pms.expiry = true
+	pms.mutex.Unlock()
+
+	return pms.each(ctx, LogSearchFields{}, func(coll *mgm.Collection) error {
+		return ensureExpiryIndexOn(ctx, coll)
+	})
+}
+
+// ClearExpiry removes the expiry of the logs matching the search fields in every partition overlapping their date range.
+//
+// Receiver:
+//	*partitionedMongoStore		pms
+//
+// Parameters:
+//	LogSearchFields	fields	- Search fields.
+//
+// Returns
+//	int64	- Number of logs changed.
+//	error	- Any error that occurs.
+//
+func (pms *partitionedMongoStore) ClearExpiry(ctx context.Context, fields LogSearchFields) (int64, error) {
+	cleared := int64(0)
+	err := pms.each(ctx, fields, func(coll *mgm.Collection) error {
+		count, err := clearExpiryIn(ctx, coll, fields)
+		cleared += count
+		return err
+	})
+
+	return cleared, err
+}
+
+// EnsureIndexes reconciles the indexes of every partition with the declared indexes.
+//
+// Receiver:
+//	*partitionedMongoStore		pms
+//
+// Returns
+//	[]IndexDrift	- Every difference found before reconciling.
+//	error			- Any error that occurs.
+//
+func (pms *partitionedMongoStore) EnsureIndexes(ctx context.Context) ([]IndexDrift, error) {
+	drift := []IndexDrift{}
+	err := pms.each(ctx, LogSearchFields{}, func(coll *mgm.Collection) error {
+		collDrift, err := ensureIndexesOn(ctx, coll)
+		drift = append(drift, collDrift...)
+		return err
+	})
+
+	return drift, err
+}
+
+// IndexUsage returns the $indexStats of every partition.
+//
+// Receiver:
+//	*partitionedMongoStore		pms
+//
+// Returns
+//	[]IndexUsage	- Usage of each index of each partition.
+//	error			- Any error that occurs.
+//
+func (pms *partitionedMongoStore) IndexUsage(ctx context.Context) ([]IndexUsage, error) {
+	usage := []IndexUsage{}
+	err := pms.each(ctx, LogSearchFields{}, func(coll *mgm.Collection) error {
+		collUsage, err := indexUsageOn(ctx, coll)
+		usage = append(usage, collUsage...)
+		return err
+	})
+
+	return usage, err
+}
+
+// UpdateDataKeys stores the key id and wrapped data key of each log in the partition for its creation time, falling
+// back to the unpartitioned logs collection.
+//
+// Receiver:
+//	*partitionedMongoStore		pms
+//
+// Parameters:
+//	[]Log	logs	- Logs with rewrapped data keys.
+//
+// Returns
+//	error - Any error that occurs.
+//
+func (pms *partitionedMongoStore) UpdateDataKeys(ctx context.Context, logs []Log) error {
+	for i := range logs {
+		coll, err := pms.collection(partitionName(pms.period, logs[i].CreatedAt))
+		if err != nil {
+			return err
+		}
+		found, err := updateDataKeyIn(ctx, coll, &logs[i])
+		if err != nil {
+			return err
+		}
+		if found {
+			continue
+		}
+
+		coll, err = pms.collection(mgm.CollName(&Log{}))
+		if err != nil {
+			return err
+		}
+		if _, err := updateDataKeyIn(ctx, coll, &logs[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DropPartitionsBefore drops every partition ending at or before the time unless it holds restored logs or logs under
+// a legal hold. The unpartitioned logs collection is never dropped.
+//
+// Receiver:
+//	*partitionedMongoStore		pms
+//
+// Parameters:
+//	time.Time	before	- Partitions ending at or before this time are dropped.
+//
+// Returns
+//	[]string	- Names of the dropped partitions.
+//	error		- Any error that occurs.
+//
+func (pms *partitionedMongoStore) DropPartitionsBefore(ctx context.Context, before time.Time) ([]string, error) {
+	partitions, err := pms.partitions(ctx, LogSearchFields{})
+	if err != nil {
+		return nil, err
+	}
+
+	dropped := []string{}
+	for _, p := range partitions {
+		if !p.bounded || p.end.After(before) {
+			continue
+		}
+		coll, err := pms.collection(p.name)
+		if err != nil {
+			return dropped, err
+		}
+		restored, err := coll.CountDocuments(ctx, bson.M{"restored_at": bson.M{"$exists": true}}, options.Count().SetLimit(1))
+		if err != nil {
+			return dropped, err
+		}
+		if restored > 0 {
+			continue
+		}
+		held, err := heldIn(ctx, coll)
+		if err != nil {
+			return dropped, err
+		}
+		if held {
+			continue
+		}
+		if err := coll.Drop(ctx); err != nil {
+			return dropped, err
+		}
+
+		pms.mutex.Lock()
+		delete(pms.prepared, p.name)
+		pms.mutex.Unlock()
+		dropped = append(dropped, p.name)
+	}
+
+	return dropped, nil
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// findSequential reads a page of logs ordered by creation date by skipping whole partitions using their counts.
+func (pms *partitionedMongoStore) findSequential(ctx context.Context, fields LogSearchFields, partitions []partition, counts []int64, skip int64, limit int64) ([]Log, error) {
+	logs := []Log{}
+	for _, page := range planPage(counts, fields.OrderBy == "created_at", skip, limit) {
+		coll, err := pms.collection(partitions[page.index].name, readOptions(fields)...)
+		if err != nil {
+			return logs, err
+		}
+		findOptions := partitionFindOptions(fields.OrderBy).SetSkip(page.skip).SetLimit(page.limit)
+		found, err := findIn(ctx, coll, fields, findOptions)
+		if err != nil {
+			return logs, err
+		}
+		logs = append(logs, found...)
+	}
+
+	return logs, nil
+}
+
+// planPage splits a page of logs between partitions holding the given counts of matching logs, read oldest partition
+// first or newest partition first. Partitions wholly before the page are skipped and those after it are not read.
+func planPage(counts []int64, newestFirst bool, skip int64, limit int64) []partitionPage {
+	pages := []partitionPage{}
+	for n := range counts {
+		i := n
+		if newestFirst {
+			i = len(counts) - 1 - n
+		}
+		if limit <= 0 {
+			break
+		}
+		if skip >= counts[i] {
+			skip -= counts[i]
+			continue
+		}
+
+		page := partitionPage{index: i, skip: skip, limit: counts[i] - skip}
+		if page.limit > limit {
+			page.limit = limit
+		}
+		pages = append(pages, page)
+		limit -= page.limit
+		skip = 0
+	}
+
+	return pages
+}
+
+// each calls fn with the collection of every partition overlapping the search fields, read with the read preference
+// the search fields allow.
+func (pms *partitionedMongoStore) each(ctx context.Context, fields LogSearchFields, fn func(coll *mgm.Collection) error) error {
+	partitions, err := pms.partitions(ctx, fields)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range partitions {
+		coll, err := pms.collection(p.name, readOptions(fields)...)
+		if err != nil {
+			return err
+		}
+		if err := fn(coll); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// heldIn reports whether a collection holds any log under an active legal hold.
+func heldIn(ctx context.Context, coll *mgm.Collection) (bool, error) {
+	for _, hold := range activeLegalHolds() {
+		count, err := countIn(ctx, coll, hold.SearchFields())
+		if err != nil || count > 0 {
+			return count > 0, err
+		}
+	}
+
+	return false, nil
+}
+
+// partitions lists the partitions overlapping the date range of the search fields in ascending order. The
+// unpartitioned logs collection comes first.
+func (pms *partitionedMongoStore) partitions(ctx context.Context, fields LogSearchFields) ([]partition, error) {
+	_, _, db, err := mgm.DefaultConfigs()
+	if err != nil {
+		return nil, err
+	}
+
+	base := mgm.CollName(&Log{})
+	names, err := db.ListCollectionNames(ctx, bson.M{"name": bson.M{"$regex": "^" + regexp.QuoteMeta(base)}})
+	if err != nil {
+		return nil, err
+	}
+
+	from, to, hasRange := fields.TimeRange()
+	partitions := []partition{}
+	for _, name := range names {
+		p, ok := parsePartition(base, name)
+		if !ok {
+			continue
+		}
+		if hasRange && p.bounded && (p.start.After(to) || !p.end.After(from)) {
+			continue
+		}
+		partitions = append(partitions, p)
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		if partitions[i].bounded != partitions[j].bounded {
+			return !partitions[i].bounded
+		}
+		return partitions[i].start.Before(partitions[j].start)
+	})
+
+	return partitions, nil
+}
+
+// prepare creates the indexes of a partition the first time it is written to.
+func (pms *partitionedMongoStore) prepare(ctx context.Context, coll *mgm.Collection) error {
+	pms.mutex.Lock()
+	defer pms.mutex.Unlock()
+	if pms.prepared[coll.Name()] {
+		return nil
+	}
+
+	if _, err := ensureIndexesOn(ctx, coll); err != nil {
+		return err
+	}
+	if pms.expiry {
+		if err := ensureExpiryIndexOn(ctx, coll); err != nil {
+			return err
+		}
+	}
+	pms.prepared[coll.Name()] = true
+
+	return nil
+}
+
+// collection returns the mgm collection with the given name.
+func (pms *partitionedMongoStore) collection(name string, opts ...*options.CollectionOptions) (*mgm.Collection, error) {
+	_, _, db, err := mgm.DefaultConfigs()
+	if err != nil {
+		return nil, err
+	}
+
+	return mgm.NewCollection(db, name, opts...), nil
+}
+
+// partitionName returns the name of the partition logs created at the time are written to.
+func partitionName(period string, createdAt time.Time) string {
+	base := mgm.CollName(&Log{})
+	createdAt = createdAt.UTC()
+	if period == WeeklyPartitions {
+		year, week := createdAt.ISOWeek()
+		return fmt.Sprintf("%s_%04d_w%02d", base, year, week)
+	}
+
+	return fmt.Sprintf("%s_%04d_%02d", base, createdAt.Year(), int(createdAt.Month()))
+}
+
+// parsePartition parses a collection name into a partition. Monthly and weekly names are both recognised so
+// partitions written before the period was changed are still read.
+func parsePartition(base string, name string) (partition, bool) {
+	if name == base {
+		return partition{name: name}, true
+	}
+	if len(name) <= len(base) || name[:len(base)] != base {
+		return partition{}, false
+	}
+	suffix := name[len(base):]
+
+	if match := monthlyPartitionName.FindStringSubmatch(suffix); match != nil {
+		year, _ := strconv.Atoi(match[1])
+		month, _ := strconv.Atoi(match[2])
+		if month < 1 || month > 12 {
+			return partition{}, false
+		}
+		start := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+		return partition{name: name, start: start, end: start.AddDate(0, 1, 0), bounded: true}, true
+	}
+
+	if match := weeklyPartitionName.FindStringSubmatch(suffix); match != nil {
+		year, _ := strconv.Atoi(match[1])
+		week, _ := strconv.Atoi(match[2])
+		if week < 1 || week > 53 {
+			return partition{}, false
+		}
+		// The 4th of January is always in the first ISO week.
+		january4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+		firstMonday := january4.AddDate(0, 0, -((int(january4.Weekday()) + 6) % 7))
+		start := firstMonday.AddDate(0, 0, (week-1)*7)
+		return partition{name: name, start: start, end: start.AddDate(0, 0, 7), bounded: true}, true
+	}
+
+	return partition{}, false
+}
+
+// partitionFindOptions sorts a partition's logs the same way SortLogs sorts the merged logs.
+func partitionFindOptions(orderBy string) *options.FindOptions {
+	switch orderBy {
+	case "":
+		return options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	case "id":
+		return options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	default:
+		return options.Find().SetSort(bson.D{{Key: orderBy, Value: -1}})
+	}
+}
+
+// nextLog decodes the next log of a cursor, returning nil once the cursor is exhausted.
+func nextLog(ctx context.Context, cursor *mongo.Cursor) (*Log, error) {
+	if !cursor.Next(ctx) {
+		return nil, cursor.Err()
+	}
+
+	l := Log{}
+	if err := cursor.Decode(&l); err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
@@ -0,0 +1,76 @@
+package models
+
+/*
+ *
+ * file: 		log_partitioned_store_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests naming partitions and splitting pages of logs between them.
+ *
+ */
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPartitionNames(t *testing.T) {
+	tests := []struct {
+		name      string
+		period    string
+		createdAt time.Time
+		want      string
+	}{
+		{"month", MonthlyPartitions, time.Date(2021, 3, 31, 23, 59, 0, 0, time.UTC), "logs_2021_03"},
+		{"month in another zone", MonthlyPartitions, time.Date(2021, 4, 1, 1, 0, 0, 0, time.FixedZone("CET", 2*60*60)), "logs_2021_03"},
+		{"week", WeeklyPartitions, time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC), "logs_2021_w09"},
+		{"week of the previous iso year", WeeklyPartitions, time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC), "logs_2020_w53"},
+		{"week of the next iso year", WeeklyPartitions, time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC), "logs_2025_w01"},
+	}
+	for _, test := range tests {
+		name := partitionName(test.period, test.createdAt)
+		if name != test.want {
+			t.Errorf("%s: named %s, want %s", test.name, name, test.want)
+			continue
+		}
+		p, ok := parsePartition("logs", name)
+		if !ok || !p.bounded || test.createdAt.Before(p.start) || !test.createdAt.Before(p.end) {
+			t.Errorf("%s: parsed %s as %+v, want bounds holding %v", test.name, name, p, test.createdAt)
+		}
+	}
+
+	for _, name := range []string{"logs_2021_13", "logs_2021_w54", "logs_2021", "logs_archive", "audit_2021_03"} {
+		if p, ok := parsePartition("logs", name); ok {
+			t.Errorf("parsed %s as %+v, want no partition", name, p)
+		}
+	}
+	if p, ok := parsePartition("logs", "logs"); !ok || p.bounded {
+		t.Errorf("parsed logs as %+v, want the unbounded collection", p)
+	}
+}
+
+func TestPlanPage(t *testing.T) {
+	tests := []struct {
+		name        string
+		counts      []int64
+		newestFirst bool
+		skip        int64
+		limit       int64
+		want        string
+	}{
+		{"first page", []int64{0, 3, 5}, false, 0, 4, "[{1 0 3} {2 0 1}]"},
+		{"page within one partition", []int64{0, 3, 5}, false, 4, 2, "[{2 1 2}]"},
+		{"page across partitions", []int64{2, 3, 5}, false, 4, 4, "[{1 2 1} {2 0 3}]"},
+		{"last page", []int64{2, 3, 5}, false, 8, 4, "[{2 3 2}]"},
+		{"past the end", []int64{2, 3, 5}, false, 10, 4, "[]"},
+		{"newest first", []int64{2, 3, 5}, true, 4, 4, "[{2 4 1} {1 0 3}]"},
+		{"newest first reaching the oldest", []int64{2, 3, 5}, true, 8, 4, "[{0 0 2}]"},
+		{"empty partitions", []int64{0, 0, 0}, false, 0, 4, "[]"},
+	}
+	for _, test := range tests {
+		if got := fmt.Sprint(planPage(test.counts, test.newestFirst, test.skip, test.limit)); got != test.want {
+			t.Errorf("%s: planned %s, want %s", test.name, got, test.want)
+		}
+	}
+}
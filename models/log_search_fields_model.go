@@ -1,8 +1,10 @@
 package models
 
 import (
+	"bytes"
 	"errors"
 	"logging_service/core"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -25,6 +27,33 @@ type LogSearchFields struct {
 	OrderBy   string
 	Page      int64
 	Limit     int64
+
+	// The fields below are not read from requests and are set by internal operations such as the retention purge.
+
+	// LocationPrefix restricts logs to locations starting with it.
+	LocationPrefix string
+	// ExcludedLocationPrefixes leaves out logs whose location starts with any of them.
+	ExcludedLocationPrefixes []string
+	// IDs restricts logs to a set of ids.
+	IDs []primitive.ObjectID
+	// ExcludeRestored leaves out logs restored from an archive.
+	ExcludeRestored bool
+	// RestoredBefore restricts logs to those restored from an archive before it.
+	RestoredBefore *time.Time
+	// Chained restricts logs to those linked into a hash chain.
+	Chained bool
+	// NotKeyID restricts logs to encrypted logs whose data key is wrapped with any other key.
+	NotKeyID string
+	// ExcludedHolds leaves out logs under any of the legal holds.
+	ExcludedHolds []LegalHold
+	// UseReadPreference lets the mongodb backends read with the configured read preference instead of the primary.
+	UseReadPreference bool
+	// AfterID restricts logs to those with a greater id, so iterating in id order can resume after the last log.
+	AfterID primitive.ObjectID
+	// Tenant restricts logs to one tenant and is set from the request's context by ScopeToTenant.
+	Tenant string
+	// Grants restrict logs to those allowed by any of them, none when empty but not nil, and are set by ScopeToCaller.
+	Grants []AccessGrant
 }
 
 // GetSearchFields all get request fields for a search.
@@ -115,7 +144,7 @@ func (lsf *LogSearchFields) GetSearchFields(c *gin.Context) error {
 //	[]map[string]interface{} - List of maps containing mongodb filters.
 //
 func (lsf *LogSearchFields) getFilters() []map[string]interface{} {
-	var createdAtPresent = !lsf.CreatedAt.IsZero()
+	var createdAtPresent = lsf.CreatedAt != nil && !lsf.CreatedAt.IsZero()
 	var fromDatePresent = lsf.FromDate != nil && !lsf.FromDate.IsZero()
 	var toDatePresent = lsf.ToDate != nil && !lsf.ToDate.IsZero()
 	var locationPresent = lsf.Location != ""
@@ -131,6 +160,12 @@ func (lsf *LogSearchFields) getFilters() []map[string]interface{} {
 	if locationPresent {
 		filters = append(filters, map[string]interface{}{"location": lsf.Location})
 	}
+	if lsf.LocationPrefix != "" {
+		filters = append(filters, map[string]interface{}{"location": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(lsf.LocationPrefix)}})
+	}
+	for _, prefix := range lsf.ExcludedLocationPrefixes {
+		filters = append(filters, map[string]interface{}{"location": bson.M{operator.Not: primitive.Regex{Pattern: "^" + regexp.QuoteMeta(prefix)}}})
+	}
 	if logLevelPresent {
 		filters = append(filters, map[string]interface{}{"log_level": lsf.LogLevel})
 	} else {
@@ -140,20 +175,237 @@ func (lsf *LogSearchFields) getFilters() []map[string]interface{} {
 	if searchIDPresent {
 		filters = append(filters, map[string]interface{}{"_id": lsf.ID})
 	}
+	if lsf.IDs != nil {
+		filters = append(filters, map[string]interface{}{"_id": bson.M{operator.In: lsf.IDs}})
+	}
+	if !lsf.AfterID.IsZero() {
+		filters = append(filters, map[string]interface{}{"_id": bson.M{operator.Gt: lsf.AfterID}})
+	}
+	if lsf.Tenant != "" {
+		filters = append(filters, map[string]interface{}{"tenant": lsf.Tenant})
+	}
+	if lsf.ExcludeRestored {
+		filters = append(filters, map[string]interface{}{"restored_at": bson.M{operator.Exists: false}})
+	}
+	if lsf.RestoredBefore != nil {
+		filters = append(filters, map[string]interface{}{"restored_at": bson.M{operator.Lte: lsf.RestoredBefore}})
+	}
+	if lsf.Chained {
+		filters = append(filters, map[string]interface{}{"sequence": bson.M{operator.Exists: true}})
+	}
+	if lsf.NotKeyID != "" {
+		filters = append(filters, map[string]interface{}{"key_id": bson.M{operator.Exists: true, operator.Ne: lsf.NotKeyID}})
+	}
+	if lsf.Grants != nil {
+		filters = append(filters, grantFilter(lsf.Grants))
+	}
+	if len(lsf.ExcludedHolds) > 0 {
+		held := []bson.M{}
+		for _, hold := range lsf.ExcludedHolds {
+			holdFields := hold.SearchFields()
+			held = append(held, bson.M{operator.And: holdFields.getFilters()})
+		}
+		filters = append(filters, map[string]interface{}{operator.Nor: held})
+	}
 
 	return filters
 }
 
+// Matches reports whether a log satisfies the same conditions getFilters produces for mongodb. It is used by storage
+// backends that evaluate search fields themselves.
+//
+// Receiver:
+//	*LogSearchFields				lsf
+//
+// Parameters:
+//	*Log	l	- Log to check.
+//
+// Returns
+//	bool - True if the log matches.
+//
+func (lsf *LogSearchFields) Matches(l *Log) bool {
+	from, to, hasRange := lsf.TimeRange()
+	if hasRange && (l.CreatedAt.Before(from) || l.CreatedAt.After(to)) {
+		return false
+	}
+	if lsf.Location != "" && l.Location != lsf.Location {
+		return false
+	}
+	if !strings.HasPrefix(l.Location, lsf.LocationPrefix) {
+		return false
+	}
+	for _, prefix := range lsf.ExcludedLocationPrefixes {
+		if strings.HasPrefix(l.Location, prefix) {
+			return false
+		}
+	}
+	if !lsf.MatchesLogLevel(l.LogLevel) {
+		return false
+	}
+	if !lsf.ID.IsZero() && l.ID != lsf.ID {
+		return false
+	}
+	if lsf.IDs != nil && !containsID(lsf.IDs, l.ID) {
+		return false
+	}
+	if !lsf.AfterID.IsZero() && bytes.Compare(l.ID[:], lsf.AfterID[:]) <= 0 {
+		return false
+	}
+	if lsf.Tenant != "" && l.Tenant != lsf.Tenant {
+		return false
+	}
+	if lsf.ExcludeRestored && l.RestoredAt != nil {
+		return false
+	}
+	if lsf.RestoredBefore != nil && (l.RestoredAt == nil || l.RestoredAt.After(*lsf.RestoredBefore)) {
+		return false
+	}
+	if lsf.Chained && l.Sequence == 0 {
+		return false
+	}
+	if lsf.NotKeyID != "" && (l.KeyID == "" || l.KeyID == lsf.NotKeyID) {
+		return false
+	}
+	if lsf.Grants != nil && !matchesAnyGrant(lsf.Grants, l) {
+		return false
+	}
+	for _, hold := range lsf.ExcludedHolds {
+		if hold.Matches(l) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// HasLocationFilter reports whether the search fields restrict logs by location.
+//
+// Receiver:
+//	*LogSearchFields				lsf
+//
+// Returns
+//	bool - True if a location, location prefix or excluded location prefix is set.
+//
+func (lsf *LogSearchFields) HasLocationFilter() bool {
+	return lsf.Location != "" || lsf.LocationPrefix != "" || len(lsf.ExcludedLocationPrefixes) > 0
+}
+
+// HasRestoredFilter reports whether the search fields restrict logs by when they were restored from an archive.
+//
+// Receiver:
+//	*LogSearchFields				lsf
+//
+// Returns
+//	bool - True if restored logs are excluded or a restored before time is set.
+//
+func (lsf *LogSearchFields) HasRestoredFilter() bool {
+	return lsf.ExcludeRestored || lsf.RestoredBefore != nil
+}
+
+// MatchesLogLevel reports whether the log level satisfies the log level condition of the search fields.
+//
+// Receiver:
+//	*LogSearchFields				lsf
+//
+// Parameters:
+//	string	logLevel	- Log level to check.
+//
+// Returns
+//	bool - True if the log level matches.
+//
+func (lsf *LogSearchFields) MatchesLogLevel(logLevel string) bool {
+	if lsf.LogLevel != "" {
+		return logLevel == lsf.LogLevel
+	}
+
+	for _, val := range core.LogLevels {
+		if val == logLevel {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TimeRange returns the inclusive created_at range the search fields are restricted to. An exact created_at search
+// is returned as a range with equal bounds.
+//
+// Receiver:
+//	*LogSearchFields				lsf
+//
+// Returns
+//	time.Time	- Start of the range.
+//	time.Time	- End of the range.
+//	bool		- False if the search fields are not restricted by date.
+//
+func (lsf *LogSearchFields) TimeRange() (time.Time, time.Time, bool) {
+	if lsf.CreatedAt != nil && !lsf.CreatedAt.IsZero() {
+		return *lsf.CreatedAt, *lsf.CreatedAt, true
+	}
+	if lsf.FromDate != nil && !lsf.FromDate.IsZero() && lsf.ToDate != nil && !lsf.ToDate.IsZero() {
+		return *lsf.FromDate, *lsf.ToDate, true
+	}
+
+	return time.Time{}, time.Time{}, false
+}
+
 func (lsf *LogSearchFields) getFindOptions() *options.FindOptions {
 	var orderByPresent = lsf.OrderBy != ""
 	options := options.Find()
-	if orderByPresent {
-		options.SetSort(bson.D{{lsf.OrderBy, -1}})
+	if lsf.OrderBy == "id" {
+		options.SetSort(bson.D{bson.DocElem{Name: "_id", Value: -1}})
+	} else if orderByPresent {
+		options.SetSort(bson.D{bson.DocElem{Name: lsf.OrderBy, Value: -1}})
 	}
 
 	return options
 }
 
+// grantFilter returns the mongodb filter matching the logs allowed by any of the grants.
+func grantFilter(grants []AccessGrant) map[string]interface{} {
+	if len(grants) == 0 {
+		return map[string]interface{}{"_id": bson.M{operator.In: []primitive.ObjectID{}}}
+	}
+
+	allowed := []bson.M{}
+	for _, grant := range grants {
+		conditions := bson.M{}
+		if len(grant.LocationPrefixes) > 0 {
+			patterns := []primitive.Regex{}
+			for _, prefix := range grant.LocationPrefixes {
+				patterns = append(patterns, primitive.Regex{Pattern: "^" + regexp.QuoteMeta(prefix)})
+			}
+			conditions["location"] = bson.M{operator.In: patterns}
+		}
+		if len(grant.LogLevels) > 0 {
+			conditions["log_level"] = bson.M{operator.In: grant.LogLevels}
+		}
+		allowed = append(allowed, conditions)
+	}
+
+	return map[string]interface{}{operator.Or: allowed}
+}
+
+// matchesAnyGrant reports whether any of the grants allows a log.
+func matchesAnyGrant(grants []AccessGrant, l *Log) bool {
+	for _, grant := range grants {
+		if grant.Matches(l) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, val := range ids {
+		if val == id {
+			return true
+		}
+	}
+	return false
+}
+
 func isOrderByFieldValid(orderByField string) bool {
 	var validOrderByField = false
 	searchFields := []string{"created_at", "id", "location", "log_level", ""}
@@ -0,0 +1,170 @@
+package models
+
+/*
+ *
+ * file: 		log_store.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the storage backend interface the log model uses for persistence.
+ *
+ */
+
+import (
+	"context"
+	"logging_service/core"
+	"sort"
+)
+
+// LogStore defines the operations a storage backend must provide to persist and query logs.
+type LogStore interface {
+	// Create stores a new log. The store is responsible for assigning the log's id.
+	Create(ctx context.Context, l *Log) error
+
+	// Find returns one page of logs matching the search fields along with the total number of matching logs.
+	Find(ctx context.Context, fields LogSearchFields, limit int64) ([]Log, int64, error)
+
+	// Count returns the number of logs matching the search fields.
+	Count(ctx context.Context, fields LogSearchFields) (int64, error)
+
+	// CountByDates returns the number of logs matching the search fields grouped by day and log level.
+	CountByDates(ctx context.Context, fields LogSearchFields) ([]core.CountResultsWithDate, error)
+
+	// Delete removes the logs matching the search fields and returns the number of logs removed.
+	Delete(ctx context.Context, fields LogSearchFields) (int64, error)
+
+	// Iterate calls fn for every log matching the search fields in ascending id order without loading every log at
+	// once. Iteration stops at the first error fn returns. fn must not use the store.
+	Iterate(ctx context.Context, fields LogSearchFields, fn func(l *Log) error) error
+}
+
+// ExpiringLogStore is implemented by backends that remove logs themselves once their ExpiresAt time has passed.
+type ExpiringLogStore interface {
+	LogStore
+
+	// EnsureExpiryIndex prepares the backend to remove expired logs.
+	EnsureExpiryIndex(ctx context.Context) error
+
+	// ClearExpiry stops the logs matching the search fields from expiring and returns the number of logs changed.
+	ClearExpiry(ctx context.Context, fields LogSearchFields) (int64, error)
+}
+
+// BulkLogStore is implemented by backends that can store many logs in one operation.
+type BulkLogStore interface {
+	LogStore
+
+	// CreateMany stores new logs, assigning the id of any log without one.
+	CreateMany(ctx context.Context, logs []Log) error
+}
+
+// store is the backend used by the log model. It defaults to the mongodb backend.
+var store LogStore = &mongoStore{}
+
+// SetLogStore sets the storage backend used by the log model.
+//
+// Parameters:
+//	LogStore	s	- Storage backend.
+//
+func SetLogStore(s LogStore) {
+	store = s
+}
+
+// GetLogStore returns the storage backend used by the log model.
+//
+// Returns
+//	LogStore	- Storage backend.
+//
+func GetLogStore() LogStore {
+	return store
+}
+
+// SortLogs sorts logs in place by the given order by field. Logs are sorted in descending order when an order by field
+// is given, matching the mongodb backend, and by creation date otherwise.
+//
+// Parameters:
+//	[]Log	logs	- Logs to sort.
+//	string	orderBy	- One of 'created_at', 'log_level', 'id', 'location' or ''.
+//
+func SortLogs(logs []Log, orderBy string) {
+	sort.SliceStable(logs, func(i, j int) bool {
+		switch orderBy {
+		case "created_at":
+			return logs[i].CreatedAt.After(logs[j].CreatedAt)
+		case "log_level":
+			return logs[i].LogLevel > logs[j].LogLevel
+		case "id":
+			return logs[i].ID.Hex() > logs[j].ID.Hex()
+		case "location":
+			return logs[i].Location > logs[j].Location
+		default:
+			return logs[i].CreatedAt.Before(logs[j].CreatedAt)
+		}
+	})
+}
+
+// GroupByDates counts logs by day and log level, producing the same results as the mongodb date aggregation.
+//
+// Parameters:
+//	[]Log	logs	- Logs to count.
+//
+// Returns
+//	[]core.CountResultsWithDate	- Counts for each day and log level.
+//
+func GroupByDates(logs []Log) []core.CountResultsWithDate {
+	counter := NewDateCounter()
+	for _, l := range logs {
+		counter.Add(l.CreatedAt.UTC().Format(core.CreatedDayFormat), l.LogLevel, 1)
+	}
+
+	return counter.Results()
+}
+
+// DateCounter accumulates log counts for each day and log level.
+type DateCounter struct {
+	counts map[core.CountWithDateID]int64
+}
+
+// NewDateCounter creates an empty DateCounter.
+//
+// Returns
+//	*DateCounter	- Empty counter.
+//
+func NewDateCounter() *DateCounter {
+	return &DateCounter{counts: map[core.CountWithDateID]int64{}}
+}
+
+// Add adds n logs to the count for the given day and log level.
+//
+// Receiver:
+//	*DateCounter	dc
+//
+// Parameters:
+//	string	date		- Day formatted with core.CreatedDayFormat.
+//	string	logLevel	- Log level.
+//	int64	n			- Number of logs to add.
+//
+func (dc *DateCounter) Add(date string, logLevel string, n int64) {
+	dc.counts[core.CountWithDateID{Date: date, LogLevel: logLevel}] += n
+}
+
+// Results returns the counts ordered by date and log level.
+//
+// Receiver:
+//	*DateCounter	dc
+//
+// Returns
+//	[]core.CountResultsWithDate	- Counts for each day and log level.
+//
+func (dc *DateCounter) Results() []core.CountResultsWithDate {
+	results := []core.CountResultsWithDate{}
+	for id, count := range dc.counts {
+		results = append(results, core.CountResultsWithDate{ID: id, Count: count})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].ID.Date != results[j].ID.Date {
+			return results[i].ID.Date < results[j].ID.Date
+		}
+		return results[i].ID.LogLevel < results[j].ID.LogLevel
+	})
+
+	return results
+}
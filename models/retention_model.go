@@ -0,0 +1,286 @@
+package models
+
+/*
+ *
+ * file: 		retention_model.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the retention policy that decides how long logs are kept.
+ *
+ */
+
+import (
+	"errors"
+	"logging_service/config"
+	"logging_service/core"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy defines the number of days logs are kept for each log level. Overrides replace the number of days
+// for logs whose location starts with a prefix. Zero days means logs are kept forever.
+type RetentionPolicy struct {
+	Levels    map[string]int      `json:"levels"`
+	Overrides []RetentionOverride `json:"overrides,omitempty"`
+}
+
+// RetentionOverride replaces the retention of logs whose location starts with LocationPrefix. An override without
+// log levels applies to every log level.
+type RetentionOverride struct {
+	LocationPrefix string   `json:"location_prefix"`
+	LogLevels      []string `json:"log_levels,omitempty"`
+	Days           int      `json:"days"`
+}
+
+// retentionPolicy is the policy used when creating logs.
+var retentionPolicy = RetentionPolicy{Levels: map[string]int{}}
+
+// NewRetentionPolicy creates a retention policy from the Retention config values.
+//
+// Parameters:
+//	config.Values	conf	- Config values.
+//
+// Returns
+//	RetentionPolicy	- Retention policy.
+//	error			- Error if a log level is unknown or a number of days is negative.
+//
+func NewRetentionPolicy(conf config.Values) (RetentionPolicy, error) {
+	policy := RetentionPolicy{Levels: map[string]int{}}
+	for logLevel, days := range conf.Retention.Levels {
+		logLevel = strings.ToUpper(logLevel)
+		if valid, all := IsValidLogLevel(logLevel); !valid || all || logLevel == "" {
+			return policy, errors.New("retention: unknown log level " + logLevel)
+		}
+		if days < 0 {
+			return policy, errors.New("retention: days must not be negative for " + logLevel)
+		}
+		policy.Levels[logLevel] = days
+	}
+
+	for _, override := range conf.Retention.Overrides {
+		if override.LocationPrefix == "" {
+			return policy, errors.New("retention: overrides must have a location prefix")
+		}
+		if override.Days < 0 {
+			return policy, errors.New("retention: days must not be negative for " + override.LocationPrefix)
+		}
+		logLevels := []string{}
+		for _, logLevel := range override.LogLevels {
+			logLevel = strings.ToUpper(logLevel)
+			if valid, all := IsValidLogLevel(logLevel); !valid || all || logLevel == "" {
+				return policy, errors.New("retention: unknown log level " + logLevel)
+			}
+			logLevels = append(logLevels, logLevel)
+		}
+		policy.Overrides = append(policy.Overrides, RetentionOverride{
+			LocationPrefix: override.LocationPrefix,
+			LogLevels:      logLevels,
+			Days:           override.Days,
+		})
+	}
+
+	return policy, nil
+}
+
+// SetRetentionPolicy sets the retention policy used when creating logs.
+//
+// Parameters:
+//	RetentionPolicy	policy	- Retention policy.
+//
+func SetRetentionPolicy(policy RetentionPolicy) {
+	retentionPolicy = policy
+}
+
+// GetRetentionPolicy returns the retention policy used when creating logs.
+//
+// Returns
+//	RetentionPolicy	- Retention policy.
+//
+func GetRetentionPolicy() RetentionPolicy {
+	return retentionPolicy
+}
+
+// IsEmpty reports whether the policy keeps every log forever.
+//
+// Receiver:
+//	RetentionPolicy		rp
+//
+// Returns
+//	bool - True if no log level or override has a number of days.
+//
+func (rp RetentionPolicy) IsEmpty() bool {
+	for _, days := range rp.Levels {
+		if days > 0 {
+			return false
+		}
+	}
+	for _, override := range rp.Overrides {
+		if override.Days > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MaxDays returns the longest number of days any log is kept under the policy.
+//
+// Receiver:
+//	RetentionPolicy		rp
+//
+// Returns
+//	int		- Longest number of days.
+//	bool	- False if some logs are kept forever.
+//
+func (rp RetentionPolicy) MaxDays() (int, bool) {
+	maxDays := 0
+	for _, logLevel := range core.LogLevels {
+		days := rp.Levels[logLevel]
+		if days == 0 {
+			return 0, false
+		}
+		if days > maxDays {
+			maxDays = days
+		}
+	}
+	for _, override := range rp.Overrides {
+		if override.Days == 0 {
+			return 0, false
+		}
+		if override.Days > maxDays {
+			maxDays = override.Days
+		}
+	}
+
+	return maxDays, true
+}
+
+// Days returns the number of days a log with the given log level and location is kept. The override with the longest
+// matching location prefix is used, falling back to the log level's number of days.
+//
+// Receiver:
+//	RetentionPolicy		rp
+//
+// Parameters:
+//	string	logLevel	- Log level of the log.
+//	string	location	- Location of the log.
+//
+// Returns
+//	int - Number of days, zero if the log is kept forever.
+//
+func (rp RetentionPolicy) Days(logLevel string, location string) int {
+	days := rp.Levels[logLevel]
+	longestPrefix := -1
+	for _, override := range rp.overridesFor(logLevel) {
+		if strings.HasPrefix(location, override.LocationPrefix) && len(override.LocationPrefix) > longestPrefix {
+			days = override.Days
+			longestPrefix = len(override.LocationPrefix)
+		}
+	}
+
+	return days
+}
+
+// ExpiresAt returns the time a log expires at under the policy.
+//
+// Receiver:
+//	RetentionPolicy		rp
+//
+// Parameters:
+//	*Log	l	- Log to get the expiry of.
+//
+// Returns
+//	*time.Time - Expiry time, nil if the log is kept forever.
+//
+func (rp RetentionPolicy) ExpiresAt(l *Log) *time.Time {
+	days := rp.Days(l.LogLevel, l.Location)
+	if days == 0 {
+		return nil
+	}
+
+	expiresAt := l.CreatedAt.AddDate(0, 0, days)
+	return &expiresAt
+}
+
+// PurgeFilters returns search fields matching every log that has outlived the policy at the given time. Each override
+// only covers locations that are not covered by a longer override prefix, and each log level's own number of days only
+// covers locations without an override.
+//
+// Receiver:
+//	RetentionPolicy		rp
+//
+// Parameters:
+//	time.Time	now	- Time to purge at.
+//
+// Returns
+//	[]LogSearchFields	- Search fields for each part of the policy that removes logs.
+//
+func (rp RetentionPolicy) PurgeFilters(now time.Time) []LogSearchFields {
+	filters := []LogSearchFields{}
+	for _, logLevel := range core.LogLevels {
+		overrides := rp.overridesFor(logLevel)
+		prefixes := []string{}
+		seen := map[string]bool{}
+
+		for _, override := range overrides {
+			if seen[override.LocationPrefix] {
+				continue
+			}
+			seen[override.LocationPrefix] = true
+			prefixes = append(prefixes, override.LocationPrefix)
+
+			// Use the first override for a prefix, matching Days.
+			days := rp.Days(logLevel, override.LocationPrefix)
+			if days == 0 {
+				continue
+			}
+			excluded := []string{}
+			for _, other := range overrides {
+				if len(other.LocationPrefix) > len(override.LocationPrefix) && strings.HasPrefix(other.LocationPrefix, override.LocationPrefix) {
+					excluded = append(excluded, other.LocationPrefix)
+				}
+			}
+			filters = append(filters, purgeFilter(logLevel, override.LocationPrefix, excluded, now, days))
+		}
+
+		if days := rp.Levels[logLevel]; days > 0 {
+			filters = append(filters, purgeFilter(logLevel, "", prefixes, now, days))
+		}
+	}
+
+	return filters
+}
+
+// overridesFor returns the overrides that apply to the log level.
+func (rp RetentionPolicy) overridesFor(logLevel string) []RetentionOverride {
+	overrides := []RetentionOverride{}
+	for _, override := range rp.Overrides {
+		if len(override.LogLevels) == 0 {
+			overrides = append(overrides, override)
+			continue
+		}
+		for _, overrideLevel := range override.LogLevels {
+			if overrideLevel == logLevel {
+				overrides = append(overrides, override)
+				break
+			}
+		}
+	}
+
+	return overrides
+}
+
+// purgeFilter creates search fields for logs of a log level created more than the given number of days ago. Logs
+// restored from an archive are removed by the archive job instead.
+func purgeFilter(logLevel string, prefix string, excluded []string, now time.Time, days int) LogSearchFields {
+	from := time.Unix(0, 0).UTC()
+	to := now.AddDate(0, 0, -days)
+	return LogSearchFields{
+		LogLevel:                 logLevel,
+		LocationPrefix:           prefix,
+		ExcludedLocationPrefixes: excluded,
+		FromDate:                 &from,
+		ToDate:                   &to,
+		ExcludeRestored:          true,
+	}
+}
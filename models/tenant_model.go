@@ -0,0 +1,67 @@
+package models
+
+/*
+ *
+ * file: 		tenant_model.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the tenant of a request's context, which stamps created logs and scopes every search.
+ *
+ */
+
+import (
+	"context"
+)
+
+// tenantKey is the context key the tenant of a request is stored under.
+type tenantKey struct{}
+
+// WithTenant returns a context scoped to a tenant. Logs created with it are stamped with the tenant, and searches,
+// counts, exports and deletes run with it only see the tenant's logs.
+//
+// Parameters:
+//	context.Context	ctx		- Parent context.
+//	string			tenant	- Tenant, empty to leave the context unscoped.
+//
+// Returns
+//	context.Context - Scoped context.
+//
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	if tenant == "" {
+		return ctx
+	}
+
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantOf returns the tenant a context is scoped to.
+//
+// Parameters:
+//	context.Context	ctx	- Context.
+//
+// Returns
+//	string	- Tenant.
+//	bool	- False if the context is not scoped to a tenant.
+//
+func TenantOf(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok && tenant != ""
+}
+
+// ScopeToTenant returns the search fields restricted to the tenant of a context, replacing any tenant they had. Search
+// fields are returned unchanged when the context is not scoped to a tenant.
+//
+// Parameters:
+//	context.Context	ctx		- Context.
+//	LogSearchFields	fields	- Search fields.
+//
+// Returns
+//	LogSearchFields - Scoped search fields.
+//
+func ScopeToTenant(ctx context.Context, fields LogSearchFields) LogSearchFields {
+	if tenant, ok := TenantOf(ctx); ok {
+		fields.Tenant = tenant
+	}
+
+	return fields
+}
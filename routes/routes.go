@@ -5,7 +5,7 @@ package routes
  * file: 		routes.go
  * project:		logging_service - NAD-A3
  * programmer: 	Conor Macpherson
- * description: Defines routes used in the logging service and initializes the logger, cors, and jwt token authentication.
+ * description: Defines routes used in the logging service and initializes the logger, cors, authentication, tenants, access rules, request signing and route scopes.
  *
  */
 
@@ -30,7 +30,7 @@ func Setup(router *gin.Engine) {
 	// Add logger, cross origin restrictions.
 	router.Use(
 		cors.New(cors.Config{
-			AllowMethods:     []string{"POST", "GET"},
+			AllowMethods:     []string{"POST", "GET", "PUT", "DELETE"},
 			AllowHeaders:     []string{"Content-Type", "Origin", "Accept", "Authorization", "*"},
 			ExposeHeaders:    []string{"Content-Length"},
 			AllowCredentials: true,
@@ -48,11 +48,64 @@ func Setup(router *gin.Engine) {
 	router.LoadHTMLGlob("public/templates/*.tmpl.html")
 	router.Static("/static", "public/static")
 
-	router.Use(security.AuthenticateJWT())
-	router.GET("/log", handlers.HandleGetLog)
-	router.GET("/log/:log_level", handlers.HandleGetLog)
-	router.POST("/log/:log_level", handlers.HandlePostLog)
-	router.GET("/log/:log_level/count/*type", handlers.HandleGetLogCount)
+	router.GET("/health", handlers.HandleGetHealth)
 
-	router.Run(":" + configs.Server.Port)
+	router.Use(security.Authenticate(), security.ScopeTenant(), security.RestrictAccess())
+	scopes := security.GetScopes(configs)
+
+	writes := router.Group("/", security.RequireScope(scopes.Write), security.RequireTenant(), security.VerifySignature(), security.LimitTenantWrites())
+	writes.POST("/log/:log_level", handlers.HandlePostLog)
+
+	reads := router.Group("/", security.RequireScope(scopes.Read), security.LimitTenantReads())
+	reads.GET("/log", security.AuditReads("search"), handlers.HandleGetLog)
+	reads.GET("/log/:log_level", security.AuditReads("search"), handlers.HandleGetLog)
+	reads.GET("/log/:log_level/count/*type", security.AuditReads("count"), handlers.HandleGetLogCount)
+	reads.GET("/log/:log_level/export", security.AuditReads("export"), handlers.HandleGetLogExport)
+	reads.GET("/holds", handlers.HandleGetLegalHolds)
+	reads.GET("/holds/:id", handlers.HandleGetLegalHold)
+	reads.POST("/jobs/export/:log_level", security.AuditReads("export_job"), handlers.HandlePostExportJob)
+	reads.GET("/jobs/:id/artifact", security.AuditReads("export_download"), handlers.HandleGetJobArtifact)
+
+	// Exports are submitted with the read scope and re-indexes and deletes with the admin scope, so either scope follows
+	// and cancels jobs. The handlers limit callers to their own jobs unless they are job admins.
+	submitters := router.Group("/", security.RequireAnyScope(scopes.Read, scopes.Admin))
+	submitters.GET("/jobs", handlers.HandleGetJobs)
+	submitters.GET("/jobs/:id", handlers.HandleGetJob)
+	submitters.DELETE("/jobs/:id", handlers.HandleDeleteJob)
+
+	admin := router.Group("/", security.RequireScope(scopes.Admin))
+	admin.POST("/log/:log_level/delete", handlers.HandlePostLogDelete)
+	admin.GET("/admin/access", handlers.HandleGetAccessTrail)
+	admin.GET("/audit", handlers.HandleGetAudit)
+	admin.GET("/admin/api-keys", handlers.HandleGetAPIKeys)
+	admin.POST("/admin/api-keys", handlers.HandlePostAPIKey)
+	admin.POST("/admin/api-keys/:id/rotate", handlers.HandlePostAPIKeyRotation)
+	admin.DELETE("/admin/api-keys/:id", handlers.HandleDeleteAPIKey)
+	admin.GET("/admin/producers", handlers.HandleGetProducers)
+	admin.POST("/admin/producers", handlers.HandlePostProducer)
+	admin.POST("/admin/producers/:id/rotate", handlers.HandlePostProducerRotation)
+	admin.DELETE("/admin/producers/:id", handlers.HandleDeleteProducer)
+	admin.POST("/holds", handlers.HandlePostLegalHold)
+	admin.PUT("/holds/:id", handlers.HandlePutLegalHold)
+	admin.DELETE("/holds/:id", handlers.HandleDeleteLegalHold)
+	admin.POST("/jobs/reindex", handlers.HandlePostReindexJob)
+	admin.POST("/jobs/delete/:log_level", handlers.HandlePostDeleteJob)
+
+	// These operations span every tenant, so with tenancy enabled they are left to super-admins.
+	cluster := router.Group("/", security.RequireScope(scopes.Admin), security.RequireSuperAdmin())
+	cluster.GET("/retention", handlers.HandleGetRetention)
+	cluster.GET("/archive", handlers.HandleGetArchive)
+	cluster.GET("/archive/manifest", handlers.HandleGetArchiveManifest)
+	cluster.POST("/archive/restore", handlers.HandlePostArchiveRestore)
+	cluster.GET("/admin/indexes", handlers.HandleGetIndexes)
+	cluster.GET("/admin/database", handlers.HandleGetDatabase)
+	cluster.GET("/admin/auth/keys", handlers.HandleGetAuthKeys)
+	cluster.GET("/integrity", handlers.HandleGetIntegrity)
+	cluster.GET("/integrity/verify", handlers.HandleGetIntegrityVerify)
+	cluster.POST("/integrity/checkpoint", handlers.HandlePostIntegrityCheckpoint)
+	cluster.GET("/encryption", handlers.HandleGetEncryption)
+
+	if err := serve(router, configs); err != nil {
+		panic(err)
+	}
 }
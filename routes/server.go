@@ -0,0 +1,112 @@
+package routes
+
+/*
+ *
+ * file: 		server.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the listeners the router is served on, over plain http or tls with an optional http listener.
+ *
+ */
+
+import (
+	"errors"
+	"log"
+	"logging_service/config"
+	"logging_service/security"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Values of Server.TLS.HTTP_MODE, redirect when it is not set.
+const (
+	httpModeRedirect = "redirect"
+	httpModeServe    = "serve"
+)
+
+// Timeouts of the listeners, so slow or idle clients cannot hold connections open.
+const (
+	readHeaderTimeout = 10 * time.Second
+	idleTimeout       = 120 * time.Second
+)
+
+// serve serves the router on Server.PORT, over tls when Server.TLS.CERT_FILE is set. With tls, Server.TLS.HTTP_PORT
+// adds a plain http listener that redirects to https, or serves the router alongside it when Server.TLS.HTTP_MODE is
+// serve. It only returns if a listener fails, with the error of whichever fails first.
+//
+// Parameters:
+//	*gin.Engine		router	- gin router
+//	config.Values	configs	- Config of the service.
+//
+// Returns
+//	error - Error of the listener that failed.
+//
+func serve(router *gin.Engine, configs config.Values) error {
+	settings := configs.Server.TLS
+	if settings.CertFile == "" {
+		log.Println("Listening and serving HTTP on :" + configs.Server.Port)
+		return newServer(configs.Server.Port, router).ListenAndServe()
+	}
+
+	tlsConfig, err := security.NewTLSConfig(configs)
+	if err != nil {
+		return err
+	}
+
+	failed := make(chan error, 2)
+	if settings.HTTPPort != "" {
+		var handler http.Handler
+		switch strings.ToLower(settings.HTTPMode) {
+		case "", httpModeRedirect:
+			handler = redirectToTLS(configs.Server.Port)
+		case httpModeServe:
+			handler = router
+		default:
+			return errors.New("Server.TLS.HTTP_MODE: must be redirect or serve")
+		}
+		go func() {
+			log.Println("Listening and serving HTTP on :" + settings.HTTPPort)
+			failed <- newServer(settings.HTTPPort, handler).ListenAndServe()
+		}()
+	}
+
+	server := newServer(configs.Server.Port, router)
+	server.TLSConfig = tlsConfig
+	go func() {
+		log.Println("Listening and serving HTTPS on :" + configs.Server.Port)
+		failed <- server.ListenAndServeTLS("", "")
+	}()
+
+	return <-failed
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// newServer returns a server of a handler on a port with the listener timeouts.
+func newServer(port string, handler http.Handler) *http.Server {
+	return &http.Server{Addr: ":" + port, Handler: handler, ReadHeaderTimeout: readHeaderTimeout, IdleTimeout: idleTimeout}
+}
+
+// redirectToTLS returns a handler permanently redirecting requests to the same host, path and query over https on a
+// port. Methods and bodies are kept, so producers posting over http are told to resend over https.
+func redirectToTLS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = hostname
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,157 @@
+package security
+
+/*
+ *
+ * file: 		access.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the access rules mapping claims to the locations and log levels a caller can read.
+ *
+ */
+
+import (
+	"errors"
+	"logging_service/config"
+	"logging_service/models"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Values of Access.UNMATCHED, allow when it is not set.
+const (
+	unmatchedAllow = "allow"
+	unmatchedDeny  = "deny"
+)
+
+// RestrictAccess is a gin middleware that restricts what a request can read to the grants of the Access.RULES its
+// claims match. Searches, counts and exports silently leave out every other log, so totals and counts only include
+// logs the caller may read. Callers matching no rule read everything, or nothing when Access.UNMATCHED is deny. Rules
+// are built once from the config. It must follow Authenticate.
+//
+// Returns
+//	gin.HandlerFunc	- next gin handler/middleware.
+//
+func RestrictAccess() gin.HandlerFunc {
+	rules, denyUnmatched, err := newAccessRules(config.GetConfig())
+	if err != nil {
+		panic(err)
+	}
+
+	return func(c *gin.Context) {
+		if len(rules) == 0 {
+			c.Next()
+			return
+		}
+
+		grants := GrantsOf(GetClaims(c), rules)
+		if grants == nil && denyUnmatched {
+			grants = []models.AccessGrant{}
+		}
+		c.Request = c.Request.WithContext(models.WithAccess(c.Request.Context(), grants))
+		c.Next()
+	}
+}
+
+// GrantsOf returns the grants of the rules whose claim holds one of their values.
+//
+// Parameters:
+//	Claims					claims	- Claims of the caller.
+//	[]config.AccessRule		rules	- Access rules.
+//
+// Returns
+//	[]models.AccessGrant - Grants of the matching rules, nil if no rule matches.
+//
+func GrantsOf(claims Claims, rules []config.AccessRule) []models.AccessGrant {
+	var grants []models.AccessGrant
+	for _, rule := range rules {
+		if !matchesAnyValue(claimValues(claims, rule.Claim), rule.Values) {
+			continue
+		}
+		grants = append(grants, models.AccessGrant{LocationPrefixes: rule.Locations, LogLevels: rule.Levels})
+	}
+
+	return grants
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// newAccessRules validates the access rules of the config, normalising their log levels.
+func newAccessRules(conf config.Values) ([]config.AccessRule, bool, error) {
+	denyUnmatched := false
+	switch strings.ToLower(conf.Access.Unmatched) {
+	case "", unmatchedAllow:
+	case unmatchedDeny:
+		denyUnmatched = true
+	default:
+		return nil, false, errors.New("Access.UNMATCHED: must be allow or deny")
+	}
+
+	rules := []config.AccessRule{}
+	for i, rule := range conf.Access.Rules {
+		name := "Access.RULES[" + strconv.Itoa(i) + "]: "
+		if rule.Claim == "" {
+			return nil, false, errors.New(name + "CLAIM: required")
+		}
+		if len(rule.Values) == 0 {
+			return nil, false, errors.New(name + "VALUES: at least one value is required")
+		}
+		levels := []string{}
+		for _, level := range rule.Levels {
+			level = strings.ToUpper(strings.TrimSpace(level))
+			if valid, all := models.IsValidLogLevel(level); !valid || all || level == "" {
+				return nil, false, errors.New(name + "LEVELS: unknown log level '" + level + "'")
+			}
+			levels = append(levels, level)
+		}
+		rule.Levels = levels
+		rules = append(rules, rule)
+	}
+
+	return rules, denyUnmatched, nil
+}
+
+// claimValues returns the values of a claim. The scope claim holds both the scopes and permissions, and jwt claims
+// holding a list, such as groups, hold each of their strings.
+func claimValues(claims Claims, name string) []string {
+	switch name {
+	case "sub":
+		return []string{claims.Subject}
+	case "scope", "permissions":
+		return append(strings.Fields(claims.Scope), claims.Permissions...)
+	}
+	if name == defaultTenantClaim && claims.Tenant != "" {
+		return []string{claims.Tenant}
+	}
+
+	switch value := claims.Others[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := []string{}
+		for _, val := range value {
+			if val, ok := val.(string); ok {
+				values = append(values, val)
+			}
+		}
+		return values
+	}
+
+	return nil
+}
+
+// matchesAnyValue reports whether any of the claim values is one of the rule's values.
+func matchesAnyValue(claimed []string, values []string) bool {
+	for _, value := range claimed {
+		if containsValue(values, value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,126 @@
+package security
+
+/*
+ *
+ * file: 		access_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests mapping claims to access grants and filtering logs by them.
+ *
+ */
+
+import (
+	"context"
+	"fmt"
+	"logging_service/config"
+	"logging_service/models"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGrantsOf(t *testing.T) {
+	useConfig(t, `Access:
+    RULES:
+        - CLAIM: groups
+          VALUES: [billing-team]
+          LOCATIONS: [billing/]
+          LEVELS: [info, ERROR]
+        - CLAIM: scope
+          VALUES: [logs:admin]
+        - CLAIM: tenant
+          VALUES: [acme]
+          LOCATIONS: [acme/]
+`)
+	rules, _, err := newAccessRules(config.GetConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	billing := models.AccessGrant{LocationPrefixes: []string{"billing/"}, LogLevels: []string{"INFO", "ERROR"}}
+	tests := []struct {
+		name   string
+		claims Claims
+		want   []models.AccessGrant
+	}{
+		{"no rule matches", Claims{Scope: "logs:read"}, nil},
+		{"group in a list claim", Claims{Others: map[string]interface{}{"groups": []interface{}{"ops", "billing-team"}}}, []models.AccessGrant{billing}},
+		{"group in a string claim", Claims{Others: map[string]interface{}{"groups": "billing-team"}}, []models.AccessGrant{billing}},
+		{"permission", Claims{Permissions: []string{"logs:admin"}}, []models.AccessGrant{{}}},
+		{"tenant", Claims{Tenant: "acme"}, []models.AccessGrant{{LocationPrefixes: []string{"acme/"}}}},
+		{"several rules", Claims{Scope: "logs:admin", Others: map[string]interface{}{"groups": "billing-team"}}, []models.AccessGrant{billing, {}}},
+	}
+	for _, test := range tests {
+		// Grants are compared by value, as rules without locations or levels hold empty rather than nil lists.
+		if got := GrantsOf(test.claims, rules); (got == nil) != (test.want == nil) || fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: granted %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAccessRulesAreValidated(t *testing.T) {
+	tests := []struct {
+		name   string
+		access string
+	}{
+		{"unknown unmatched", "    UNMATCHED: maybe\n"},
+		{"missing claim", "    RULES:\n        - VALUES: [a]\n"},
+		{"missing values", "    RULES:\n        - CLAIM: groups\n"},
+		{"unknown level", "    RULES:\n        - CLAIM: groups\n          VALUES: [a]\n          LEVELS: [LOUD]\n"},
+		{"every level", "    RULES:\n        - CLAIM: groups\n          VALUES: [a]\n          LEVELS: [ALL]\n"},
+	}
+	for _, test := range tests {
+		useConfig(t, "Access:\n"+test.access)
+		if _, _, err := newAccessRules(config.GetConfig()); err == nil {
+			t.Errorf("%s: accepted", test.name)
+		}
+	}
+}
+
+func TestRestrictAccessFiltersLogs(t *testing.T) {
+	logs := []models.Log{
+		{LogLevel: "INFO", Location: "billing/invoices"},
+		{LogLevel: "DEBUG", Location: "billing/invoices"},
+		{LogLevel: "INFO", Location: "shipping"},
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		claims Claims
+		want   []bool
+	}{
+		{"no rules", "", Claims{}, []bool{true, true, true}},
+		{"unmatched allowed", "Access:\n    RULES:\n        - CLAIM: sub\n          VALUES: [auditor]\n          LOCATIONS: [billing/]\n", Claims{Subject: "someone"}, []bool{true, true, true}},
+		{"matched", "Access:\n    RULES:\n        - CLAIM: sub\n          VALUES: [auditor]\n          LOCATIONS: [billing/]\n          LEVELS: [INFO]\n", Claims{Subject: "auditor"}, []bool{true, false, false}},
+		{"unmatched denied", "Access:\n    UNMATCHED: deny\n    RULES:\n        - CLAIM: sub\n          VALUES: [auditor]\n", Claims{Subject: "someone"}, []bool{false, false, false}},
+	}
+	for _, test := range tests {
+		useConfig(t, test.config)
+		var ctx context.Context
+		status := serveWithClaims(test.claims, "/log", RestrictAccess(), func(c *gin.Context) {
+			ctx = c.Request.Context()
+		})
+		if status != http.StatusOK {
+			t.Fatalf("%s: responded %d", test.name, status)
+		}
+
+		fields := models.ScopeToCaller(ctx, models.LogSearchFields{})
+		for i := range logs {
+			if got := fields.Matches(&logs[i]); got != test.want[i] {
+				t.Errorf("%s: log %d readable %v, want %v", test.name, i, got, test.want[i])
+			}
+		}
+	}
+}
+
+func TestEmptyGrantsAllowNothing(t *testing.T) {
+	l := models.Log{LogLevel: "INFO", Location: "billing"}
+	if fields := models.ScopeToCaller(models.WithAccess(context.Background(), nil), models.LogSearchFields{}); !fields.Matches(&l) {
+		t.Error("nil grants denied a log, want every log allowed")
+	}
+	if fields := models.ScopeToCaller(models.WithAccess(context.Background(), []models.AccessGrant{}), models.LogSearchFields{}); fields.Matches(&l) {
+		t.Error("empty grants allowed a log, want none allowed")
+	}
+}
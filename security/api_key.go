@@ -0,0 +1,582 @@
+package security
+
+/*
+ *
+ * file: 		api_key.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines service managed api keys, an alternative to jwts for machine to machine requests.
+ *
+ */
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"logging_service/audit"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// apiKeyPrefix starts every api key so they are recognisable in configs and secret scanners.
+const apiKeyPrefix = "lsk_"
+
+// apiKeyHeader is the header api keys are sent in, as an alternative to "Authorization: ApiKey <key>".
+const apiKeyHeader = "X-API-Key"
+
+// defaultRotationGrace is used when ApiKeys.ROTATION_GRACE_HOURS is not set.
+const defaultRotationGrace = 24 * time.Hour
+
+// lastUsedFlushInterval is how often changed last used times are saved.
+const lastUsedFlushInterval = time.Minute
+
+// ErrAPIKeyNotFound is returned when no api key has the given id.
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKey is a service managed key bound to scopes and, optionally, to the locations it may write and read and to a
+// tenant. Only a hash of its secret is stored. After a rotation the previous secret stays valid until PreviousExpiresAt, so callers can
+// switch to the new secret without downtime.
+type APIKey struct {
+	ID                string     `json:"id"`
+	Name              string     `json:"name" binding:"required"`
+	Scopes            []string   `json:"scopes" binding:"required"`
+	Locations         []string   `json:"locations,omitempty"`
+	Tenant            string     `json:"tenant,omitempty"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+	CreatedBy         string     `json:"created_by"`
+	CreatedAt         time.Time  `json:"created_at"`
+	RotatedAt         *time.Time `json:"rotated_at,omitempty"`
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty"`
+	LastUsedAt        *time.Time `json:"last_used_at,omitempty"`
+}
+
+// IssuedAPIKey is an api key along with its secret, which is only returned when the key is created or rotated.
+type IssuedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// apiKeyRecord is an api key as it is saved, with the hashes of its current and previous secrets.
+type apiKeyRecord struct {
+	APIKey
+	Hash         string `json:"hash"`
+	PreviousHash string `json:"previous_hash,omitempty"`
+}
+
+// apiKeyFile holds the api keys, saved as a json array in a file.
+type apiKeyFile struct {
+	path  string
+	grace time.Duration
+	mutex sync.RWMutex
+	keys  []apiKeyRecord
+	dirty bool
+}
+
+// apiKeys are the api keys, nil when no api key file is configured.
+var apiKeys *apiKeyFile
+
+// LoadAPIKeys loads the api keys from a file and starts saving their last used times. The file is created when the
+// first key is created.
+//
+// Parameters:
+//	string	path		- Path of the api key file, empty to disable api keys.
+//	int		graceHours	- Hours a rotated key's previous secret stays valid, zero for the default.
+//
+// Returns
+//	error - Error if the file cannot be read.
+//
+func LoadAPIKeys(path string, graceHours int) error {
+	apiKeys = nil
+	if path == "" {
+		return nil
+	}
+
+	file := &apiKeyFile{path: path, grace: time.Duration(graceHours) * time.Hour, keys: []apiKeyRecord{}}
+	if file.grace <= 0 {
+		file.grace = defaultRotationGrace
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, &file.keys); err != nil {
+			return err
+		}
+	}
+
+	apiKeys = file
+	go file.runLastUsedFlush()
+	return nil
+}
+
+// APIKeysEnabled reports whether an api key file is configured.
+//
+// Returns
+//	bool - True if api keys can be created.
+//
+func APIKeysEnabled() bool {
+	return apiKeys != nil
+}
+
+// ListAPIKeys returns the api keys without their secrets.
+//
+// Parameters:
+//	string	tenant	- Tenant the keys belong to, empty for every key.
+//
+// Returns
+//	[]APIKey - Api keys in the order they were created.
+//
+func ListAPIKeys(tenant string) []APIKey {
+	keys := []APIKey{}
+	if apiKeys == nil {
+		return keys
+	}
+
+	apiKeys.mutex.RLock()
+	defer apiKeys.mutex.RUnlock()
+	for _, record := range apiKeys.keys {
+		if tenant == "" || record.Tenant == tenant {
+			keys = append(keys, record.APIKey)
+		}
+	}
+
+	return keys
+}
+
+// GetAPIKey returns an api key without its secrets.
+//
+// Parameters:
+//	string	id		- Id of the key.
+//	string	tenant	- Tenant the key must belong to, empty for any tenant.
+//
+// Returns
+//	APIKey	- Api key.
+//	error	- ErrAPIKeyNotFound if no key of the tenant has the id.
+//
+func GetAPIKey(id string, tenant string) (APIKey, error) {
+	if apiKeys == nil {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+
+	apiKeys.mutex.RLock()
+	defer apiKeys.mutex.RUnlock()
+	index := indexOfAPIKey(apiKeys.keys, id, tenant)
+	if index < 0 {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+
+	return apiKeys.keys[index].APIKey, nil
+}
+
+// CreateAPIKey creates an api key with a new secret. The key must have been validated.
+//
+// Parameters:
+//	APIKey	key		- Name, scopes, locations and expiry of the key.
+//	string	actor	- Caller creating the key.
+//
+// Returns
+//	IssuedAPIKey	- Created key and its secret.
+//	error			- Error if the key cannot be audited or saved.
+//
+func CreateAPIKey(key APIKey, actor string) (IssuedAPIKey, error) {
+	if apiKeys == nil {
+		return IssuedAPIKey{}, errors.New("api keys: no api key file is configured")
+	}
+
+	key.ID = primitive.NewObjectID().Hex()
+	key.CreatedBy = actor
+	key.CreatedAt = time.Now().UTC()
+	key.RotatedAt = nil
+	key.PreviousExpiresAt = nil
+	key.LastUsedAt = nil
+	secret, hash, err := newAPIKeySecret(key.ID)
+	if err != nil {
+		return IssuedAPIKey{}, err
+	}
+
+	apiKeys.mutex.Lock()
+	defer apiKeys.mutex.Unlock()
+	if err := key.audit("api_key_create", actor); err != nil {
+		return IssuedAPIKey{}, err
+	}
+	keys := append(append([]apiKeyRecord{}, apiKeys.keys...), apiKeyRecord{APIKey: key, Hash: hash})
+	if err := apiKeys.save(keys); err != nil {
+		return IssuedAPIKey{}, err
+	}
+
+	return IssuedAPIKey{APIKey: key, Key: secret}, nil
+}
+
+// RotateAPIKey gives an api key a new secret. The current secret stays valid for the rotation grace period, so the
+// key has two active secrets until callers have switched. A secret left from an earlier rotation stops being valid.
+//
+// Parameters:
+//	string	id		- Id of the key.
+//	string	tenant	- Tenant the key must belong to, empty for any tenant.
+//	string	actor	- Caller rotating the key.
+//
+// Returns
+//	IssuedAPIKey	- Rotated key and its new secret.
+//	error			- ErrAPIKeyNotFound, or an error if the rotation cannot be audited or saved.
+//
+func RotateAPIKey(id string, tenant string, actor string) (IssuedAPIKey, error) {
+	if apiKeys == nil {
+		return IssuedAPIKey{}, ErrAPIKeyNotFound
+	}
+	secret, hash, err := newAPIKeySecret(id)
+	if err != nil {
+		return IssuedAPIKey{}, err
+	}
+
+	apiKeys.mutex.Lock()
+	defer apiKeys.mutex.Unlock()
+	keys := append([]apiKeyRecord{}, apiKeys.keys...)
+	index := indexOfAPIKey(keys, id, tenant)
+	if index < 0 {
+		return IssuedAPIKey{}, ErrAPIKeyNotFound
+	}
+
+	rotatedAt := time.Now().UTC()
+	previousExpiresAt := rotatedAt.Add(apiKeys.grace)
+	record := keys[index]
+	record.PreviousHash = record.Hash
+	record.Hash = hash
+	record.RotatedAt = &rotatedAt
+	record.PreviousExpiresAt = &previousExpiresAt
+	if err := record.audit("api_key_rotate", actor); err != nil {
+		return IssuedAPIKey{}, err
+	}
+	keys[index] = record
+	if err := apiKeys.save(keys); err != nil {
+		return IssuedAPIKey{}, err
+	}
+
+	return IssuedAPIKey{APIKey: record.APIKey, Key: secret}, nil
+}
+
+// RevokeAPIKey removes an api key, so neither of its secrets is valid any more.
+//
+// Parameters:
+//	string	id		- Id of the key.
+//	string	tenant	- Tenant the key must belong to, empty for any tenant.
+//	string	actor	- Caller revoking the key.
+//
+// Returns
+//	APIKey	- Revoked key.
+//	error	- ErrAPIKeyNotFound, or an error if the revocation cannot be audited or saved.
+//
+func RevokeAPIKey(id string, tenant string, actor string) (APIKey, error) {
+	if apiKeys == nil {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+
+	apiKeys.mutex.Lock()
+	defer apiKeys.mutex.Unlock()
+	keys := append([]apiKeyRecord{}, apiKeys.keys...)
+	index := indexOfAPIKey(keys, id, tenant)
+	if index < 0 {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+
+	key := keys[index].APIKey
+	if err := key.audit("api_key_revoke", actor); err != nil {
+		return key, err
+	}
+
+	return key, apiKeys.save(append(keys[:index], keys[index+1:]...))
+}
+
+// apiKeyProvider authenticates requests carrying an api key in the X-API-Key header or an "Authorization: ApiKey"
+// header. An api key's scopes are granted as its claims, and its subject is "apikey:" followed by its id.
+type apiKeyProvider struct{}
+
+// Name implements Provider.
+//
+// Receiver:
+//	apiKeyProvider	p
+//
+// Returns
+//	string - Name of the provider.
+//
+func (p apiKeyProvider) Name() string {
+	return "api_key"
+}
+
+// Recognises implements Provider, reporting whether the request carries an api key.
+//
+// Receiver:
+//	apiKeyProvider	p
+//
+// Parameters:
+//	*http.Request	r	- Request to check.
+//
+// Returns
+//	bool - True if the request carries an api key.
+//
+func (p apiKeyProvider) Recognises(r *http.Request) bool {
+	_, ok := apiKeyOf(r)
+	return ok
+}
+
+// Authenticate implements Provider, checking the request's api key and returning the claims it grants.
+//
+// Receiver:
+//	apiKeyProvider	p
+//
+// Parameters:
+//	*http.Request	r	- Request to authenticate.
+//
+// Returns
+//	Claims	- Claims granted by the key.
+//	error	- Error if the key is not valid.
+//
+func (p apiKeyProvider) Authenticate(r *http.Request) (Claims, error) {
+	raw, _ := apiKeyOf(r)
+	key, err := apiKeys.authenticate(raw, time.Now().UTC())
+	if err != nil {
+		return Claims{}, err
+	}
+
+	return Claims{Subject: "apikey:" + key.ID, Scope: strings.Join(key.Scopes, " "), Permissions: key.Scopes, Locations: key.Locations,
+		Tenant: key.Tenant}, nil
+}
+
+// AllowsLocation reports whether the request may write or read a location. Requests authenticated with an api key
+// bound to locations may only use locations starting with one of them.
+//
+// Parameters:
+//	*gin.Context	c			- Handler context from gin.
+//	string			location	- Location to check.
+//
+// Returns
+//	bool - True if the location is allowed.
+//
+func AllowsLocation(c *gin.Context, location string) bool {
+	allowed := GetClaims(c).Locations
+	if len(allowed) == 0 {
+		return true
+	}
+	if location == "" {
+		return false
+	}
+	for _, prefix := range allowed {
+		if strings.HasPrefix(location, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate trims the name, scopes and locations of the key and checks them.
+//
+// Receiver:
+//	*APIKey		k
+//
+// Parameters:
+//	[]string	known	- Scopes and permissions the service checks, the only ones a key may be granted.
+//
+// Returns
+//	error - Error describing the first field that is not valid.
+//
+func (k *APIKey) Validate(known []string) error {
+	k.Name = strings.TrimSpace(k.Name)
+	if k.Name == "" {
+		return errors.New("name: required")
+	}
+	k.Scopes = trimmedValues(k.Scopes)
+	if len(k.Scopes) == 0 {
+		return errors.New("scopes: at least one scope is required")
+	}
+	for _, scope := range k.Scopes {
+		if !containsValue(known, scope) {
+			return errors.New("scopes: unknown scope " + scope)
+		}
+	}
+	k.Locations = trimmedValues(k.Locations)
+	k.Tenant = strings.TrimSpace(k.Tenant)
+	if k.ExpiresAt != nil && !k.ExpiresAt.After(time.Now()) {
+		return errors.New("expires_at: must be in the future")
+	}
+
+	return nil
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// audit records a change to the key in the audit trail when one is configured.
+func (k APIKey) audit(action string, actor string) error {
+	if audit.GetTrail() == nil {
+		return nil
+	}
+	_, err := audit.Append(audit.Record{
+		Actor:   actor,
+		Tenant:  k.Tenant,
+		Action:  action,
+		Details: map[string]interface{}{"api_key_id": k.ID, "name": k.Name, "scopes": k.Scopes, "locations": k.Locations, "tenant": k.Tenant},
+	})
+
+	return err
+}
+
+// apiKeyOf returns the api key a request carries, if any.
+func apiKeyOf(r *http.Request) (string, bool) {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return key, true
+	}
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "ApiKey ") {
+		return strings.TrimSpace(authorization[7:]), true
+	}
+
+	return "", false
+}
+
+// newAPIKeySecret returns a new secret for the key of an id, and the hash it is saved as. The id is part of the secret
+// so the key can be found without comparing the secret against every saved hash.
+func newAPIKeySecret(id string) (string, string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	secret := apiKeyPrefix + id + "_" + base64.RawURLEncoding.EncodeToString(random)
+
+	return secret, hashAPIKey(secret), nil
+}
+
+// hashAPIKey returns the hash an api key secret is saved as. The secrets are random, so a plain sha256 is enough.
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticate returns the key a secret belongs to if the secret is its current one, or its previous one within the
+// rotation grace period, and the key has not expired. Its last used time is updated and saved by the next flush.
+func (akf *apiKeyFile) authenticate(secret string, now time.Time) (APIKey, error) {
+	if akf == nil {
+		return APIKey{}, errors.New("api keys: no api key file is configured")
+	}
+	if !strings.HasPrefix(secret, apiKeyPrefix) {
+		return APIKey{}, errors.New("api keys: malformed key")
+	}
+	id := strings.SplitN(strings.TrimPrefix(secret, apiKeyPrefix), "_", 2)[0]
+	hash := hashAPIKey(secret)
+
+	akf.mutex.Lock()
+	defer akf.mutex.Unlock()
+	index := indexOfAPIKey(akf.keys, id, "")
+	if index < 0 {
+		return APIKey{}, errors.New("api keys: unknown key " + id)
+	}
+	record := &akf.keys[index]
+	current := subtle.ConstantTimeCompare([]byte(hash), []byte(record.Hash)) == 1
+	previous := record.PreviousHash != "" && record.PreviousExpiresAt != nil && now.Before(*record.PreviousExpiresAt) &&
+		subtle.ConstantTimeCompare([]byte(hash), []byte(record.PreviousHash)) == 1
+	if !current && !previous {
+		return APIKey{}, errors.New("api keys: wrong secret for key " + id)
+	}
+	if record.ExpiresAt != nil && !now.Before(*record.ExpiresAt) {
+		return APIKey{}, errors.New("api keys: key " + id + " has expired")
+	}
+
+	record.LastUsedAt = &now
+	akf.dirty = true
+	return record.APIKey, nil
+}
+
+// runLastUsedFlush saves the api keys whenever their last used times have changed since the last flush.
+func (akf *apiKeyFile) runLastUsedFlush() {
+	for {
+		time.Sleep(lastUsedFlushInterval)
+		akf.mutex.Lock()
+		if akf.dirty {
+			if err := akf.save(append([]apiKeyRecord{}, akf.keys...)); err != nil {
+				log.Println(err)
+			}
+		}
+		akf.mutex.Unlock()
+	}
+}
+
+// save writes the keys to the api key file, then replaces the loaded keys. The mutex must be held.
+func (akf *apiKeyFile) save(keys []apiKeyRecord) error {
+	if err := writeSecretFile(akf.path, keys); err != nil {
+		return err
+	}
+
+	akf.keys = keys
+	akf.dirty = false
+	return nil
+}
+
+// writeSecretFile writes a value as json to a temporary file only the service's user can read, and renames it over a
+// file, so the file is never left half written.
+func writeSecretFile(path string, value interface{}) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	temporary, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporary.Name())
+	if err := temporary.Chmod(0600); err != nil {
+		temporary.Close()
+		return err
+	}
+	if _, err := temporary.Write(content); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Sync(); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temporary.Name(), path)
+}
+
+// indexOfAPIKey returns the index of the key with the id, in the tenant unless it is empty, -1 if there is none.
+func indexOfAPIKey(keys []apiKeyRecord, id string, tenant string) int {
+	for i, key := range keys {
+		if key.ID == id && (tenant == "" || key.Tenant == tenant) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// trimmedValues returns the non-empty values of a list with surrounding spaces removed.
+func trimmedValues(values []string) []string {
+	trimmed := []string{}
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			trimmed = append(trimmed, value)
+		}
+	}
+
+	return trimmed
+}
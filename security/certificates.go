@@ -0,0 +1,224 @@
+package security
+
+/*
+ *
+ * file: 		certificates.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the tls configuration the service is served with and the reload of its certificate files.
+ *
+ */
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"log"
+	"logging_service/config"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCertificateReload is used when Server.TLS.RELOAD_SECONDS is not set.
+const defaultCertificateReload = 30 * time.Second
+
+// Values of Server.TLS.CLIENT_AUTH, verify when it is not set.
+const (
+	clientAuthVerify  = "verify"
+	clientAuthRequire = "require"
+)
+
+// tlsVersions are the values of Server.TLS.MIN_VERSION.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// certificateFiles holds the certificate, key and client CA the service is served with, reloading them whenever one of
+// the files changes. A reload that fails keeps serving the files loaded before it.
+type certificateFiles struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	modified    map[string]time.Time
+}
+
+// NewTLSConfig returns the tls configuration of Server.TLS and starts reloading its certificate, key and client CA
+// files every Server.TLS.RELOAD_SECONDS when they have changed, so renewed certificates are served without a restart.
+// With a client CA, client certificates signed by it are verified so the mtls provider can authenticate them, and
+// when Server.TLS.CLIENT_AUTH is require connections without one are refused.
+//
+// Parameters:
+//	config.Values	conf	- Config of the service.
+//
+// Returns
+//	*tls.Config	- Tls configuration.
+//	error		- Error if the settings are not valid or the files cannot be loaded.
+//
+func NewTLSConfig(conf config.Values) (*tls.Config, error) {
+	settings := conf.Server.TLS
+	if settings.CertFile == "" || settings.KeyFile == "" {
+		return nil, errors.New("Server.TLS: CERT_FILE and KEY_FILE are both required")
+	}
+
+	minVersion := uint16(tls.VersionTLS12)
+	if settings.MinVersion != "" {
+		version, ok := tlsVersions[settings.MinVersion]
+		if !ok {
+			return nil, errors.New("Server.TLS.MIN_VERSION: must be 1.0, 1.1, 1.2 or 1.3")
+		}
+		minVersion = version
+	}
+	ciphers, err := cipherSuitesOf(settings.Ciphers)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth := tls.VerifyClientCertIfGiven
+	switch strings.ToLower(settings.ClientAuth) {
+	case "", clientAuthVerify:
+	case clientAuthRequire:
+		clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, errors.New("Server.TLS.CLIENT_AUTH: must be verify or require")
+	}
+	if settings.ClientCAFile == "" && settings.ClientAuth != "" {
+		return nil, errors.New("Server.TLS.CLIENT_AUTH: needs a CLIENT_CA_FILE")
+	}
+
+	files := &certificateFiles{certFile: settings.CertFile, keyFile: settings.KeyFile, clientCAFile: settings.ClientCAFile,
+		modified: map[string]time.Time{}}
+	if _, err := files.reload(); err != nil {
+		return nil, err
+	}
+	interval := time.Duration(settings.ReloadSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultCertificateReload
+	}
+	go files.run(interval)
+
+	base := &tls.Config{MinVersion: minVersion, CipherSuites: ciphers, GetCertificate: files.getCertificate}
+	if settings.ClientCAFile != "" {
+		base.ClientAuth = clientAuth
+		base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			perConnection := base.Clone()
+			perConnection.GetConfigForClient = nil
+			perConnection.ClientCAs = files.getClientCAs()
+			return perConnection, nil
+		}
+	}
+
+	return base, nil
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// cipherSuitesOf returns the ids of the named cipher suites, nil for Go's defaults. Only suites Go considers secure can
+// be named, and they only apply to tls 1.2 and older, as tls 1.3 suites cannot be configured.
+func cipherSuitesOf(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	ids := []uint16{}
+	for _, name := range names {
+		found := false
+		for _, suite := range tls.CipherSuites() {
+			if suite.Name == strings.TrimSpace(name) {
+				ids = append(ids, suite.ID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.New("Server.TLS.CIPHERS: unknown or insecure cipher suite '" + name + "'")
+		}
+	}
+
+	return ids, nil
+}
+
+// getCertificate returns the certificate currently loaded.
+func (cf *certificateFiles) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cf.mutex.RLock()
+	defer cf.mutex.RUnlock()
+	return cf.certificate, nil
+}
+
+// getClientCAs returns the client CAs currently loaded.
+func (cf *certificateFiles) getClientCAs() *x509.CertPool {
+	cf.mutex.RLock()
+	defer cf.mutex.RUnlock()
+	return cf.clientCAs
+}
+
+// reload loads the files if any of them has changed since they were last loaded, reporting whether they were.
+func (cf *certificateFiles) reload() (bool, error) {
+	modified := map[string]time.Time{}
+	changed := false
+	for _, path := range []string{cf.certFile, cf.keyFile, cf.clientCAFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		modified[path] = info.ModTime()
+		if !info.ModTime().Equal(cf.modified[path]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(cf.certFile, cf.keyFile)
+	if err != nil {
+		return false, errors.New("Server.TLS: " + err.Error())
+	}
+	var clientCAs *x509.CertPool
+	if cf.clientCAFile != "" {
+		content, err := ioutil.ReadFile(cf.clientCAFile)
+		if err != nil {
+			return false, err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(content) {
+			return false, errors.New("Server.TLS.CLIENT_CA_FILE: no certificates found in " + cf.clientCAFile)
+		}
+	}
+
+	cf.mutex.Lock()
+	cf.certificate = &certificate
+	cf.clientCAs = clientCAs
+	cf.modified = modified
+	cf.mutex.Unlock()
+	return true, nil
+}
+
+// run reloads the files every interval when they have changed. A certificate and key written one after the other may
+// briefly not match, so a failed reload is logged and retried at the next interval.
+func (cf *certificateFiles) run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		reloaded, err := cf.reload()
+		if err != nil {
+			log.Println("tls: keeping the loaded certificate: " + err.Error())
+		} else if reloaded {
+			log.Println("tls: reloaded the certificate files")
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package security
+
+/*
+ *
+ * file: 		certificates_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests the tls settings and reloading the certificate files the service is served with.
+ *
+ */
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"logging_service/config"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewTLSConfig(t *testing.T) {
+	directory := t.TempDir()
+	certFile, keyFile := writeCertificate(t, directory, "server", 1)
+	caFile, _ := writeCertificate(t, directory, "ca", 2)
+	files := "        CERT_FILE: " + certFile + "\n        KEY_FILE: " + keyFile + "\n"
+
+	tests := []struct {
+		name           string
+		tls            string
+		wantErr        bool
+		wantMinVersion uint16
+		wantClientAuth tls.ClientAuthType
+	}{
+		{"defaults", files, false, tls.VersionTLS12, tls.NoClientCert},
+		{"minimum version", files + "        MIN_VERSION: \"1.3\"\n", false, tls.VersionTLS13, tls.NoClientCert},
+		{"client CA", files + "        CLIENT_CA_FILE: " + caFile + "\n", false, tls.VersionTLS12, tls.VerifyClientCertIfGiven},
+		{"client certificate required", files + "        CLIENT_CA_FILE: " + caFile + "\n        CLIENT_AUTH: require\n", false, tls.VersionTLS12,
+			tls.RequireAndVerifyClientCert},
+		{"no key", "        CERT_FILE: " + certFile + "\n", true, 0, 0},
+		{"unknown version", files + "        MIN_VERSION: \"1.4\"\n", true, 0, 0},
+		{"insecure cipher", files + "        CIPHERS:\n            - TLS_RSA_WITH_RC4_128_SHA\n", true, 0, 0},
+		{"unknown client auth", files + "        CLIENT_CA_FILE: " + caFile + "\n        CLIENT_AUTH: request\n", true, 0, 0},
+		{"client auth without a CA", files + "        CLIENT_AUTH: require\n", true, 0, 0},
+		{"missing certificate", "        CERT_FILE: " + filepath.Join(directory, "missing.pem") + "\n        KEY_FILE: " + keyFile + "\n", true, 0, 0},
+		{"key of another certificate", "        CERT_FILE: " + certFile + "\n        KEY_FILE: " + filepath.Join(directory, "ca.key") + "\n", true, 0, 0},
+	}
+	for _, test := range tests {
+		useConfig(t, "Server:\n    TLS:\n        RELOAD_SECONDS: 3600\n"+test.tls)
+		conf, err := NewTLSConfig(config.GetConfig())
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: returned %v, want an error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err == nil && (conf.MinVersion != test.wantMinVersion || conf.ClientAuth != test.wantClientAuth) {
+			t.Errorf("%s: configured version %x client auth %v, want %x and %v", test.name, conf.MinVersion, conf.ClientAuth,
+				test.wantMinVersion, test.wantClientAuth)
+		}
+	}
+}
+
+func TestReloadCertificateFiles(t *testing.T) {
+	directory := t.TempDir()
+	certFile, keyFile := writeCertificate(t, directory, "server", 1)
+	caFile, _ := writeCertificate(t, directory, "ca", 10)
+	files := &certificateFiles{certFile: certFile, keyFile: keyFile, clientCAFile: caFile, modified: map[string]time.Time{}}
+	if reloaded, err := files.reload(); !reloaded || err != nil {
+		t.Fatalf("loaded %v, %v, want the files loaded", reloaded, err)
+	}
+
+	// Each step changes the files, and later steps keep serving what the last successful reload loaded.
+	renewedAt := time.Now().Add(time.Hour)
+	tests := []struct {
+		name         string
+		change       func()
+		wantReloaded bool
+		wantErr      bool
+		wantSerial   int64
+		wantCAs      int
+	}{
+		{"unchanged", func() {}, false, false, 1, 1},
+		{"certificate renewed before its key", func() {
+			renewed, _ := writeCertificate(t, directory, "renewed", 3)
+			copyFile(t, renewed, certFile, renewedAt)
+		}, false, true, 1, 1},
+		{"key renewed", func() {
+			copyFile(t, filepath.Join(directory, "renewed.key"), keyFile, renewedAt)
+		}, true, false, 3, 1},
+		{"client CA added", func() {
+			other, _ := writeCertificate(t, directory, "other-ca", 11)
+			content, err := ioutil.ReadFile(caFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			added, err := ioutil.ReadFile(other)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeFile(t, caFile, append(content, added...), renewedAt.Add(time.Hour))
+		}, true, false, 3, 2},
+		{"client CA emptied", func() {
+			writeFile(t, caFile, []byte("not a certificate"), renewedAt.Add(2*time.Hour))
+		}, false, true, 3, 2},
+		{"certificate removed", func() {
+			if err := os.Remove(certFile); err != nil {
+				t.Fatal(err)
+			}
+		}, false, true, 3, 2},
+	}
+	for _, test := range tests {
+		test.change()
+		reloaded, err := files.reload()
+		if reloaded != test.wantReloaded || (err != nil) != test.wantErr {
+			t.Errorf("%s: reloaded %v, %v, want %v and an error %v", test.name, reloaded, err, test.wantReloaded, test.wantErr)
+		}
+		certificate, err := files.getCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leaf.SerialNumber.Int64() != test.wantSerial || len(files.getClientCAs().Subjects()) != test.wantCAs {
+			t.Errorf("%s: serving serial %d with %d client CAs, want %d with %d", test.name, leaf.SerialNumber.Int64(),
+				len(files.getClientCAs().Subjects()), test.wantSerial, test.wantCAs)
+		}
+	}
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// writeCertificate writes a self-signed certificate with a serial number and its key to name.pem and name.key in a
+// directory, returning their paths.
+func writeCertificate(t *testing.T, directory string, name string, serial int64) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(directory, name+".pem")
+	keyFile := filepath.Join(directory, name+".key")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), time.Now())
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), time.Now())
+	return certFile, keyFile
+}
+
+// copyFile copies a file over another, giving it a modification time.
+func copyFile(t *testing.T, from string, to string, modifiedAt time.Time) {
+	content, err := ioutil.ReadFile(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, to, content, modifiedAt)
+}
+
+// writeFile writes a file with a modification time, so a reload sees it changed however quickly it is rewritten.
+func writeFile(t *testing.T, path string, content []byte, modifiedAt time.Time) {
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modifiedAt, modifiedAt); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,73 @@
+package security
+
+/*
+ *
+ * file: 		claims.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the jwt claims made available to handlers and the permission checks using them.
+ *
+ */
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// claimsKey is the gin context key the claims of an authenticated request are stored under.
+const claimsKey = "claims"
+
+// Claims are the claims of an authenticated jwt that handlers use. Providers that do not use jwts grant the same
+// claims, Locations holds the locations an api key is bound to and Tenant the tenant the caller belongs to. Others
+// holds every claim of a jwt, such as its groups, for access rules.
+type Claims struct {
+	Subject     string                 `json:"sub"`
+	Scope       string                 `json:"scope"`
+	Permissions []string               `json:"permissions"`
+	Locations   []string               `json:"-"`
+	Tenant      string                 `json:"-"`
+	Others      map[string]interface{} `json:"-"`
+}
+
+// GetClaims returns the claims of the request's jwt.
+//
+// Parameters:
+//	*gin.Context	c	- Handler context from gin.
+//
+// Returns
+//	Claims - Claims, empty if the request was not authenticated.
+//
+func GetClaims(c *gin.Context) Claims {
+	claims, _ := c.Get(claimsKey)
+	if claims, ok := claims.(Claims); ok {
+		return claims
+	}
+
+	return Claims{}
+}
+
+// HasPermission reports whether the request's jwt grants a permission, either in its permissions claim or its scope.
+//
+// Parameters:
+//	*gin.Context	c			- Handler context from gin.
+//	string			permission	- Permission to check.
+//
+// Returns
+//	bool - True if the permission is granted.
+//
+func HasPermission(c *gin.Context, permission string) bool {
+	claims := GetClaims(c)
+	for _, granted := range claims.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	for _, granted := range strings.Fields(claims.Scope) {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
@@ -5,45 +5,133 @@ package security
  * file: 		jwt_auth.go
  * project:		logging_service - NAD-A3
  * programmer: 	Conor Macpherson
- * description: Defines the middleware for authentication jwt tokens from/with Auth0.
+ * description: Defines the providers authenticating jwt tokens from Auth0, oidc issuers and hs256 shared secrets.
  *
  */
 
 import (
-	"log"
-	"logging_service/config"
+	"errors"
 	"net/http"
 
 	"github.com/auth0-community/go-auth0"
-	"github.com/gin-gonic/gin"
 	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
 )
 
-// AuthenticateJWT is a gin middleware that authenticates a jwt in the Authorization header before proceeding with processing a request.
+// jwtProvider authenticates bearer tokens signed with one algorithm and, when it has an issuer, issued by it. The
+// validator is built once, and keys are looked up in a cached key set or are a shared secret. The caller's tenant is
+// read from the tenantClaim claim.
+type jwtProvider struct {
+	name        string
+	issuer      string
+	algorithm   jose.SignatureAlgorithm
+	tenantClaim string
+	validator   *auth0.JWTValidator
+}
+
+// Name implements Provider.
+//
+// Receiver:
+//	*jwtProvider	p
+//
+// Returns
+//	string - Name of the provider.
+//
+func (p *jwtProvider) Name() string {
+	return p.name
+}
+
+// Recognises implements Provider, reporting whether the request's bearer token is signed with the provider's
+// algorithm and names its issuer. The token is not verified.
+//
+// Receiver:
+//	*jwtProvider	p
+//
+// Parameters:
+//	*http.Request	r	- Request to check.
+//
+// Returns
+//	bool - True if the token is meant for the provider.
+//
+func (p *jwtProvider) Recognises(r *http.Request) bool {
+	token, err := auth0.FromHeader(r)
+	if err != nil || len(token.Headers) < 1 || token.Headers[0].Algorithm != string(p.algorithm) {
+		return false
+	}
+	if p.issuer == "" {
+		return true
+	}
+
+	claims := jwt.Claims{}
+	if err := token.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return false
+	}
+	return claims.Issuer == p.issuer
+}
+
+// Authenticate implements Provider, verifying the request's bearer token and returning its claims, with the tenant
+// of its tenant claim when it is a string and every claim in Others.
+//
+// Receiver:
+//	*jwtProvider	p
+//
+// Parameters:
+//	*http.Request	r	- Request to authenticate.
 //
 // Returns
-//	gin.HandlerFunc	- next gin handler/middleware.
-func AuthenticateJWT() gin.HandlerFunc {
+//	Claims	- Claims of the token.
+//	error	- Error if the token is not valid.
+//
+func (p *jwtProvider) Authenticate(r *http.Request) (Claims, error) {
+	claims := Claims{}
+	token, err := p.validator.ValidateRequest(r)
+	if err != nil {
+		return claims, err
+	}
+	others := map[string]interface{}{}
+	if err := p.validator.Claims(r, token, &claims, &others); err != nil {
+		return claims, err
+	}
+	if tenant, ok := others[p.tenantClaim].(string); ok {
+		claims.Tenant = tenant
+	}
+	claims.Others = others
 
-	return func(c *gin.Context) {
+	return claims, nil
+}
 
-		conf := config.GetConfig()
-		client := auth0.NewJWKClient(auth0.JWKClientOptions{URI: conf.Auth.Auth0Domain + ".well-known/jwks.json"}, nil)
-		configuration := auth0.NewConfiguration(client, []string{conf.Auth.Auth0Audience}, conf.Auth.Auth0Domain, jose.RS256)
-		validator := auth0.NewValidator(configuration, nil)
+/*
+ *
+ * Helpers
+ *
+ */
 
-		_, err := validator.ValidateRequest(c.Request)
+// newJWTProvider creates a provider verifying tokens with the keys of a secret provider.
+func newJWTProvider(name string, issuer string, audience string, algorithm jose.SignatureAlgorithm, tenantClaim string, keys auth0.SecretProvider) *jwtProvider {
+	var audiences []string
+	if audience != "" {
+		audiences = []string{audience}
+	}
+	configuration := auth0.NewConfiguration(keys, audiences, issuer, algorithm)
 
-		if err != nil {
-			log.Println(err)
-			terminateWithError(http.StatusUnauthorized, "token is not valid", c)
-			return
-		}
-		c.Next()
+	return &jwtProvider{
+		name:        name,
+		issuer:      issuer,
+		algorithm:   algorithm,
+		tenantClaim: tenantClaim,
+		validator:   auth0.NewValidator(configuration, nil),
 	}
 }
 
-func terminateWithError(statusCode int, message string, c *gin.Context) {
-	c.JSON(statusCode, gin.H{"error": message})
-	c.Abort()
+// signatureAlgorithm returns the algorithm of a provider config, or a default when it is not set.
+func signatureAlgorithm(value string, fallback jose.SignatureAlgorithm) (jose.SignatureAlgorithm, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	switch algorithm := jose.SignatureAlgorithm(value); algorithm {
+	case jose.RS256, jose.RS384, jose.RS512, jose.PS256, jose.PS384, jose.PS512, jose.ES256, jose.ES384, jose.ES512:
+		return algorithm, nil
+	}
+
+	return "", errors.New("ALGORITHM: must be an RS, PS or ES algorithm such as RS256")
 }
@@ -0,0 +1,321 @@
+package security
+
+/*
+ *
+ * file: 		key_set.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the cached json web key set tokens are verified against and its background refresh.
+ *
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/auth0-community/go-auth0"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// defaultKeyRefreshInterval is used when Auth.JWKS_REFRESH_MINUTES is not set.
+const defaultKeyRefreshInterval = time.Hour
+
+// defaultKeyRefetchInterval is used when Auth.JWKS_REFETCH_SECONDS is not set.
+const defaultKeyRefetchInterval = 30 * time.Second
+
+// defaultKeyMaxStale is used when Auth.JWKS_MAX_STALE_HOURS is not set.
+const defaultKeyMaxStale = 24 * time.Hour
+
+// keyFetchTimeout is how long fetching the key set may take.
+const keyFetchTimeout = 10 * time.Second
+
+// ErrUnknownKey is returned when a token is signed by a key that is not in the key set.
+var ErrUnknownKey = errors.New("jwks: the token is signed by an unknown key")
+
+// ErrStaleKeys is returned when the key set has not been refreshed for longer than Auth.JWKS_MAX_STALE_HOURS.
+var ErrStaleKeys = errors.New("jwks: the key set is too old to trust")
+
+// KeySetStatus describes the cached key set and the outcome of the last refresh.
+type KeySetStatus struct {
+	Provider      string     `json:"provider"`
+	URI           string     `json:"uri"`
+	KeyIDs        []string   `json:"key_ids"`
+	Refreshes     int        `json:"refreshes"`
+	Failures      int        `json:"failures"`
+	Stale         bool       `json:"stale"`
+	RefreshedAt   *time.Time `json:"refreshed_at,omitempty"`
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextRefreshAt *time.Time `json:"next_refresh_at,omitempty"`
+}
+
+// keySet caches the keys of a json web key set by key id. Keys are kept when a refresh fails, so tokens can still be
+// verified while the issuer is unreachable, until they are older than maxStale. When the key set has no uri, it is
+// found in the issuer's oidc discovery document before the first fetch.
+type keySet struct {
+	uri             string
+	discoveryURI    string
+	client          *http.Client
+	refreshInterval time.Duration
+	refetchInterval time.Duration
+	maxStale        time.Duration
+
+	mutex  sync.RWMutex
+	keys   map[string]jose.JSONWebKey
+	status KeySetStatus
+
+	// fetchMutex serializes fetches so concurrent requests signed by an unknown key share one.
+	fetchMutex sync.Mutex
+}
+
+var keySetMutex sync.RWMutex
+var keySets []*keySet
+
+// GetKeySetStatuses returns the status of the key set of every provider that verifies tokens against one.
+//
+// Returns
+//	[]KeySetStatus - Key set statuses in the order the providers are configured.
+//
+func GetKeySetStatuses() []KeySetStatus {
+	keySetMutex.RLock()
+	defer keySetMutex.RUnlock()
+	statuses := []KeySetStatus{}
+	for _, keys := range keySets {
+		statuses = append(statuses, keys.getStatus())
+	}
+
+	return statuses
+}
+
+// GetSecret implements auth0.SecretProvider, returning the cached key the request's token is signed by. An unknown key
+// id, or a key set older than Auth.JWKS_MAX_STALE_HOURS, refetches the key set at most once per refetch interval in
+// case the issuer has rotated its keys.
+//
+// Receiver:
+//	*keySet		k
+//
+// Parameters:
+//	*http.Request	r	- Request holding the token.
+//
+// Returns
+//	interface{}	- Key the token is verified against.
+//	error		- Error if the key is unknown or the key set is too old to trust.
+//
+func (k *keySet) GetSecret(r *http.Request) (interface{}, error) {
+	token, err := auth0.FromHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(token.Headers) < 1 {
+		return nil, auth0.ErrNoJWTHeaders
+	}
+	id := token.Headers[0].KeyID
+
+	if key, ok := k.getKey(id); ok {
+		return key, nil
+	}
+
+	k.fetchMutex.Lock()
+	defer k.fetchMutex.Unlock()
+	// Another request may have fetched the key while this one waited.
+	if key, ok := k.getKey(id); ok {
+		return key, nil
+	}
+	if k.canRefetch() {
+		k.refresh()
+	}
+	if key, ok := k.getKey(id); ok {
+		return key, nil
+	}
+	if status := k.getStatus(); status.Stale {
+		return nil, ErrStaleKeys
+	}
+
+	return nil, ErrUnknownKey
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// newKeySet creates an empty key set for a provider, fetched from a uri or from the jwks_uri of a discovery document,
+// and registers it so its status is reported.
+func newKeySet(provider string, uri string, discoveryURI string, refreshInterval time.Duration, refetchInterval time.Duration, maxStale time.Duration) *keySet {
+	keys := &keySet{
+		uri:             uri,
+		discoveryURI:    discoveryURI,
+		client:          &http.Client{Timeout: keyFetchTimeout},
+		refreshInterval: refreshInterval,
+		refetchInterval: refetchInterval,
+		maxStale:        maxStale,
+		keys:            map[string]jose.JSONWebKey{},
+		status:          KeySetStatus{Provider: provider, URI: uri, KeyIDs: []string{}},
+	}
+
+	keySetMutex.Lock()
+	keySets = append(keySets, keys)
+	keySetMutex.Unlock()
+	return keys
+}
+
+// getKey returns the cached key of a key id, unless the key set is too old to trust.
+func (k *keySet) getKey(id string) (interface{}, bool) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	key, ok := k.keys[id]
+	if !ok || k.isStale(time.Now()) {
+		return nil, false
+	}
+
+	return key.Key, true
+}
+
+// canRefetch reports whether the refetch interval has passed since the key set was last fetched.
+func (k *keySet) canRefetch() bool {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	return k.status.LastAttemptAt == nil || time.Since(*k.status.LastAttemptAt) >= k.refetchInterval
+}
+
+// isStale reports whether the keys were last refreshed longer than maxStale ago. The mutex must be held.
+func (k *keySet) isStale(now time.Time) bool {
+	return k.status.RefreshedAt != nil && k.maxStale > 0 && now.Sub(*k.status.RefreshedAt) > k.maxStale
+}
+
+// getStatus returns the key set status.
+func (k *keySet) getStatus() KeySetStatus {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	status := k.status
+	status.KeyIDs = append([]string{}, k.status.KeyIDs...)
+	status.Stale = k.isStale(time.Now())
+
+	return status
+}
+
+// refresh fetches the key set, replacing the cached keys. The cached keys are kept if the fetch fails.
+func (k *keySet) refresh() error {
+	attemptedAt := time.Now()
+	keys, err := k.fetch()
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.status.LastAttemptAt = &attemptedAt
+	if err != nil {
+		k.status.Failures++
+		k.status.LastError = err.Error()
+		log.Println(err)
+		return err
+	}
+
+	k.keys = keys
+	k.status.KeyIDs = make([]string, 0, len(keys))
+	for id := range keys {
+		k.status.KeyIDs = append(k.status.KeyIDs, id)
+	}
+	sort.Strings(k.status.KeyIDs)
+	k.status.Refreshes++
+	k.status.RefreshedAt = &attemptedAt
+	k.status.LastError = ""
+	return nil
+}
+
+// fetch downloads the signing keys of the key set by key id.
+func (k *keySet) fetch() (map[string]jose.JSONWebKey, error) {
+	uri, err := k.jwksURI()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := k.client.Get(uri)
+	if err != nil {
+		return nil, errors.New("jwks: " + err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("jwks: " + uri + " responded with " + resp.Status)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return nil, auth0.ErrInvalidContentType
+	}
+
+	jwks := auth0.JWKS{}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, errors.New("jwks: " + err.Error())
+	}
+
+	keys := map[string]jose.JSONWebKey{}
+	for _, key := range jwks.Keys {
+		if key.Use == "" || key.Use == "sig" {
+			keys[key.KeyID] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks: " + uri + " has no signing keys")
+	}
+
+	return keys, nil
+}
+
+// jwksURI returns the uri of the key set, reading it from the discovery document the first time it is needed.
+func (k *keySet) jwksURI() (string, error) {
+	k.mutex.RLock()
+	uri := k.uri
+	k.mutex.RUnlock()
+	if uri != "" {
+		return uri, nil
+	}
+
+	resp, err := k.client.Get(k.discoveryURI)
+	if err != nil {
+		return "", errors.New("oidc discovery: " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("oidc discovery: " + k.discoveryURI + " responded with " + resp.Status)
+	}
+
+	discovery := struct {
+		JWKSURI string `json:"jwks_uri"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return "", errors.New("oidc discovery: " + err.Error())
+	}
+	if discovery.JWKSURI == "" {
+		return "", errors.New("oidc discovery: " + k.discoveryURI + " has no jwks_uri")
+	}
+
+	k.mutex.Lock()
+	k.uri = discovery.JWKSURI
+	k.status.URI = discovery.JWKSURI
+	k.mutex.Unlock()
+	return discovery.JWKSURI, nil
+}
+
+// run refreshes the key set every refresh interval. A failed refresh is retried after the refetch interval, so a
+// briefly unreachable issuer is caught up with quickly while the cached keys are still served.
+func (k *keySet) run() {
+	for {
+		wait := k.refreshInterval
+		k.fetchMutex.Lock()
+		if err := k.refresh(); err != nil && k.refetchInterval < wait {
+			wait = k.refetchInterval
+		}
+		k.fetchMutex.Unlock()
+
+		next := time.Now().Add(wait)
+		k.mutex.Lock()
+		k.status.NextRefreshAt = &next
+		k.mutex.Unlock()
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,167 @@
+package security
+
+/*
+ *
+ * file: 		key_set_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests finding the keys tokens are signed by in a cached json web key set.
+ *
+ */
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/auth0-community/go-auth0"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestUnknownKeysRefetchTheKeySetAtMostOncePerInterval(t *testing.T) {
+	issuer := newTestIssuer(t, "a")
+	defer issuer.server.Close()
+	keys := newKeySet("test", issuer.server.URL, "", time.Hour, time.Hour, 24*time.Hour)
+
+	tests := []struct {
+		name        string
+		keyID       string
+		rotate      bool
+		allowAt     bool
+		wantErr     error
+		wantFetches int
+	}{
+		{"first token fetches the key set", "a", false, false, nil, 1},
+		{"known key is cached", "a", false, false, nil, 1},
+		{"unknown key within the interval", "b", true, false, ErrUnknownKey, 1},
+		{"unknown key again within the interval", "b", false, false, ErrUnknownKey, 1},
+		{"unknown key after the interval", "b", false, true, nil, 2},
+		{"rotated key is cached", "b", false, false, nil, 2},
+		{"key that is never served", "c", false, true, ErrUnknownKey, 3},
+		{"key that is never served within the interval", "c", false, false, ErrUnknownKey, 3},
+	}
+	for _, test := range tests {
+		if test.rotate {
+			issuer.addKey(t, test.keyID)
+		}
+		if test.allowAt {
+			keys.mutex.Lock()
+			attemptedAt := time.Now().Add(-2 * time.Hour)
+			keys.status.LastAttemptAt = &attemptedAt
+			keys.mutex.Unlock()
+		}
+
+		_, err := keys.GetSecret(issuer.request(t, test.keyID))
+		if err != test.wantErr || issuer.getFetches() != test.wantFetches {
+			t.Errorf("%s: returned %v after %d fetches, want %v after %d", test.name, err, issuer.getFetches(), test.wantErr,
+				test.wantFetches)
+		}
+	}
+}
+
+func TestStaleKeySetsAreNotTrusted(t *testing.T) {
+	issuer := newTestIssuer(t, "a")
+	keys := newKeySet("test", issuer.server.URL, "", time.Hour, time.Hour, time.Hour)
+	if _, err := keys.GetSecret(issuer.request(t, "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the issuer is unreachable, the cached keys are served until they are older than the maximum age.
+	issuer.server.Close()
+	keys.mutex.Lock()
+	refreshedAt := time.Now().Add(-2 * time.Hour)
+	keys.status.RefreshedAt = &refreshedAt
+	keys.status.LastAttemptAt = &refreshedAt
+	keys.mutex.Unlock()
+	if _, err := keys.GetSecret(issuer.request(t, "a")); err != ErrStaleKeys {
+		t.Errorf("returned %v for a stale key set, want %v", err, ErrStaleKeys)
+	}
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// testIssuer serves a json web key set and counts how often it is fetched.
+type testIssuer struct {
+	server *httptest.Server
+
+	mutex   sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	fetches int
+}
+
+// newTestIssuer starts serving a key set holding keys of the key ids.
+func newTestIssuer(t *testing.T, keyIDs ...string) *testIssuer {
+	issuer := &testIssuer{keys: map[string]*rsa.PrivateKey{}}
+	for _, id := range keyIDs {
+		issuer.addKey(t, id)
+	}
+	issuer.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issuer.mutex.Lock()
+		defer issuer.mutex.Unlock()
+		issuer.fetches++
+		jwks := auth0.JWKS{}
+		for id, key := range issuer.keys {
+			jwks.Keys = append(jwks.Keys, jose.JSONWebKey{Key: &key.PublicKey, KeyID: id, Algorithm: string(jose.RS256), Use: "sig"})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jwks)
+	}))
+
+	return issuer
+}
+
+// addKey adds a key to the key set served.
+func (i *testIssuer) addKey(t *testing.T, id string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.mutex.Lock()
+	i.keys[id] = key
+	i.mutex.Unlock()
+}
+
+// getFetches returns how often the key set was fetched.
+func (i *testIssuer) getFetches() int {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	return i.fetches
+}
+
+// request returns a request holding a token signed by a key id. Key ids the issuer does not hold are signed by a key
+// of their own.
+func (i *testIssuer) request(t *testing.T, id string) *http.Request {
+	i.mutex.Lock()
+	key, ok := i.keys[id]
+	i.mutex.Unlock()
+	if !ok {
+		var err error
+		if key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: id}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(jwt.Claims{Subject: "someone"}).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/logs", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	return r
+}
@@ -0,0 +1,312 @@
+package security
+
+/*
+ *
+ * file: 		provider.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the pluggable authentication providers and the middleware trying them in turn.
+ *
+ */
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"logging_service/config"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/auth0-community/go-auth0"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// Provider types of Auth.PROVIDERS.
+const (
+	ProviderAuth0    = "auth0"
+	ProviderOIDC     = "oidc"
+	ProviderHS256    = "hs256"
+	ProviderMTLS     = "mtls"
+	ProviderDisabled = "disabled"
+)
+
+// Provider authenticates the requests carrying credentials it recognises.
+type Provider interface {
+	// Name returns the name the provider is reported and logged as.
+	Name() string
+	// Recognises reports whether the request carries credentials meant for the provider.
+	Recognises(r *http.Request) bool
+	// Authenticate returns the claims of a request the provider recognises, or an error if its credentials are not
+	// valid.
+	Authenticate(r *http.Request) (Claims, error)
+}
+
+// Authenticate is a gin middleware that authenticates a request with the first provider that recognises its
+// credentials, trying api keys first and then the providers of Auth.PROVIDERS in order. A request recognised by no
+// provider, or whose credentials are not valid, is rejected with 401. Providers are built once from the config.
+//
+// Returns
+//	gin.HandlerFunc	- next gin handler/middleware.
+func Authenticate() gin.HandlerFunc {
+	providers, err := NewProviders(config.GetConfig())
+	if err != nil {
+		panic(err)
+	}
+	for _, provider := range providers {
+		log.Println("auth: using the " + provider.Name() + " provider")
+	}
+
+	return func(c *gin.Context) {
+		for _, provider := range providers {
+			if !provider.Recognises(c.Request) {
+				continue
+			}
+
+			claims, err := provider.Authenticate(c.Request)
+			if err != nil {
+				log.Println(provider.Name() + ": " + err.Error())
+				terminateWithError(http.StatusUnauthorized, "credentials are not valid", c)
+				return
+			}
+			c.Set(claimsKey, claims)
+			c.Set(providerKey, provider.Name())
+			c.Next()
+			return
+		}
+
+		terminateWithError(http.StatusUnauthorized, "token is not valid", c)
+	}
+}
+
+// NewProviders builds the authentication providers of the config. Api keys are always tried first, then requests
+// signed by producers. Without Auth.PROVIDERS, tokens are verified against Auth0 with Auth.AUTH_0_DOMAIN and
+// Auth.AUTH_0_AUDIENCE.
+//
+// Parameters:
+//	config.Values	conf	- Config values.
+//
+// Returns
+//	[]Provider	- Providers in the order they are tried.
+//	error		- Error if a provider's config is not valid.
+//
+func NewProviders(conf config.Values) ([]Provider, error) {
+	providers := []Provider{apiKeyProvider{}, &producerProvider{scope: GetScopes(conf).Write, window: signatureWindow(conf),
+		maxBody: signedBodyLimit(conf)}}
+	settings := conf.Auth.Providers
+	if len(settings) == 0 && conf.Auth.Auth0Domain != "" {
+		settings = append(settings, config.AuthProvider{Type: ProviderAuth0, Issuer: conf.Auth.Auth0Domain, Audience: conf.Auth.Auth0Audience})
+	} else if len(settings) == 0 {
+		log.Println("auth: neither Auth.PROVIDERS nor Auth.AUTH_0_DOMAIN is set, only api keys are accepted")
+	}
+
+	names := map[string]bool{}
+	for i, setting := range settings {
+		if setting.Name == "" {
+			setting.Name = setting.Type
+		}
+		if names[setting.Name] {
+			setting.Name += "_" + strconv.Itoa(i)
+		}
+		names[setting.Name] = true
+
+		provider, err := newProvider(conf, setting)
+		if err != nil {
+			return nil, errors.New("Auth.PROVIDERS: " + setting.Name + ": " + err.Error())
+		}
+		providers = append(providers, provider)
+	}
+
+	return providers, nil
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// newProvider builds the provider of one entry of Auth.PROVIDERS.
+func newProvider(conf config.Values, setting config.AuthProvider) (Provider, error) {
+	switch strings.ToLower(setting.Type) {
+	case ProviderAuth0, ProviderOIDC:
+		if setting.Issuer == "" {
+			return nil, errors.New("ISSUER: required")
+		}
+		algorithm, err := signatureAlgorithm(setting.Algorithm, jose.RS256)
+		if err != nil {
+			return nil, err
+		}
+		var keys *keySet
+		if strings.ToLower(setting.Type) == ProviderAuth0 {
+			keys = newCachedKeySet(conf, setting.Name, setting.Issuer+".well-known/jwks.json", "")
+		} else {
+			keys = newCachedKeySet(conf, setting.Name, "", strings.TrimSuffix(setting.Issuer, "/")+"/.well-known/openid-configuration")
+		}
+		return newJWTProvider(setting.Name, setting.Issuer, setting.Audience, algorithm, tenantClaim(conf), keys), nil
+	case ProviderHS256:
+		secret := setting.Secret
+		if setting.SecretFile != "" {
+			content, err := ioutil.ReadFile(setting.SecretFile)
+			if err != nil {
+				return nil, err
+			}
+			secret = strings.TrimSpace(string(content))
+		}
+		if len(secret) < 32 {
+			return nil, errors.New("SECRET: must be at least 32 characters")
+		}
+		return newJWTProvider(setting.Name, setting.Issuer, setting.Audience, jose.HS256, tenantClaim(conf), auth0.NewKeyProvider([]byte(secret))), nil
+	case ProviderMTLS:
+		if len(setting.Scopes) == 0 {
+			return nil, errors.New("SCOPES: at least one scope is required")
+		}
+		return &mtlsProvider{name: setting.Name, subjects: setting.Subjects, scopes: setting.Scopes, tenant: setting.Tenant}, nil
+	case ProviderDisabled:
+		scopes := setting.Scopes
+		if len(scopes) == 0 {
+			granted := GetScopes(conf)
+			scopes = []string{granted.Read, granted.Write, granted.Admin}
+		}
+		log.Println("auth: authentication is disabled for requests from localhost")
+		return &localProvider{name: setting.Name, scopes: scopes, tenant: setting.Tenant}, nil
+	}
+
+	return nil, errors.New("TYPE: must be auth0, oidc, hs256, mtls or disabled")
+}
+
+// newCachedKeySet creates a key set refreshed in the background with the Auth.JWKS settings.
+func newCachedKeySet(conf config.Values, provider string, uri string, discoveryURI string) *keySet {
+	refreshInterval := time.Duration(conf.Auth.JWKSRefreshMinutes) * time.Minute
+	if refreshInterval <= 0 {
+		refreshInterval = defaultKeyRefreshInterval
+	}
+	refetchInterval := time.Duration(conf.Auth.JWKSRefetchSeconds) * time.Second
+	if refetchInterval <= 0 {
+		refetchInterval = defaultKeyRefetchInterval
+	}
+	maxStale := time.Duration(conf.Auth.JWKSMaxStaleHours) * time.Hour
+	if maxStale <= 0 {
+		maxStale = defaultKeyMaxStale
+	}
+
+	keys := newKeySet(provider, uri, discoveryURI, refreshInterval, refetchInterval, maxStale)
+	go keys.run()
+	return keys
+}
+
+// mtlsProvider authenticates requests with a verified tls client certificate, granting its scopes and tenant to
+// certificates whose common name is one of its subjects, or to every verified certificate when it has none.
+type mtlsProvider struct {
+	name     string
+	subjects []string
+	scopes   []string
+	tenant   string
+}
+
+// Name implements Provider.
+func (p *mtlsProvider) Name() string {
+	return p.name
+}
+
+// Recognises implements Provider, reporting whether the request has a verified client certificate and no other
+// credentials.
+func (p *mtlsProvider) Recognises(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && r.Header.Get("Authorization") == ""
+}
+
+// Authenticate implements Provider, granting the provider's scopes and tenant to the certificate's common name.
+func (p *mtlsProvider) Authenticate(r *http.Request) (Claims, error) {
+	subject := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if len(p.subjects) > 0 && !containsValue(p.subjects, subject) {
+		return Claims{}, errors.New("client certificate " + subject + " is not allowed")
+	}
+
+	return Claims{Subject: "cert:" + subject, Scope: strings.Join(p.scopes, " "), Permissions: p.scopes, Tenant: p.tenant}, nil
+}
+
+// producerProviderName is the name of the producer provider.
+const producerProviderName = "producer"
+
+// producerProvider authenticates log ingestion requests signed by a producer and carrying no other credentials,
+// granting the write scope and the producer's tenant. Its subject is "producer:" followed by the producer's id.
+type producerProvider struct {
+	scope   string
+	window  time.Duration
+	maxBody int64
+}
+
+// Name implements Provider.
+func (p *producerProvider) Name() string {
+	return producerProviderName
+}
+
+// Recognises implements Provider, reporting whether the request is a POST signed by a producer without a token.
+func (p *producerProvider) Recognises(r *http.Request) bool {
+	return producers != nil && r.Method == http.MethodPost && r.Header.Get("Authorization") == "" &&
+		r.Header.Get(producerHeader) != "" && r.Header.Get(signatureHeader) != ""
+}
+
+// Authenticate implements Provider, verifying the request's signature and granting the write scope and the
+// producer's tenant.
+func (p *producerProvider) Authenticate(r *http.Request) (Claims, error) {
+	producer, err := producers.verify(r, p.window, p.maxBody, time.Now().UTC())
+	if err != nil {
+		return Claims{}, err
+	}
+
+	return Claims{Subject: "producer:" + producer.ID, Scope: p.scope, Permissions: []string{p.scope}, Tenant: producer.Tenant}, nil
+}
+
+// localProvider grants its scopes and tenant to requests without credentials that come from localhost, for
+// development.
+type localProvider struct {
+	name   string
+	scopes []string
+	tenant string
+}
+
+// Name implements Provider.
+func (p *localProvider) Name() string {
+	return p.name
+}
+
+// Recognises implements Provider, reporting whether the request has no credentials and its connection comes from a
+// loopback address. Forwarded headers are ignored, so requests through a proxy are not recognised unless the proxy
+// itself runs on localhost.
+func (p *localProvider) Recognises(r *http.Request) bool {
+	if r.Header.Get("Authorization") != "" {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// Authenticate implements Provider, granting the provider's scopes and tenant.
+func (p *localProvider) Authenticate(r *http.Request) (Claims, error) {
+	return Claims{Subject: "local", Scope: strings.Join(p.scopes, " "), Permissions: p.scopes, Tenant: p.tenant}, nil
+}
+
+// containsValue reports whether a list holds a value.
+func containsValue(values []string, value string) bool {
+	for _, val := range values {
+		if val == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func terminateWithError(statusCode int, message string, c *gin.Context) {
+	c.JSON(statusCode, gin.H{"error": message})
+	c.Abort()
+}
@@ -0,0 +1,87 @@
+package security
+
+/*
+ *
+ * file: 		read_audit.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the middleware recording searches, counts and exports in the access trail.
+ *
+ */
+
+import (
+	"log"
+	"logging_service/audit"
+	"logging_service/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// resultSizeKey is the gin context key handlers store the number of logs a read returned under.
+const resultSizeKey = "result_size"
+
+// providerKey is the gin context key the name of the provider that authenticated a request is stored under.
+const providerKey = "provider"
+
+// AuditReads is a gin middleware that records a read of logs in the access trail once it has been handled, with the
+// caller's identity and tenant, the filters it gave, the response status, how many logs it returned and how long it took. Reads
+// are not recorded when Audit.ACCESS_FILE is not set, and a record that cannot be written is logged without failing
+// the read. It must follow Authenticate.
+//
+// Parameters:
+//	string	action	- Action the reads are recorded as, such as search or export.
+//
+// Returns
+//	gin.HandlerFunc	- next gin handler/middleware.
+//
+func AuditReads(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		trail := audit.GetAccessTrail()
+		if trail == nil {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		c.Next()
+		duration := time.Since(start)
+
+		filters := map[string]string{}
+		for name, values := range c.Request.URL.Query() {
+			if len(values) > 0 {
+				filters[name] = values[0]
+			}
+		}
+		for _, param := range c.Params {
+			filters[param.Key] = param.Value
+		}
+
+		details := map[string]interface{}{
+			"method":      c.Request.Method,
+			"path":        c.Request.URL.Path,
+			"status":      c.Writer.Status(),
+			"duration_ms": duration.Milliseconds(),
+			"client_ip":   c.ClientIP(),
+			"provider":    c.GetString(providerKey),
+		}
+		if size, ok := c.Get(resultSizeKey); ok {
+			details["result_size"] = size
+		}
+		tenant, _ := models.TenantOf(c.Request.Context())
+
+		if _, err := trail.Append(audit.Record{Actor: GetClaims(c).Subject, Tenant: tenant, Action: action, Filters: filters, Details: details}); err != nil {
+			log.Println("access trail: " + err.Error())
+		}
+	}
+}
+
+// SetResultSize stores the number of logs a read returned, so it is recorded in the access trail.
+//
+// Parameters:
+//	*gin.Context	c		- Handler context from gin.
+//	int64			size	- Number of logs returned.
+//
+func SetResultSize(c *gin.Context, size int64) {
+	c.Set(resultSizeKey, size)
+}
@@ -0,0 +1,579 @@
+package security
+
+/*
+ *
+ * file: 		request_signing.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the producers whose secrets sign ingestion requests, and the middleware verifying the signatures.
+ *
+ */
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"logging_service/audit"
+	"logging_service/config"
+	"logging_service/models"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Headers of a signed request.
+const (
+	producerHeader  = "X-Producer-ID"
+	timestampHeader = "X-Signature-Timestamp"
+	nonceHeader     = "X-Signature-Nonce"
+	signatureHeader = "X-Signature"
+)
+
+// producerSecretPrefix starts every producer secret so they are recognisable in configs and secret scanners.
+const producerSecretPrefix = "lsp_"
+
+// defaultSignatureWindow is used when Signing.WINDOW_SECONDS is not set.
+const defaultSignatureWindow = 5 * time.Minute
+
+// defaultSignedBodyBytes is used when Signing.MAX_BODY_BYTES is not set.
+const defaultSignedBodyBytes = 1 << 20
+
+// maxNonceLength is the longest nonce a signed request may carry.
+const maxNonceLength = 128
+
+// ErrProducerNotFound is returned when no producer has the given id.
+var ErrProducerNotFound = errors.New("producer not found")
+
+// errSignedBodyTooLarge is returned when a signed request's body is longer than Signing.MAX_BODY_BYTES.
+var errSignedBodyTooLarge = errors.New("request signing: the body is too large to verify")
+
+// Producer is a service that signs the logs it sends with a shared secret, optionally bound to a tenant. After a
+// rotation the previous secret stays valid until PreviousExpiresAt, so the producer can switch without downtime.
+type Producer struct {
+	ID                string     `json:"id"`
+	Name              string     `json:"name" binding:"required"`
+	Tenant            string     `json:"tenant,omitempty"`
+	CreatedBy         string     `json:"created_by"`
+	CreatedAt         time.Time  `json:"created_at"`
+	RotatedAt         *time.Time `json:"rotated_at,omitempty"`
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty"`
+	LastUsedAt        *time.Time `json:"last_used_at,omitempty"`
+}
+
+// IssuedProducer is a producer along with its secret, which is only returned when the producer is created or rotated.
+type IssuedProducer struct {
+	Producer
+	Secret string `json:"secret"`
+}
+
+// producerRecord is a producer as it is saved, with its current and previous secrets. Signatures are verified with the
+// secrets themselves, so unlike api keys they cannot be saved as hashes.
+type producerRecord struct {
+	Producer
+	Secret         string `json:"secret"`
+	PreviousSecret string `json:"previous_secret,omitempty"`
+}
+
+// producerFile holds the producers, saved as a json array in a file, and the nonces of recently signed requests. Nonces
+// are only kept in memory, so requests signed before the file was loaded are rejected rather than replayable after a
+// restart.
+type producerFile struct {
+	path      string
+	grace     time.Duration
+	loadedAt  time.Time
+	mutex     sync.RWMutex
+	producers []producerRecord
+	dirty     bool
+
+	nonceMutex sync.Mutex
+	nonces     map[string]time.Time
+	prunedAt   time.Time
+}
+
+// producers are the producers, nil when no producer file is configured.
+var producers *producerFile
+
+// LoadProducers loads the producers from a file and starts saving their last used times. The file is created when the
+// first producer is created.
+//
+// Parameters:
+//	string	path		- Path of the producer file, empty to disable request signing.
+//	int		graceHours	- Hours a rotated producer's previous secret stays valid, zero for the default.
+//
+// Returns
+//	error - Error if the file cannot be read.
+//
+func LoadProducers(path string, graceHours int) error {
+	producers = nil
+	if path == "" {
+		return nil
+	}
+
+	file := &producerFile{path: path, grace: time.Duration(graceHours) * time.Hour, loadedAt: time.Now().UTC(), producers: []producerRecord{},
+		nonces: map[string]time.Time{}}
+	if file.grace <= 0 {
+		file.grace = defaultRotationGrace
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, &file.producers); err != nil {
+			return err
+		}
+	}
+
+	producers = file
+	go file.runLastUsedFlush()
+	return nil
+}
+
+// ProducersEnabled reports whether a producer file is configured.
+//
+// Returns
+//	bool - True if producers can be created.
+//
+func ProducersEnabled() bool {
+	return producers != nil
+}
+
+// ListProducers returns the producers without their secrets.
+//
+// Parameters:
+//	string	tenant	- Tenant the producers belong to, empty for every producer.
+//
+// Returns
+//	[]Producer - Producers in the order they were created.
+//
+func ListProducers(tenant string) []Producer {
+	list := []Producer{}
+	if producers == nil {
+		return list
+	}
+
+	producers.mutex.RLock()
+	defer producers.mutex.RUnlock()
+	for _, record := range producers.producers {
+		if tenant == "" || record.Tenant == tenant {
+			list = append(list, record.Producer)
+		}
+	}
+
+	return list
+}
+
+// CreateProducer creates a producer with a new secret.
+//
+// Parameters:
+//	Producer	producer	- Name and tenant of the producer.
+//	string		actor		- Caller creating the producer.
+//
+// Returns
+//	IssuedProducer	- Created producer and its secret.
+//	error			- Error if the producer is not valid or cannot be audited or saved.
+//
+func CreateProducer(producer Producer, actor string) (IssuedProducer, error) {
+	if producers == nil {
+		return IssuedProducer{}, errors.New("request signing: no producer file is configured")
+	}
+	if err := producer.Validate(); err != nil {
+		return IssuedProducer{}, err
+	}
+
+	producer.ID = primitive.NewObjectID().Hex()
+	producer.CreatedBy = actor
+	producer.CreatedAt = time.Now().UTC()
+	producer.RotatedAt = nil
+	producer.PreviousExpiresAt = nil
+	producer.LastUsedAt = nil
+	secret, err := newProducerSecret()
+	if err != nil {
+		return IssuedProducer{}, err
+	}
+
+	producers.mutex.Lock()
+	defer producers.mutex.Unlock()
+	if err := producer.audit("producer_create", actor); err != nil {
+		return IssuedProducer{}, err
+	}
+	records := append(append([]producerRecord{}, producers.producers...), producerRecord{Producer: producer, Secret: secret})
+	if err := producers.save(records); err != nil {
+		return IssuedProducer{}, err
+	}
+
+	return IssuedProducer{Producer: producer, Secret: secret}, nil
+}
+
+// RotateProducer gives a producer a new secret. The current secret stays valid for the rotation grace period, so the
+// producer has two active secrets until it has switched. A secret left from an earlier rotation stops being valid.
+//
+// Parameters:
+//	string	id		- Id of the producer.
+//	string	tenant	- Tenant the producer must belong to, empty for any tenant.
+//	string	actor	- Caller rotating the producer.
+//
+// Returns
+//	IssuedProducer	- Rotated producer and its new secret.
+//	error			- ErrProducerNotFound, or an error if the rotation cannot be audited or saved.
+//
+func RotateProducer(id string, tenant string, actor string) (IssuedProducer, error) {
+	if producers == nil {
+		return IssuedProducer{}, ErrProducerNotFound
+	}
+	secret, err := newProducerSecret()
+	if err != nil {
+		return IssuedProducer{}, err
+	}
+
+	producers.mutex.Lock()
+	defer producers.mutex.Unlock()
+	records := append([]producerRecord{}, producers.producers...)
+	index := indexOfProducer(records, id, tenant)
+	if index < 0 {
+		return IssuedProducer{}, ErrProducerNotFound
+	}
+
+	rotatedAt := time.Now().UTC()
+	previousExpiresAt := rotatedAt.Add(producers.grace)
+	record := records[index]
+	record.PreviousSecret = record.Secret
+	record.Secret = secret
+	record.RotatedAt = &rotatedAt
+	record.PreviousExpiresAt = &previousExpiresAt
+	if err := record.audit("producer_rotate", actor); err != nil {
+		return IssuedProducer{}, err
+	}
+	records[index] = record
+	if err := producers.save(records); err != nil {
+		return IssuedProducer{}, err
+	}
+
+	return IssuedProducer{Producer: record.Producer, Secret: secret}, nil
+}
+
+// RevokeProducer removes a producer, so neither of its secrets is valid any more.
+//
+// Parameters:
+//	string	id		- Id of the producer.
+//	string	tenant	- Tenant the producer must belong to, empty for any tenant.
+//	string	actor	- Caller revoking the producer.
+//
+// Returns
+//	Producer	- Revoked producer.
+//	error		- ErrProducerNotFound, or an error if the revocation cannot be audited or saved.
+//
+func RevokeProducer(id string, tenant string, actor string) (Producer, error) {
+	if producers == nil {
+		return Producer{}, ErrProducerNotFound
+	}
+
+	producers.mutex.Lock()
+	defer producers.mutex.Unlock()
+	records := append([]producerRecord{}, producers.producers...)
+	index := indexOfProducer(records, id, tenant)
+	if index < 0 {
+		return Producer{}, ErrProducerNotFound
+	}
+
+	producer := records[index].Producer
+	if err := producer.audit("producer_revoke", actor); err != nil {
+		return producer, err
+	}
+
+	return producer, producers.save(append(records[:index], records[index+1:]...))
+}
+
+// VerifySignature is a gin middleware that verifies the signature of a request signed by a producer. The X-Signature
+// header holds the hex hmac-sha256, keyed with the producer's secret, of the method, request uri, X-Signature-Timestamp,
+// X-Signature-Nonce and hex sha256 of the body, joined by newlines. Requests are rejected with 401 when the timestamp
+// is outside Signing.WINDOW_SECONDS of now or before the service started, the signature does not match, or the
+// producer has already used the nonce, and with 413 when the body is longer than Signing.MAX_BODY_BYTES. Unsigned
+// requests are only rejected when Signing.REQUIRED is set, and signatures are ignored when Signing.FILE is not set and
+// they are not required. Requests authenticated by the producer provider were verified by it. It must follow
+// Authenticate.
+//
+// Returns
+//	gin.HandlerFunc	- next gin handler/middleware.
+//
+func VerifySignature() gin.HandlerFunc {
+	conf := config.GetConfig().Signing
+	window := signatureWindow(config.GetConfig())
+	maxBody := signedBodyLimit(config.GetConfig())
+
+	return func(c *gin.Context) {
+		if c.GetString(providerKey) == producerProviderName {
+			c.Next()
+			return
+		}
+		if !conf.Required && (producers == nil || c.GetHeader(producerHeader) == "" && c.GetHeader(signatureHeader) == "") {
+			c.Next()
+			return
+		}
+		if c.GetHeader(producerHeader) == "" && c.GetHeader(signatureHeader) == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"Error": "requests must be signed by a producer"})
+			return
+		}
+
+		producer, err := producers.verify(c.Request, window, maxBody, time.Now().UTC())
+		if err == errSignedBodyTooLarge {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"Error": err.Error()})
+			return
+		} else if err != nil {
+			log.Println(err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"Error": "invalid request signature"})
+			return
+		}
+		if tenant, ok := models.TenantOf(c.Request.Context()); ok && producer.Tenant != "" && producer.Tenant != tenant {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"Error": "the producer belongs to another tenant"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// SignRequest returns the signature of a request's parts, as sent in the X-Signature header.
+//
+// Parameters:
+//	string	secret		- Secret of the producer.
+//	string	method		- Method of the request.
+//	string	uri			- Path and query of the request.
+//	string	timestamp	- Unix time in seconds sent in the X-Signature-Timestamp header.
+//	string	nonce		- Nonce sent in the X-Signature-Nonce header.
+//	[]byte	body		- Body of the request.
+//
+// Returns
+//	string - Hex hmac-sha256 of the request.
+//
+func SignRequest(secret string, method string, uri string, timestamp string, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.ToUpper(method) + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(bodyHash[:])))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Validate trims the name and tenant of the producer and checks them.
+//
+// Receiver:
+//	*Producer	p
+//
+// Returns
+//	error - Error describing the first field that is not valid.
+//
+func (p *Producer) Validate() error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return errors.New("name: required")
+	}
+	p.Tenant = strings.TrimSpace(p.Tenant)
+
+	return nil
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// audit records a change to the producer in the audit trail when one is configured.
+func (p Producer) audit(action string, actor string) error {
+	if audit.GetTrail() == nil {
+		return nil
+	}
+	_, err := audit.Append(audit.Record{
+		Actor:   actor,
+		Tenant:  p.Tenant,
+		Action:  action,
+		Details: map[string]interface{}{"producer_id": p.ID, "name": p.Name, "tenant": p.Tenant},
+	})
+
+	return err
+}
+
+// signatureWindow returns how far a signed request's timestamp may be from now.
+func signatureWindow(conf config.Values) time.Duration {
+	window := time.Duration(conf.Signing.WindowSeconds) * time.Second
+	if window <= 0 {
+		return defaultSignatureWindow
+	}
+
+	return window
+}
+
+// signedBodyLimit returns the longest body of a signed request that is read to verify its signature.
+func signedBodyLimit(conf config.Values) int64 {
+	if conf.Signing.MaxBodyBytes <= 0 {
+		return defaultSignedBodyBytes
+	}
+
+	return conf.Signing.MaxBodyBytes
+}
+
+// newProducerSecret returns a new random producer secret.
+func newProducerSecret() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return producerSecretPrefix + base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// verify checks the signature of a request, returning the producer that signed it. The body is only read, up to
+// maxBody bytes, once the producer is known, and is put back for the handlers. The nonce is only recorded once the
+// signature is known to be valid, so unsigned requests cannot use up a producer's nonces.
+func (pf *producerFile) verify(r *http.Request, window time.Duration, maxBody int64, now time.Time) (Producer, error) {
+	if pf == nil {
+		return Producer{}, errors.New("request signing: no producer file is configured")
+	}
+	id := r.Header.Get(producerHeader)
+	timestamp := r.Header.Get(timestampHeader)
+	nonce := r.Header.Get(nonceHeader)
+	signature := r.Header.Get(signatureHeader)
+	if id == "" || timestamp == "" || nonce == "" || signature == "" {
+		return Producer{}, errors.New("request signing: " + producerHeader + ", " + timestampHeader + ", " + nonceHeader +
+			" and " + signatureHeader + " are all required")
+	}
+	if len(nonce) > maxNonceLength {
+		return Producer{}, errors.New("request signing: nonce is longer than " + strconv.Itoa(maxNonceLength) + " characters")
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Producer{}, errors.New("request signing: malformed timestamp " + timestamp)
+	}
+	if signedAt := time.Unix(seconds, 0); signedAt.Before(now.Add(-window)) || signedAt.After(now.Add(window)) {
+		return Producer{}, errors.New("request signing: timestamp of producer " + id + " is outside the signature window")
+	}
+	if seconds <= pf.loadedAt.Unix() {
+		return Producer{}, errors.New("request signing: producer " + id + " signed the request before the service started")
+	}
+	given, err := hex.DecodeString(signature)
+	if err != nil {
+		return Producer{}, errors.New("request signing: malformed signature")
+	}
+
+	pf.mutex.RLock()
+	index := indexOfProducer(pf.producers, id, "")
+	record := producerRecord{}
+	if index >= 0 {
+		record = pf.producers[index]
+	}
+	pf.mutex.RUnlock()
+	if index < 0 {
+		return Producer{}, errors.New("request signing: unknown producer " + id)
+	}
+
+	body := []byte{}
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxBody))
+		r.Body.Close()
+		if err != nil && err.Error() == "http: request body too large" {
+			return Producer{}, errSignedBodyTooLarge
+		} else if err != nil {
+			return Producer{}, err
+		}
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	matches := func(secret string) bool {
+		expected, _ := hex.DecodeString(SignRequest(secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body))
+		return hmac.Equal(given, expected)
+	}
+	current := matches(record.Secret)
+	previous := record.PreviousSecret != "" && record.PreviousExpiresAt != nil && now.Before(*record.PreviousExpiresAt) &&
+		matches(record.PreviousSecret)
+	if !current && !previous {
+		return Producer{}, errors.New("request signing: wrong signature for producer " + id)
+	}
+
+	pf.mutex.Lock()
+	index = indexOfProducer(pf.producers, id, "")
+	if index < 0 {
+		pf.mutex.Unlock()
+		return Producer{}, errors.New("request signing: producer " + id + " was revoked")
+	}
+	pf.producers[index].LastUsedAt = &now
+	pf.dirty = true
+	producer := pf.producers[index].Producer
+	pf.mutex.Unlock()
+
+	if !pf.useNonce(id, nonce, window, now) {
+		return Producer{}, errors.New("request signing: producer " + id + " reused nonce " + nonce)
+	}
+
+	return producer, nil
+}
+
+// useNonce records a producer's nonce, reporting false if it was already used. Nonces are forgotten once requests
+// signed with them would be outside the signature window anyway.
+func (pf *producerFile) useNonce(id string, nonce string, window time.Duration, now time.Time) bool {
+	pf.nonceMutex.Lock()
+	defer pf.nonceMutex.Unlock()
+	if now.Sub(pf.prunedAt) > window {
+		for key, usedAt := range pf.nonces {
+			if now.Sub(usedAt) > 2*window {
+				delete(pf.nonces, key)
+			}
+		}
+		pf.prunedAt = now
+	}
+
+	key := id + "\n" + nonce
+	if _, used := pf.nonces[key]; used {
+		return false
+	}
+	pf.nonces[key] = now
+
+	return true
+}
+
+// runLastUsedFlush saves the producers whenever their last used times have changed since the last flush.
+func (pf *producerFile) runLastUsedFlush() {
+	for {
+		time.Sleep(lastUsedFlushInterval)
+		pf.mutex.Lock()
+		if pf.dirty {
+			if err := pf.save(append([]producerRecord{}, pf.producers...)); err != nil {
+				log.Println(err)
+			}
+		}
+		pf.mutex.Unlock()
+	}
+}
+
+// save writes the producers to the producer file, then replaces the loaded producers. The mutex must be held.
+func (pf *producerFile) save(records []producerRecord) error {
+	if err := writeSecretFile(pf.path, records); err != nil {
+		return err
+	}
+
+	pf.producers = records
+	pf.dirty = false
+	return nil
+}
+
+// indexOfProducer returns the index of the producer with the id, in the tenant unless it is empty, -1 if there is none.
+func indexOfProducer(records []producerRecord, id string, tenant string) int {
+	for i, record := range records {
+		if record.ID == id && (tenant == "" || record.Tenant == tenant) {
+			return i
+		}
+	}
+
+	return -1
+}
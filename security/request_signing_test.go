@@ -0,0 +1,151 @@
+package security
+
+/*
+ *
+ * file: 		request_signing_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests verifying requests signed by producers.
+ *
+ */
+
+import (
+	"logging_service/models"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVerifySignedRequests(t *testing.T) {
+	if err := LoadProducers(filepath.Join(t.TempDir(), "producers.json"), 1); err != nil {
+		t.Fatal(err)
+	}
+	defer LoadProducers("", 0)
+
+	billing, err := CreateProducer(Producer{Name: "billing", Tenant: "acme"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := RotateProducer(billing.ID, "", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shipping, err := CreateProducer(Producer{Name: "shipping", Tenant: "acme"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	window := 5 * time.Minute
+	now := producers.loadedAt.Add(time.Minute)
+	afterGrace := now.Add(2 * time.Hour)
+	tests := []struct {
+		name     string
+		secret   string
+		producer string
+		signedAt time.Time
+		nonce    string
+		now      time.Time
+		wantOK   bool
+	}{
+		{"current secret", rotated.Secret, billing.ID, now, "n1", now, true},
+		{"reused nonce", rotated.Secret, billing.ID, now, "n1", now, false},
+		{"nonce used by another producer", shipping.Secret, shipping.ID, now, "n1", now, true},
+		{"previous secret within the grace", billing.Secret, billing.ID, now, "n2", now, true},
+		{"previous secret after the grace", billing.Secret, billing.ID, afterGrace, "n3", afterGrace, false},
+		{"current secret after the grace", rotated.Secret, billing.ID, afterGrace, "n4", afterGrace, true},
+		{"before the window", rotated.Secret, billing.ID, now.Add(-window - time.Second), "n5", now, false},
+		{"after the window", rotated.Secret, billing.ID, now.Add(window + time.Second), "n6", now, false},
+		{"signed before the service started", rotated.Secret, billing.ID, producers.loadedAt, "n7", now, false},
+		{"unknown producer", rotated.Secret, "someone", now, "n8", now, false},
+		{"wrong secret", shipping.Secret, billing.ID, now, "n9", now, false},
+	}
+	for _, test := range tests {
+		r := signedRequest(test.secret, test.producer, test.signedAt, test.nonce, `{"message": "a"}`)
+		producer, err := producers.verify(r, window, 1024, test.now)
+		if test.wantOK && (err != nil || producer.ID != test.producer) {
+			t.Errorf("%s: verified producer %q, %v, want %q", test.name, producer.ID, err, test.producer)
+		} else if !test.wantOK && err == nil {
+			t.Errorf("%s: verified producer %q, want an error", test.name, producer.ID)
+		}
+	}
+
+	// Bodies over the limit are refused without being read whole.
+	r := signedRequest(rotated.Secret, billing.ID, now, "n10", `{"message": "a"}`)
+	if _, err := producers.verify(r, window, 8, now); err != errSignedBodyTooLarge {
+		t.Errorf("verified an oversize body with %v, want %v", err, errSignedBodyTooLarge)
+	}
+}
+
+func TestVerifySignatureKeepsToTheProducersTenant(t *testing.T) {
+	useConfig(t, "Tenancy:\n    ENABLED: true\n")
+	if err := LoadProducers(filepath.Join(t.TempDir(), "producers.json"), 1); err != nil {
+		t.Fatal(err)
+	}
+	defer LoadProducers("", 0)
+	producer, err := CreateProducer(Producer{Name: "billing", Tenant: "acme"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Requests are signed within the current second, so the service is taken to have started a little earlier.
+	producers.loadedAt = producers.loadedAt.Add(-time.Minute)
+
+	tests := []struct {
+		name       string
+		tenant     string
+		nonce      string
+		wantStatus int
+	}{
+		{"producer's tenant", "acme", "n1", http.StatusCreated},
+		{"another tenant", "globex", "n2", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.Use(func(c *gin.Context) { c.Set(claimsKey, Claims{Tenant: test.tenant}) }, ScopeTenant(), VerifySignature())
+		router.POST("/log/:log_level", func(c *gin.Context) { c.Status(http.StatusCreated) })
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, signedRequest(producer.Secret, producer.ID, time.Now(), test.nonce, `{"message": "a"}`))
+		if recorder.Code != test.wantStatus {
+			t.Errorf("%s: responded %d, want %d", test.name, recorder.Code, test.wantStatus)
+		}
+	}
+
+	// Requests signed without other credentials are authenticated as the producer, in the producer's tenant.
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Authenticate(), ScopeTenant())
+	router.POST("/log/:log_level", VerifySignature(), func(c *gin.Context) {
+		tenant, _ := models.TenantOf(c.Request.Context())
+		c.String(http.StatusCreated, GetClaims(c).Subject+" "+tenant)
+	})
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, signedRequest(producer.Secret, producer.ID, time.Now(), "n3", `{"message": "a"}`))
+	if want := "producer:" + producer.ID + " acme"; recorder.Code != http.StatusCreated || recorder.Body.String() != want {
+		t.Errorf("responded %d %q, want 201 %q", recorder.Code, recorder.Body.String(), want)
+	}
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// signedRequest returns a request to create a log, signed by a producer.
+func signedRequest(secret string, producer string, signedAt time.Time, nonce string, body string) *http.Request {
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	r := httptest.NewRequest(http.MethodPost, "/log/INFO", strings.NewReader(body))
+	r.Header.Set(producerHeader, producer)
+	r.Header.Set(timestampHeader, timestamp)
+	r.Header.Set(nonceHeader, nonce)
+	r.Header.Set(signatureHeader, SignRequest(secret, http.MethodPost, "/log/INFO", timestamp, nonce, []byte(body)))
+
+	return r
+}
@@ -0,0 +1,96 @@
+package security
+
+/*
+ *
+ * file: 		scopes.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the scopes routes require and the middleware enforcing them.
+ *
+ */
+
+import (
+	"logging_service/config"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scopes required when Auth.READ_SCOPE, Auth.WRITE_SCOPE and Auth.ADMIN_SCOPE are not set.
+const (
+	defaultReadScope  = "logs:read"
+	defaultWriteScope = "logs:write"
+	defaultAdminScope = "logs:admin"
+)
+
+// Scopes are the scopes routes require. Read is required to search, count and export logs, Write to create them, and
+// Admin for destructive and configuration endpoints.
+type Scopes struct {
+	Read  string `json:"read"`
+	Write string `json:"write"`
+	Admin string `json:"admin"`
+}
+
+// GetScopes returns the scopes routes require from the config.
+//
+// Parameters:
+//	config.Values	conf	- Config values.
+//
+// Returns
+//	Scopes - Required scopes.
+//
+func GetScopes(conf config.Values) Scopes {
+	scopes := Scopes{Read: conf.Auth.ReadScope, Write: conf.Auth.WriteScope, Admin: conf.Auth.AdminScope}
+	if scopes.Read == "" {
+		scopes.Read = defaultReadScope
+	}
+	if scopes.Write == "" {
+		scopes.Write = defaultWriteScope
+	}
+	if scopes.Admin == "" {
+		scopes.Admin = defaultAdminScope
+	}
+
+	return scopes
+}
+
+// RequireAnyScope is a gin middleware that responds with 403 unless the request's token grants at least one of the
+// scopes. It must follow Authenticate.
+//
+// Parameters:
+//	...string	scopes	- Scopes of which one is required.
+//
+// Returns
+//	gin.HandlerFunc	- next gin handler/middleware.
+//
+func RequireAnyScope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, scope := range scopes {
+			if HasPermission(c, scope) {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"Error": "the token is missing one of the " + strings.Join(scopes, ", ") + " scopes"})
+	}
+}
+
+// RequireScope is a gin middleware that responds with 403 unless the request's token grants a scope, either in its
+// scope or permissions claim. It must follow Authenticate.
+//
+// Parameters:
+//	string	scope	- Required scope.
+//
+// Returns
+//	gin.HandlerFunc	- next gin handler/middleware.
+//
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasPermission(c, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"Error": "the token is missing the " + scope + " scope"})
+			return
+		}
+		c.Next()
+	}
+}
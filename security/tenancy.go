@@ -0,0 +1,237 @@
+package security
+
+/*
+ *
+ * file: 		tenancy.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Defines the middleware scoping requests to the caller's tenant and limiting each tenant's requests.
+ *
+ */
+
+import (
+	"logging_service/config"
+	"logging_service/models"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultTenantClaim is used when Tenancy.CLAIM is not set.
+const defaultTenantClaim = "tenant"
+
+// defaultSuperAdminScope is used when Tenancy.SUPER_ADMIN_SCOPE is not set.
+const defaultSuperAdminScope = "logs:super-admin"
+
+// allTenants is the tenant query parameter super-admins use to query every tenant.
+const allTenants = "*"
+
+// GetSuperAdminScope returns the scope letting callers choose their tenant or query every tenant.
+//
+// Parameters:
+//	config.Values	conf	- Config values.
+//
+// Returns
+//	string - Super-admin scope.
+//
+func GetSuperAdminScope(conf config.Values) string {
+	if conf.Tenancy.SuperAdminScope == "" {
+		return defaultSuperAdminScope
+	}
+
+	return conf.Tenancy.SuperAdminScope
+}
+
+// ScopeTenant is a gin middleware that scopes the request's context to the caller's tenant when Tenancy.ENABLED is
+// set, so logs it creates are stamped with the tenant and searches, counts, exports and deletes only see the tenant's
+// logs. Callers without a tenant are rejected with 403. Super-admins may choose a tenant with the tenant query
+// parameter, or query every tenant with tenant=*, and are not scoped when they have no tenant of their own. It must
+// follow Authenticate.
+//
+// Returns
+//	gin.HandlerFunc	- next gin handler/middleware.
+//
+func ScopeTenant() gin.HandlerFunc {
+	conf := config.GetConfig()
+	superAdminScope := GetSuperAdminScope(conf)
+
+	return func(c *gin.Context) {
+		if !conf.Tenancy.Enabled {
+			c.Next()
+			return
+		}
+
+		tenant := GetClaims(c).Tenant
+		superAdmin := HasPermission(c, superAdminScope)
+		if requested := c.Query("tenant"); requested != "" && requested != tenant {
+			if !superAdmin {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"Error": "only super-admins can query other tenants"})
+				return
+			}
+			tenant = requested
+			if requested == allTenants {
+				tenant = ""
+			}
+		}
+		if tenant == "" && !superAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"Error": "the credentials are not bound to a tenant"})
+			return
+		}
+
+		c.Request = c.Request.WithContext(models.WithTenant(c.Request.Context(), tenant))
+		c.Next()
+	}
+}
+
+// RequireSuperAdmin is a gin middleware that responds with 403 when Tenancy.ENABLED is set and the request's token does
+// not grant Tenancy.SUPER_ADMIN_SCOPE, guarding operations that span every tenant. It must follow Authenticate.
+//
+// Returns
+//	gin.HandlerFunc	- next gin handler/middleware.
+//
+func RequireSuperAdmin() gin.HandlerFunc {
+	conf := config.GetConfig()
+	superAdminScope := GetSuperAdminScope(conf)
+
+	return func(c *gin.Context) {
+		if conf.Tenancy.Enabled && !HasPermission(c, superAdminScope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"Error": "the token is missing the " + superAdminScope + " scope"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireTenant is a gin middleware that responds with 400 when Tenancy.ENABLED is set and the request is not scoped
+// to a tenant, so every log written is stamped with one. Super-admins writing logs must choose a tenant with the
+// tenant query parameter. It must follow ScopeTenant.
+//
+// Returns
+//	gin.HandlerFunc	- next gin handler/middleware.
+//
+func RequireTenant() gin.HandlerFunc {
+	enabled := config.GetConfig().Tenancy.Enabled
+
+	return func(c *gin.Context) {
+		if _, ok := models.TenantOf(c.Request.Context()); enabled && !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": "a tenant is required, set the tenant query parameter"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// LimitTenantWrites is a gin middleware that responds with 429 once a tenant has made Tenancy.WRITES_PER_MINUTE
+// write requests in the current minute, or the tenant's own limit in Tenancy.LIMITS. It must follow ScopeTenant.
+//
+// Returns
+//	gin.HandlerFunc	- next gin handler/middleware.
+//
+func LimitTenantWrites() gin.HandlerFunc {
+	conf := config.GetConfig()
+	limits := map[string]int{}
+	for tenant, limit := range conf.Tenancy.Limits {
+		limits[tenant] = limit.WritesPerMinute
+	}
+
+	return newTenantLimiter("write", conf.Tenancy.WritesPerMinute, limits).handle
+}
+
+// LimitTenantReads is a gin middleware that responds with 429 once a tenant has made Tenancy.READS_PER_MINUTE read
+// requests in the current minute, or the tenant's own limit in Tenancy.LIMITS. It must follow ScopeTenant.
+//
+// Returns
+//	gin.HandlerFunc	- next gin handler/middleware.
+//
+func LimitTenantReads() gin.HandlerFunc {
+	conf := config.GetConfig()
+	limits := map[string]int{}
+	for tenant, limit := range conf.Tenancy.Limits {
+		limits[tenant] = limit.ReadsPerMinute
+	}
+
+	return newTenantLimiter("read", conf.Tenancy.ReadsPerMinute, limits).handle
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// tenantClaim returns the jwt claim holding the caller's tenant.
+func tenantClaim(conf config.Values) string {
+	if conf.Tenancy.Claim == "" {
+		return defaultTenantClaim
+	}
+
+	return conf.Tenancy.Claim
+}
+
+// tenantLimiter counts each tenant's requests in fixed one minute windows. A limit of zero or less is unlimited, and
+// requests that are not scoped to a tenant are not counted.
+type tenantLimiter struct {
+	kind      string
+	perMinute int
+	limits    map[string]int
+
+	mutex   sync.Mutex
+	windows map[string]tenantWindow
+}
+
+// tenantWindow is the number of requests a tenant has made since the start of a minute.
+type tenantWindow struct {
+	start time.Time
+	count int
+}
+
+// newTenantLimiter creates a limiter with a default limit and limits overriding it for some tenants.
+func newTenantLimiter(kind string, perMinute int, limits map[string]int) *tenantLimiter {
+	return &tenantLimiter{kind: kind, perMinute: perMinute, limits: limits, windows: map[string]tenantWindow{}}
+}
+
+// handle counts the request against its tenant, responding with 429 and Retry-After when the tenant is over its limit.
+func (tl *tenantLimiter) handle(c *gin.Context) {
+	tenant, ok := models.TenantOf(c.Request.Context())
+	if !ok {
+		c.Next()
+		return
+	}
+
+	if allowed, retryAfter := tl.allow(tenant, time.Now()); !allowed {
+		c.Header("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"Error": "the tenant has reached its " + tl.kind + " limit, try again later"})
+		return
+	}
+	c.Next()
+}
+
+// allow counts a request of a tenant, reporting whether it is within the tenant's limit and, if not, how long until
+// the next window starts.
+func (tl *tenantLimiter) allow(tenant string, now time.Time) (bool, time.Duration) {
+	limit := tl.perMinute
+	if tenantLimit, ok := tl.limits[tenant]; ok && tenantLimit != 0 {
+		limit = tenantLimit
+	}
+	if limit <= 0 {
+		return true, 0
+	}
+
+	start := now.Truncate(time.Minute)
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+	window := tl.windows[tenant]
+	if !window.start.Equal(start) {
+		window = tenantWindow{start: start}
+	}
+	if window.count >= limit {
+		return false, start.Add(time.Minute).Sub(now)
+	}
+	window.count++
+	tl.windows[tenant] = window
+
+	return true, 0
+}
@@ -0,0 +1,105 @@
+package security
+
+/*
+ *
+ * file: 		tenancy_test.go
+ * project:		logging_service - NAD-A3
+ * programmer: 	Conor Macpherson
+ * description: Tests scoping requests to the caller's tenant and the super-admin checks.
+ *
+ */
+
+import (
+	"io/ioutil"
+	"logging_service/models"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestScopeTenant(t *testing.T) {
+	useConfig(t, "Tenancy:\n    ENABLED: true\n")
+
+	tests := []struct {
+		name       string
+		claims     Claims
+		query      string
+		wantStatus int
+		wantTenant string
+	}{
+		{"own tenant", Claims{Tenant: "acme"}, "", http.StatusOK, "acme"},
+		{"own tenant named", Claims{Tenant: "acme"}, "?tenant=acme", http.StatusOK, "acme"},
+		{"other tenant", Claims{Tenant: "acme"}, "?tenant=globex", http.StatusForbidden, ""},
+		{"every tenant", Claims{Tenant: "acme"}, "?tenant=*", http.StatusForbidden, ""},
+		{"admin scope is not enough", Claims{Tenant: "acme", Scope: "logs:admin"}, "?tenant=globex", http.StatusForbidden, ""},
+		{"no tenant", Claims{Scope: "logs:read"}, "", http.StatusForbidden, ""},
+		{"super-admin other tenant", Claims{Tenant: "acme", Scope: "logs:super-admin"}, "?tenant=globex", http.StatusOK, "globex"},
+		{"super-admin every tenant", Claims{Tenant: "acme", Scope: "logs:super-admin"}, "?tenant=*", http.StatusOK, ""},
+		{"super-admin without a tenant", Claims{Permissions: []string{"logs:super-admin"}}, "", http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		tenant := ""
+		status := serveWithClaims(test.claims, "/logs"+test.query, ScopeTenant(), func(c *gin.Context) {
+			tenant, _ = models.TenantOf(c.Request.Context())
+		})
+		if status != test.wantStatus || tenant != test.wantTenant {
+			t.Errorf("%s: responded %d scoped to %q, want %d scoped to %q", test.name, status, tenant, test.wantStatus, test.wantTenant)
+		}
+	}
+}
+
+func TestRequireSuperAdmin(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		claims     Claims
+		wantStatus int
+	}{
+		{"tenancy disabled", "Tenancy:\n    ENABLED: false\n", Claims{Tenant: "acme", Scope: "logs:admin"}, http.StatusOK},
+		{"tenant admin", "Tenancy:\n    ENABLED: true\n", Claims{Tenant: "acme", Scope: "logs:admin"}, http.StatusForbidden},
+		{"super-admin", "Tenancy:\n    ENABLED: true\n", Claims{Scope: "logs:admin logs:super-admin"}, http.StatusOK},
+		{"configured scope", "Tenancy:\n    ENABLED: true\n    SUPER_ADMIN_SCOPE: ops\n", Claims{Scope: "logs:super-admin"}, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		useConfig(t, test.config)
+		if status := serveWithClaims(test.claims, "/archive", RequireSuperAdmin(), nil); status != test.wantStatus {
+			t.Errorf("%s: responded %d, want %d", test.name, status, test.wantStatus)
+		}
+	}
+}
+
+/*
+ *
+ * Helpers
+ *
+ */
+
+// useConfig points the service at a config file holding the yaml for the rest of the test.
+func useConfig(t *testing.T, yaml string) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LOGGING_SERVICE_CONFIG_PATH", path)
+}
+
+// serveWithClaims serves a GET request authenticated with the claims through a middleware, calling handler when the
+// middleware lets the request through, and returns the response's status.
+func serveWithClaims(claims Claims, target string, middleware gin.HandlerFunc, handler gin.HandlerFunc) int {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(claimsKey, claims) }, middleware)
+	router.GET("/*path", func(c *gin.Context) {
+		if handler != nil {
+			handler(c)
+		}
+		c.Status(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	return recorder.Code
+}